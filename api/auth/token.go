@@ -2,6 +2,7 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,18 +14,76 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+const (
+	// AccessTokenDuration is how long an access token is valid for
+	AccessTokenDuration = time.Hour * 1
+	// RefreshTokenDuration is how long a refresh token is valid for
+	RefreshTokenDuration = time.Hour * 24 * 30
+)
+
+// ErrRevokedToken is returned when a token belongs to a session that has been revoked
+var ErrRevokedToken = errors.New("Token has been revoked")
+
+// RevocationStore reports whether the session identified by a token's jti claim has been revoked
+type RevocationStore interface {
+	IsRevoked(jti string) bool
+}
+
+var revocations RevocationStore
+
+// SetRevocationStore sets the store used to reject revoked tokens. If no store is set, tokens are only checked for a valid signature and expiry
+func SetRevocationStore(store RevocationStore) {
+	revocations = store
+}
+
+// TokenPair is an access token and the refresh token that can be used to replace it once it expires
+type TokenPair struct {
+	Token          string
+	Expires        time.Time
+	RefreshToken   string
+	RefreshExpires time.Time
+}
+
 // CreateToken creates a login token that will be used by UI and API users
-// expires after 1 hour
-func CreateToken(userID uuid.UUID, authority string) (string, error) {
+// expires after 1 hour. The jti claim is the ID of the session the token belongs to
+func CreateToken(userID uuid.UUID, authority string, sessionID uuid.UUID) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["permission"] = authority
 	claims["user_id"] = userID
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix() //Token expires after 1 hour
+	claims["jti"] = sessionID.String()
+	claims["exp"] = time.Now().Add(AccessTokenDuration).Unix() //Token expires after 1 hour
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(os.Getenv("API_SECRET")))
 }
 
+// CreateRefreshToken creates a refresh token that can be exchanged for a new access token until it expires or its session is revoked
+func CreateRefreshToken(userID uuid.UUID, authority string, sessionID uuid.UUID, expires time.Time) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["type"] = "refresh"
+	claims["permission"] = authority
+	claims["user_id"] = userID
+	claims["jti"] = sessionID.String()
+	claims["exp"] = expires.Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+}
+
+// CreateTokenPair creates an access token and a refresh token for a session
+func CreateTokenPair(userID uuid.UUID, authority string, sessionID uuid.UUID, refreshExpires time.Time) (pair TokenPair, err error) {
+	pair.Token, err = CreateToken(userID, authority, sessionID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	pair.Expires = time.Now().Add(AccessTokenDuration)
+	pair.RefreshToken, err = CreateRefreshToken(userID, authority, sessionID, refreshExpires)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	pair.RefreshExpires = refreshExpires
+	return pair, nil
+}
+
 // CreatePasswordResetToken creates a password reset token that will be included in a password reset link
 // expires after 15 minutes
 func CreatePasswordResetToken(userID uuid.UUID, password string) (string, error) {
@@ -38,8 +97,8 @@ func CreatePasswordResetToken(userID uuid.UUID, password string) (string, error)
 	return token.SignedString([]byte(os.Getenv("API_SECRET")))
 }
 
-func TokenValid(r *http.Request) error {
-	tokenString := ExtractToken(r)
+// parseToken validates the signature and expiry of a token
+func parseToken(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
@@ -47,14 +106,52 @@ func TokenValid(r *http.Request) error {
 		return []byte(os.Getenv("API_SECRET")), nil
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Invalid token")
+	}
+	return claims, nil
+}
+
+// checkRevoked rejects tokens without a jti claim and tokens whose session has been revoked
+func checkRevoked(claims jwt.MapClaims) error {
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return ErrRevokedToken
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
+	if revocations != nil && revocations.IsRevoked(jti) {
+		return ErrRevokedToken
 	}
 	return nil
 }
 
+// parseAccessToken validates an access token (not a refresh or password reset token) and makes sure it has not been revoked
+func parseAccessToken(r *http.Request) (jwt.MapClaims, error) {
+	claims, err := parseToken(ExtractToken(r))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["type"]; ok {
+		return nil, errors.New("Invalid token type")
+	}
+	err = checkRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func TokenValid(r *http.Request) error {
+	claims, err := parseAccessToken(r)
+	if err != nil {
+		return err
+	}
+	Pretty(claims)
+	return nil
+}
+
 func ExtractToken(r *http.Request) string {
 	keys := r.URL.Query()
 	token := keys.Get("token")
@@ -70,18 +167,11 @@ func ExtractToken(r *http.Request) string {
 
 func ExtractUITokenID(r *http.Request) (uuid.UUID, error) {
 
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	claims, err := parseAccessToken(r)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid && claims["permission"] == "ui" {
+	if claims["permission"] == "ui" {
 		uid, err := uuid.FromString(fmt.Sprintf("%s", claims["user_id"]))
 		if err != nil {
 			return uuid.UUID{}, err
@@ -93,41 +183,59 @@ func ExtractUITokenID(r *http.Request) (uuid.UUID, error) {
 
 func ExtractAPIUserTokenID(r *http.Request) (uuid.UUID, string, error) {
 
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	claims, err := parseAccessToken(r)
 	if err != nil {
 		return uuid.UUID{}, "", err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		uid, err := uuid.FromString(fmt.Sprintf("%s", claims["user_id"]))
-		if err != nil {
-			return uuid.UUID{}, "", err
-		}
-		return uid, fmt.Sprintf("%s", claims["permission"]), nil
+	uid, err := uuid.FromString(fmt.Sprintf("%s", claims["user_id"]))
+	if err != nil {
+		return uuid.UUID{}, "", err
 	}
-	return uuid.UUID{}, "", nil
+	return uid, fmt.Sprintf("%s", claims["permission"]), nil
+}
+
+// ExtractSessionID retrieves the session ID (jti claim) of a valid access token
+func ExtractSessionID(r *http.Request) (uuid.UUID, error) {
+
+	claims, err := parseAccessToken(r)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return uuid.FromString(fmt.Sprintf("%s", claims["jti"]))
+}
+
+// ExtractRefreshToken validates a refresh token and retrieves the user ID, permission and session ID it was issued for
+func ExtractRefreshToken(tokenString string) (uuid.UUID, string, uuid.UUID, error) {
+
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return uuid.UUID{}, "", uuid.UUID{}, err
+	}
+	if claims["type"] != "refresh" {
+		return uuid.UUID{}, "", uuid.UUID{}, errors.New("Invalid token type")
+	}
+	err = checkRevoked(claims)
+	if err != nil {
+		return uuid.UUID{}, "", uuid.UUID{}, err
+	}
+	uid, err := uuid.FromString(fmt.Sprintf("%s", claims["user_id"]))
+	if err != nil {
+		return uuid.UUID{}, "", uuid.UUID{}, err
+	}
+	sessionID, err := uuid.FromString(fmt.Sprintf("%s", claims["jti"]))
+	if err != nil {
+		return uuid.UUID{}, "", uuid.UUID{}, err
+	}
+	return uid, fmt.Sprintf("%s", claims["permission"]), sessionID, nil
 }
 
 func ExtractResetTokenID(r *http.Request) (uuid.UUID, string, error) {
 
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	claims, err := parseToken(ExtractToken(r))
 	if err != nil {
 		return uuid.UUID{}, "", err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid && claims["type"] == "reset" {
+	if claims["type"] == "reset" {
 		uid, err := uuid.FromString(fmt.Sprintf("%s", claims["user_id"]))
 		if err != nil {
 			return uuid.UUID{}, "", err
@@ -33,13 +33,21 @@ func (server *Server) CreateAPIUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
+	// The linked user is sent notifications through the API user's webhooks, so only they can link it to their account
+	if user.SmartmailUserID.Valid {
+		tokenID, err := auth.ExtractUITokenID(r)
+		if err != nil || tokenID != user.SmartmailUserID.UUID {
+			responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+			return
+		}
+	}
 	user.Prepare()
 	err = user.Validate("")
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	userCreated, err := user.SaveAPIUser(server.DB)
+	userCreated, err := server.Store.APIUsers().Save(&user)
 
 	if err != nil {
 
@@ -55,9 +63,7 @@ func (server *Server) CreateAPIUser(w http.ResponseWriter, r *http.Request) {
 // GetAPIUsers retrieves 100 users
 func (server *Server) GetAPIUsers(w http.ResponseWriter, r *http.Request) {
 
-	user := models.APIUser{}
-
-	users, err := user.FindAllAPIUsers(server.DB)
+	users, err := server.Store.APIUsers().FindAll()
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -70,8 +76,7 @@ func (server *Server) GetAPIUser(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	uid := uuid.FromStringOrNil(vars["id"])
-	user := models.APIUser{}
-	userGotten, err := user.FindAPIUserByID(server.DB, uid)
+	userGotten, err := server.Store.APIUsers().FindByID(uid)
 	if err != nil {
 		responses.ERROR(w, http.StatusBadRequest, err)
 		return
@@ -110,7 +115,7 @@ func (server *Server) UpdateAPIUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	updatedUser, err := user.UpdateAPIUser(server.DB, uid)
+	updatedUser, err := server.Store.APIUsers().Update(&user, uid)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
@@ -124,8 +129,6 @@ func (server *Server) DeleteAPIUser(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	user := models.User{}
-
 	uid := uuid.FromStringOrNil(vars["id"])
 	tokenID, err := auth.ExtractUITokenID(r)
 	if err != nil {
@@ -136,7 +139,7 @@ func (server *Server) DeleteAPIUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
-	_, err = user.DeleteUser(server.DB, uid)
+	_, err = server.Store.Users().Delete(uid)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -1,27 +1,128 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	"github.com/rs/cors"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres database driver
-	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/migrations"
+	"github.com/nmelhado/smartmail-api/api/rating"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/scheduler"
+	"github.com/nmelhado/smartmail-api/api/webhooks"
 )
 
-// Server creates a domain that is used for all API endpoints
+// Server creates a domain that is used for all API endpoints. Handlers read and write through Store, DB is kept for migrations and jobs
 type Server struct {
-	DB     *gorm.DB
-	Router *mux.Router
+	DB        *gorm.DB
+	Store     repository.Store
+	Router    *mux.Router
+	Geocoder  geocode.Geocoder
+	Rater     *rating.Rater
+	Scheduler *scheduler.Scheduler
 }
 
-// Initialize starts the DB connection
+// defaultExpiryInterval is how often address assignments are checked for expiry when ADDRESS_EXPIRY_INTERVAL is not set
+const defaultExpiryInterval = time.Hour
+
+// defaultWebhookInterval is how often queued webhook deliveries are sent when WEBHOOK_DELIVERY_INTERVAL is not set
+const defaultWebhookInterval = 30 * time.Second
+
+// defaultMailingJobInterval is how often the mailing job worker looks for uploads when MAILING_JOB_INTERVAL is not set
+const defaultMailingJobInterval = 10 * time.Second
+
+// Initialize starts the DB connection and sets up everything the server needs to handle requests
 func (server *Server) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, CloudHost, DbName string) {
+	var err error
+	server.Connect(Dbdriver, DbUser, DbPassword, DbPort, DbHost, CloudHost, DbName)
+
+	// The schema is only changed by `migrate up`, the API refuses to start on a schema it does not know
+	migrator, err := server.Migrator()
+	if err != nil {
+		log.Fatal("Unable to load the migrations: ", err)
+	}
+	err = migrator.CheckCurrent()
+	if errors.Is(err, migrations.ErrSchemaAhead) {
+		log.Fatalf("%v. Start a build that has these migrations", err)
+	}
+	if err != nil {
+		log.Fatalf("%v. Run the migrate up command before starting the API", err)
+	}
+
+	server.Geocoder, err = geocode.NewFromEnv()
+	if err != nil {
+		log.Fatal("Unable to set up the geocoder: ", err)
+	}
+	server.Rater, err = rating.NewFromEnv()
+	if err != nil {
+		log.Fatal("Unable to load the rate tables: ", err)
+	}
+
+	expiryInterval := defaultExpiryInterval
+	if os.Getenv("ADDRESS_EXPIRY_INTERVAL") != "" {
+		expiryInterval, err = time.ParseDuration(os.Getenv("ADDRESS_EXPIRY_INTERVAL"))
+		if err != nil {
+			log.Fatal("Invalid ADDRESS_EXPIRY_INTERVAL: ", err)
+		}
+	}
+	webhookInterval := defaultWebhookInterval
+	if os.Getenv("WEBHOOK_DELIVERY_INTERVAL") != "" {
+		webhookInterval, err = time.ParseDuration(os.Getenv("WEBHOOK_DELIVERY_INTERVAL"))
+		if err != nil {
+			log.Fatal("Invalid WEBHOOK_DELIVERY_INTERVAL: ", err)
+		}
+	}
+	mailingJobInterval := defaultMailingJobInterval
+	if os.Getenv("MAILING_JOB_INTERVAL") != "" {
+		mailingJobInterval, err = time.ParseDuration(os.Getenv("MAILING_JOB_INTERVAL"))
+		if err != nil {
+			log.Fatal("Invalid MAILING_JOB_INTERVAL: ", err)
+		}
+	}
+	dispatcher := webhooks.NewDispatcher(server.Store)
+	server.Scheduler = scheduler.New(scheduler.Job{
+		Name:     "expire-addresses",
+		Interval: expiryInterval,
+		Run: func() error {
+			_, err := server.ExpireAddresses()
+			return err
+		},
+	}, scheduler.Job{
+		Name:     "deliver-webhooks",
+		Interval: webhookInterval,
+		Run: func() error {
+			_, err := dispatcher.Deliver(time.Now())
+			return err
+		},
+	}, scheduler.Job{
+		Name:     "process-mailing-jobs",
+		Interval: mailingJobInterval,
+		Run: func() error {
+			_, err := server.ProcessMailingJobs(time.Now())
+			return err
+		},
+	})
+
+	server.Router = mux.NewRouter()
+
+	server.initializeRoutes()
+}
+
+// Connect starts the DB connection. It is all that is needed to run admin commands
+func (server *Server) Connect(Dbdriver, DbUser, DbPassword, DbPort, DbHost, CloudHost, DbName string) {
 	var err error
 	DBURL := ""
 	if os.Getenv("APP_ENV") == "production" {
@@ -42,11 +143,23 @@ func (server *Server) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, C
 		fmt.Printf("Connected to the %s database\n", connectType)
 	}
 
-	server.DB.Debug().AutoMigrate(&models.User{}, &models.Address{}, &models.AddressAssignment{}, &models.Contact{}, &models.APIUser{}, &models.PackageDescription{}, &models.Package{}) //database migration
+	server.Store = repository.NewGorm(server.DB)
+	auth.SetRevocationStore(server.Store.Sessions())
+}
 
-	server.Router = mux.NewRouter()
+// Migrator loads the DB migrations from the migration directory
+func (server *Server) Migrator() (*migrations.Migrator, error) {
+	return migrations.New(server.DB, migrations.Dir())
+}
 
-	server.initializeRoutes()
+// ExpireAddresses moves every overdue address assignment to expired
+func (server *Server) ExpireAddresses() (int64, error) {
+	expired, err := server.Store.Assignments().Expire(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("Expired %d address assignments\n", expired)
+	return expired, nil
 }
 
 // Run alerts that the server is up and running
@@ -59,6 +172,29 @@ func (server *Server) Run(addr string) {
 		Debug:            true,
 	})
 
+	httpServer := &http.Server{Addr: addr, Handler: c.Handler(server.Router)}
+
+	// The scheduler runs for as long as the server does
+	if server.Scheduler != nil {
+		server.Scheduler.Start()
+	}
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		fmt.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
 	fmt.Println("Listening to port 8080")
-	log.Fatal(http.ListenAndServe(addr, c.Handler(server.Router)))
+	err := httpServer.ListenAndServe()
+	if server.Scheduler != nil {
+		server.Scheduler.Stop()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxBatchLookups is the most SmartIDs a single batch request can resolve
+const MaxBatchLookups = 1000
+
+// batchChunkSize is how many lookups are resolved with each set of queries. Results are streamed back a chunk at a time
+const batchChunkSize = 100
+
+// BatchLookup is one SmartID, or delivery token, in a batch request. The date defaults to today
+type BatchLookup struct {
+	SmartID string `json:"smart_id"`
+	Date    string `json:"date"`
+}
+
+// BatchAddressRequest is the body of a batch mailing address request
+type BatchAddressRequest struct {
+	Lookups []BatchLookup `json:"lookups"`
+}
+
+// batchFormat picks the result format from ?format= or the Accept header, JSON lines by default
+func batchFormat(r *http.Request) responses.BatchFormat {
+	format := r.URL.Query().Get("format")
+	if format == string(responses.CSV) || (format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv")) {
+		return responses.CSV
+	}
+	return responses.JSONLines
+}
+
+// GetMailingAddressesBySmartIDs resolves up to MaxBatchLookups SmartIDs to mailing addresses for bulk mailers. Each lookup is
+// resolved like GetMailingAddressBySmartID, with the same grants and disclosures, but a chunk of lookups shares a few queries.
+// Lookups that cannot be resolved are reported in their result rather than failing the batch
+func (server *Server) GetMailingAddressesBySmartIDs(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := BatchAddressRequest{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if len(request.Lookups) == 0 {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required lookups"))
+		return
+	}
+	if len(request.Lookups) > MaxBatchLookups {
+		responses.ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("A batch is limited to %d lookups", MaxBatchLookups))
+		return
+	}
+
+	writer := responses.NewBatchWriter(w, batchFormat(r))
+	for start := 0; start < len(request.Lookups); start += batchChunkSize {
+		end := start + batchChunkSize
+		if end > len(request.Lookups) {
+			end = len(request.Lookups)
+		}
+		results, err := server.resolveMailingBatch(r, request.Lookups[start:end], start)
+		if err != nil {
+			if !writer.Started() {
+				responses.ERROR(w, http.StatusInternalServerError, err)
+				return
+			}
+			writer.Fail(err)
+			return
+		}
+		err = writer.Write(results...)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// resolveMailingBatch resolves a chunk of lookups. offset is the index of the chunk's first lookup in the request
+func (server *Server) resolveMailingBatch(r *http.Request, lookups []BatchLookup, offset int) ([]responses.BatchAddressResult, error) {
+	principal := middlewares.PrincipalFromContext(r)
+	today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+
+	results := make([]responses.BatchAddressResult, len(lookups))
+	dates := make([]time.Time, len(lookups))
+	smartIDs := make([]string, len(lookups))
+	users := make([]*models.User, len(lookups))
+	tokens := make([]*models.DeliveryToken, len(lookups))
+	wanted := []string{}
+	for i, lookup := range lookups {
+		results[i] = responses.BatchAddressResult{Index: offset + i, SmartID: lookup.SmartID, Date: lookup.Date}
+		dates[i] = today
+		if lookup.Date != "" {
+			date, err := time.Parse("2006-01-02", lookup.Date)
+			if err != nil {
+				results[i].Error = "Date must be formatted YYYY-MM-DD"
+				continue
+			}
+			dates[i] = date
+		}
+		results[i].Date = dates[i].Format("2006-01-02")
+
+		// Tokens are looked up one at a time, their uses are only counted for the lookups that find an address
+		if models.IsDeliveryToken(lookup.SmartID) {
+			user, token, err := server.findAddressee(r, "", lookup.SmartID, models.MailDelivery)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			users[i], tokens[i] = user, token
+			continue
+		}
+		smartID, err := parseSmartID(server.Store, lookup.SmartID)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		smartIDs[i] = smartID
+		wanted = append(wanted, smartID)
+	}
+
+	found, err := server.Store.Users().FindBySmartIDs(wanted)
+	if err != nil {
+		return nil, err
+	}
+	bySmartID := map[string]models.User{}
+	for _, user := range *found {
+		bySmartID[user.SmartID] = user
+	}
+
+	uids := []uuid.UUID{}
+	from, to := time.Time{}, time.Time{}
+	for i := range lookups {
+		if smartIDs[i] != "" {
+			user, ok := bySmartID[smartIDs[i]]
+			if !ok {
+				results[i].Error = fmt.Sprintf("Unable to find smartID: %s", smartIDs[i])
+				continue
+			}
+			users[i] = &user
+		}
+		if users[i] == nil {
+			continue
+		}
+		uids = append(uids, users[i].ID)
+		if from.IsZero() || dates[i].Before(from) {
+			from = dates[i]
+		}
+		if to.IsZero() || dates[i].After(to) {
+			to = dates[i]
+		}
+	}
+
+	assignments, err := server.Store.Assignments().FindForUsers(uids, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	byUser := map[uuid.UUID][]models.AddressAssignment{}
+	for _, aa := range *assignments {
+		byUser[aa.UserID] = append(byUser[aa.UserID], aa)
+	}
+
+	addresses := make([]*models.AddressAssignment, len(lookups))
+	for i := range lookups {
+		if users[i] == nil {
+			continue
+		}
+		aa, ok := models.MailingAddressOn(byUser[users[i].ID], dates[i])
+		if !ok {
+			results[i].Error = "No active address"
+			continue
+		}
+		addresses[i] = aa
+	}
+
+	var decisions []models.GrantDecision
+	err = server.Store.Transaction(func(store repository.Store) error {
+		failed, err := useDeliveryTokens(store, principal, addresses, tokens)
+		if err != nil {
+			return err
+		}
+		for i := range failed {
+			if failed[i] != nil {
+				results[i].Error = failed[i].Error()
+			}
+		}
+		decisions, err = shareAddresses(store, principal, addresses, tokens)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, aa := range addresses {
+		if aa != nil {
+			results[i].Address = mailingAddressResponse(aa, decisions[i], tokens[i])
+		}
+	}
+	return results, nil
+}
+
+// mailingAddressResponse is the reply to a mailing address lookup, limited to the zip code unless the decision allows the full address
+func mailingAddressResponse(aa *models.AddressAssignment, decision models.GrantDecision, token *models.DeliveryToken) *responses.AddressSmartIDResponse {
+	reply := &responses.AddressSmartIDResponse{}
+	responses.TranslateSmartAddressResponse(aa, reply)
+	responses.RestrictToZip(reply, decision)
+	responses.HideSmartID(reply, token)
+	reply.DeliveryInstructions = ""
+	return reply
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
+)
+
+// GetCarriers lists every carrier in the carrier registry
+func (server *Server) GetCarriers(w http.ResponseWriter, r *http.Request) {
+	carriers, err := server.Store.Carriers().FindAll()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.CarriersResponse{Carriers: *carriers, Success: true})
+}
+
+// CreateCarrier adds a carrier to the carrier registry
+func (server *Server) CreateCarrier(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	carrier := models.Carrier{}
+	err = json.Unmarshal(body, &carrier)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	carrier.Prepare()
+	err = carrier.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	carrierCreated, err := server.Store.Carriers().Save(&carrier)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.RequestURI, carrierCreated.ID))
+	responses.JSON(w, http.StatusCreated, carrierCreated)
+}
+
+// UpdateCarrier updates a carrier in the carrier registry
+func (server *Server) UpdateCarrier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cid, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	carrier := models.Carrier{}
+	err = json.Unmarshal(body, &carrier)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	carrier.Prepare()
+	err = carrier.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	updatedCarrier, err := server.Store.Carriers().Update(&carrier, cid)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	responses.JSON(w, http.StatusOK, updatedCarrier)
+}
+
+// DeleteCarrier removes a carrier from the carrier registry
+func (server *Server) DeleteCarrier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cid, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	_, err = server.Store.Carriers().Delete(cid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Entity", fmt.Sprintf("%d", cid))
+	responses.JSON(w, http.StatusNoContent, "")
+}
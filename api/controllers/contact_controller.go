@@ -111,33 +111,24 @@ func (server *Server) AddContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contact := models.User{}
+	addContact.Contact.SmartID, err = parseSmartID(server.Store, addContact.Contact.SmartID)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
 
-	if addContact.Contact.Email != "" && addContact.Contact.Phone != "" {
-		err = server.DB.Debug().Model(models.User{}).Where("smart_id = ? AND email = ? AND phone = ?", addContact.Contact.SmartID, addContact.Contact.Email, addContact.Contact.Phone).Take(&contact).Error
-		if err != nil {
-			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unable to find contact"))
-			return
-		}
-	} else if addContact.Contact.Email != "" {
-		err = server.DB.Debug().Model(models.User{}).Where("smart_id = ? AND email = ?", addContact.Contact.SmartID, addContact.Contact.Email).Take(&contact).Error
-		if err != nil {
-			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unable to find contact"))
-			return
-		}
-	} else if addContact.Contact.Phone != "" {
-		err = server.DB.Debug().Model(models.User{}).Where("smart_id = ? AND phone = ?", addContact.Contact.SmartID, addContact.Contact.Phone).Take(&contact).Error
-		if err != nil {
-			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unable to find contact"))
-			return
-		}
-	} else {
+	// A contact can only be added by someone who knows their email or phone as well as their SmartID
+	if addContact.Contact.Email == "" && addContact.Contact.Phone == "" {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unable to find contact"))
+		return
+	}
+	contact, err := server.Store.Users().FindContact(addContact.Contact.SmartID, addContact.Contact.Email, addContact.Contact.Phone)
+	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unable to find contact"))
 		return
 	}
 
-	newContact := models.Contact{}
-	newContact, err = newContact.SaveContact(server.DB, addContact.UserID, contact.ID)
+	newContact, err := server.Store.Contacts().Save(addContact.UserID, contact.ID)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -159,9 +150,9 @@ func (server *Server) AddContact(w http.ResponseWriter, r *http.Request) {
 func (server *Server) pullContacts(uid uuid.UUID, limit int64, offset int64, sort string, search null.String) (totalContacts int64, contacts []responses.Contact, err error) {
 	var rawContacts []models.Contact
 	if search.Valid {
-		totalContacts, rawContacts, err = models.SearchContacts(server.DB, uid, limit, offset, search.String)
+		totalContacts, rawContacts, err = server.Store.Contacts().Search(uid, limit, offset, search.String)
 	} else {
-		totalContacts, rawContacts, err = models.GetContacts(server.DB, uid, limit, offset, sort)
+		totalContacts, rawContacts, err = server.Store.Contacts().FindForUser(uid, limit, offset, sort)
 	}
 	if err != nil {
 		return
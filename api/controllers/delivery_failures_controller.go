@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
+	"github.com/nmelhado/smartmail-api/api/webhooks"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// DeliveryFailureRequest is the body of a carrier's failed delivery report. The address is the address_assignment_id a lookup
+// returned, or it is found from the tracking number of a package the carrier saved
+type DeliveryFailureRequest struct {
+	AddressAssignmentID uint64               `json:"address_assignment_id"`
+	Tracking            string               `json:"tracking"`
+	Reason              models.FailureReason `json:"reason"`
+}
+
+// DeliveryReviewRequest is the body of an admin's decision on an address in the review queue
+type DeliveryReviewRequest struct {
+	Decision models.ReviewDecision `json:"decision"`
+}
+
+// deliveryConfidence scores an assignment from the reports made against it within models.FailureWindow
+func deliveryConfidence(store repository.Store, aaid uint64, now time.Time) (float64, bool, error) {
+	failures, err := store.DeliveryFailures().FindForAssignment(aaid, now.Add(-models.FailureWindow))
+	if err != nil {
+		return 0, false, err
+	}
+	return models.DeliveryConfidence(*failures, now), models.NeedsReview(*failures, now), nil
+}
+
+// deliveryWarning returns the lookup warning for a sender or recipient address carriers have reported as undeliverable.
+// Either assignment may be nil when it was not looked up
+func (server *Server) deliveryWarning(sender *models.AddressAssignment, recipient *models.AddressAssignment) (string, error) {
+	now := time.Now()
+	warnings := []string{}
+	for _, lookup := range []struct {
+		role string
+		aa   *models.AddressAssignment
+	}{{"sender", sender}, {"recipient", recipient}} {
+		if lookup.aa == nil || lookup.aa.ID == 0 {
+			continue
+		}
+		confidence, _, err := deliveryConfidence(server.Store, lookup.aa.ID, now)
+		if err != nil {
+			return "", err
+		}
+		if confidence < 1 {
+			warnings = append(warnings, fmt.Sprintf("Carriers have reported the %s's address as undeliverable (confidence %.2f).", lookup.role, confidence))
+		}
+	}
+	return strings.Join(warnings, " "), nil
+}
+
+// warnToAndFrom sets the delivery warning of the sender and recipient of a to and from lookup, and adds both to its overall warning
+func (server *Server) warnToAndFrom(reply *responses.ToAndFromAddressSmartIDResponse, sender *models.AddressAssignment, recipient *models.AddressAssignment) error {
+	senderWarning, err := server.deliveryWarning(sender, nil)
+	if err != nil {
+		return err
+	}
+	recipientWarning, err := server.deliveryWarning(nil, recipient)
+	if err != nil {
+		return err
+	}
+	reply.Sender.Warning = senderWarning
+	reply.Recipient.Warning = recipientWarning
+	reply.Warning = joinWarnings(reply.Warning, joinWarnings(senderWarning, recipientWarning))
+	return nil
+}
+
+// joinWarnings adds a warning to a lookup's existing warning
+func joinWarnings(warning string, more string) string {
+	if warning == "" || more == "" {
+		return warning + more
+	}
+	return warning + " " + more
+}
+
+// ReportDeliveryFailure lets a carrier report that it could not deliver to an address it was given. The report lowers the address's
+// confidence, which is shown in lookups, is listed as unseen for the user and sent to the webhooks of the API users linked to them,
+// and raises the address for review once it reaches the threshold
+func (server *Server) ReportDeliveryFailure(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	carrier, err := server.Store.Carriers().FindByAPIUserID(apiUserID)
+	if err != nil {
+		responses.ERROR(w, http.StatusForbidden, errors.New("Only carriers can report failed deliveries"))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	failureRequest := DeliveryFailureRequest{}
+	err = json.Unmarshal(body, &failureRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	trackingNumber := tracking.Normalize(failureRequest.Tracking)
+	aaid := failureRequest.AddressAssignmentID
+	if aaid == 0 {
+		if trackingNumber == "" {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required address_assignment_id or tracking"))
+			return
+		}
+		pkg, err := server.Store.Packages().FindByTrackingAndCarrier(apiUserID, trackingNumber)
+		if err != nil || !pkg.AddressAssignmentID.Valid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("Address not found"))
+			return
+		}
+		aaid = uint64(pkg.AddressAssignmentID.Int64)
+	}
+
+	// A carrier can only report addresses it was given in full, anything else is not found so that assignment IDs cannot be probed
+	count, _, err := server.Store.Disclosures().Find(models.DisclosureFilter{
+		APIUserID:           uuid.NullUUID{UUID: apiUserID, Valid: true},
+		AddressAssignmentID: null.IntFrom(int64(aaid)),
+		Scope:               models.AddressReadScope,
+	}, 1, 0)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if count == 0 {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Address not found"))
+		return
+	}
+	aa, err := server.Store.Assignments().FindByID(aaid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Address not found"))
+		return
+	}
+
+	failure := models.NewDeliveryFailure(*aa, *carrier, failureRequest.Reason, null.NewString(trackingNumber, trackingNumber != ""))
+	err = failure.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	// The report is only saved along with the user's notification, and the score is read in the same transaction so that it counts the report
+	now := time.Now()
+	var confidence float64
+	var underReview bool
+	err = server.Store.Transaction(func(store repository.Store) error {
+		_, err := store.DeliveryFailures().Save(&failure)
+		if err != nil {
+			return err
+		}
+		_, err = webhooks.EnqueueFailure(store, failure, aa, now)
+		if err != nil {
+			return err
+		}
+		confidence, underReview, err = deliveryConfidence(store, aaid, now)
+		return err
+	})
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	failure.Carrier = *carrier
+
+	responses.JSON(w, http.StatusCreated, responses.DeliveryConfidence{
+		Failure:     responses.TranslateDeliveryFailure(failure),
+		Confidence:  confidence,
+		UnderReview: underReview,
+	})
+}
+
+// GetDeliveryFailures lists the failed deliveries carriers have reported against a user's addresses, newest first.
+// ?unseen=true lists only the reports the user has not marked seen, which is how clients notify the user of new reports
+func (server *Server) GetDeliveryFailures(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	unseen := r.URL.Query().Get("unseen") == "true"
+
+	failures, err := server.Store.DeliveryFailures().FindForUser(uid, unseen)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	reply := responses.DeliveryFailuresResponse{Success: true, Failures: []responses.DeliveryFailure{}}
+	for _, df := range *failures {
+		failure := responses.TranslateDeliveryFailure(df)
+		address := responses.TranslateAddress(&df.AddressAssignment)
+		failure.Address = &address
+		reply.Failures = append(reply.Failures, failure)
+	}
+	responses.JSON(w, http.StatusOK, reply)
+}
+
+// MarkDeliveryFailuresSeen marks every failed delivery reported against a user's addresses as seen
+func (server *Server) MarkDeliveryFailuresSeen(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	_, err = server.Store.DeliveryFailures().MarkSeen(uid, time.Now())
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// GetDeliveryReviews lists the admin review queue: addresses whose confidence has reached models.ReviewThreshold with reports
+// that have not been reviewed, lowest confidence first
+func (server *Server) GetDeliveryReviews(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	unreviewed, err := server.Store.DeliveryFailures().FindUnreviewed(now.Add(-models.FailureWindow))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	reply := responses.DeliveryReviewsResponse{Reviews: []responses.DeliveryReview{}}
+	queued := map[uint64]bool{}
+	for _, df := range *unreviewed {
+		if queued[df.AddressAssignmentID] {
+			continue
+		}
+		queued[df.AddressAssignmentID] = true
+		failures, err := server.Store.DeliveryFailures().FindForAssignment(df.AddressAssignmentID, now.Add(-models.FailureWindow))
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !models.NeedsReview(*failures, now) {
+			continue
+		}
+		aa, err := server.Store.Assignments().FindByID(df.AddressAssignmentID)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		user, err := server.Store.Users().FindByID(aa.UserID)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		address, err := server.Store.Addresses().FindByID(aa.AddressID)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		aa.User, aa.Address = *user, *address
+		reply.Reviews = append(reply.Reviews, responses.DeliveryReview{
+			AddressAssignmentID: aa.ID,
+			UserID:              aa.UserID,
+			SmartID:             aa.User.SmartID,
+			Confidence:          models.DeliveryConfidence(*failures, now),
+			Address:             responses.TranslateAddress(aa),
+			Failures:            responses.TranslateDeliveryFailures(failures),
+		})
+	}
+	// Reviews start in order of their oldest unreviewed report, which breaks ties
+	sort.SliceStable(reply.Reviews, func(i, j int) bool {
+		return reply.Reviews[i].Confidence < reply.Reviews[j].Confidence
+	})
+	responses.JSON(w, http.StatusOK, reply)
+}
+
+// ReviewDeliveryFailures saves an admin's decision on an address in the review queue. Reports reviewed as valid no longer count
+// against the address, reports reviewed as invalid keep counting but take the address off the queue
+func (server *Server) ReviewDeliveryFailures(w http.ResponseWriter, r *http.Request) {
+	aaid, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	reviewRequest := DeliveryReviewRequest{}
+	err = json.Unmarshal(body, &reviewRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if reviewRequest.Decision != models.ReviewValid && reviewRequest.Decision != models.ReviewInvalid {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Decision must be valid or invalid"))
+		return
+	}
+
+	now := time.Now()
+	reviewed, err := server.Store.DeliveryFailures().Review(aaid, reviewRequest.Decision, middlewares.PrincipalFromContext(r).ID(), now)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if reviewed == 0 {
+		responses.ERROR(w, http.StatusNotFound, errors.New("No reports to review"))
+		return
+	}
+	confidence, _, err := deliveryConfidence(server.Store, aaid, now)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{"address_assignment_id": aaid, "reviewed": reviewed, "confidence": confidence})
+}
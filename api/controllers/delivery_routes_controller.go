@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/routing"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxRouteStops is the most stops a single delivery route can have
+const MaxRouteStops = 500
+
+// RouteStopRequest is one stop on a delivery route, either a package the carrier is delivering or a SmartID (or delivery token)
+type RouteStopRequest struct {
+	Tracking string `json:"tracking"`
+	SmartID  string `json:"smart_id"`
+}
+
+// DeliveryRouteRequest is the body of a delivery route request. The date defaults to today
+type DeliveryRouteRequest struct {
+	Depot geocode.Location   `json:"depot"`
+	Date  string             `json:"date"`
+	Stops []RouteStopRequest `json:"stops"`
+}
+
+// PlanDeliveryRoute resolves the package address of each stop like a package lookup, with the same grants and disclosures, and
+// returns the order to deliver them in from the depot. Held addresses, addresses the carrier may not see and stops that cannot be
+// resolved are returned separately, the route is never built from an address the carrier is not given
+func (server *Server) PlanDeliveryRoute(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := DeliveryRouteRequest{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if request.Depot == (geocode.Location{}) || math.Abs(request.Depot.Latitude) > 90 || math.Abs(request.Depot.Longitude) > 180 {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required depot latitude and longitude"))
+		return
+	}
+	if len(request.Stops) == 0 {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required stops"))
+		return
+	}
+	if len(request.Stops) > MaxRouteStops {
+		responses.ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("A route is limited to %d stops", MaxRouteStops))
+		return
+	}
+	date, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	if request.Date != "" {
+		date, err = time.Parse("2006-01-02", request.Date)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Date must be formatted YYYY-MM-DD"))
+			return
+		}
+	}
+
+	reply, err := server.resolveDeliveryRoute(r, apiUserID, request.Depot, request.Stops, date)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, reply)
+}
+
+// resolveDeliveryRoute finds each stop's address on the date, with the users and assignments loaded a few queries at a time like a
+// batch lookup, and orders the stops that can be delivered to
+func (server *Server) resolveDeliveryRoute(r *http.Request, apiUserID uuid.UUID, depot geocode.Location, stops []RouteStopRequest, date time.Time) (responses.DeliveryRoute, error) {
+	reply := responses.DeliveryRoute{Date: date.Format("2006-01-02"), Stops: []responses.RouteStop{}, Unroutable: []responses.UnroutableStop{}}
+	errs := make([]string, len(stops))
+	uids := make([]uuid.UUID, len(stops))
+	smartIDs := make([]string, len(stops))
+	tokens := make([]*models.DeliveryToken, len(stops))
+	wanted := []string{}
+	for i, stop := range stops {
+		switch {
+		case stop.Tracking != "":
+			// Carriers can only route the packages they are carrying
+			pkg, err := server.Store.Packages().FindByTrackingAndCarrier(apiUserID, tracking.Normalize(stop.Tracking))
+			if err != nil {
+				errs[i] = "Package not found"
+			} else if pkg.Delivered {
+				errs[i] = "Package has been delivered"
+			} else if !pkg.RecipientID.Valid {
+				errs[i] = "Package has no recipient"
+			} else {
+				uids[i] = pkg.RecipientID.UUID
+			}
+		case models.IsDeliveryToken(stop.SmartID):
+			// Tokens are looked up one at a time, their uses are only counted for the stops that find an address
+			user, token, err := server.findAddressee(r, "", stop.SmartID, models.PackageDelivery)
+			if err != nil {
+				errs[i] = err.Error()
+				continue
+			}
+			uids[i], tokens[i] = user.ID, token
+		case stop.SmartID != "":
+			smartID, err := parseSmartID(server.Store, stop.SmartID)
+			if err != nil {
+				errs[i] = err.Error()
+				continue
+			}
+			smartIDs[i] = smartID
+			wanted = append(wanted, smartID)
+		default:
+			errs[i] = "Required tracking or smartID"
+		}
+	}
+
+	found, err := server.Store.Users().FindBySmartIDs(wanted)
+	if err != nil {
+		return reply, err
+	}
+	bySmartID := map[string]uuid.UUID{}
+	for _, user := range *found {
+		bySmartID[user.SmartID] = user.ID
+	}
+	asking := []uuid.UUID{}
+	for i := range stops {
+		if smartIDs[i] != "" {
+			uid, ok := bySmartID[smartIDs[i]]
+			if !ok {
+				errs[i] = fmt.Sprintf("Unable to find smartID: %s", smartIDs[i])
+				continue
+			}
+			uids[i] = uid
+		}
+		if uids[i] != uuid.Nil {
+			asking = append(asking, uids[i])
+		}
+	}
+
+	assignments, err := server.Store.Assignments().FindForUsers(asking, date, date.AddDate(0, 0, 1))
+	if err != nil {
+		return reply, err
+	}
+	byUser := map[uuid.UUID][]models.AddressAssignment{}
+	for _, aa := range *assignments {
+		byUser[aa.UserID] = append(byUser[aa.UserID], aa)
+	}
+	addresses := make([]*models.AddressAssignment, len(stops))
+	for i := range stops {
+		if uids[i] == uuid.Nil {
+			continue
+		}
+		aa, ok := models.PackageAddressOn(byUser[uids[i]], date)
+		if !ok {
+			errs[i] = "No active address"
+			continue
+		}
+		addresses[i] = aa
+	}
+
+	var decisions []models.GrantDecision
+	principal := middlewares.PrincipalFromContext(r)
+	err = server.Store.Transaction(func(store repository.Store) error {
+		failed, err := useDeliveryTokens(store, principal, addresses, tokens)
+		if err != nil {
+			return err
+		}
+		for i := range failed {
+			if failed[i] != nil {
+				errs[i] = failed[i].Error()
+			}
+		}
+		decisions, err = shareAddresses(store, principal, addresses, tokens)
+		return err
+	})
+	if err != nil {
+		return reply, err
+	}
+
+	routed := []int{}
+	locations := []geocode.Location{}
+	replies := make([]*responses.AddressSmartIDResponse, len(stops))
+	for i, aa := range addresses {
+		if aa == nil {
+			continue
+		}
+		replies[i] = &responses.AddressSmartIDResponse{}
+		responses.TranslateSmartAddressResponse(aa, replies[i])
+		responses.RestrictToZip(replies[i], decisions[i])
+		responses.HideSmartID(replies[i], tokens[i])
+		switch {
+		case aa.IsHold():
+			errs[i] = fmt.Sprintf("Packages are held until %s", replies[i].HeldUntil)
+		case decisions[i] != models.GrantAllow:
+			errs[i] = "Recipient has not allowed their address to be shared"
+		case aa.Address.Latitude == 0 && aa.Address.Longitude == 0:
+			errs[i] = "Address has not been geocoded"
+		default:
+			routed = append(routed, i)
+			locations = append(locations, geocode.Location{Latitude: aa.Address.Latitude, Longitude: aa.Address.Longitude})
+		}
+	}
+
+	route := routing.Optimize(depot, locations)
+	for n, position := range route.Order {
+		i := routed[position]
+		reply.Stops = append(reply.Stops, responses.RouteStop{
+			Index:    i,
+			Tracking: stops[i].Tracking,
+			SmartID:  stops[i].SmartID,
+			LegMiles: roundMiles(route.Legs[n]),
+			Address:  replies[i],
+		})
+	}
+	reply.ReturnMiles = roundMiles(route.Return)
+	reply.Miles = roundMiles(route.Miles)
+	for i, stop := range stops {
+		if errs[i] != "" {
+			reply.Unroutable = append(reply.Unroutable, responses.UnroutableStop{
+				Index:    i,
+				Tracking: stop.Tracking,
+				SmartID:  stop.SmartID,
+				Error:    errs[i],
+				Address:  replies[i],
+			})
+		}
+	}
+	return reply, nil
+}
+
+// roundMiles rounds a distance to a hundredth of a mile for display
+func roundMiles(miles float64) float64 {
+	return math.Round(miles*100) / 100
+}
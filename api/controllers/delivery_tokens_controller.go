@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+)
+
+// findAddressee finds the user for a SmartID, or for a delivery token supplied in its place. The token must belong to the API
+// user making the request and be for the kind of delivery. Its use is only counted once the address is shared, see useDeliveryToken.
+// role names the user in errors, e.g. sender or recipient
+func (server *Server) findAddressee(r *http.Request, role string, reference string, kind models.DeliveryKind) (*models.User, *models.DeliveryToken, error) {
+	if models.IsDeliveryToken(reference) {
+		principal := middlewares.PrincipalFromContext(r)
+		if principal == nil || principal.APIUser == nil {
+			return &models.User{}, nil, models.ErrDeliveryTokenInvalid
+		}
+		token, err := server.Store.DeliveryTokens().Find(models.NormalizeDeliveryToken(reference), principal.APIUser.ID, kind)
+		if err != nil {
+			return &models.User{}, nil, err
+		}
+		user, err := server.Store.Users().FindByID(token.UserID)
+		if err != nil {
+			return &models.User{}, nil, models.ErrDeliveryTokenInvalid
+		}
+		return user, token, nil
+	}
+
+	smartID, err := parseSmartID(server.Store, reference)
+	if err != nil {
+		return &models.User{}, nil, err
+	}
+	user, err := server.Store.Users().FindBySmartID(smartID)
+	if err != nil {
+		if role == "" {
+			return &models.User{}, nil, fmt.Errorf("Unable to find smartID: %s", smartID)
+		}
+		return &models.User{}, nil, fmt.Errorf("Unable to find %s with smartID: %s", role, smartID)
+	}
+	return user, nil, nil
+}
+
+// useDeliveryToken counts a lookup that found an address against the delivery token it was made with, if any, and updates the
+// token's uses. It is called in the transaction that records the disclosure, so a lookup that fails does not spend a use
+func useDeliveryToken(store repository.Store, principal *models.Principal, token *models.DeliveryToken) error {
+	if token == nil {
+		return nil
+	}
+	used, err := store.DeliveryTokens().Use(token.Token, principal.APIUser.ID, token.Kind)
+	if err != nil {
+		return err
+	}
+	*token = *used
+	return nil
+}
+
+// useDeliveryTokens is useDeliveryToken for many lookups. A lookup whose token can no longer be used is dropped from addresses,
+// and its error is returned at its index
+func useDeliveryTokens(store repository.Store, principal *models.Principal, addresses []*models.AddressAssignment, tokens []*models.DeliveryToken) ([]error, error) {
+	failed := make([]error, len(addresses))
+	for i, aa := range addresses {
+		if aa == nil {
+			continue
+		}
+		err := useDeliveryToken(store, principal, tokens[i])
+		if errors.Is(err, models.ErrDeliveryTokenInvalid) {
+			failed[i] = err
+			addresses[i] = nil
+			continue
+		}
+		if err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// shareStatus is the status to respond with when shareAddress fails, a delivery token used up by another lookup meanwhile
+// is handled like findAddressee rejecting it
+func shareStatus(err error) int {
+	if errors.Is(err, models.ErrDeliveryTokenInvalid) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// addresseeStatus is the status to respond with when findAddressee fails, malformed SmartIDs are bad requests
+func addresseeStatus(err error) int {
+	if errors.Is(err, smartid.ErrInvalidFormat) || errors.Is(err, smartid.ErrInvalidCheckDigit) {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// GetDeliveryTokens lists the delivery tokens a user has minted, newest first
+func (server *Server) GetDeliveryTokens(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+
+	tokens, err := server.Store.DeliveryTokens().FindForUser(uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateDeliveryTokens(*tokens))
+}
+
+// CreateDeliveryToken mints a token that one API user can use in place of the user's SmartID
+func (server *Server) CreateDeliveryToken(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	deliveryToken := models.DeliveryToken{}
+	err = json.Unmarshal(body, &deliveryToken)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	deliveryToken.Prepare()
+	deliveryToken.UserID = uid
+	err = deliveryToken.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	apiUser, err := server.Store.APIUsers().FindByID(deliveryToken.APIUserID)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("API user not found"))
+		return
+	}
+
+	savedToken, err := server.Store.DeliveryTokens().Save(&deliveryToken)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	savedToken.APIUser = *apiUser
+	responses.JSON(w, http.StatusCreated, responses.TranslateDeliveryToken(*savedToken))
+}
+
+// RevokeDeliveryToken stops a user's delivery token from working
+func (server *Server) RevokeDeliveryToken(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	dtid, err := strconv.ParseUint(mux.Vars(r)["token_id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = server.Store.DeliveryTokens().Revoke(uid, dtid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Entity", fmt.Sprintf("%d", dtid))
+	responses.JSON(w, http.StatusNoContent, "")
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// defaultDisclosureLimit is the page size when a disclosure request does not set a limit
+const defaultDisclosureLimit = 100
+
+// recordDisclosures logs that the API user or retailer making the request was shown each assignment, token is set when they were looked up
+// with a delivery token. Retailers are only ever given zip codes. Handlers call it before responding, if it fails the address must not be
+// returned. store may be a transaction
+func recordDisclosures(store repository.Store, r *http.Request, scope models.Scope, tracking null.String, token *models.DeliveryToken, assignments ...*models.AddressAssignment) error {
+	principal := middlewares.PrincipalFromContext(r)
+	if principal == nil {
+		return nil
+	}
+	retailer := principal.User != nil && principal.User.Authority == models.RetailerAuth
+	if principal.APIUser == nil && !retailer {
+		return nil
+	}
+	for _, aa := range assignments {
+		var disclosure models.AddressDisclosure
+		if retailer {
+			disclosure = models.NewRetailerDisclosure(*principal.User, *aa)
+		} else {
+			disclosure = models.NewAddressDisclosure(*principal.APIUser, *aa, scope, tracking)
+		}
+		if token != nil {
+			disclosure.DeliveryTokenID = null.IntFrom(int64(token.ID))
+		}
+		_, err := store.Disclosures().Save(&disclosure)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserDisclosures lists who has been shown a user's address, newest first
+func (server *Server) GetUserDisclosures(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uid, err := uuid.FromString(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	tokenID, err := auth.ExtractUITokenID(r)
+	if err != nil || tokenID != uid {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	limit, offset, err := disclosurePage(r.URL.Query().Get("limit"), r.URL.Query().Get("page"))
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	filter := models.DisclosureFilter{UserID: uuid.NullUUID{UUID: uid, Valid: true}}
+	count, disclosures, err := server.Store.Disclosures().Find(filter, limit, offset)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateAddressDisclosures(count, disclosures))
+}
+
+// GetDisclosures lets an admin query the disclosure log by user, SmartID, API user, tracking number and time
+func (server *Server) GetDisclosures(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.DisclosureFilter{}
+
+	if query.Get("user_id") != "" {
+		uid, err := uuid.FromString(query.Get("user_id"))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.UserID = uuid.NullUUID{UUID: uid, Valid: true}
+	}
+	if query.Get("smart_id") != "" {
+		smartID, err := parseSmartID(server.Store, query.Get("smart_id"))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		user, err := server.Store.Users().FindBySmartID(smartID)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("Unable to find smartID: %s", smartID))
+			return
+		}
+		filter.UserID = uuid.NullUUID{UUID: user.ID, Valid: true}
+	}
+	if query.Get("api_user_id") != "" {
+		aid, err := uuid.FromString(query.Get("api_user_id"))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.APIUserID = uuid.NullUUID{UUID: aid, Valid: true}
+	}
+	if trackingNumber := tracking.Normalize(query.Get("tracking")); trackingNumber != "" {
+		filter.Tracking = null.StringFrom(trackingNumber)
+	}
+	for _, bound := range []struct {
+		name  string
+		value *null.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if query.Get(bound.name) == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", query.Get(bound.name))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		*bound.value = null.TimeFrom(parsed)
+	}
+
+	limit, offset, err := disclosurePage(query.Get("limit"), query.Get("page"))
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	count, disclosures, err := server.Store.Disclosures().Find(filter, limit, offset)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateAddressDisclosures(count, disclosures))
+}
+
+// disclosurePage converts the limit and page query values into a limit and offset, pages start at 1
+func disclosurePage(limitQuery string, pageQuery string) (int64, int64, error) {
+	limit := int64(defaultDisclosureLimit)
+	if limitQuery != "" {
+		limitConvert, err := strconv.Atoi(limitQuery)
+		if err != nil || limitConvert < 1 {
+			return 0, 0, errors.New("Invalid limit")
+		}
+		limit = int64(limitConvert)
+	}
+	var offset int64
+	if pageQuery != "" {
+		pageConvert, err := strconv.Atoi(pageQuery)
+		if err != nil || pageConvert < 1 {
+			return 0, 0, errors.New("Invalid page")
+		}
+		offset = (int64(pageConvert) - 1) * limit
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// shareAddress applies the user's grant for the API user making the request and records the disclosure.
+// It returns GrantAllow when the full address may be returned, any other decision means only the zip code may be.
+// API users with the admin scope are not subject to grants, and neither are lookups made with a delivery token
+// because the user minted the token for that API user
+func (server *Server) shareAddress(r *http.Request, aa *models.AddressAssignment, tracking null.String, token *models.DeliveryToken) (models.GrantDecision, error) {
+	decision := models.GrantAllow
+	principal := middlewares.PrincipalFromContext(r)
+	err := server.Store.Transaction(func(store repository.Store) error {
+		err := useDeliveryToken(store, principal, token)
+		if err != nil {
+			return err
+		}
+		if grantsApply(principal, token) {
+			grant, err := store.Grants().Request(aa.UserID, principal.APIUser.ID)
+			if err != nil {
+				return err
+			}
+			decision = grant.Effective(time.Now())
+		}
+		return recordDisclosures(store, r, disclosureScope(decision), tracking, token, aa)
+	})
+	if err != nil {
+		return "", err
+	}
+	return decision, nil
+}
+
+// grantsApply returns true if the principal's lookup is subject to the user's grant, see shareAddress
+func grantsApply(principal *models.Principal, token *models.DeliveryToken) bool {
+	return token == nil && principal != nil && principal.APIUser != nil && !principal.HasScope(models.AdminScope)
+}
+
+// disclosureScope is the scope a disclosure is recorded with, ZipReadScope unless the full address was shared
+func disclosureScope(decision models.GrantDecision) models.Scope {
+	if decision != models.GrantAllow {
+		return models.ZipReadScope
+	}
+	return models.AddressReadScope
+}
+
+// shareAddresses is shareAddress for many lookups by one principal, with a single grant request and a single disclosure insert.
+// tokens holds the delivery token of each lookup, if any, and a nil address is skipped. store may be a transaction
+func shareAddresses(store repository.Store, principal *models.Principal, addresses []*models.AddressAssignment, tokens []*models.DeliveryToken) ([]models.GrantDecision, error) {
+	asking := []uuid.UUID{}
+	for i, aa := range addresses {
+		if aa != nil && grantsApply(principal, tokens[i]) {
+			asking = append(asking, aa.UserID)
+		}
+	}
+	grants := map[uuid.UUID]models.ShareGrant{}
+	if len(asking) > 0 {
+		var err error
+		grants, err = store.Grants().RequestAll(asking, principal.APIUser.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	decisions := make([]models.GrantDecision, len(addresses))
+	disclosures := []models.AddressDisclosure{}
+	for i, aa := range addresses {
+		if aa == nil {
+			continue
+		}
+		decisions[i] = models.GrantAllow
+		if grantsApply(principal, tokens[i]) {
+			grant := grants[aa.UserID]
+			decisions[i] = grant.Effective(now)
+		}
+		if principal != nil && principal.APIUser != nil {
+			disclosure := models.NewAddressDisclosure(*principal.APIUser, *aa, disclosureScope(decisions[i]), null.String{})
+			if tokens[i] != nil {
+				disclosure.DeliveryTokenID = null.IntFrom(int64(tokens[i].ID))
+			}
+			disclosures = append(disclosures, disclosure)
+		}
+	}
+	return decisions, store.Disclosures().SaveAll(disclosures)
+}
+
+// userFromToken checks that the UI token belongs to the user in the route
+func userFromToken(r *http.Request) (uuid.UUID, error) {
+	uid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		return uuid.Nil, err
+	}
+	tokenID, err := auth.ExtractUITokenID(r)
+	if err != nil || tokenID != uid {
+		return uuid.Nil, errors.New(http.StatusText(http.StatusUnauthorized))
+	}
+	return uid, nil
+}
+
+// GetShareGrants lists a user's address sharing grants, ?pending=true lists only the requests waiting for an answer
+func (server *Server) GetShareGrants(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	pending := r.URL.Query().Get("pending") == "true"
+
+	grants, err := server.Store.Grants().FindForUser(uid, pending)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateShareGrants(*grants))
+}
+
+// SaveShareGrant allows, denies or asks about sharing a user's full address with an API user. It also answers a pending request
+func (server *Server) SaveShareGrant(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	grant := models.ShareGrant{}
+	err = json.Unmarshal(body, &grant)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	grant.Prepare()
+	grant.UserID = uid
+	err = grant.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	_, err = server.Store.APIUsers().FindByID(grant.APIUserID)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("API user not found"))
+		return
+	}
+
+	savedGrant, err := server.Store.Grants().Save(&grant)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateShareGrant(*savedGrant))
+}
+
+// DeleteShareGrant removes a user's grant, the API user gets the zip code only and the user is asked again on its next lookup
+func (server *Server) DeleteShareGrant(w http.ResponseWriter, r *http.Request) {
+	uid, err := userFromToken(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	gid, err := strconv.ParseUint(mux.Vars(r)["grant_id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, err = server.Store.Grants().Delete(uid, gid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Entity", fmt.Sprintf("%d", gid))
+	responses.JSON(w, http.StatusNoContent, "")
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nmelhado/smartmail-api/api/labels"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
+)
+
+// CreateShippingLabel resolves a package's sender and recipient like ShipperProvidePackageAddressToAndFromBySmartID and returns a 4x6
+// shipping label for it. ?format= is pdf (the default) or zpl
+func (server *Server) CreateShippingLabel(w http.ResponseWriter, r *http.Request) {
+	format, err := labels.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	addressAndInfoRequest, status, err := parseShipperRequest(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+	if !addressAndInfoRequest.RecipientSmartID.Valid {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required recipient smartID"))
+		return
+	}
+	if tracking.Normalize(addressAndInfoRequest.Tracking) == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required tracking"))
+		return
+	}
+	// A label is only printed for a package that is saved, so the carrier must be known
+	carrier, err := resolveCarrier(server.Store, addressAndInfoRequest.Carrier, tracking.Normalize(addressAndInfoRequest.Tracking))
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("Invalid carrier: %v", err))
+		return
+	}
+
+	addresses, status, err := server.resolveShipment(r, &addressAndInfoRequest)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+	if addresses.Recipient.Consent != "" {
+		responses.ERROR(w, http.StatusForbidden, errors.New("Recipient has not allowed their address to be shared"))
+		return
+	}
+
+	label, err := labels.Render(labels.Label{
+		From:     addresses.Sender,
+		To:       addresses.Recipient,
+		Carrier:  carrier.Code,
+		Tracking: addressAndInfoRequest.Tracking,
+		Date:     addressAndInfoRequest.TargetDate,
+	}, format)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "label-"+addressAndInfoRequest.Tracking+"."+string(format)))
+	w.WriteHeader(http.StatusCreated)
+	w.Write(label)
+}
@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/nmelhado/smartmail-api/api/auth"
 	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
 	"github.com/nmelhado/smartmail-api/api/responses"
 	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
 	uuid "github.com/satori/go.uuid"
@@ -35,7 +37,7 @@ func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	token, validUser, err := server.SignIn(user.Email, user.Password)
+	tokens, validUser, err := server.SignIn(user.Email, user.Password)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
@@ -50,10 +52,12 @@ func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := responses.UserAndAddressResponse{
-		User:      finalUser,
-		Addresses: addresses,
-		Token:     token,
-		Expires:   time.Now().Add(time.Hour * 1),
+		User:           finalUser,
+		Addresses:      addresses,
+		Token:          tokens.Token,
+		Expires:        tokens.Expires,
+		RefreshToken:   tokens.RefreshToken,
+		RefreshExpires: tokens.RefreshExpires,
 	}
 	responses.JSON(w, http.StatusOK, response)
 }
@@ -79,20 +83,130 @@ func (server *Server) Token(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := server.SignInAPIUser(aUser.Username, aUser.Password)
+	tokens, err := server.SignInAPIUser(aUser.Username, aUser.Password)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
 		return
 	}
 
-	response := responses.TokenResponse{
-		Token:   token,
-		Expires: time.Now().Add(time.Hour * 1),
+	response := responses.TranslateTokenResponse(tokens)
+	responses.JSON(w, http.StatusOK, response)
+}
+
+// errRefreshTokenUsed rolls back a refresh when its session was revoked by a concurrent refresh
+var errRefreshTokenUsed = errors.New("Refresh token has already been used")
+
+// RefreshTokenRequest is the struct to receive a refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new access token and refresh token.
+// The old refresh token's session is revoked, so every refresh token can only be used once
+func (server *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	refreshRequest := RefreshTokenRequest{}
+	err = json.Unmarshal(body, &refreshRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	uid, _, sessionID, err := auth.ExtractRefreshToken(refreshRequest.RefreshToken)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
 	}
+
+	session, err := server.Store.Sessions().FindByID(sessionID)
+	if err != nil || !session.Active() || session.UserID != uid {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	// The permission is looked up again rather than copied from the old token, so permission changes take effect on refresh
+	authority := "ui"
+	if session.Kind == models.APISession {
+		aUser, err := server.Store.APIUsers().FindByID(uid)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		authority = string(aUser.Permission)
+	} else {
+		_, err = server.Store.Users().FindByID(uid)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+	}
+
+	var tokens auth.TokenPair
+	err = server.Store.Transaction(func(store repository.Store) error {
+		revoked, err := store.Sessions().Revoke(sessionID)
+		if err != nil {
+			return err
+		}
+		// Another request exchanged the refresh token after it was checked above
+		if revoked != 1 {
+			return errRefreshTokenUsed
+		}
+		tokens, err = startSession(store, uid, session.Kind, authority)
+		return err
+	})
+	if err == errRefreshTokenUsed {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := responses.TranslateTokenResponse(tokens)
 	responses.JSON(w, http.StatusOK, response)
 }
 
+// Logout revokes the session of the access token used to make the request, along with its refresh token
+func (server *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := auth.ExtractSessionID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	_, err = server.Store.Sessions().Revoke(sessionID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, responses.LogoutResponse{Success: true})
+}
+
+// RevokeUserSessions revokes every session for a UI or API user
+func (server *Server) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uid, err := uuid.FromString(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	revoked, err := server.Store.Sessions().RevokeAllForUser(uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, responses.RevokeSessionsResponse{Success: true, Revoked: revoked})
+}
+
 // RequestResetPassword finds a user by email and then returns a password reset token
 func (server *Server) RequestResetPassword(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
@@ -107,9 +221,7 @@ func (server *Server) RequestResetPassword(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user := models.User{}
-
-	err = server.DB.Debug().Model(models.User{}).Where("email = ?", requestedUser.Email).Take(&user).Error
+	user, err := server.Store.Users().FindByEmail(requestedUser.Email)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
@@ -142,9 +254,7 @@ func (server *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := &models.User{}
-
-	user, err = user.FindUserByID(server.DB, reqUID)
+	user, err := server.Store.Users().FindByID(reqUID)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -168,7 +278,7 @@ func (server *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err = user.UpdateAUser(server.DB, reqUID)
+	user, err = server.Store.Users().Update(user, reqUID)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
@@ -182,49 +292,52 @@ func (server *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	responses.JSON(w, http.StatusOK, response)
 }
 
-// SignIn retrieves an auth token that is used for UI originating API endpoints
-func (server *Server) SignIn(email, password string) (string, models.User, error) {
+// SignIn starts a session and retrieves the auth tokens that are used for UI originating API endpoints
+func (server *Server) SignIn(email, password string) (auth.TokenPair, models.User, error) {
 
-	var err error
-
-	user := models.User{}
-
-	err = server.DB.Debug().Model(models.User{}).Where("email = ?", email).Take(&user).Error
+	user, err := server.Store.Users().FindByEmail(email)
 	if err != nil {
-		return "", models.User{}, errors.New("User Not Found")
+		return auth.TokenPair{}, models.User{}, errors.New("User Not Found")
 	}
 	err = models.VerifyPassword(user.Password, password)
 	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", models.User{}, err
+		return auth.TokenPair{}, models.User{}, err
 	}
-	token, err := auth.CreateToken(user.ID, "ui")
-	return token, user, err
+	tokens, err := startSession(server.Store, user.ID, models.UISession, "ui")
+	return tokens, *user, err
 }
 
-// SignInAPIUser retrieves an auth token that is used for API endpoints
-func (server *Server) SignInAPIUser(username, password string) (string, error) {
-
-	var err error
+// SignInAPIUser starts a session and retrieves the auth tokens that are used for API endpoints
+func (server *Server) SignInAPIUser(username, password string) (auth.TokenPair, error) {
 
-	aUser := models.APIUser{}
-
-	err = server.DB.Debug().Model(models.APIUser{}).Where("username = ?", username).Take(&aUser).Error
+	aUser, err := server.Store.APIUsers().FindByUsername(username)
 	if err != nil {
-		return "", errors.New("User Not Found")
+		return auth.TokenPair{}, errors.New("User Not Found")
 	}
 	err = models.VerifyPassword(aUser.Password, password)
 	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", err
+		return auth.TokenPair{}, err
 	}
-	token, err := auth.CreateToken(aUser.ID, string(aUser.Permission))
-	return token, err
+	return startSession(server.Store, aUser.ID, models.APISession, string(aUser.Permission))
+}
+
+// startSession saves a new session and creates the access and refresh tokens for it
+func startSession(store repository.Store, userID uuid.UUID, kind string, authority string) (auth.TokenPair, error) {
+	session := models.Session{
+		UserID:    userID,
+		Kind:      kind,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenDuration),
+	}
+	_, err := store.Sessions().Save(&session)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	return auth.CreateTokenPair(userID, authority, session.ID, session.ExpiresAt)
 }
 
 // RetrieveAllAddresses retrieves all non deleted addresses for a user
 func (server *Server) RetrieveAllAddresses(userID uuid.UUID) (finalAddresses []responses.BasicAddress, err error) {
-	addressAssignment := models.AddressAssignment{}
-
-	addresses, err := addressAssignment.FindAllActiveAddressesForUser(server.DB, userID)
+	addresses, err := server.Store.Assignments().FindAllActiveForUser(userID)
 	if err != nil {
 		return
 	}
@@ -236,9 +349,7 @@ func (server *Server) RetrieveAllAddresses(userID uuid.UUID) (finalAddresses []r
 
 // RetrieveAllUserAddresses retrieves all non deleted addresses for a user
 func (server *Server) RetrieveAllUserAddresses(user models.User) (finalAddresses []responses.BasicAddress, finalUser responses.CreateUserResponse, err error) {
-	addressAssignment := models.AddressAssignment{}
-
-	addresses, err := addressAssignment.FindAllActiveAddressesForUser(server.DB, user.ID)
+	addresses, err := server.Store.Assignments().FindAllActiveForUser(user.ID)
 	if err != nil {
 		return
 	}
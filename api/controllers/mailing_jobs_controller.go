@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxMailingListBytes is the largest mailing list that can be uploaded
+const maxMailingListBytes = 50 << 20
+
+const (
+	// mailingJobLease is how long the worker holds a job between saving its progress. A job whose worker stopped is picked up again once it runs out
+	mailingJobLease = 5 * time.Minute
+	// mailingJobChunkSize is how many rows the worker resolves between saving its progress
+	mailingJobChunkSize = 100
+	// mailingResultPage is how many rows are read at a time while a result file is written
+	mailingResultPage = 1000
+)
+
+// CreateMailingJob queues a mailing list to be resolved in the background and returns the job. The list is CSV, sent as the body or as
+// the file field of a multipart form, with a smart_id column. ?date= (YYYY-MM-DD, default today) is used for rows without a date column
+func (server *Server) CreateMailingJob(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	mailDate, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	if r.URL.Query().Get("date") != "" {
+		mailDate, err = time.Parse("2006-01-02", r.URL.Query().Get("date"))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Date must be formatted YYYY-MM-DD"))
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMailingListBytes)
+	var upload io.Reader = r.Body
+	fileName := r.URL.Query().Get("file_name")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required file"))
+			return
+		}
+		defer file.Close()
+		upload = file
+		fileName = header.Filename
+	}
+
+	job := models.NewMailingJob(apiUserID, fileName, mailDate)
+	rows, err := job.ReadCSV(upload)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	err = server.Store.Transaction(func(store repository.Store) error {
+		_, err := store.MailingJobs().Save(&job)
+		if err != nil {
+			return err
+		}
+		return store.MailingJobs().SaveRows(rows)
+	})
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, job.ID))
+	responses.JSON(w, http.StatusAccepted, responses.TranslateMailingJob(job))
+}
+
+// findMailingJob loads the job in the route for the API user making the request, writing the error response if it cannot
+func (server *Server) findMailingJob(w http.ResponseWriter, r *http.Request) (*models.MailingJob, bool) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+	jid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	job, err := server.Store.MailingJobs().FindByID(apiUserID, jid)
+	if gorm.IsRecordNotFoundError(err) {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Mailing job not found"))
+		return nil, false
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return job, true
+}
+
+// GetMailingJob returns the status and progress of one of the API user's jobs
+func (server *Server) GetMailingJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := server.findMailingJob(w, r)
+	if !ok {
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateMailingJob(*job))
+}
+
+// CancelMailingJob stops one of the API user's jobs that has not finished
+func (server *Server) CancelMailingJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := server.findMailingJob(w, r)
+	if !ok {
+		return
+	}
+	err := server.Store.MailingJobs().Cancel(job.APIUserID, job.ID, time.Now())
+	if err != nil {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	w.Header().Set("Entity", job.ID.String())
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// GetMailingJobResult downloads a completed job's mailing list as CSV, with the address columns appended to every row
+func (server *Server) GetMailingJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := server.findMailingJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != models.JobCompleted {
+		responses.ERROR(w, http.StatusConflict, fmt.Errorf("Mailing job is %s", job.Status))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "smartmail-"+job.ID.String()+".csv"))
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	writer.Write(append(append([]string{}, job.Header...), responses.MailingColumns()...))
+	for offset := int64(0); ; offset += mailingResultPage {
+		rows, err := server.Store.MailingJobs().FindRows(job.ID, mailingResultPage, offset)
+		if err != nil {
+			log.Printf("Unable to write the result of mailing job %s: %v", job.ID, err)
+			return
+		}
+		for _, row := range *rows {
+			writer.Write(append(append([]string{}, row.Record...), row.Result...))
+		}
+		writer.Flush()
+		if len(*rows) < mailingResultPage {
+			return
+		}
+	}
+}
+
+// ProcessMailingJobs removes the jobs past their retention period, then resolves every job that is waiting, one chunk of rows at a time.
+// now is when processing starts, the clock runs on from it so that every claim and lease renewal is made with the current time.
+// It returns how many jobs it worked on
+func (server *Server) ProcessMailingJobs(now time.Time) (int, error) {
+	started := time.Now()
+	clock := func() time.Time {
+		return now.Add(time.Since(started))
+	}
+	_, err := server.Store.MailingJobs().DeleteExpired(now)
+	if err != nil {
+		return 0, err
+	}
+	worked := 0
+	for {
+		job, err := server.Store.MailingJobs().Claim(clock(), mailingJobLease)
+		if gorm.IsRecordNotFoundError(err) {
+			return worked, nil
+		}
+		if err != nil {
+			return worked, err
+		}
+		worked++
+		err = server.runMailingJob(job, clock)
+		if err == models.ErrMailingJobCancelled {
+			continue
+		}
+		if err != nil {
+			// The job is left running, it is picked up again once its lease runs out
+			return worked, err
+		}
+	}
+}
+
+// runMailingJob resolves a claimed job's rows until none are left, saving progress and renewing the lease after each chunk.
+// The API user is loaded again before each chunk, so a job fails once its API user can no longer read addresses.
+// clock returns the current time
+func (server *Server) runMailingJob(job *models.MailingJob, clock func() time.Time) error {
+	for {
+		apiUser, err := server.Store.APIUsers().FindByID(job.APIUserID)
+		if gorm.IsRecordNotFoundError(err) {
+			job.Finish(models.JobFailed, clock(), "API user not found")
+			return server.Store.MailingJobs().Finish(job)
+		}
+		if err != nil {
+			return err
+		}
+		principal := &models.Principal{APIUser: apiUser}
+		if !principal.HasScope(models.AddressReadScope) {
+			job.Finish(models.JobFailed, clock(), "API user is no longer allowed to read addresses")
+			return server.Store.MailingJobs().Finish(job)
+		}
+
+		rows, err := server.Store.MailingJobs().FindPendingRows(job.ID, mailingJobChunkSize)
+		if err != nil {
+			return err
+		}
+		now := clock()
+		if len(*rows) == 0 {
+			job.Finish(models.JobCompleted, now, "")
+			return server.Store.MailingJobs().Finish(job)
+		}
+		// The grants and disclosures of a chunk are only kept with its results, so a cancelled chunk discloses nothing
+		err = server.Store.Transaction(func(store repository.Store) error {
+			failed, err := resolveMailingRows(store, principal, job, *rows, now)
+			if err != nil {
+				return err
+			}
+			err = store.MailingJobs().SaveResults(*rows)
+			if err != nil {
+				return err
+			}
+			// The lease is renewed from when the chunk was resolved, not from when the job was claimed
+			return store.MailingJobs().UpdateProgress(job, len(*rows), failed, clock().Add(mailingJobLease))
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resolveMailingRows looks up the mailing address of each row with FindMailingAddress, applying grants like a single lookup,
+// and fills in each row's result. It returns how many rows could not be resolved
+func resolveMailingRows(store repository.Store, principal *models.Principal, job *models.MailingJob, rows []models.MailingJobRow, now time.Time) (int, error) {
+	reasons := make([]string, len(rows))
+	dates := make([]time.Time, len(rows))
+	smartIDs := make([]string, len(rows))
+	wanted := []string{}
+	for i, row := range rows {
+		date, err := job.RowDate(row)
+		if err != nil {
+			reasons[i] = err.Error()
+			continue
+		}
+		dates[i] = date
+		smartID, err := parseSmartID(store, job.RowSmartID(row))
+		if err != nil {
+			reasons[i] = err.Error()
+			continue
+		}
+		smartIDs[i] = smartID
+		wanted = append(wanted, smartID)
+	}
+
+	found, err := store.Users().FindBySmartIDs(wanted)
+	if err != nil {
+		return 0, err
+	}
+	bySmartID := map[string]models.User{}
+	for _, user := range *found {
+		bySmartID[user.SmartID] = user
+	}
+
+	addresses := make([]*models.AddressAssignment, len(rows))
+	for i := range rows {
+		if smartIDs[i] == "" {
+			continue
+		}
+		user, ok := bySmartID[smartIDs[i]]
+		if !ok {
+			reasons[i] = fmt.Sprintf("Unable to find smartID: %s", smartIDs[i])
+			continue
+		}
+		aa, err := store.Assignments().FindMailingAddress(user, dates[i])
+		if gorm.IsRecordNotFoundError(err) || (err == nil && aa.ID == 0) {
+			reasons[i] = "No active address"
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		addresses[i] = aa
+	}
+
+	decisions, err := shareAddresses(store, principal, addresses, make([]*models.DeliveryToken, len(rows)))
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	for i, aa := range addresses {
+		var address *responses.AddressSmartIDResponse
+		if aa != nil {
+			address = mailingAddressResponse(aa, decisions[i], nil)
+		} else {
+			failed++
+			rows[i].Error.SetValid(reasons[i])
+		}
+		rows[i].Result = responses.MailingRecord(address, reasons[i])
+		rows[i].ProcessedAt.SetValid(now)
+	}
+	return failed, nil
+}
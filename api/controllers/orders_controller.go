@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// OrderRequest is the body of a retailer's new order. ShipDate is YYYY-MM-DD and defaults to today
+type OrderRequest struct {
+	SmartID   string `json:"smart_id"`
+	Reference string `json:"reference"`
+	ShipDate  string `json:"ship_date"`
+}
+
+// HandOffRequest is the body of a retailer handing an order to a carrier. Carrier is the registry code, it is detected from the
+// tracking number when it is left out
+type HandOffRequest struct {
+	Carrier  string `json:"carrier"`
+	Tracking string `json:"tracking"`
+}
+
+// MakeRetailer lets a user take orders as a retailer, with the logos and redirect URL shown to their customers
+func (server *Server) MakeRetailer(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	branding := models.User{}
+	err = json.Unmarshal(body, &branding)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	err = branding.ValidateRetailer()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	retailer, err := server.Store.Users().UpdateRetailer(&branding, uid)
+	if gorm.IsRecordNotFoundError(err) {
+		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.SenderRecipient{
+		Name:        null.StringFrom(retailer.FirstName + " " + retailer.LastName),
+		SmartID:     null.StringFrom(retailer.SmartID),
+		LargeLogo:   retailer.LargeLogo,
+		SmallLogo:   retailer.SmallLogo,
+		RedirectURL: retailer.RedirectURL,
+		Role:        null.StringFrom(string(retailer.Authority)),
+	})
+}
+
+// retailerFromPrincipal returns the retailer making the request
+func retailerFromPrincipal(r *http.Request) (*models.User, error) {
+	principal := middlewares.PrincipalFromContext(r)
+	if principal == nil || principal.User == nil || principal.User.Authority != models.RetailerAuth {
+		return nil, errors.New(http.StatusText(http.StatusUnauthorized))
+	}
+	return principal.User, nil
+}
+
+// orderCarrier returns the code of the carrier the order was handed off to, if any
+func (server *Server) orderCarrier(order *models.Order) (string, error) {
+	if !order.CarrierID.Valid {
+		return "", nil
+	}
+	carrier, err := server.Store.Carriers().FindByID(uint64(order.CarrierID.Int64))
+	if err != nil {
+		return "", err
+	}
+	return carrier.Code, nil
+}
+
+// CreateOrder starts an order for the customer with the SmartID they gave at checkout. The retailer is given the zip code of
+// their package address on the ship date to quote shipping, never the address
+func (server *Server) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	retailer, err := retailerFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	orderRequest := OrderRequest{}
+	err = json.Unmarshal(body, &orderRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	shipDate, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	if orderRequest.ShipDate != "" {
+		shipDate, err = time.Parse("2006-01-02", orderRequest.ShipDate)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Ship date must be formatted YYYY-MM-DD"))
+			return
+		}
+	}
+
+	// Delivery tokens are for API users, a retailer can only be given a SmartID
+	recipient, _, err := server.findAddressee(r, "", orderRequest.SmartID, models.PackageDelivery)
+	if err != nil {
+		responses.ERROR(w, addresseeStatus(err), err)
+		return
+	}
+	aa, err := server.Store.Assignments().FindPackageAddress(*recipient, shipDate)
+	if gorm.IsRecordNotFoundError(err) || (err == nil && aa.ID == 0) {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("No active address"))
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	order := models.NewOrder(retailer.ID, recipient.ID, orderRequest.Reference, shipDate, aa.Address.ZipCode)
+	err = order.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	// The zip code is disclosed to the retailer with the order, so both are saved or neither is
+	err = server.Store.Transaction(func(store repository.Store) error {
+		_, err := store.Orders().Save(&order)
+		if err != nil {
+			return err
+		}
+		disclosure := models.NewRetailerDisclosure(*retailer, *aa)
+		_, err = store.Disclosures().Save(&disclosure)
+		return err
+	})
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", r.Host+r.URL.Path+"/"+order.ID.String())
+	responses.JSON(w, http.StatusCreated, responses.TranslateOrder(order, ""))
+}
+
+// findRetailerOrder loads the order in the route for the retailer making the request, writing the error response if it cannot
+func (server *Server) findRetailerOrder(w http.ResponseWriter, r *http.Request) (*models.Order, bool) {
+	retailer, err := retailerFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+	oid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	order, err := server.Store.Orders().FindByID(oid)
+	if gorm.IsRecordNotFoundError(err) || (err == nil && order.RetailerID != retailer.ID) {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Order not found"))
+		return nil, false
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return order, true
+}
+
+// GetOrder returns one of the retailer's orders
+func (server *Server) GetOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := server.findRetailerOrder(w, r)
+	if !ok {
+		return
+	}
+	carrier, err := server.orderCarrier(order)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateOrder(*order, carrier))
+}
+
+// HandOffOrder gives one of the retailer's orders to a carrier with its tracking number. Only that carrier can then resolve the address
+func (server *Server) HandOffOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := server.findRetailerOrder(w, r)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	handOffRequest := HandOffRequest{}
+	err = json.Unmarshal(body, &handOffRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	trackingNumber := tracking.Normalize(handOffRequest.Tracking)
+	if trackingNumber == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required tracking"))
+		return
+	}
+	carrier, err := resolveCarrier(server.Store, handOffRequest.Carrier, trackingNumber)
+	if isTrackingError(err) {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Invalid carrier"))
+		return
+	}
+
+	err = order.HandOff(carrier, trackingNumber, time.Now())
+	if err == nil {
+		err = server.Store.Orders().HandOff(order)
+	}
+	if err == models.ErrOrderHandedOff {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateOrder(*order, carrier.Code))
+}
+
+// GetOrderAddress resolves the recipient's package address for the carrier an order was handed off to. The first lookup saves the
+// order as a package from the retailer to the recipient. The recipient's grant for the carrier applies like any other lookup
+func (server *Server) GetOrderAddress(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	oid, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	order, err := server.Store.Orders().FindByID(oid)
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	carrier := &models.Carrier{}
+	if err == nil && order.CarrierID.Valid {
+		carrier, err = server.Store.Carriers().FindByID(uint64(order.CarrierID.Int64))
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	// Orders that were not handed to this carrier are not found, so other API users cannot learn they exist
+	if err != nil || !order.CarrierID.Valid || carrier.APIUserID != apiUserID {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Order not found"))
+		return
+	}
+
+	recipient, err := server.Store.Users().FindByID(order.RecipientID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	aa, err := server.Store.Assignments().FindPackageAddress(*recipient, order.ShipDate)
+	if gorm.IsRecordNotFoundError(err) || (err == nil && aa.ID == 0) {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("No active address"))
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if order.Status == models.OrderHandedOff {
+		// The package and the shipped order are saved together, so a failed lookup leaves the order to be shipped by the next one,
+		// and a lookup that loses the race to ship the order keeps no package of its own
+		err = server.Store.Transaction(func(store repository.Store) error {
+			packageDescription, err := store.Packages().SaveDescription(&models.PackageDescription{})
+			if err != nil {
+				return err
+			}
+			newPackage := models.Package{
+				MailCarrierID:        apiUserID,
+				CarrierID:            order.CarrierID,
+				SenderID:             uuid.NullUUID{UUID: order.RetailerID, Valid: true},
+				RecipientID:          uuid.NullUUID{UUID: order.RecipientID, Valid: true},
+				Tracking:             order.Tracking,
+				PackageDescriptionID: packageDescription.ID,
+				AddressAssignmentID:  null.IntFrom(int64(aa.ID)),
+			}
+			err = store.Packages().Save(&newPackage)
+			if err != nil {
+				return err
+			}
+			order.Ship(newPackage.ID, time.Now())
+			return store.Orders().Ship(order)
+		})
+		if err == models.ErrOrderShipped {
+			order, err = server.Store.Orders().FindByID(oid)
+		}
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	decision, err := server.shareAddress(r, aa, order.Tracking, nil)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	address := &responses.AddressSmartIDResponse{}
+	responses.TranslateSmartAddressResponse(aa, address)
+	responses.RestrictToZip(address, decision)
+	address.Warning, err = server.deliveryWarning(nil, aa)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	reply := responses.TranslateOrder(*order, carrier.Code)
+	reply.Recipient = address
+	responses.JSON(w, http.StatusOK, reply)
+}
@@ -11,9 +11,12 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
 	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
 	"github.com/nmelhado/smartmail-api/api/responses"
 	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
 	uuid "github.com/satori/go.uuid"
 	"gopkg.in/guregu/null.v3"
 )
@@ -40,11 +43,15 @@ func (server *Server) PreviewPackages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	packageModel := models.Package{}
+	openPackages, deliveredPackages, err := server.Store.Packages().FindPreviewForUser(uid)
 
-	openPackages, deliveredPackages, err := packageModel.FindAllPackagesForUser(server.DB, uid)
+	carriers, err := server.Store.Carriers().Registry()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	packagesResponse := responses.TranslatePreviewPackagesResponse(*openPackages, *deliveredPackages)
+	packagesResponse := responses.TranslatePreviewPackagesResponse(*openPackages, *deliveredPackages, carriers)
 
 	responses.JSON(w, http.StatusOK, packagesResponse)
 }
@@ -71,11 +78,15 @@ func (server *Server) CheckOpenPackages(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	packageModel := models.Package{}
+	openPackages, err := server.Store.Packages().FindAllOpenForUser(uid)
 
-	openPackages, err := packageModel.FindAllOpenPackagesForUser(server.DB, uid)
+	carriers, err := server.Store.Carriers().Registry()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	packagesResponse := responses.TranslatePackagesResponse(*openPackages)
+	packagesResponse := responses.TranslatePackagesResponse(*openPackages, carriers)
 
 	responses.JSON(w, http.StatusOK, packagesResponse)
 }
@@ -129,11 +140,15 @@ func (server *Server) GetPackages(w http.ResponseWriter, r *http.Request) {
 		search = null.StringFrom(searchQuery)
 	}
 
-	packageModel := models.Package{}
+	count, requestedPackages, err := server.Store.Packages().FindForUser(uid, packageType, limit, offset, search)
 
-	count, requestedPackages, err := packageModel.FindPackagesForUser(server.DB, uid, packageType, limit, offset, search)
+	carriers, err := server.Store.Carriers().Registry()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	packagesResponse := responses.TranslatePackagesResponse(requestedPackages)
+	packagesResponse := responses.TranslatePackagesResponse(requestedPackages, carriers)
 	packagesResponse.Count = count
 
 	responses.JSON(w, http.StatusOK, packagesResponse)
@@ -176,9 +191,12 @@ func (server *Server) UpdatePackage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	packageModel := models.Package{}
-
-	updatedPackage, err := packageModel.UpdatePackage(server.DB, packageToUpdate.UserID, packageToUpdate.Tracking, packageToUpdate.Delivered, packageToUpdate.DeliveredOn, packageToUpdate.EstimatedDelivery)
+	// A change is saved as an event, which is kept with the delivery state derived from it
+	updatedPackage := &models.Package{}
+	err = server.Store.Transaction(func(store repository.Store) error {
+		updatedPackage, err = store.Packages().Update(packageToUpdate.UserID, tracking.Normalize(packageToUpdate.Tracking), packageToUpdate.Delivered, packageToUpdate.DeliveredOn, packageToUpdate.EstimatedDelivery)
+		return err
+	})
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
@@ -204,28 +222,8 @@ type UpdatePackageDescription struct {
 
 // UpdatePackageDescription updates a package's description
 func (server *Server) UpdatePackageDescription(w http.ResponseWriter, r *http.Request) {
-	uid, permission, err := auth.ExtractAPIUserTokenID(r)
-	if err != nil {
-		fmt.Print("\nUnauthorized\n")
-		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
-		return
-	}
-
-	// Get the API user. This allows to get us to get the linked smartmail account for the next step
-	apiUser := &models.APIUser{}
-	apiUser, err = apiUser.FindAPIUserByID(server.DB, uid)
-	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
-		return
-	}
-
-	hasPermission := models.FullPermissions(apiUser.Permission)
-
-	if !hasPermission || string(apiUser.Permission) != permission {
-		fmt.Print("\nUnauthorized 2\n")
-		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
-		return
-	}
+	// The API user's linked smartmail account is the shipper of the package
+	apiUser := middlewares.PrincipalFromContext(r).APIUser
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -241,8 +239,7 @@ func (server *Server) UpdatePackageDescription(w http.ResponseWriter, r *http.Re
 	}
 
 	// Use the tracking number and the smartmail ID (obtained above) to get the package
-	existingPackage := &models.Package{}
-	existingPackage, err = existingPackage.FindPackageByTrackingAndShipper(server.DB, apiUser.SmartmailUser.ID, packageToUpdate.Tracking)
+	existingPackage, err := server.Store.Packages().FindByTrackingAndShipper(apiUser.SmartmailUser.ID, tracking.Normalize(packageToUpdate.Tracking))
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
@@ -252,7 +249,7 @@ func (server *Server) UpdatePackageDescription(w http.ResponseWriter, r *http.Re
 
 	// Once all existing packages have been updated, remove this logic
 	if existingPackage.PackageDescriptionID > 0 {
-		updatedPackageDescription, err = updatedPackageDescription.UpdatePackageDescription(server.DB, existingPackage.PackageDescriptionID, packageToUpdate.Contents, packageToUpdate.OrderLink, packageToUpdate.OrderImage)
+		updatedPackageDescription, err = server.Store.Packages().UpdateDescription(existingPackage.PackageDescriptionID, packageToUpdate.Contents, packageToUpdate.OrderLink, packageToUpdate.OrderImage)
 		if err != nil {
 			formattedError := formaterror.FormatError(err.Error())
 			responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
@@ -264,14 +261,14 @@ func (server *Server) UpdatePackageDescription(w http.ResponseWriter, r *http.Re
 			OrderImage: packageToUpdate.OrderImage,
 			OrderLink:  packageToUpdate.OrderLink,
 		}
-		updatedPackageDescription, err = createPackageDescription.SavePackageDescription(server.DB)
+		updatedPackageDescription, err = server.Store.Packages().SaveDescription(createPackageDescription)
 		if err != nil {
 			formattedError := formaterror.FormatError(err.Error())
 			responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
 			return
 		}
 		// Assign a package description ID
-		err = existingPackage.SetPackageDescription(server.DB, existingPackage.ID, updatedPackageDescription.ID)
+		err = server.Store.Packages().SetDescription(existingPackage.ID, updatedPackageDescription.ID)
 		if err != nil {
 			formattedError := formaterror.FormatError(err.Error())
 			responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
@@ -288,3 +285,128 @@ func (server *Server) UpdatePackageDescription(w http.ResponseWriter, r *http.Re
 
 	responses.JSON(w, http.StatusOK, response)
 }
+
+// PackageEventRequest is the struct for a carrier's tracking event
+type PackageEventRequest struct {
+	Tracking          string               `json:"tracking"`
+	Status            models.PackageStatus `json:"status"`
+	Location          null.String          `json:"location"`
+	EstimatedDelivery null.Time            `json:"estimated_delivery"`
+	OccurredAt        null.Time            `json:"occurred_at"`
+}
+
+// AddPackageEvent appends a tracking event to a package handled by the carrier making the request
+func (server *Server) AddPackageEvent(w http.ResponseWriter, r *http.Request) {
+	principal := middlewares.PrincipalFromContext(r)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	eventRequest := PackageEventRequest{}
+	err = json.Unmarshal(body, &eventRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	existingPackage, err := server.Store.Packages().FindByTrackingAndCarrier(principal.ID(), tracking.Normalize(eventRequest.Tracking))
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+
+	event := models.PackageEvent{
+		PackageID:         existingPackage.ID,
+		Status:            eventRequest.Status,
+		Location:          eventRequest.Location,
+		EstimatedDelivery: eventRequest.EstimatedDelivery,
+		OccurredAt:        eventRequest.OccurredAt.Time,
+		SourceID:          uuid.NullUUID{UUID: principal.ID(), Valid: true},
+	}
+	event.Prepare()
+	err = event.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// The event and the package's delivery state derived from it are saved together
+	err = server.Store.Transaction(func(store repository.Store) error {
+		_, err := store.Packages().SaveEvent(&event)
+		return err
+	})
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+
+	event.Source = *principal.APIUser
+	responses.JSON(w, http.StatusCreated, responses.TranslatePackageEvent(event))
+}
+
+// GetPackageEvents returns the tracking timeline of a package the user sent or received
+func (server *Server) GetPackageEvents(w http.ResponseWriter, r *http.Request) {
+	uid, err := auth.ExtractUITokenID(r)
+	if err != nil {
+		fmt.Print("\nUnauthorized\n")
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	vars := mux.Vars(r)
+	existingPackage, err := server.Store.Packages().FindByTrackingForUser(uid, tracking.Normalize(vars["tracking"]))
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+
+	events, err := server.Store.Packages().FindEvents(existingPackage.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, responses.TranslatePackageEventsResponse(*existingPackage, *events))
+}
+
+// GetPackageReroutes lists the carrier's packages whose recipient has moved since they were shipped, with the old and new addresses.
+// The new address is shared like any other lookup, so the recipient's grant applies and the disclosure is recorded
+func (server *Server) GetPackageReroutes(w http.ResponseWriter, r *http.Request) {
+	principal := middlewares.PrincipalFromContext(r)
+
+	reroutes, err := server.Store.Packages().FindReroutes(principal.ID())
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	reroutesResponse := responses.PackageReroutesResponse{Reroutes: []responses.PackageReroute{}, Success: true}
+	for _, reroute := range reroutes {
+		decision, err := server.shareAddress(r, &reroute.To, reroute.Package.Tracking, nil)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		reroutesResponse.Reroutes = append(reroutesResponse.Reroutes, responses.TranslatePackageReroute(reroute, decision))
+	}
+
+	responses.JSON(w, http.StatusOK, reroutesResponse)
+}
+
+// AcknowledgePackageReroute records that the carrier has sent the package on to the recipient's new address
+func (server *Server) AcknowledgePackageReroute(w http.ResponseWriter, r *http.Request) {
+	principal := middlewares.PrincipalFromContext(r)
+	trackingNumber := tracking.Normalize(mux.Vars(r)["tracking"])
+
+	err := server.Store.Packages().AcknowledgeReroute(principal.ID(), trackingNumber)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Entity", trackingNumber)
+	responses.JSON(w, http.StatusNoContent, "")
+}
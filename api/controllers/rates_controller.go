@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/rating"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/guregu/null.v3"
+)
+
+// RateRequest is the body of a rating request. The origin is a SmartID or, for shippers without one, a zip code
+type RateRequest struct {
+	OriginSmartID      string  `json:"origin_smart_id"`
+	OriginZip          string  `json:"origin_zip"`
+	DestinationSmartID string  `json:"destination_smart_id"`
+	ShipDate           string  `json:"ship_date"`
+	Weight             float64 `json:"weight"`
+	Length             float64 `json:"length"`
+	Width              float64 `json:"width"`
+	Height             float64 `json:"height"`
+}
+
+// rateLocation finds where the user with the SmartID receives packages on the ship date. role names the user in errors
+func (server *Server) rateLocation(role string, rawSmartID string, shipDate time.Time) (*models.AddressAssignment, geocode.Location, int, error) {
+	smartID, err := parseSmartID(server.Store, rawSmartID)
+	if err != nil {
+		return nil, geocode.Location{}, http.StatusBadRequest, err
+	}
+	user, err := server.Store.Users().FindBySmartID(smartID)
+	if err != nil {
+		return nil, geocode.Location{}, http.StatusUnprocessableEntity, fmt.Errorf("Unable to find %s with smartID: %s", role, smartID)
+	}
+	aa, err := server.Store.Assignments().FindPackageAddress(*user, shipDate)
+	if gorm.IsRecordNotFoundError(err) || (err == nil && aa.ID == 0) {
+		return nil, geocode.Location{}, http.StatusUnprocessableEntity, fmt.Errorf("No active address for %s", role)
+	}
+	if err != nil {
+		return nil, geocode.Location{}, http.StatusInternalServerError, err
+	}
+	// A held address is not given out as a zip code either, so it cannot be rated
+	if aa.IsHold() {
+		return nil, geocode.Location{}, http.StatusUnprocessableEntity, fmt.Errorf("Packages for the %s are held until %s", role, aa.EndDate.Time.Format("2006-01-02"))
+	}
+	location, err := server.Rater.Locate(aa.Address)
+	if err != nil {
+		return nil, geocode.Location{}, http.StatusUnprocessableEntity, fmt.Errorf("Unable to locate %s: %v", role, err)
+	}
+	return aa, location, http.StatusOK, nil
+}
+
+// GetRates estimates the zone and price of a package with every registered carrier that has a rate table. The caller only
+// needs zip code access, so only the origin and destination zip codes are returned
+func (server *Server) GetRates(w http.ResponseWriter, r *http.Request) {
+	if server.Rater == nil {
+		responses.ERROR(w, http.StatusServiceUnavailable, errors.New("Rating is not configured"))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	rateRequest := RateRequest{}
+	err = json.Unmarshal(body, &rateRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	pkg := rating.Package{Weight: rateRequest.Weight, Length: rateRequest.Length, Width: rateRequest.Width, Height: rateRequest.Height}
+	err = pkg.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	shipDate, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	if rateRequest.ShipDate != "" {
+		shipDate, err = time.Parse("2006-01-02", rateRequest.ShipDate)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Ship date must be formatted YYYY-MM-DD"))
+			return
+		}
+	}
+	if rateRequest.DestinationSmartID == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required destination smartID"))
+		return
+	}
+
+	destination, to, status, err := server.rateLocation("recipient", rateRequest.DestinationSmartID, shipDate)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+	assignments := []*models.AddressAssignment{destination}
+
+	var from geocode.Location
+	originZip := strings.TrimSpace(rateRequest.OriginZip)
+	switch {
+	case rateRequest.OriginSmartID != "":
+		origin, location, status, err := server.rateLocation("sender", rateRequest.OriginSmartID, shipDate)
+		if err != nil {
+			responses.ERROR(w, status, err)
+			return
+		}
+		from = location
+		originZip = origin.Address.ZipCode
+		assignments = append(assignments, origin)
+	case originZip != "":
+		from, err = server.Rater.LocateZip(originZip)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, fmt.Errorf("Unable to locate origin: %v", err))
+			return
+		}
+	default:
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required origin smartID or zip"))
+		return
+	}
+
+	carriers, err := server.Store.Carriers().FindAll()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = recordDisclosures(server.Store, r, models.ZipReadScope, null.String{}, nil, assignments...)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	miles := geocode.Miles(from, to)
+	reply := responses.RatesResponse{
+		OriginZip:      originZip,
+		DestinationZip: destination.Address.ZipCode,
+		ShipDate:       shipDate.Format("2006-01-02"),
+		Rates:          []responses.Rate{},
+	}
+	for _, carrier := range *carriers {
+		if !server.Rater.HasTable(carrier.Code) {
+			continue
+		}
+		rate := responses.Rate{Carrier: carrier.Code, Name: carrier.Name}
+		quote, err := server.Rater.Quote(carrier.Code, miles, pkg)
+		if err != nil {
+			rate.Error = err.Error()
+		} else {
+			rate.Zone = quote.Zone
+			rate.BillableWeight = quote.BillableWeight
+			rate.Price = quote.Price
+		}
+		reply.Rates = append(reply.Rates, rate)
+	}
+	responses.JSON(w, http.StatusOK, reply)
+}
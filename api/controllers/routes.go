@@ -1,6 +1,9 @@
 package controllers
 
-import "github.com/nmelhado/smartmail-api/api/middlewares"
+import (
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+)
 
 func (s *Server) initializeRoutes() {
 
@@ -16,6 +19,10 @@ func (s *Server) initializeRoutes() {
 
 	// Token Route
 	s.Router.HandleFunc("/token", middlewares.SetMiddlewareJSON(s.Token)).Methods("POST")
+	s.Router.HandleFunc("/token/refresh", middlewares.SetMiddlewareJSON(s.RefreshToken)).Methods("POST")
+
+	// Logout route
+	s.Router.HandleFunc("/logout", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.Logout))).Methods("POST")
 
 	// Sign up route
 	s.Router.HandleFunc("/signup", middlewares.SetMiddlewareJSON(s.CreateUserAndAddress)).Methods("POST")
@@ -32,43 +39,104 @@ func (s *Server) initializeRoutes() {
 	s.Router.HandleFunc("/api_users/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.UpdateAPIUser))).Methods("PUT")
 	s.Router.HandleFunc("/api_users/{id}", middlewares.SetMiddlewareAuthentication(s.DeleteAPIUser)).Methods("DELETE")
 
+	// Carrier registry routes (admin)
+	s.Router.HandleFunc("/carriers", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetCarriers, models.AdminScope))).Methods("GET")
+	s.Router.HandleFunc("/carriers", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.CreateCarrier, models.AdminScope))).Methods("POST")
+	s.Router.HandleFunc("/carriers/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.UpdateCarrier, models.AdminScope))).Methods("PUT")
+	s.Router.HandleFunc("/carriers/{id}", middlewares.SetMiddlewareScope(s.Store, s.DeleteCarrier, models.AdminScope)).Methods("DELETE")
+
 	// Users routes
 	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJSON(s.CreateUser)).Methods("POST")
 	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJSON(s.GetUsers)).Methods("GET")
 	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJSON(s.GetUser)).Methods("GET")
 	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.UpdateUser))).Methods("PUT")
 	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareAuthentication(s.DeleteUser)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/retailer", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.MakeRetailer, models.AdminScope))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}/sessions/revoke", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.RevokeUserSessions, models.AdminScope))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/disclosures", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetUserDisclosures))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/grants", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetShareGrants))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/grants", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.SaveShareGrant))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/grants/{grant_id}", middlewares.SetMiddlewareAuthentication(s.DeleteShareGrant)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/delivery_tokens", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetDeliveryTokens))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/delivery_tokens", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.CreateDeliveryToken))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/delivery_tokens/{token_id}", middlewares.SetMiddlewareAuthentication(s.RevokeDeliveryToken)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/delivery_failures", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetDeliveryFailures))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/delivery_failures/seen", middlewares.SetMiddlewareAuthentication(s.MarkDeliveryFailuresSeen)).Methods("POST")
+
+	// Webhook routes (API users)
+	s.Router.HandleFunc("/webhooks", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetWebhooks, models.PackageReadScope))).Methods("GET")
+	s.Router.HandleFunc("/webhooks", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.CreateWebhook, models.PackageReadScope))).Methods("POST")
+	s.Router.HandleFunc("/webhooks/deliveries/dead", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetDeadWebhookDeliveries, models.PackageReadScope))).Methods("GET")
+	s.Router.HandleFunc("/webhooks/deliveries/{id}/redeliver", middlewares.SetMiddlewareScope(s.Store, s.RedeliverWebhookDelivery, models.PackageReadScope)).Methods("POST")
+	s.Router.HandleFunc("/webhooks/{id}", middlewares.SetMiddlewareScope(s.Store, s.DeleteWebhook, models.PackageReadScope)).Methods("DELETE")
+
+	// Mailing job routes (API users)
+	s.Router.HandleFunc("/mailing/jobs", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.CreateMailingJob, models.AddressReadScope))).Methods("POST")
+	s.Router.HandleFunc("/mailing/jobs/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetMailingJob, models.AddressReadScope))).Methods("GET")
+	s.Router.HandleFunc("/mailing/jobs/{id}/cancel", middlewares.SetMiddlewareScope(s.Store, s.CancelMailingJob, models.AddressReadScope)).Methods("POST")
+	// Downloads CSV, the handler sets the content type
+	s.Router.HandleFunc("/mailing/jobs/{id}/result", middlewares.SetMiddlewareScope(s.Store, s.GetMailingJobResult, models.AddressReadScope)).Methods("GET")
+
+	// Order routes (retailers)
+	s.Router.HandleFunc("/orders", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.CreateOrder, models.OrderWriteScope))).Methods("POST")
+	s.Router.HandleFunc("/orders/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetOrder, models.OrderWriteScope))).Methods("GET")
+	s.Router.HandleFunc("/orders/{id}/handoff", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.HandOffOrder, models.OrderWriteScope))).Methods("POST")
+	// Order address route (the carrier an order was handed off to)
+	s.Router.HandleFunc("/carrier/orders/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetOrderAddress, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+
+	// Delivery route routes (mail carrier)
+	s.Router.HandleFunc("/carrier/route", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.PlanDeliveryRoute, models.AddressReadScope, models.PackageReadScope))).Methods("POST")
+
+	// Failed delivery routes (mail carrier)
+	s.Router.HandleFunc("/carrier/delivery_failures", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.ReportDeliveryFailure, models.PackageWriteScope))).Methods("POST")
+
+	// Failed delivery review routes (admin)
+	s.Router.HandleFunc("/delivery_failures/reviews", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetDeliveryReviews, models.AdminScope))).Methods("GET")
+	s.Router.HandleFunc("/delivery_failures/reviews/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.ReviewDeliveryFailures, models.AdminScope))).Methods("POST")
+
+	// Address disclosure log routes (admin)
+	s.Router.HandleFunc("/disclosures", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetDisclosures, models.AdminScope))).Methods("GET")
 
 	// Mailing addresses sender and recipient routes
-	s.Router.HandleFunc("/addresses/mail/{sender_smart_id}/{recipient_smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetMailingAddressToAndFromBySmartID)).Methods("GET")
+	s.Router.HandleFunc("/addresses/mail/{sender_smart_id}/{recipient_smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetMailingAddressToAndFromBySmartID, models.AddressReadScope))).Methods("GET")
 
 	// Package addresses sender and recipient routes (mail carrier)
-	s.Router.HandleFunc("/addresses/package/{sender_smart_id}/{recipient_smart_id}/{date}/{tracking}", middlewares.SetMiddlewareJSON(s.GetPackageAddressToAndFromBySmartID)).Methods("GET")
-	s.Router.HandleFunc("/addresses/package/{sender_smart_id}/{recipient_smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetPackageAddressToAndFromBySmartID)).Methods("GET")
-	s.Router.HandleFunc("/addresses/package/tracking", middlewares.SetMiddlewareJSON(s.ProvidePackageAddressToAndFromBySmartID)).Methods("POST")
+	s.Router.HandleFunc("/addresses/package/{sender_smart_id}/{recipient_smart_id}/{date}/{tracking}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageAddressToAndFromBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+	s.Router.HandleFunc("/addresses/package/{sender_smart_id}/{recipient_smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageAddressToAndFromBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+	s.Router.HandleFunc("/addresses/package/tracking", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.ProvidePackageAddressToAndFromBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("POST")
 
 	// Zip routes
-	s.Router.HandleFunc("/zip/mail/{smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetMailingZipBySmartID)).Methods("GET")
-	s.Router.HandleFunc("/zip/package/{smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetPackageZipBySmartID)).Methods("GET")
+	s.Router.HandleFunc("/zip/mail/{smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetMailingZipBySmartID, models.ZipReadScope))).Methods("GET")
+	s.Router.HandleFunc("/zip/package/{smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageZipBySmartID, models.ZipReadScope))).Methods("GET")
+
+	// Rating routes
+	s.Router.HandleFunc("/rates", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetRates, models.ZipReadScope))).Methods("POST")
 
 	// Address routes
 	s.Router.HandleFunc("/address", middlewares.SetMiddlewareJSON(s.CreateAddress)).Methods("POST")
 	s.Router.HandleFunc("/address/{id}", middlewares.SetMiddlewareJSON(s.GetAddressByID)).Methods("GET")
-	s.Router.HandleFunc("/address/mail/{smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetMailingAddressBySmartID)).Methods("GET")
+	s.Router.HandleFunc("/address/mail/{smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetMailingAddressBySmartID, models.AddressReadScope))).Methods("GET")
+	// Streams JSON lines or CSV, the handler sets the content type
+	s.Router.HandleFunc("/address/mail/batch", middlewares.SetMiddlewareScope(s.Store, s.GetMailingAddressesBySmartIDs, models.AddressReadScope)).Methods("POST")
 	s.Router.HandleFunc("/address/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.UpdateAddress))).Methods("PUT")
 	s.Router.HandleFunc("/address/{id}", middlewares.SetMiddlewareAuthentication(s.DeleteAddress)).Methods("DELETE")
 		// Shipper routes
-		s.Router.HandleFunc("/shipper/addresses/package", middlewares.SetMiddlewareJSON(s.ShipperProvidePackageAddressToAndFromBySmartID)).Methods("POST")
+		s.Router.HandleFunc("/shipper/addresses/package", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.ShipperProvidePackageAddressToAndFromBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("POST")
+		s.Router.HandleFunc("/shipper/label", middlewares.SetMiddlewareScope(s.Store, s.CreateShippingLabel, models.AddressReadScope, models.PackageWriteScope)).Methods("POST")
 		// Carrier routes
-		s.Router.HandleFunc("/address/package/sender/{smart_id}/{date}/{tracking}", middlewares.SetMiddlewareJSON(s.GetPackageSenderAddressBySmartID)).Methods("GET")
-		s.Router.HandleFunc("/address/package/sender/{smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetPackageSenderAddressBySmartID)).Methods("GET")
-		s.Router.HandleFunc("/address/package/recipient/{smart_id}/{date}/{tracking}", middlewares.SetMiddlewareJSON(s.GetPackageRecipientAddressBySmartID)).Methods("GET")
-		s.Router.HandleFunc("/address/package/recipient/{smart_id}/{date}", middlewares.SetMiddlewareJSON(s.GetPackageRecipientAddressBySmartID)).Methods("GET")
+		s.Router.HandleFunc("/address/package/sender/{smart_id}/{date}/{tracking}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageSenderAddressBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+		s.Router.HandleFunc("/address/package/sender/{smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageSenderAddressBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+		s.Router.HandleFunc("/address/package/recipient/{smart_id}/{date}/{tracking}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageRecipientAddressBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+		s.Router.HandleFunc("/address/package/recipient/{smart_id}/{date}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageRecipientAddressBySmartID, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
 
 	// Packages route
 	s.Router.HandleFunc("/preview_packages/{user_id}", middlewares.SetMiddlewareJSON(s.PreviewPackages)).Methods("GET")
 	s.Router.HandleFunc("/check_packages/{user_id}", middlewares.SetMiddlewareJSON(s.CheckOpenPackages)).Methods("GET")
 	s.Router.HandleFunc("/packages/{user_id}", middlewares.SetMiddlewareJSON(s.GetPackages)).Queries("limit", "{limit}", "page", "{page}", "type", "{type}", "search", "{search}").Methods("GET")
 	s.Router.HandleFunc("/package", middlewares.SetMiddlewareJSON(s.UpdatePackage)).Methods("Put")
-	s.Router.HandleFunc("/package/description", middlewares.SetMiddlewareJSON(s.UpdatePackageDescription)).Methods("Put")
+	s.Router.HandleFunc("/package/description", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.UpdatePackageDescription, models.PackageWriteScope))).Methods("Put")
+	s.Router.HandleFunc("/package/events", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.AddPackageEvent, models.PackageWriteScope))).Methods("POST")
+	s.Router.HandleFunc("/package/events/{tracking}", middlewares.SetMiddlewareJSON(s.GetPackageEvents)).Methods("GET")
+	s.Router.HandleFunc("/package/reroutes", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareScope(s.Store, s.GetPackageReroutes, models.AddressReadScope, models.PackageWriteScope))).Methods("GET")
+	s.Router.HandleFunc("/package/reroutes/{tracking}/acknowledge", middlewares.SetMiddlewareScope(s.Store, s.AcknowledgePackageReroute, models.PackageWriteScope)).Methods("POST")
 }
@@ -39,7 +39,7 @@ func (server *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	userCreated, err := user.SaveUser(server.DB)
+	userCreated, err := server.Store.Users().Save(&user)
 
 	if err != nil {
 
@@ -48,6 +48,8 @@ func (server *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
 		return
 	}
+	userResponse.ID = userCreated.ID
+	userResponse.SmartID = userCreated.SmartID
 	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.RequestURI, userCreated.ID))
 	responses.JSON(w, http.StatusCreated, userResponse)
 }
@@ -55,9 +57,7 @@ func (server *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 // GetUsers retrieves 100 users
 func (server *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
 
-	user := models.User{}
-
-	users, err := user.FindAllUsers(server.DB)
+	users, err := server.Store.Users().FindAll()
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -70,8 +70,7 @@ func (server *Server) GetUser(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	uid := uuid.FromStringOrNil(vars["id"])
-	user := models.User{}
-	userGotten, err := user.FindUserByID(server.DB, uid)
+	userGotten, err := server.Store.Users().FindByID(uid)
 	if err != nil {
 		responses.ERROR(w, http.StatusBadRequest, err)
 		return
@@ -112,7 +111,7 @@ func (server *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	updatedUser, err := user.UpdateAUserBasic(server.DB, uid)
+	updatedUser, err := server.Store.Users().UpdateBasic(&user, uid)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
@@ -129,8 +128,6 @@ func (server *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	user := models.User{}
-
 	uid := uuid.FromStringOrNil(vars["id"])
 	tokenID, err := auth.ExtractUITokenID(r)
 	if err != nil {
@@ -141,7 +138,7 @@ func (server *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
-	_, err = user.DeleteUser(server.DB, uid)
+	_, err = server.Store.Users().Delete(uid)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -2,23 +2,16 @@ package controllers
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nmelhado/smartmail-api/api/models"
-
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
 	uuid "github.com/satori/go.uuid"
-)
-
-var (
-	UPS_INPUT = "UPS"
-	UPS_USER = "UPS"
-	USPS_INPUT = "USPS"
-	USPS_USER = "USPS"
-	FEDEX_INPUT = "FEDEX"
-	FEDEX_USER = "FEDEX"
-	LASERSHIP_INPUT = "LASERSHIP"
-	LASERSHIP_USER = "LASERSHIP"
+	"gopkg.in/guregu/null.v3"
 )
 
 // Convert I to 1, S to 5, Z to 2, or O to 0)
@@ -27,21 +20,97 @@ func sanitizeSmartID(unsanitizedSmartID string) string {
 	return replacer.Replace(unsanitizedSmartID)
 }
 
-func translateCarrier(db *gorm.DB, carrier string) (carrierID uuid.UUID, err error) {
-	carrierUser  := models.APIUser{}
-
-	switch strings.ToUpper(carrier) {
-		case UPS_INPUT:
-			carrierID, err = carrierUser.FindAPIUserIDByUsername(db, UPS_USER)
-		case FEDEX_INPUT:
-			carrierID, err = carrierUser.FindAPIUserIDByUsername(db, FEDEX_USER)
-		case USPS_INPUT:
-			carrierID, err = carrierUser.FindAPIUserIDByUsername(db, USPS_USER)
-		case LASERSHIP_INPUT:
-			carrierID, err = carrierUser.FindAPIUserIDByUsername(db, LASERSHIP_USER)
-		default:
-			err = errors.New("Invalid carrier")
+// parseSmartID upper-cases and sanitizes a SmartID supplied by a client and then verifies its check character,
+// so that mistyped SmartIDs are rejected before the DB is queried. SmartIDs users picked before the server issued them have no
+// check character, they are accepted when they match a legacy SmartID exactly
+func parseSmartID(store repository.Store, rawSmartID string) (string, error) {
+	smartID := sanitizeSmartID(strings.ToUpper(strings.TrimSpace(rawSmartID)))
+	err := smartid.Validate(smartID)
+	if err != nil {
+		if smartID != "" && len(smartID) <= smartid.Length {
+			user, findErr := store.Users().FindBySmartID(smartID)
+			if findErr == nil && user.LegacySmartID {
+				return smartID, nil
+			}
+		}
+		return smartID, fmt.Errorf("%w for smartID: %s", err, smartID)
+	}
+	return smartID, nil
+}
+
+// resolveCarrier finds the carrier for a normalized tracking number. When no carrier code is supplied the carrier
+// is detected from the tracking number's check digit, and then from the registry's tracking patterns
+func resolveCarrier(store repository.Store, code string, trackingNumber string) (models.Carrier, error) {
+	if code == "" && trackingNumber != "" {
+		detected, err := tracking.Detect(trackingNumber)
+		if err == nil {
+			code = detected
+		} else {
+			return matchCarrierPattern(store, trackingNumber)
 		}
+	}
+	carrier, err := store.Carriers().FindByCode(code)
+	if err != nil {
+		return models.Carrier{}, err
+	}
+	if trackingNumber == "" {
+		return *carrier, nil
+	}
+	return *carrier, checkCarrierTracking(*carrier, trackingNumber)
+}
+
+// matchCarrierPattern finds the only registry carrier without a check digit rule whose tracking patterns match the tracking number
+func matchCarrierPattern(store repository.Store, trackingNumber string) (models.Carrier, error) {
+	registry, err := store.Carriers().Registry()
+	if err != nil {
+		return models.Carrier{}, err
+	}
+	matches := []models.Carrier{}
+	for _, carrier := range registry {
+		if !tracking.Supports(carrier.Code) && carrier.MatchesTracking(trackingNumber) {
+			matches = append(matches, carrier)
+		}
+	}
+	if len(matches) != 1 {
+		return models.Carrier{}, fmt.Errorf("%w: %s", tracking.ErrUnknownCarrier, trackingNumber)
+	}
+	return matches[0], nil
+}
+
+// checkCarrierTracking validates a tracking number with the carrier's check digit rule, or with its registry patterns
+// when there is no rule for the carrier
+func checkCarrierTracking(carrier models.Carrier, trackingNumber string) error {
+	if tracking.Supports(carrier.Code) {
+		return tracking.Validate(carrier.Code, trackingNumber)
+	}
+	if len(carrier.TrackingPatterns) > 0 && !carrier.MatchesTracking(trackingNumber) {
+		return fmt.Errorf("%w for %s: %s", tracking.ErrInvalidTracking, carrier.Code, trackingNumber)
+	}
+	return nil
+}
+
+// requesterTracking normalizes a tracking number supplied by a carrier and validates it against the requesting carrier.
+// API users that are not in the carrier registry are not validated
+func requesterTracking(store repository.Store, apiUserID uuid.UUID, rawTracking string) (null.String, null.Int, error) {
+	trackingNumber := tracking.Normalize(rawTracking)
+	if trackingNumber == "" {
+		return null.StringFromPtr(nil), null.IntFromPtr(nil), nil
+	}
+	carrier, err := store.Carriers().FindByAPIUserID(apiUserID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return null.StringFrom(trackingNumber), null.IntFromPtr(nil), nil
+		}
+		return null.StringFromPtr(nil), null.IntFromPtr(nil), err
+	}
+	err = checkCarrierTracking(*carrier, trackingNumber)
+	if err != nil {
+		return null.StringFromPtr(nil), null.IntFromPtr(nil), err
+	}
+	return null.StringFrom(trackingNumber), null.IntFrom(int64(carrier.ID)), nil
+}
 
-	return
+// isTrackingError returns true if a tracking number was malformed or its carrier could not be detected
+func isTrackingError(err error) bool {
+	return errors.Is(err, tracking.ErrInvalidTracking) || errors.Is(err, tracking.ErrUnknownCarrier)
 }
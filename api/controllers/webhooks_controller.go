@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
+	uuid "github.com/satori/go.uuid"
+)
+
+// apiUserFromPrincipal returns the ID of the API user making the request
+func apiUserFromPrincipal(r *http.Request) (uuid.UUID, error) {
+	principal := middlewares.PrincipalFromContext(r)
+	if principal == nil || principal.APIUser == nil {
+		return uuid.Nil, errors.New(http.StatusText(http.StatusUnauthorized))
+	}
+	return principal.APIUser.ID, nil
+}
+
+// GetWebhooks lists the webhooks the API user has registered
+func (server *Server) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	webhooks, err := server.Store.Webhooks().FindForAPIUser(apiUserID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateWebhooks(*webhooks))
+}
+
+// CreateWebhook registers an endpoint to be sent the address changes of users the API user has packages in flight for.
+// The signing secret is only returned here
+func (server *Server) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	webhook := models.Webhook{}
+	err = json.Unmarshal(body, &webhook)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	webhook.Prepare()
+	webhook.APIUserID = apiUserID
+	err = webhook.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	savedWebhook, err := server.Store.Webhooks().Save(&webhook)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	reply := responses.TranslateWebhook(*savedWebhook)
+	reply.Secret = savedWebhook.Secret
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, savedWebhook.ID))
+	responses.JSON(w, http.StatusCreated, reply)
+}
+
+// DeleteWebhook removes one of the API user's webhooks, deliveries that have not been sent are dropped
+func (server *Server) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	wid, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, err = server.Store.Webhooks().Delete(apiUserID, wid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Entity", fmt.Sprintf("%d", wid))
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// GetDeadWebhookDeliveries lists the dead-letter list, the deliveries to the API user's webhooks that failed every attempt
+func (server *Server) GetDeadWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	deliveries, err := server.Store.Webhooks().FindDead(apiUserID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, responses.TranslateWebhookDeliveries(*deliveries))
+}
+
+// RedeliverWebhookDelivery takes a delivery off the dead-letter list and queues it to be sent again
+func (server *Server) RedeliverWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	apiUserID, err := apiUserFromPrincipal(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	did, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = server.Store.Webhooks().Redeliver(apiUserID, did)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Entity", fmt.Sprintf("%d", did))
+	responses.JSON(w, http.StatusNoContent, "")
+}
@@ -0,0 +1,58 @@
+package geocode
+
+import (
+	"sync"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+)
+
+// DefaultCacheSize is the number of addresses kept by the cache created in NewFromEnv
+const DefaultCacheSize = 10000
+
+// Cache wraps a Geocoder so that the same address is only geocoded once.
+// Once the cache is full the oldest entries are evicted first
+type Cache struct {
+	geocoder Geocoder
+	size     int
+	mutex    sync.Mutex
+	entries  map[string]Location
+	order    []string
+}
+
+// NewCache wraps a Geocoder with a cache holding up to size addresses
+func NewCache(geocoder Geocoder, size int) *Cache {
+	return &Cache{
+		geocoder: geocoder,
+		size:     size,
+		entries:  map[string]Location{},
+	}
+}
+
+// Geocode returns the cached location for an address, geocoding it only on a cache miss
+func (c *Cache) Geocode(address models.Address) (Location, error) {
+	key := addressKey(address)
+
+	c.mutex.Lock()
+	location, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok {
+		return location, nil
+	}
+
+	location, err := c.geocoder.Geocode(address)
+	if err != nil {
+		return Location{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		if len(c.order) >= c.size && c.size > 0 {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.entries[key] = location
+		c.order = append(c.order, key)
+	}
+	return location, nil
+}
@@ -0,0 +1,15 @@
+package geocode
+
+import "math"
+
+// earthRadiusMiles is the mean radius of the earth used to measure distances
+const earthRadiusMiles = 3958.8
+
+// Miles returns the great circle distance between two locations
+func Miles(from Location, to Location) float64 {
+	radians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat := radians(to.Latitude - from.Latitude)
+	dLng := radians(to.Longitude - from.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(radians(from.Latitude))*math.Cos(radians(to.Latitude))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,56 @@
+package geocode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+)
+
+// Location is the latitude and longitude returned by a Geocoder
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Geocoder converts an address into a latitude and longitude
+type Geocoder interface {
+	Geocode(address models.Address) (Location, error)
+}
+
+// ErrNotFound is returned when a Geocoder is unable to locate an address
+var ErrNotFound = errors.New("GeoLocation Error: address not found")
+
+// NewFromEnv builds the Geocoder selected by the GEOCODER env variable ("google" or "offline") and wraps it in a cache.
+// The Google geocoder reads its key from GOOGLE_GEOCODING_API_KEY and the offline geocoder reads its centroid table from GEOCODER_OFFLINE_FILE
+func NewFromEnv() (Geocoder, error) {
+	var geocoder Geocoder
+	switch strings.ToLower(os.Getenv("GEOCODER")) {
+	case "", "google":
+		apiKey := os.Getenv("GOOGLE_GEOCODING_API_KEY")
+		if apiKey == "" {
+			return nil, errors.New("GOOGLE_GEOCODING_API_KEY is required to use the google geocoder")
+		}
+		geocoder = NewGoogle(apiKey)
+	case "offline":
+		offline, err := NewOfflineFromFile(os.Getenv("GEOCODER_OFFLINE_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		geocoder = offline
+	default:
+		return nil, fmt.Errorf("Unknown geocoder: %s", os.Getenv("GEOCODER"))
+	}
+	return NewCache(geocoder, DefaultCacheSize), nil
+}
+
+// addressKey normalizes the parts of an address that are used for geocoding
+func addressKey(address models.Address) string {
+	parts := []string{address.LineOne, address.City, address.State, address.ZipCode}
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(strings.Join(strings.Fields(part), " "))
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,77 @@
+package geocode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+)
+
+// GoogleGeocodeURL is the Google Geocoding API endpoint
+const GoogleGeocodeURL = "https://maps.googleapis.com/maps/api/geocode/json"
+
+// Google geocodes addresses with the Google Geocoding API
+type Google struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+}
+
+type geoInfo struct {
+	Results      []result `json:"results"`
+	ErrorMessage string   `json:"error_message"`
+}
+
+type result struct {
+	Geometry geometry `json:"geometry"`
+}
+
+type geometry struct {
+	Location latLng `json:"location"`
+}
+
+type latLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// NewGoogle creates a Google geocoder that uses the provided API key
+func NewGoogle(apiKey string) *Google {
+	return &Google{
+		APIKey:  apiKey,
+		BaseURL: GoogleGeocodeURL,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Geocode looks up an address with the Google Geocoding API
+func (g *Google) Geocode(address models.Address) (Location, error) {
+	query := url.Values{}
+	query.Set("address", strings.Join([]string{address.LineOne, address.City, address.State, address.ZipCode}, " "))
+	query.Set("key", g.APIKey)
+
+	res, err := g.Client.Get(g.BaseURL + "?" + query.Encode())
+	if err != nil {
+		return Location{}, err
+	}
+	defer res.Body.Close()
+
+	var info geoInfo
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		return Location{}, err
+	}
+
+	if len(info.Results) < 1 {
+		return Location{}, fmt.Errorf("GeoLocation Error:  %s", info.ErrorMessage)
+	}
+
+	return Location{
+		Latitude:  info.Results[0].Geometry.Location.Lat,
+		Longitude: info.Results[0].Geometry.Location.Lng,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package geocode
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+)
+
+// Offline geocodes addresses to the centroid of their zip code (or city when the zip code is unknown)
+// using a table loaded from a file. It is used for tests and air-gapped deployments
+type Offline struct {
+	zips   map[string]Location
+	cities map[string]Location
+}
+
+// NewOfflineFromFile loads a centroid table from a CSV file with the header zip_code,city,state,latitude,longitude
+func NewOfflineFromFile(path string) (*Offline, error) {
+	if path == "" {
+		return nil, errors.New("A centroid file is required to use the offline geocoder")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewOffline(file)
+}
+
+// NewOffline loads a centroid table from CSV data with the header zip_code,city,state,latitude,longitude
+func NewOffline(data io.Reader) (*Offline, error) {
+	reader := csv.NewReader(data)
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 1 {
+		return nil, errors.New("Centroid file is empty")
+	}
+
+	offline := &Offline{
+		zips:   map[string]Location{},
+		cities: map[string]Location{},
+	}
+	// skip the header row
+	for i, row := range rows[1:] {
+		if len(row) != 5 {
+			return nil, fmt.Errorf("Centroid file line %d: expected 5 columns, got %d", i+2, len(row))
+		}
+		latitude, err := strconv.ParseFloat(row[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Centroid file line %d: invalid latitude: %s", i+2, row[3])
+		}
+		longitude, err := strconv.ParseFloat(row[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Centroid file line %d: invalid longitude: %s", i+2, row[4])
+		}
+		location := Location{Latitude: latitude, Longitude: longitude}
+		if zip := normalizeZip(row[0]); zip != "" {
+			offline.zips[zip] = location
+		}
+		if row[1] != "" && row[2] != "" {
+			offline.cities[cityKey(row[1], row[2])] = location
+		}
+	}
+	return offline, nil
+}
+
+// Geocode returns the centroid for the address's zip code, falling back to its city and state
+func (o *Offline) Geocode(address models.Address) (Location, error) {
+	if location, ok := o.LookupZip(address.ZipCode); ok {
+		return location, nil
+	}
+	if location, ok := o.cities[cityKey(address.City, address.State)]; ok {
+		return location, nil
+	}
+	return Location{}, ErrNotFound
+}
+
+// LookupZip returns the centroid for a zip code
+func (o *Offline) LookupZip(zip string) (Location, bool) {
+	location, ok := o.zips[normalizeZip(zip)]
+	return location, ok
+}
+
+// normalizeZip reduces a zip code to its first 5 digits (ZIP+4 codes share their 5 digit centroid)
+func normalizeZip(zip string) string {
+	zip = strings.TrimSpace(zip)
+	if len(zip) > 5 {
+		zip = zip[:5]
+	}
+	return zip
+}
+
+func cityKey(city, state string) string {
+	return strings.ToUpper(strings.TrimSpace(city)) + "|" + strings.ToUpper(strings.TrimSpace(state))
+}
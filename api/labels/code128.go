@@ -0,0 +1,103 @@
+package labels
+
+import (
+	"errors"
+)
+
+// code128Patterns are the bar and space widths of each Code 128 symbol value, starting with a bar. 106 is the stop pattern
+var code128Patterns = []string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeC  = 99
+	code128CodeB  = 100
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// ErrCode128Character is returned for data Code 128 cannot hold in code sets B and C
+var ErrCode128Character = errors.New("Barcode data must be printable ASCII")
+
+// Code128 encodes data as a Code 128 barcode and returns the width of each bar and space in modules, starting with a bar.
+// Runs of four or more digits use code set C, which holds two digits per symbol, everything else uses code set B
+func Code128(data string) ([]int, error) {
+	if data == "" {
+		return nil, errors.New("Required barcode data")
+	}
+	for i := 0; i < len(data); i++ {
+		if data[i] < 32 || data[i] > 126 {
+			return nil, ErrCode128Character
+		}
+	}
+
+	values := []int{}
+	codeC := false
+	for i := 0; i < len(data); {
+		digits := digitRun(data[i:])
+		// An odd run is started in code set B so that code set C ends with the run
+		if digits >= 4 {
+			if digits%2 == 1 {
+				values = append(values, int(data[i])-32)
+				i++
+				digits--
+			}
+			if !codeC {
+				values = append(values, code128CodeC)
+				codeC = true
+			}
+			for ; digits > 0; digits -= 2 {
+				values = append(values, int(data[i]-'0')*10+int(data[i+1]-'0'))
+				i += 2
+			}
+			continue
+		}
+		if codeC {
+			values = append(values, code128CodeB)
+			codeC = false
+		}
+		values = append(values, int(data[i])-32)
+		i++
+	}
+
+	// The first switch becomes the start symbol
+	start := code128StartB
+	if values[0] == code128CodeC {
+		start = code128StartC
+		values = values[1:]
+	}
+	symbols := append([]int{start}, values...)
+	checksum := start
+	for i, value := range values {
+		checksum += (i + 1) * value
+	}
+	symbols = append(symbols, checksum%103, code128Stop)
+
+	widths := []int{}
+	for _, symbol := range symbols {
+		for _, width := range code128Patterns[symbol] {
+			widths = append(widths, int(width-'0'))
+		}
+	}
+	return widths, nil
+}
+
+// digitRun returns how many digits data starts with
+func digitRun(data string) int {
+	n := 0
+	for n < len(data) && data[n] >= '0' && data[n] <= '9' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,92 @@
+// Package labels renders 4x6 inch shipping labels as PDF and ZPL. Everything is drawn here, barcodes included,
+// so rendering needs nothing but the standard library
+package labels
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nmelhado/smartmail-api/api/responses"
+)
+
+// Format is the output format of a label
+type Format string
+
+const (
+	// PDF labels are for office printers
+	PDF Format = "pdf"
+	// ZPL labels are for Zebra thermal printers at 203 dpi
+	ZPL Format = "zpl"
+)
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	if f == ZPL {
+		return "application/x-zpl"
+	}
+	return "application/pdf"
+}
+
+// ErrFormat is returned for a format other than pdf or zpl
+var ErrFormat = errors.New("Format must be pdf or zpl")
+
+// ParseFormat reads a format, an empty format is PDF
+func ParseFormat(format string) (Format, error) {
+	switch Format(strings.ToLower(format)) {
+	case "", PDF:
+		return PDF, nil
+	case ZPL:
+		return ZPL, nil
+	}
+	return "", ErrFormat
+}
+
+// Label is everything printed on a shipping label. From may be empty, SmartID is the recipient's and is left off the label when it is empty
+type Label struct {
+	From     responses.AddressSmartIDResponse
+	To       responses.AddressSmartIDResponse
+	Carrier  string
+	Tracking string
+	Date     string
+}
+
+// Render draws the label in the format
+func Render(label Label, format Format) ([]byte, error) {
+	if label.Tracking == "" {
+		return nil, errors.New("Required tracking")
+	}
+	switch format {
+	case PDF:
+		return renderPDF(label)
+	case ZPL:
+		return renderZPL(label)
+	}
+	return nil, ErrFormat
+}
+
+// addressLines returns the lines of an address as they are printed, skipping the ones that are empty
+func addressLines(address responses.AddressSmartIDResponse) []string {
+	lines := []string{}
+	add := func(line string) {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	add(address.FirstName + " " + address.LastName)
+	add(address.BusinessName)
+	if address.AttentionTo != "" {
+		add("ATTN: " + address.AttentionTo)
+	}
+	add(address.LineOne)
+	add(address.LineTwo)
+	cityLine := address.City
+	if address.State != "" {
+		cityLine += ", " + address.State
+	}
+	add(strings.TrimPrefix(cityLine+" "+address.ZipCode, ", "))
+	if address.Country != "" && address.Country != "United States" {
+		add(address.Country)
+	}
+	return lines
+}
@@ -0,0 +1,178 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The PDF page is 4x6 inches in points, with the origin in the bottom left corner
+const (
+	pdfWidth  = 288
+	pdfHeight = 432
+	pdfMargin = 14
+)
+
+// pdfPage is the content stream of a page being drawn
+type pdfPage struct {
+	bytes.Buffer
+}
+
+// text draws a line of text with its baseline at y. font is F1 for Helvetica or F2 for Helvetica-Bold
+func (p *pdfPage) text(font string, size float64, x float64, y float64, line string) {
+	fmt.Fprintf(p, "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, pdfNumber(size), pdfNumber(x), pdfNumber(y), pdfString(line))
+}
+
+// rect fills a rectangle from its bottom left corner
+func (p *pdfPage) rect(x float64, y float64, width float64, height float64) {
+	fmt.Fprintf(p, "%s %s %s %s re f\n", pdfNumber(x), pdfNumber(y), pdfNumber(width), pdfNumber(height))
+}
+
+// rule draws a horizontal line across the page
+func (p *pdfPage) rule(y float64) {
+	p.rect(pdfMargin, y, pdfWidth-2*pdfMargin, 1)
+}
+
+// code128 draws the bars of a Code 128 barcode, centred in the page
+func (p *pdfPage) code128(widths []int, y float64, height float64) {
+	modules := 0
+	for _, width := range widths {
+		modules += width
+	}
+	// Code 128 needs a quiet zone of 10 modules on both sides
+	module := 2.0
+	if available := float64(pdfWidth - 2*pdfMargin); float64(modules+20)*module > available {
+		module = available / float64(modules+20)
+	}
+	x := (pdfWidth - float64(modules)*module) / 2
+	for i, width := range widths {
+		if i%2 == 0 {
+			p.rect(x, y, float64(width)*module, height)
+		}
+		x += float64(width) * module
+	}
+}
+
+// qr draws a QR code size points wide with its bottom left corner at x, y
+func (p *pdfPage) qr(modules [][]bool, x float64, y float64, size float64) {
+	module := size / float64(len(modules))
+	for row, line := range modules {
+		for col, dark := range line {
+			if dark {
+				p.rect(x+float64(col)*module, y+size-float64(row+1)*module, module, module)
+			}
+		}
+	}
+}
+
+// renderPDF draws the label as a single page PDF. It uses only the standard Helvetica fonts and no dates, so the same label is
+// always the same file
+func renderPDF(label Label) ([]byte, error) {
+	barcode, err := Code128(label.Tracking)
+	if err != nil {
+		return nil, err
+	}
+	var qr [][]bool
+	if label.To.SmartID != "" {
+		qr, err = QR(label.To.SmartID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := &pdfPage{}
+	top := float64(pdfHeight - pdfMargin)
+
+	page.text("F2", 7, pdfMargin, top-7, "FROM:")
+	for i, line := range addressLines(label.From) {
+		page.text("F1", 8, pdfMargin, top-17-float64(i)*9, truncate(line, 34))
+	}
+	page.text("F2", 14, 190, top-14, truncate(strings.ToUpper(label.Carrier), 11))
+	page.text("F1", 8, 190, top-26, label.Date)
+	page.rule(348)
+
+	page.text("F2", 7, pdfMargin, 334, "SHIP TO:")
+	for i, line := range addressLines(label.To) {
+		font, size := "F1", 12.0
+		if i == 0 {
+			font, size = "F2", 14.0
+		}
+		page.text(font, size, pdfMargin+10, 314-float64(i)*16, truncate(line, 30))
+	}
+	page.rule(196)
+
+	page.text("F2", 7, pdfMargin, 184, "TRACKING #:")
+	page.code128(barcode, 112, 60)
+	page.text("F2", 11, pdfMargin, 96, label.Tracking)
+	page.rule(86)
+
+	if qr != nil {
+		page.qr(qr, pdfMargin, pdfMargin, 64)
+		page.text("F2", 7, pdfMargin+76, 60, "SMARTID:")
+		page.text("F2", 14, pdfMargin+76, 42, label.To.SmartID)
+	}
+
+	return pdfDocument(page.Bytes()), nil
+}
+
+// pdfDocument wraps a content stream in a PDF with one page and the two fonts it uses
+func pdfDocument(content []byte) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfWidth, pdfHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+	}
+
+	document := &bytes.Buffer{}
+	document.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, object := range objects {
+		offsets = append(offsets, document.Len())
+		fmt.Fprintf(document, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := document.Len()
+	fmt.Fprintf(document, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(document, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(document, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return document.Bytes()
+}
+
+// pdfNumber formats a number with at most two decimals
+func pdfNumber(n float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", n), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// pdfString escapes text for a PDF string in WinAnsiEncoding. Characters outside Latin-1 cannot be drawn by the standard fonts and
+// are replaced with ?
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// truncate shortens a line to at most n characters so it fits on the label
+func truncate(line string, n int) string {
+	runes := []rune(line)
+	if len(runes) <= n {
+		return line
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,394 @@
+package labels
+
+import (
+	"errors"
+)
+
+// qrVersion is the layout of one QR code version at error correction level M
+type qrVersion struct {
+	// dataCodewords is the length of each data block, there is one error correction block of ecCodewords per data block
+	dataCodewords []int
+	ecCodewords   int
+	// alignment is the centre of the alignment pattern in the bottom right corner, 0 for version 1 which has none
+	alignment int
+}
+
+// qrVersions are versions 1 to 4, which hold up to 62 bytes at level M. That is plenty for a SmartID and keeps every
+// version to a single alignment pattern and no version information
+var qrVersions = []qrVersion{
+	{dataCodewords: []int{16}, ecCodewords: 10},
+	{dataCodewords: []int{28}, ecCodewords: 16, alignment: 18},
+	{dataCodewords: []int{44}, ecCodewords: 26, alignment: 22},
+	{dataCodewords: []int{32, 32}, ecCodewords: 18, alignment: 26},
+}
+
+// ErrQRTooLong is returned when the data does not fit in the largest supported QR code
+var ErrQRTooLong = errors.New("Data is too long for a QR code")
+
+// QR encodes data as a QR code in byte mode at error correction level M, using the smallest version it fits in.
+// The result is indexed by row then column and true is a dark module. It does not include the quiet zone
+func QR(data string) ([][]bool, error) {
+	for i, version := range qrVersions {
+		capacity := 0
+		for _, n := range version.dataCodewords {
+			capacity += n
+		}
+		// 4 bits of mode and 8 bits of length before the data
+		if 12+len(data)*8 > capacity*8 {
+			continue
+		}
+		qr := newQRMatrix(i+1, version)
+		qr.drawCodewords(qrCodewords(data, version, capacity))
+		qr.applyBestMask()
+		return qr.modules, nil
+	}
+	return nil, ErrQRTooLong
+}
+
+// qrCodewords returns the data codewords followed by the error correction codewords, interleaved block by block
+func qrCodewords(data string, version qrVersion, capacity int) []byte {
+	bits := qrBits{}
+	bits.append(0x4, 4)
+	bits.append(len(data), 8)
+	for _, b := range []byte(data) {
+		bits.append(int(b), 8)
+	}
+	// Terminator, then pad to a whole byte
+	for i := 0; i < 4 && len(bits) < capacity*8; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	codewords := bits.bytes()
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	divisor := reedSolomonDivisor(version.ecCodewords)
+	blocks := [][]byte{}
+	ecBlocks := [][]byte{}
+	offset := 0
+	for _, n := range version.dataCodewords {
+		block := codewords[offset : offset+n]
+		offset += n
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+	}
+
+	result := []byte{}
+	for i := 0; ; i++ {
+		added := false
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	for i := 0; i < version.ecCodewords; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// qrBits is a bit buffer, most significant bit first
+type qrBits []bool
+
+func (qb *qrBits) append(value int, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*qb = append(*qb, (value>>uint(i))&1 == 1)
+	}
+}
+
+func (qb qrBits) bytes() []byte {
+	result := make([]byte, len(qb)/8)
+	for i, bit := range qb {
+		if bit {
+			result[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies two elements of GF(256) with the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x byte, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z&0x80 != 0
+		z <<= 1
+		if carry {
+			z ^= 0x1D
+		}
+		if (y>>uint(i))&1 == 1 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// reedSolomonDivisor returns the generator polynomial of the degree, highest power first without its leading 1
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords of the data
+func reedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// qrMatrix is a QR code being drawn. function marks the modules of the finder, timing, alignment and format patterns,
+// which are not part of the data and are not masked
+type qrMatrix struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func newQRMatrix(number int, version qrVersion) *qrMatrix {
+	size := number*4 + 17
+	qr := &qrMatrix{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, size)
+		qr.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		qr.set(6, i, i%2 == 0)
+		qr.set(i, 6, i%2 == 0)
+	}
+	qr.drawFinder(3, 3)
+	qr.drawFinder(3, size-4)
+	qr.drawFinder(size-4, 3)
+	if version.alignment != 0 {
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				qr.set(version.alignment+dy, version.alignment+dx, max(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+	// Reserve the format areas, they are drawn once the mask is chosen
+	qr.drawFormat(0)
+	return qr
+}
+
+// set draws a function module
+func (qr *qrMatrix) set(row int, col int, dark bool) {
+	qr.modules[row][col] = dark
+	qr.function[row][col] = true
+}
+
+// drawFinder draws a finder pattern and its separator around the centre
+func (qr *qrMatrix) drawFinder(row int, col int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			r, c := row+dy, col+dx
+			if r < 0 || r >= qr.size || c < 0 || c >= qr.size {
+				continue
+			}
+			distance := max(abs(dx), abs(dy))
+			qr.set(r, c, distance != 2 && distance != 4)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level M and the mask, along with the dark module
+func (qr *qrMatrix) drawFormat(mask int) {
+	// Level M is 00, followed by the mask and a BCH(15, 5) code
+	data := mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.set(i, 8, bit(i))
+	}
+	qr.set(7, 8, bit(6))
+	qr.set(8, 8, bit(7))
+	qr.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.set(8, 14-i, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		qr.set(8, qr.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.set(qr.size-15+i, 8, bit(i))
+	}
+	qr.set(qr.size-8, 8, true)
+}
+
+// drawCodewords places the codewords in the two column wide zigzag from the bottom right corner, skipping function modules
+func (qr *qrMatrix) drawCodewords(codewords []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				col := right - j
+				upward := (right+1)&2 == 0
+				row := vert
+				if upward {
+					row = qr.size - 1 - vert
+				}
+				if !qr.function[row][col] && i < len(codewords)*8 {
+					qr.modules[row][col] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules the mask pattern selects, applying it twice undoes it
+func (qr *qrMatrix) applyMask(mask int) {
+	for row := 0; row < qr.size; row++ {
+		for col := 0; col < qr.size; col++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (row+col)%2 == 0
+			case 1:
+				flip = row%2 == 0
+			case 2:
+				flip = col%3 == 0
+			case 3:
+				flip = (row+col)%3 == 0
+			case 4:
+				flip = (row/2+col/3)%2 == 0
+			case 5:
+				flip = row*col%2+row*col%3 == 0
+			case 6:
+				flip = (row*col%2+row*col%3)%2 == 0
+			case 7:
+				flip = ((row+col)%2+row*col%3)%2 == 0
+			}
+			if flip && !qr.function[row][col] {
+				qr.modules[row][col] = !qr.modules[row][col]
+			}
+		}
+	}
+}
+
+// applyBestMask tries every mask and keeps the one with the lowest penalty
+func (qr *qrMatrix) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(mask)
+		penalty := qr.penalty()
+		if bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask)
+	}
+	qr.applyMask(best)
+	qr.drawFormat(best)
+}
+
+// penalty scores the matrix with the four rules of the QR code specification, lower is easier to scan
+func (qr *qrMatrix) penalty() int {
+	penalty := 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	line := func(i int, j int, horizontal bool) bool {
+		if horizontal {
+			return qr.modules[i][j]
+		}
+		return qr.modules[j][i]
+	}
+	for _, horizontal := range []bool{true, false} {
+		for i := 0; i < qr.size; i++ {
+			run := 1
+			for j := 1; j <= qr.size; j++ {
+				if j < qr.size && line(i, j, horizontal) == line(i, j-1, horizontal) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+			for j := 0; j+11 <= qr.size; j++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if line(i, j+k, horizontal) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for row := 0; row < qr.size; row++ {
+		for col := 0; col < qr.size; col++ {
+			if qr.modules[row][col] {
+				dark++
+			}
+			if row+1 < qr.size && col+1 < qr.size {
+				c := qr.modules[row][col]
+				if qr.modules[row][col+1] == c && qr.modules[row+1][col] == c && qr.modules[row+1][col+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := qr.size * qr.size
+	penalty += abs(dark*100/total-50) / 5 * 10
+	return penalty
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(x int, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,94 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The ZPL label is 4x6 inches in dots at 203 dpi, with the origin in the top left corner
+const (
+	zplWidth  = 812
+	zplHeight = 1218
+	zplMargin = 30
+)
+
+// zplLabel is the ZPL of a label being drawn
+type zplLabel struct {
+	bytes.Buffer
+}
+
+// text draws a line of text with its top at y
+func (z *zplLabel) text(size int, x int, y int, line string) {
+	fmt.Fprintf(z, "^FO%d,%d^A0N,%d,%d^FH^FD%s^FS\n", x, y, size, size, zplString(line))
+}
+
+// rule draws a horizontal line across the label
+func (z *zplLabel) rule(y int) {
+	fmt.Fprintf(z, "^FO%d,%d^GB%d,3,3^FS\n", zplMargin, y, zplWidth-2*zplMargin)
+}
+
+// renderZPL draws the label as ZPL. The printer draws the barcodes itself, Code128 is only used to check the tracking number can be
+// encoded and to choose a bar width that fits the label
+func renderZPL(label Label) ([]byte, error) {
+	barcode, err := Code128(label.Tracking)
+	if err != nil {
+		return nil, err
+	}
+	if label.To.SmartID != "" {
+		_, err = QR(label.To.SmartID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	modules := 0
+	for _, width := range barcode {
+		modules += width
+	}
+	module := (zplWidth - 2*zplMargin) / modules
+	if module > 3 {
+		module = 3
+	}
+	if module < 1 {
+		module = 1
+	}
+
+	z := &zplLabel{}
+	fmt.Fprintf(z, "^XA\n^CI28\n^PW%d\n^LL%d\n", zplWidth, zplHeight)
+
+	z.text(20, zplMargin, zplMargin, "FROM:")
+	for i, line := range addressLines(label.From) {
+		z.text(24, zplMargin, zplMargin+26+i*26, truncate(line, 34))
+	}
+	z.text(48, 540, zplMargin, truncate(strings.ToUpper(label.Carrier), 11))
+	z.text(24, 540, zplMargin+56, label.Date)
+	z.rule(250)
+
+	z.text(20, zplMargin, 275, "SHIP TO:")
+	for i, line := range addressLines(label.To) {
+		size := 40
+		if i == 0 {
+			size = 48
+		}
+		z.text(size, zplMargin+30, 310+i*54, truncate(line, 30))
+	}
+	z.rule(665)
+
+	z.text(20, zplMargin, 690, "TRACKING #:")
+	fmt.Fprintf(z, "^FO%d,%d^BY%d^BCN,170,N,N,N,A^FH^FD%s^FS\n", (zplWidth-modules*module)/2, 730, module, zplString(label.Tracking))
+	z.text(32, zplMargin, 915, label.Tracking)
+	z.rule(965)
+
+	if label.To.SmartID != "" {
+		fmt.Fprintf(z, "^FO%d,%d^BQN,2,5^FH^FDMA,%s^FS\n", zplMargin, 985, zplString(label.To.SmartID))
+		z.text(20, 260, 1060, "SMARTID:")
+		z.text(48, 260, 1090, label.To.SmartID)
+	}
+	z.WriteString("^XZ\n")
+	return z.Bytes(), nil
+}
+
+// zplString escapes the characters ZPL treats as commands in a field sent with ^FH
+func zplString(text string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace(text)
+}
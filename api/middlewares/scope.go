@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+)
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// SetMiddlewareScope authenticates the request and checks that the UI user or API user it was made by has every scope the route needs.
+// Requests without a valid token get a 401, requests from users that lack a scope get a 403.
+// The principal is attached to the request context, handlers read it with PrincipalFromContext
+func SetMiddlewareScope(store repository.Store, next http.HandlerFunc, scopes ...models.Scope) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uid, permission, err := auth.ExtractAPIUserTokenID(r)
+		if err != nil {
+			fmt.Print("\nUnauthorized\n")
+			responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+			return
+		}
+
+		principal, err := repository.LoadPrincipal(store, uid, permission)
+		if err != nil {
+			fmt.Print("\nUnauthorized\n")
+			responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+			return
+		}
+
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				fmt.Printf("\nForbidden: missing scope %s\n", scope)
+				responses.ERROR(w, http.StatusForbidden, errors.New(http.StatusText(http.StatusForbidden)))
+				return
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey, principal)))
+	}
+}
+
+// PrincipalFromContext returns the principal attached by SetMiddlewareScope, or nil if the route is not scoped
+func PrincipalFromContext(r *http.Request) *models.Principal {
+	principal, _ := r.Context().Value(principalKey).(*models.Principal)
+	return principal
+}
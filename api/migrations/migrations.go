@@ -0,0 +1,295 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/models"
+)
+
+// DefaultDir is where the migration files live when MIGRATIONS_DIR is not set
+const DefaultDir = "api/migrations/sql"
+
+// noTransaction is the first line of a migration script that cannot run inside a transaction (e.g. ALTER TYPE ... ADD VALUE on older Postgres).
+// Its statements are sent one at a time, since Postgres runs a multi-statement query in an implicit transaction
+const noTransaction = "-- migrate:no-transaction"
+
+// ErrSchemaBehind is returned when the DB has migrations that have not been applied
+var ErrSchemaBehind = errors.New("Database schema is behind")
+
+// ErrSchemaAhead is returned when the DB has applied migrations that this build does not know about
+var ErrSchemaAhead = errors.New("Database schema is ahead")
+
+var fileName = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a numbered pair of up and down SQL scripts
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// SchemaMigration is the DB table structure that records which migrations have been applied
+type SchemaMigration struct {
+	Version   int       `gorm:"primary_key"`
+	Name      string    `gorm:"size:255;not null;"`
+	AppliedAt time.Time `gorm:"not null;"`
+}
+
+// Migrator applies and rolls back migrations against the DB
+type Migrator struct {
+	DB         *gorm.DB
+	Migrations []Migration
+}
+
+// Dir returns the migration directory, MIGRATIONS_DIR overrides the default
+func Dir() string {
+	if os.Getenv("MIGRATIONS_DIR") != "" {
+		return os.Getenv("MIGRATIONS_DIR")
+	}
+	return DefaultDir
+}
+
+// Load reads every migration in a directory. Each version needs both an up and a down file
+func Load(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := fileName.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		contents, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("Migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		sql := string(contents)
+		if match[3] == "up" {
+			m.Up = sql
+		} else {
+			m.Down = sql
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("Migration %d_%s is missing its up file", m.Version, m.Name)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("Migration %d_%s is missing its down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// New loads the migrations in a directory
+func New(db *gorm.DB, dir string) (*Migrator, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// applied returns the versions recorded in schema_migrations, creating the table if needed
+func (m *Migrator) applied() (map[int]SchemaMigration, error) {
+	err := m.DB.Debug().Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name varchar(255) NOT NULL,
+	applied_at timestamp with time zone NOT NULL
+)`).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []SchemaMigration{}
+	err = m.DB.Debug().Model(&SchemaMigration{}).Order("version").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]SchemaMigration{}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := []Status{}
+	for _, migration := range m.Migrations {
+		row, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied, in order
+func (m *Migrator) Pending() ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// CheckCurrent returns ErrSchemaAhead if the DB has applied a migration that is not loaded, e.g. it was migrated by a newer
+// build or a migration was removed, and ErrSchemaBehind if any migration has not been applied
+func (m *Migrator) CheckCurrent() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	known := map[int]bool{}
+	pending := []Migration{}
+	for _, migration := range m.Migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	unknown := []int{}
+	for version := range applied {
+		if !known[version] {
+			unknown = append(unknown, version)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Ints(unknown)
+		return fmt.Errorf("%w: %d unknown migrations applied starting at %04d_%s", ErrSchemaAhead, len(unknown), unknown[0], applied[unknown[0]].Name)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migrations starting at %04d_%s", ErrSchemaBehind, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// Up applies every pending migration in order and returns the ones it applied. It stops at the first failure
+func (m *Migrator) Up() ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	applied := []Migration{}
+	for _, migration := range pending {
+		err = m.run(migration.Up, func(db *gorm.DB) error {
+			return db.Debug().Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("Migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down rolls back the most recently applied migrations, newest first, and returns the ones it rolled back
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	rolledBack := []Migration{}
+	for i := len(statuses) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		migration := statuses[i].Migration
+		if !statuses[i].Applied {
+			continue
+		}
+		err = m.run(migration.Down, func(db *gorm.DB) error {
+			return db.Debug().Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("Rolling back migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		rolledBack = append(rolledBack, migration)
+	}
+	return rolledBack, nil
+}
+
+// run executes a migration script and records it. Both happen in one transaction unless the script opts out
+func (m *Migrator) run(sql string, record func(db *gorm.DB) error) error {
+	if strings.HasPrefix(strings.TrimSpace(sql), noTransaction) {
+		for _, statement := range statements(sql) {
+			err := m.DB.Debug().Exec(statement).Error
+			if err != nil {
+				return err
+			}
+		}
+		return record(m.DB)
+	}
+
+	return models.Transaction(m.DB, func(tx *gorm.DB) error {
+		err := tx.Debug().Exec(sql).Error
+		if err != nil {
+			return err
+		}
+		return record(tx)
+	})
+}
+
+// statements splits a script into the statements that end each line with a semicolon, skipping comment lines
+func statements(sql string) []string {
+	result := []string{}
+	current := []string{}
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(trimmed, ";") {
+			result = append(result, strings.Join(current, "\n"))
+			current = []string{}
+		}
+	}
+	if len(current) > 0 {
+		result = append(result, strings.Join(current, "\n"))
+	}
+	return result
+}
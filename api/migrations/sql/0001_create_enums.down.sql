@@ -0,0 +1,3 @@
+DROP TYPE IF EXISTS api_permission;
+DROP TYPE IF EXISTS authority;
+DROP TYPE IF EXISTS status;
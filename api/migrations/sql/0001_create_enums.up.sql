@@ -0,0 +1,35 @@
+-- The first migrations describe the schema that AutoMigrate used to create. Every statement is guarded so that
+-- a database that was set up by AutoMigrate can be brought under migrations without losing data.
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'status') THEN
+		CREATE TYPE status AS ENUM (
+			'permanent',
+			'temporary',
+			'package_only_permanent',
+			'package_only_temporary',
+			'mail_only_permanent',
+			'mail_only_temporary',
+			'expired',
+			'deleted');
+	END IF;
+
+	IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'authority') THEN
+		CREATE TYPE authority AS ENUM (
+			'user',
+			'retailer',
+			'admin',
+			'engineer');
+	END IF;
+
+	IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'api_permission') THEN
+		CREATE TYPE api_permission AS ENUM (
+			'none',
+			'full',
+			'limited',
+			'admin',
+			'engineer');
+	END IF;
+END
+$$;
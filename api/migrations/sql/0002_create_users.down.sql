@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS api_users;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS users (
+	id uuid PRIMARY KEY,
+	smart_id varchar(8) NOT NULL UNIQUE,
+	email varchar(100) NOT NULL UNIQUE,
+	first_name varchar(30) NOT NULL,
+	last_name varchar(30) NOT NULL,
+	phone varchar(30) NOT NULL,
+	authority authority,
+	password varchar(100) NOT NULL,
+	small_logo varchar(100),
+	large_logo varchar(100),
+	redirect_url varchar(100),
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ix_smart_id ON users (smart_id);
+
+CREATE TABLE IF NOT EXISTS api_users (
+	id uuid PRIMARY KEY,
+	username varchar(100) NOT NULL UNIQUE,
+	email varchar(100) NOT NULL UNIQUE,
+	name varchar(30) NOT NULL,
+	phone varchar(30) NOT NULL,
+	smartmail_user_id uuid REFERENCES users(id),
+	permission api_permission DEFAULT 'none',
+	password varchar(100) NOT NULL,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS address_assignments;
+DROP TABLE IF EXISTS addresses;
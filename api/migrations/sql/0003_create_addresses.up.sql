@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS addresses (
+	id bigserial PRIMARY KEY,
+	nickname varchar(255),
+	line_one varchar(255) NOT NULL,
+	line_two varchar(255),
+	business_name varchar(255),
+	attention_to varchar(255),
+	city varchar(255) NOT NULL,
+	state varchar(255) NOT NULL,
+	zip_code varchar(255) NOT NULL,
+	country varchar(255) NOT NULL,
+	phone varchar(255),
+	latitude numeric,
+	longitude numeric,
+	delivery_instructions varchar(255),
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS address_assignments (
+	id bigserial PRIMARY KEY,
+	user_id uuid REFERENCES users(id),
+	address_id int REFERENCES addresses(id),
+	status status,
+	start_date timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	end_date timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_address_assignments_user_id_status ON address_assignments (user_id, status);
+
+CREATE TABLE IF NOT EXISTS contacts (
+	id bigserial PRIMARY KEY,
+	user_id uuid REFERENCES users(id),
+	contact_id uuid REFERENCES users(id),
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_contacts_user_id ON contacts (user_id);
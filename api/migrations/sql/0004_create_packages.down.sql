@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS packages;
+DROP TABLE IF EXISTS package_descriptions;
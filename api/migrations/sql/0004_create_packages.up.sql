@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS package_descriptions (
+	id bigserial PRIMARY KEY,
+	contents varchar(255),
+	order_link varchar(255),
+	order_image varchar(255),
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS packages (
+	id bigserial PRIMARY KEY,
+	mail_carrier_id uuid NOT NULL REFERENCES api_users(id),
+	sender_id uuid REFERENCES users(id),
+	recipient_id uuid REFERENCES users(id),
+	tracking varchar(255),
+	package_description_id bigint REFERENCES package_descriptions(id),
+	estimated_delivery timestamp with time zone,
+	delivered boolean,
+	delivered_on timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_packages_tracking ON packages (tracking);
+CREATE INDEX IF NOT EXISTS ix_packages_sender_id ON packages (sender_id);
+CREATE INDEX IF NOT EXISTS ix_packages_recipient_id ON packages (recipient_id);
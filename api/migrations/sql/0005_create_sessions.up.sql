@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL,
+	kind varchar(10) NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	revoked_at timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_sessions_user_id ON sessions (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS ix_address_assignments_end_date;
+DROP TABLE IF EXISTS address_status_transitions;
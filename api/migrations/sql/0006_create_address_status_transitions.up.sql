@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS address_status_transitions (
+	id bigserial PRIMARY KEY,
+	address_assignment_id int REFERENCES address_assignments(id),
+	from_status status,
+	to_status status,
+	transitioned_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_address_status_transitions_assignment_id ON address_status_transitions (address_assignment_id);
+CREATE INDEX IF NOT EXISTS ix_address_assignments_end_date ON address_assignments (end_date) WHERE end_date IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE address_assignments ADD COLUMN IF NOT EXISTS recurrence varchar(50);
@@ -0,0 +1,4 @@
+-- Postgres cannot remove values from an enum, so the hold statuses are left in place.
+-- Holds are expired so that nothing depends on them.
+UPDATE address_assignments SET status = 'expired' WHERE status IN ('hold', 'mail_only_hold', 'package_only_hold');
+ALTER TABLE address_assignments DROP COLUMN IF EXISTS pickup_location;
@@ -0,0 +1,7 @@
+-- migrate:no-transaction
+-- Postgres before 12 cannot add enum values inside a transaction
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'hold';
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'mail_only_hold';
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'package_only_hold';
+
+ALTER TABLE address_assignments ADD COLUMN IF NOT EXISTS pickup_location varchar(255);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS package_events (
+	id bigserial PRIMARY KEY,
+	package_id bigint NOT NULL REFERENCES packages(id),
+	status varchar(30) NOT NULL,
+	location varchar(255),
+	estimated_delivery timestamp with time zone,
+	occurred_at timestamp with time zone NOT NULL,
+	source_id uuid REFERENCES api_users(id),
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_package_events_package_id ON package_events (package_id);
@@ -0,0 +1,2 @@
+ALTER TABLE packages DROP COLUMN IF EXISTS carrier_id;
+DROP TABLE IF EXISTS carriers;
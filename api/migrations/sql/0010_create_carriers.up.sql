@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS carriers (
+	id bigserial PRIMARY KEY,
+	code varchar(30) NOT NULL UNIQUE,
+	name varchar(100) NOT NULL,
+	api_user_id uuid NOT NULL UNIQUE REFERENCES api_users(id),
+	tracking_patterns text,
+	tracking_url varchar(255),
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The carriers that used to be built in, linked to the API user with the same username
+INSERT INTO carriers (code, name, api_user_id, tracking_patterns, tracking_url)
+SELECT seed.code, seed.name, api_users.id, seed.tracking_patterns, seed.tracking_url
+FROM (VALUES
+	('UPS', 'UPS', E'^1Z[0-9A-Z]{16}$', 'https://www.ups.com/track?tracknum={tracking}'),
+	('USPS', 'USPS', E'^9[1-5][0-9]{20}$\n^[A-Z]{2}[0-9]{9}US$', 'https://tools.usps.com/go/TrackConfirmAction?tLabels={tracking}'),
+	('FEDEX', 'FedEx', E'^[0-9]{12}$\n^[0-9]{15}$\n^[0-9]{20}$\n^[0-9]{22}$', 'https://www.fedex.com/fedextrack/?trknbr={tracking}'),
+	('LASERSHIP', 'LaserShip', E'^1LS[0-9A-Z]{12,}$\n^L[A-Z][0-9]{8}$', 'https://www.lasership.com/track/{tracking}')
+) AS seed (code, name, tracking_patterns, tracking_url), api_users
+WHERE api_users.username = seed.code
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE packages ADD COLUMN IF NOT EXISTS carrier_id bigint REFERENCES carriers(id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS address_disclosures;
+DROP FUNCTION IF EXISTS address_disclosures_append_only();
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS address_disclosures (
+	id bigserial PRIMARY KEY,
+	api_user_id uuid NOT NULL REFERENCES api_users(id),
+	user_id uuid NOT NULL REFERENCES users(id),
+	address_assignment_id int NOT NULL REFERENCES address_assignments(id),
+	scope varchar(30) NOT NULL,
+	permission api_permission,
+	tracking varchar(255),
+	disclosed_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_address_disclosures_user_id ON address_disclosures (user_id, disclosed_at);
+CREATE INDEX IF NOT EXISTS ix_address_disclosures_api_user_id ON address_disclosures (api_user_id, disclosed_at);
+
+-- The audit log is append-only, rows can be added but never changed or removed
+CREATE OR REPLACE FUNCTION address_disclosures_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'address_disclosures is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER address_disclosures_append_only BEFORE UPDATE OR DELETE ON address_disclosures
+FOR EACH ROW EXECUTE PROCEDURE address_disclosures_append_only();
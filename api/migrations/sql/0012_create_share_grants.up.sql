@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS share_grants (
+	id bigserial PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES users(id),
+	api_user_id uuid NOT NULL REFERENCES api_users(id),
+	decision varchar(10) NOT NULL CHECK (decision IN ('allow', 'deny', 'ask')),
+	expires_at timestamp with time zone,
+	requested_at timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ux_share_grants_user_id_api_user_id ON share_grants (user_id, api_user_id);
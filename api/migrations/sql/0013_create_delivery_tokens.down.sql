@@ -0,0 +1,2 @@
+ALTER TABLE address_disclosures DROP COLUMN IF EXISTS delivery_token_id;
+DROP TABLE IF EXISTS delivery_tokens;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS delivery_tokens (
+	id bigserial PRIMARY KEY,
+	token varchar(40) NOT NULL UNIQUE,
+	user_id uuid NOT NULL REFERENCES users(id),
+	api_user_id uuid NOT NULL REFERENCES api_users(id),
+	kind varchar(10) NOT NULL CHECK (kind IN ('mail', 'package')),
+	max_uses bigint CHECK (max_uses > 0),
+	uses bigint NOT NULL DEFAULT 0,
+	expires_at timestamp with time zone,
+	revoked_at timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	CHECK (max_uses IS NOT NULL OR expires_at IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS ix_delivery_tokens_user_id ON delivery_tokens (user_id);
+
+ALTER TABLE address_disclosures ADD COLUMN IF NOT EXISTS delivery_token_id bigint REFERENCES delivery_tokens(id);
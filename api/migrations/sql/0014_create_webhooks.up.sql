@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id bigserial PRIMARY KEY,
+	api_user_id uuid NOT NULL REFERENCES api_users(id),
+	url varchar(2048) NOT NULL,
+	secret varchar(80) NOT NULL,
+	events text NOT NULL DEFAULT '',
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_webhooks_api_user_id ON webhooks (api_user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	webhook_id bigint NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event varchar(30) NOT NULL,
+	payload text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamp with time zone NOT NULL,
+	last_status bigint,
+	last_error text,
+	delivered_at timestamp with time zone,
+	dead_at timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+-- The dispatcher only looks for deliveries that are still waiting to be sent
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE delivered_at IS NULL AND dead_at IS NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS ix_packages_reroutes;
+
+ALTER TABLE packages DROP COLUMN IF EXISTS reroute_assignment_id;
+ALTER TABLE packages DROP COLUMN IF EXISTS address_changed;
+ALTER TABLE packages DROP COLUMN IF EXISTS address_assignment_id;
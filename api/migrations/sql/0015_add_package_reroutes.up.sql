@@ -0,0 +1,5 @@
+ALTER TABLE packages ADD COLUMN IF NOT EXISTS address_assignment_id bigint REFERENCES address_assignments(id);
+ALTER TABLE packages ADD COLUMN IF NOT EXISTS address_changed boolean NOT NULL DEFAULT false;
+ALTER TABLE packages ADD COLUMN IF NOT EXISTS reroute_assignment_id bigint REFERENCES address_assignments(id);
+
+CREATE INDEX IF NOT EXISTS ix_packages_reroutes ON packages (mail_carrier_id) WHERE address_changed;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS mailing_job_rows;
+DROP TABLE IF EXISTS mailing_jobs;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS mailing_jobs (
+	id uuid PRIMARY KEY,
+	api_user_id uuid NOT NULL REFERENCES api_users(id),
+	file_name varchar(255) NOT NULL DEFAULT '',
+	header text NOT NULL,
+	smart_id_column integer NOT NULL,
+	date_column integer,
+	mail_date timestamp with time zone NOT NULL,
+	status varchar(20) NOT NULL,
+	total_rows integer NOT NULL DEFAULT 0,
+	processed_rows integer NOT NULL DEFAULT 0,
+	failed_rows integer NOT NULL DEFAULT 0,
+	error text,
+	claimed_until timestamp with time zone,
+	started_at timestamp with time zone,
+	finished_at timestamp with time zone,
+	expires_at timestamp with time zone NOT NULL,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mailing_jobs_api_user_id ON mailing_jobs (api_user_id);
+-- The worker only looks for jobs that are waiting or whose worker stopped
+CREATE INDEX IF NOT EXISTS ix_mailing_jobs_pending ON mailing_jobs (created_at) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS ix_mailing_jobs_expires_at ON mailing_jobs (expires_at);
+
+CREATE TABLE IF NOT EXISTS mailing_job_rows (
+	id bigserial PRIMARY KEY,
+	job_id uuid NOT NULL REFERENCES mailing_jobs(id) ON DELETE CASCADE,
+	row_number integer NOT NULL,
+	record text NOT NULL,
+	result text,
+	error text,
+	processed_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ux_mailing_job_rows_job_id_row_number ON mailing_job_rows (job_id, row_number);
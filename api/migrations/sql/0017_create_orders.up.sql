@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS orders (
+	id uuid PRIMARY KEY,
+	retailer_id uuid NOT NULL REFERENCES users(id),
+	recipient_id uuid NOT NULL REFERENCES users(id),
+	reference varchar(100) NOT NULL DEFAULT '',
+	ship_date timestamp with time zone NOT NULL,
+	zip_code varchar(20) NOT NULL,
+	status varchar(20) NOT NULL CHECK (status IN ('created', 'handed_off', 'shipped')),
+	carrier_id bigint REFERENCES carriers(id),
+	tracking varchar(255),
+	package_id bigint REFERENCES packages(id),
+	handed_off_at timestamp with time zone,
+	shipped_at timestamp with time zone,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+	CHECK (status = 'created' OR (carrier_id IS NOT NULL AND tracking IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS ix_orders_retailer_id ON orders (retailer_id);
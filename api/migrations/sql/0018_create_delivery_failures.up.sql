@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS delivery_failures (
+	id bigserial PRIMARY KEY,
+	address_assignment_id bigint NOT NULL REFERENCES address_assignments(id),
+	user_id uuid NOT NULL REFERENCES users(id),
+	carrier_id bigint NOT NULL REFERENCES carriers(id),
+	reason varchar(20) NOT NULL CHECK (reason IN ('vacant', 'no_such_number', 'refused', 'moved')),
+	tracking varchar(255),
+	seen_at timestamp with time zone,
+	review_decision varchar(20) NOT NULL DEFAULT '' CHECK (review_decision IN ('', 'valid', 'invalid')),
+	reviewed_at timestamp with time zone,
+	reviewed_by uuid,
+	created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_delivery_failures_address_assignment_id ON delivery_failures (address_assignment_id);
+CREATE INDEX IF NOT EXISTS ix_delivery_failures_user_id ON delivery_failures (user_id);
+-- The admin review queue only looks at reports that have not been reviewed
+CREATE INDEX IF NOT EXISTS ix_delivery_failures_unreviewed ON delivery_failures (created_at) WHERE review_decision = '';
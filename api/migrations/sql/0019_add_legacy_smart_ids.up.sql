@@ -0,0 +1,5 @@
+-- Users who signed up before SmartIDs were issued by the server picked their own, which have no check character.
+-- Those SmartIDs keep resolving when they match exactly.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS legacy_smart_id boolean NOT NULL DEFAULT false;
+-- Every existing user is marked, a SmartID the server issued always passes the check so the flag never applies to it.
+UPDATE users SET legacy_smart_id = true;
@@ -0,0 +1,9 @@
+-- Retailer disclosures cannot be kept without the column, the append-only trigger is lifted to remove them
+ALTER TABLE address_disclosures DISABLE TRIGGER address_disclosures_append_only;
+DELETE FROM address_disclosures WHERE retailer_id IS NOT NULL;
+ALTER TABLE address_disclosures ENABLE TRIGGER address_disclosures_append_only;
+
+DROP INDEX IF EXISTS ix_address_disclosures_retailer_id;
+ALTER TABLE address_disclosures DROP CONSTRAINT IF EXISTS address_disclosures_requester_check;
+ALTER TABLE address_disclosures DROP COLUMN IF EXISTS retailer_id;
+ALTER TABLE address_disclosures ALTER COLUMN api_user_id SET NOT NULL;
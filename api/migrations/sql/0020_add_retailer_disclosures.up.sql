@@ -0,0 +1,7 @@
+-- Retailers are shown their customers' zip codes when they take an order. They are users, not API users, so a disclosure
+-- records either the API user or the retailer it was made to.
+ALTER TABLE address_disclosures ALTER COLUMN api_user_id DROP NOT NULL;
+ALTER TABLE address_disclosures ADD COLUMN IF NOT EXISTS retailer_id uuid REFERENCES users(id);
+ALTER TABLE address_disclosures ADD CONSTRAINT address_disclosures_requester_check CHECK ((api_user_id IS NULL) <> (retailer_id IS NULL));
+
+CREATE INDEX IF NOT EXISTS ix_address_disclosures_retailer_id ON address_disclosures (retailer_id, disclosed_at);
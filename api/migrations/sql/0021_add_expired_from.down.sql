@@ -0,0 +1 @@
+ALTER TABLE address_assignments DROP COLUMN IF EXISTS expired_from;
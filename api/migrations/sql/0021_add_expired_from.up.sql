@@ -0,0 +1,12 @@
+-- Superseded permanent assignments are expired like temporary ones. They are still looked up for past dates and restored when the
+-- permanent assignment that replaced them is deleted or moved, so the status they were expired from is kept.
+ALTER TABLE address_assignments ADD COLUMN IF NOT EXISTS expired_from status;
+
+UPDATE address_assignments aa SET expired_from = t.from_status
+FROM (
+	SELECT DISTINCT ON (address_assignment_id) address_assignment_id, from_status
+	FROM address_status_transitions
+	WHERE to_status = 'expired'
+	ORDER BY address_assignment_id, transitioned_at DESC
+) t
+WHERE aa.id = t.address_assignment_id AND aa.status = 'expired';
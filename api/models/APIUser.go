@@ -14,15 +14,7 @@ import (
 
 type Permission string
 
-/*
-postgres command to create enum:
-CREATE TYPE api_permission AS ENUM (
-	'none',
-	'full',
-	'limited',
-	'admin',
-	'engineer');
-*/
+// The api_permission enum is created in migration 0001_create_enums
 
 const (
 	// NoPermission is the default permission type, it gives no access
@@ -62,31 +54,6 @@ type APIUser struct {
 	UpdatedAt       time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
-// FullPermissions returns true if the provided permission level is adequate to access address full information
-func FullPermissions(permission Permission) bool {
-	switch permission {
-	case
-		AdminPermission,
-		EngineerPermission,
-		FullPermission:
-		return true
-	}
-	return false
-}
-
-// LimitedPermissions returns true if the provided permission level is adequate to access zip code information
-func LimitedPermissions(permission Permission) bool {
-	switch permission {
-	case
-		AdminPermission,
-		EngineerPermission,
-		FullPermission,
-		LimitedPermission:
-		return true
-	}
-	return false
-}
-
 // BeforeCreate will set a UUID rather than numeric ID.
 func (au *APIUser) BeforeCreate(scope *gorm.Scope) error {
 	uuid := uuid.NewV4()
@@ -207,6 +174,16 @@ func (au *APIUser) FindAPIUserByID(db *gorm.DB, uid uuid.UUID) (*APIUser, error)
 	return au, err
 }
 
+// FindAPIUsersForUser retrieves the API users linked to a smartmail user
+func (au *APIUser) FindAPIUsersForUser(db *gorm.DB, uid uuid.UUID) (*[]APIUser, error) {
+	aUsers := []APIUser{}
+	err := db.Debug().Model(&APIUser{}).Where("smartmail_user_id = ?", uid).Find(&aUsers).Error
+	if err != nil {
+		return &[]APIUser{}, err
+	}
+	return &aUsers, nil
+}
+
 // FindAPIUserIDByUsername retrieves the data for an API user ID by using their username
 func (au *APIUser) FindAPIUserIDByUsername(db *gorm.DB, name string) (uid uuid.UUID, err error) {
 	err = db.Debug().Set("gorm:auto_preload", true).Model(APIUser{}).Where("username = ?", name).Take(&au).Error
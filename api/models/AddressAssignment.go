@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/utils/recurrence"
 	uuid "github.com/satori/go.uuid"
 	"gopkg.in/guregu/null.v3"
 )
@@ -15,18 +16,7 @@ import (
 // refer to link for `Status` field: https://github.com/jinzhu/gorm/issues/1978
 type Status string
 
-/*
-postgres command to create enum:
-CREATE TYPE status AS ENUM (
-	'permanent',
-	'temporary',
-	'package_only_permanent',
-	'package_only_temporary',
-	'mail_only_permanent',
-	'mail_only_temporary',
-	'expired',
-	'deleted');
-*/
+// The status enum is created in migrations 0001_create_enums and 0008_add_hold_statuses
 
 const (
 	Permanent            Status = "permanent"
@@ -37,6 +27,9 @@ const (
 	MailOnlyTemporary    Status = "mail_only_temporary"
 	Expired              Status = "expired"
 	Deleted              Status = "deleted"
+	Hold                 Status = "hold"
+	MailOnlyHold         Status = "mail_only_hold"
+	PackageOnlyHold      Status = "package_only_hold"
 )
 
 // Scan - not quite sure what this does
@@ -50,18 +43,24 @@ func (s Status) Value() (driver.Value, error) {
 	return string(s), nil
 }
 
-// AddressAssignment is the DB table structure and json input structure for an address assignment. It is a one to many relationship table. One user can have many addresses
+// AddressAssignment is the DB table structure and json input structure for an address assignment. It is a one to many relationship table. One user can have many addresses.
+// A temporary assignment can have a Recurrence rule (see the recurrence package) that limits it to part of every year or week.
+// A hold assignment stops delivery until its end date, mail can be collected from the optional PickupLocation.
+// ExpiredFrom is the status an expired assignment had, a superseded permanent assignment is still looked up for past dates
 type AddressAssignment struct {
-	ID        uint64    `gorm:"primary_key;auto_increment" json:"id"`
-	User      User      `json:"user"`
-	UserID    uuid.UUID `gorm:"type:uuid" sql:"type:uuid REFERENCES users(id)" json:"user_id"`
-	Address   Address   `json:"address"`
-	AddressID uint64    `sql:"type:int REFERENCES addresses(id)" json:"address_id"`
-	Status    Status    `sql:"type:status" json:"status"`
-	StartDate time.Time `gorm:"default:CURRENT_TIMESTAMP;not null;" json:"start_date"`
-	EndDate   null.Time `gorm:"default:null" json:"end_date"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID             uint64      `gorm:"primary_key;auto_increment" json:"id"`
+	User           User        `json:"user"`
+	UserID         uuid.UUID   `gorm:"type:uuid" sql:"type:uuid REFERENCES users(id)" json:"user_id"`
+	Address        Address     `json:"address"`
+	AddressID      uint64      `sql:"type:int REFERENCES addresses(id)" json:"address_id"`
+	Status         Status      `sql:"type:status" json:"status"`
+	StartDate      time.Time   `gorm:"default:CURRENT_TIMESTAMP;not null;" json:"start_date"`
+	EndDate        null.Time   `gorm:"default:null" json:"end_date"`
+	Recurrence     null.String `gorm:"size:50" json:"recurrence"`
+	PickupLocation null.String `gorm:"size:255" json:"pickup_location"`
+	ExpiredFrom    null.String `sql:"type:status" json:"expired_from"`
+	CreatedAt      time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
 var validPackageStatus []Status = []Status{
@@ -90,11 +89,34 @@ var permanentStatus []Status = []Status{
 	PackageOnlyPermanent,
 }
 
+var holdStatus []Status = []Status{
+	Hold,
+	MailOnlyHold,
+	PackageOnlyHold,
+}
+
+// expirableStatus are the statuses the nightly job expires once the assignment's end date has passed. A permanent assignment
+// only has an end date once it is superseded
+var expirableStatus []Status = []Status{
+	Permanent,
+	Temporary,
+	PackageOnlyPermanent,
+	PackageOnlyTemporary,
+	MailOnlyPermanent,
+	MailOnlyTemporary,
+	Hold,
+	MailOnlyHold,
+	PackageOnlyHold,
+}
+
 var expiredAndDeleted []Status = []Status{
 	Expired,
 	Deleted,
 }
 
+// ErrTemporaryConflict is returned when a temporary address would be in effect on the same days as another
+var ErrTemporaryConflict = errors.New("There is a conflict with another temporary address change - please make sure that the dates for temporary addresses don't overlap")
+
 func contains(arr []Status, status Status) bool {
 	for _, a := range arr {
 		if a == status {
@@ -121,7 +143,23 @@ func (aa *AddressAssignment) Validate() error {
 	if aa.StartDate.IsZero() {
 		return errors.New("Start date required")
 	}
-	if contains(temporaryStatus, aa.Status) {
+	if aa.Recurrence.Valid {
+		if !contains(temporaryStatus, aa.Status) {
+			return errors.New("Only temporary addresses can recur")
+		}
+		_, err := recurrence.Parse(aa.Recurrence.String)
+		if err != nil {
+			return err
+		}
+	}
+	if contains(holdStatus, aa.Status) && !aa.EndDate.Valid {
+		return errors.New("End date required for a hold")
+	}
+	if aa.PickupLocation.Valid && !contains(holdStatus, aa.Status) {
+		return errors.New("Only holds can have a pickup location")
+	}
+	// A recurring address can be used every season with no end date
+	if contains(temporaryStatus, aa.Status) && !aa.Recurrence.Valid {
 		if !aa.EndDate.Valid {
 			return errors.New("End date required for temporary address")
 		}
@@ -129,20 +167,127 @@ func (aa *AddressAssignment) Validate() error {
 	return nil
 }
 
+// IsHold returns true if the assignment stops delivery rather than providing an address
+func (aa *AddressAssignment) IsHold() bool {
+	return contains(holdStatus, aa.Status)
+}
+
+// IsTemporary returns true if the assignment is one of the temporary statuses
+func (aa *AddressAssignment) IsTemporary() bool {
+	return contains(temporaryStatus, aa.Status)
+}
+
+// IsPermanent returns true if the assignment is one of the permanent statuses
+func (aa *AddressAssignment) IsPermanent() bool {
+	return contains(permanentStatus, aa.Status)
+}
+
+// IsOverdue returns true if the assignment is a temporary address, hold or superseded permanent address whose end date has passed on the day
+func (aa *AddressAssignment) IsOverdue(now time.Time) bool {
+	return contains(expirableStatus, aa.Status) && aa.ended(now)
+}
+
+// ended returns true if the assignment's end date has passed on the day
+func (aa *AddressAssignment) ended(now time.Time) bool {
+	today, _ := time.Parse("2006-01-02", now.Format("2006-01-02"))
+	return aa.EndDate.Valid && !aa.EndDate.Time.After(today)
+}
+
+// WasPermanent returns true if the assignment is permanent or is a superseded permanent assignment that has been expired
+func (aa *AddressAssignment) WasPermanent(status Status) bool {
+	return aa.Status == status || (aa.Status == Expired && aa.ExpiredFrom.Valid && Status(aa.ExpiredFrom.String) == status)
+}
+
+// IsSuperseded returns true if the assignment is a permanent assignment that was expired once another replaced it
+func (aa *AddressAssignment) IsSuperseded() bool {
+	return aa.Status == Expired && aa.ExpiredFrom.Valid && contains(permanentStatus, Status(aa.ExpiredFrom.String))
+}
+
+// lookupStatus is the status a lookup uses, a superseded permanent assignment is still found for the dates it covered
+func (aa *AddressAssignment) lookupStatus() Status {
+	if aa.IsSuperseded() {
+		return Status(aa.ExpiredFrom.String)
+	}
+	return aa.Status
+}
+
+// Expire moves the assignment to expired, keeping the status it had
+func (aa *AddressAssignment) Expire(now time.Time) {
+	aa.ExpiredFrom = null.StringFrom(string(aa.Status))
+	aa.Status = Expired
+	aa.UpdatedAt = now
+}
+
+// Restore puts a superseded permanent assignment that has been expired back to its permanent status
+func (aa *AddressAssignment) Restore(now time.Time) {
+	if aa.Status == Expired && aa.ExpiredFrom.Valid {
+		aa.Status = Status(aa.ExpiredFrom.String)
+		aa.ExpiredFrom = null.String{}
+	}
+	aa.UpdatedAt = now
+}
+
+// overlapHorizon is how far ahead overlapping recurring assignments are checked. Every yearly and weekly rule repeats within this time
+const overlapHorizon = 4*366 + 7
+
+// ActiveOn returns true if the assignment is in effect on the date, including its recurrence rule
+func (aa *AddressAssignment) ActiveOn(date time.Time) bool {
+	if !aa.StartDate.Before(date) || (aa.EndDate.Valid && !aa.EndDate.Time.After(date)) {
+		return false
+	}
+	if !aa.Recurrence.Valid {
+		return true
+	}
+	rule, err := recurrence.Parse(aa.Recurrence.String)
+	if err != nil {
+		return false
+	}
+	return rule.Matches(date)
+}
+
+// Overlaps returns true if both assignments are in effect on any of the same days
+func (aa *AddressAssignment) Overlaps(other *AddressAssignment) bool {
+	start := aa.StartDate
+	if other.StartDate.After(start) {
+		start = other.StartDate
+	}
+	end := start.AddDate(0, 0, overlapHorizon)
+	if aa.EndDate.Valid && aa.EndDate.Time.Before(end) {
+		end = aa.EndDate.Time
+	}
+	if other.EndDate.Valid && other.EndDate.Time.Before(end) {
+		end = other.EndDate.Time
+	}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if aa.ActiveOn(day) && other.ActiveOn(day) {
+			return true
+		}
+	}
+	return false
+}
+
 // SaveAddressAssignment is used to save an address assignment. It is called once a user already exists and the address has been created
 func (aa *AddressAssignment) SaveAddressAssignment(db *gorm.DB) (*AddressAssignment, error) {
 	var err error
 	if contains(temporaryStatus, aa.Status) {
+		// Candidates share part of the date range, recurring candidates only conflict if they are in effect on the same days
 		conflictingAddresses := []AddressAssignment{}
-		err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND status IN ('temporary', ?) AND ((start_date <= ? AND start_date >= ?) OR (end_date >= ? AND end_date <= ?))", aa.UserID, aa.Status, aa.EndDate, aa.StartDate, aa.StartDate, aa.EndDate).Limit(1).Find(&conflictingAddresses).Error
+		query := db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND status IN ('temporary', ?) AND (end_date IS NULL OR end_date >= ?)", aa.UserID, aa.Status, aa.StartDate)
+		if aa.EndDate.Valid {
+			query = query.Where("start_date <= ?", aa.EndDate)
+		}
+		err = query.Find(&conflictingAddresses).Error
 		if err != nil {
 			return &AddressAssignment{}, err
 		}
-		if len(conflictingAddresses) > 0 {
-			return &AddressAssignment{}, errors.New("There is a conflict with another temporary address change - please make sure that the dates for temporary addresses don't overlap")
+		for i := range conflictingAddresses {
+			if aa.Overlaps(&conflictingAddresses[i]) {
+				return &AddressAssignment{}, ErrTemporaryConflict
+			}
 		}
 	}
-	err = db.Debug().Model(&AddressAssignment{}).Create(&aa).Error
+	// the user and address are saved before their assignment, so they are not saved again here
+	err = db.Debug().Set("gorm:save_associations", false).Model(&AddressAssignment{}).Create(&aa).Error
 	if err != nil {
 		return &AddressAssignment{}, err
 	}
@@ -157,6 +302,9 @@ func (aa *AddressAssignment) SaveAddressAssignment(db *gorm.DB) (*AddressAssignm
 		}
 		if contains(permanentStatus, aa.Status) {
 			err = db.Debug().Model(&AddressAssignment{}).Where("status IN (?) AND id <> ? AND user_id = ? AND end_date IS NULL", permanentStatus, aa.ID, aa.UserID).Updates(AddressAssignment{EndDate: null.TimeFrom(aa.StartDate.AddDate(0, 0, -1)), UpdatedAt: time.Now()}).Error
+			if err != nil {
+				return &AddressAssignment{}, err
+			}
 		}
 	}
 	return aa, nil
@@ -174,15 +322,11 @@ func (aa *AddressAssignment) UpdateAddress(db *gorm.DB, aaid uint64, originalSta
 	}
 
 	if aa.Status == Permanent && aa.StartDate.Format("2006-01-02") != originalStart.Format("2006-01-02") {
-		priorAddress := AddressAssignment{}
-		err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND status = ? AND end_date = ?", aa.UserID, Permanent, originalStart.AddDate(0, 0, -1)).Find(&priorAddress).Error
+		priorAddress, err := findPriorPermanent(db, aa.UserID, originalStart)
 		if err != nil {
-			if gorm.IsRecordNotFoundError(err) {
-				return errors.New("Could not find previous address")
-			}
 			return err
 		}
-		err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND id = ?", aa.UserID, priorAddress.ID).Updates(AddressAssignment{EndDate: null.TimeFrom(aa.StartDate.AddDate(0, 0, -1)), UpdatedAt: time.Now()}).Error
+		err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND id = ?", aa.UserID, priorAddress.ID).Updates(priorAddress.MoveEnd(null.TimeFrom(aa.StartDate.AddDate(0, 0, -1)), time.Now())).Error
 		if err != nil {
 			if gorm.IsRecordNotFoundError(err) {
 				return errors.New("Address not found")
@@ -208,32 +352,86 @@ func (aa *AddressAssignment) UpdateAddressAssignment(db *gorm.DB) (*AddressAssig
 
 // FindMailingAddressWithSmartID allows a mailcarrier to retrieve the correct address to send mail to a user by inputing a User (retieved through SmartID) and an estimated date of delivery
 func (aa *AddressAssignment) FindMailingAddressWithSmartID(db *gorm.DB, user User, targetDate time.Time) (*AddressAssignment, error) {
+	return findAddressForDate(db, user, targetDate, []Status{Hold, MailOnlyHold}, []Status{MailOnlyTemporary, Temporary}, validMailStatus)
+}
+
+// FindPackageAddressWithSmartID allows a mailcarrier to retrieve the correct address to send packages to a user by inputing a User (retieved through SmartID) and an estimated date of delivery
+func (aa *AddressAssignment) FindPackageAddressWithSmartID(db *gorm.DB, user User, targetDate time.Time) (*AddressAssignment, error) {
+	return findAddressForDate(db, user, targetDate, []Status{Hold, PackageOnlyHold}, []Status{PackageOnlyTemporary, Temporary}, validPackageStatus)
+}
+
+// findAddressForDate returns the hold in effect on the target date, or else the temporary address in effect on the target date,
+// falling back to the permanent address, including one that has since been superseded. Recurrence rules are evaluated here rather than in the query
+func findAddressForDate(db *gorm.DB, user User, targetDate time.Time, hold []Status, temporary []Status, valid []Status) (*AddressAssignment, error) {
 	var err error
-	address := AddressAssignment{}
+	holds := []AddressAssignment{}
+	err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND status IN (?) AND start_date < ? AND end_date > ?", user.ID, hold, targetDate, targetDate).Limit(1).Find(&holds).Error
+	if err != nil {
+		return &AddressAssignment{}, err
+	}
+	if len(holds) > 0 {
+		return &holds[0], nil
+	}
 
-	err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND status IN (?, ?) AND start_date < ? AND (end_date IS NULL OR end_date > ?)", user.ID, MailOnlyTemporary, Temporary, targetDate, targetDate).Find(&address).Error
+	candidates := []AddressAssignment{}
+	err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND status IN (?) AND start_date < ? AND (end_date IS NULL OR end_date > ?)", user.ID, temporary, targetDate, targetDate).Find(&candidates).Error
 	if err != nil {
-		err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND status IN (?) AND start_date < ? AND (end_date IS NULL OR end_date > ?)", user.ID, validMailStatus, targetDate, targetDate).Find(&address).Error
-		if err != nil {
-			return &AddressAssignment{}, err
+		return &AddressAssignment{}, err
+	}
+	for i := range candidates {
+		if candidates[i].ActiveOn(targetDate) {
+			return &candidates[i], nil
 		}
 	}
+
+	// A superseded permanent assignment is expired, it is still the address for the dates it covered
+	address := AddressAssignment{}
+	err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND (status IN (?) OR (status = ? AND expired_from IN (?))) AND recurrence IS NULL AND start_date < ? AND (end_date IS NULL OR end_date > ?)", user.ID, valid, Expired, wasPermanent(valid), targetDate, targetDate).Find(&address).Error
+	if err != nil {
+		return &AddressAssignment{}, err
+	}
 	return &address, nil
 }
 
-// FindPackageAddressWithSmartID allows a mailcarrier to retrieve the correct address to send packages to a user by inputing a User (retieved through SmartID) and an estimated date of delivery
-func (aa *AddressAssignment) FindPackageAddressWithSmartID(db *gorm.DB, user User, targetDate time.Time) (*AddressAssignment, error) {
-	var err error
-	address := AddressAssignment{}
+// wasPermanent returns the permanent statuses in valid, the statuses an expired assignment can be looked up under
+func wasPermanent(valid []Status) []Status {
+	statuses := []Status{}
+	for _, status := range valid {
+		if contains(permanentStatus, status) {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
 
-	err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND status IN (?, ?) AND start_date < ? AND (end_date IS NULL OR end_date > ?)", user.ID, PackageOnlyTemporary, Temporary, targetDate, targetDate).Find(&address).Error
-	if err != nil {
-		err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id = ? AND status IN (?) AND start_date < ? AND (end_date IS NULL OR end_date > ?)", user.ID, validPackageStatus, targetDate, targetDate).Find(&address).Error
-		if err != nil {
-			return &AddressAssignment{}, err
+// MailingAddressOn picks the assignment FindMailingAddressWithSmartID would return from a user's assignments
+func MailingAddressOn(assignments []AddressAssignment, targetDate time.Time) (*AddressAssignment, bool) {
+	return addressOn(assignments, targetDate, []Status{Hold, MailOnlyHold}, []Status{MailOnlyTemporary, Temporary}, validMailStatus)
+}
+
+// PackageAddressOn picks the assignment FindPackageAddressWithSmartID would return from a user's assignments
+func PackageAddressOn(assignments []AddressAssignment, targetDate time.Time) (*AddressAssignment, bool) {
+	return addressOn(assignments, targetDate, []Status{Hold, PackageOnlyHold}, []Status{PackageOnlyTemporary, Temporary}, validPackageStatus)
+}
+
+// addressOn applies the same precedence as findAddressForDate to assignments that are already loaded
+func addressOn(assignments []AddressAssignment, targetDate time.Time, hold []Status, temporary []Status, valid []Status) (*AddressAssignment, bool) {
+	for i := range assignments {
+		if contains(hold, assignments[i].Status) && assignments[i].EndDate.Valid && assignments[i].ActiveOn(targetDate) {
+			return &assignments[i], true
 		}
 	}
-	return &address, nil
+	for i := range assignments {
+		if contains(temporary, assignments[i].Status) && assignments[i].ActiveOn(targetDate) {
+			return &assignments[i], true
+		}
+	}
+	for i := range assignments {
+		if contains(valid, assignments[i].lookupStatus()) && !assignments[i].Recurrence.Valid && assignments[i].ActiveOn(targetDate) {
+			return &assignments[i], true
+		}
+	}
+	return &AddressAssignment{}, false
 }
 
 // FindAllActiveAddressesForUser retrieves the last 100 active addresses a user has linked to their account. Used in UI to provide users currently active addresses
@@ -259,6 +457,22 @@ func (aa *AddressAssignment) FindAllAddressesForUser(db *gorm.DB, uid uuid.UUID)
 	return &addresses, nil
 }
 
+// FindAddressAssignmentsForUsers retrieves, in one query, the users' assignments that are in effect at some point between from and to,
+// including superseded permanent assignments that have been expired.
+// Used to resolve a batch of SmartIDs, MailingAddressOn and PackageAddressOn then pick each user's assignment for their date
+func FindAddressAssignmentsForUsers(db *gorm.DB, uids []uuid.UUID, from time.Time, to time.Time) (*[]AddressAssignment, error) {
+	var err error
+	addresses := []AddressAssignment{}
+	if len(uids) == 0 {
+		return &addresses, nil
+	}
+	err = db.Debug().Set("gorm:auto_preload", true).Model(&AddressAssignment{}).Where("user_id IN (?) AND (status NOT IN (?) OR (status = ? AND expired_from IN (?))) AND start_date < ? AND (end_date IS NULL OR end_date > ?)", uids, expiredAndDeleted, Expired, permanentStatus, to, from).Order("id").Find(&addresses).Error
+	if err != nil {
+		return &[]AddressAssignment{}, err
+	}
+	return &addresses, nil
+}
+
 // DeleteAddress removes an address assignment from the DB (should never use this unless correcting an accidental addition)
 func (aa *AddressAssignment) DeleteAddress(db *gorm.DB, aaid uint64) error {
 
@@ -271,36 +485,81 @@ func (aa *AddressAssignment) DeleteAddress(db *gorm.DB, aaid uint64) error {
 	}
 
 	if aa.Status == Permanent {
-		priorAddress := AddressAssignment{}
-		err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND status = ? AND end_date = ?", aa.UserID, Permanent, aa.StartDate.AddDate(0, 0, -1)).Find(&priorAddress).Error
+		priorAddress, err := findPriorPermanent(db, aa.UserID, aa.StartDate)
+		if err != nil {
+			return err
+		}
+		err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND id = ?", aa.UserID, priorAddress.ID).Updates(priorAddress.MoveEnd(aa.EndDate, time.Now())).Error
 		if err != nil {
 			if gorm.IsRecordNotFoundError(err) {
-				return errors.New("Could not find previous address")
+				return errors.New("Address not found")
 			}
+			return db.Error
+		}
+
+	}
+
+	return nil
+}
+
+// findPriorPermanent retrieves the user's permanent assignment that ends the day before start, it may have been expired since
+func findPriorPermanent(db *gorm.DB, uid uuid.UUID, start time.Time) (*AddressAssignment, error) {
+	priorAddress := AddressAssignment{}
+	err := db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND (status = ? OR (status = ? AND expired_from = ?)) AND end_date = ?", uid, Permanent, Expired, Permanent, start.AddDate(0, 0, -1)).Find(&priorAddress).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &AddressAssignment{}, errors.New("Could not find previous address")
+		}
+		return &AddressAssignment{}, err
+	}
+	return &priorAddress, nil
+}
+
+// MoveEnd sets a superseded permanent assignment's end date, restoring it if it had been expired and the new end date has not passed.
+// It returns the columns to update
+func (aa *AddressAssignment) MoveEnd(endDate null.Time, now time.Time) map[string]interface{} {
+	aa.EndDate = endDate
+	aa.UpdatedAt = now
+	if !aa.ended(now) {
+		aa.Restore(now)
+	}
+	return map[string]interface{}{"status": aa.Status, "expired_from": aa.ExpiredFrom, "end_date": aa.EndDate, "updated_at": aa.UpdatedAt}
+}
+
+// ExpireAddressAssignments moves temporary assignments and holds past their end date, and permanent assignments that have been superseded,
+// to expired. A transition is recorded for every assignment that is expired. Returns the number of assignments expired
+func ExpireAddressAssignments(db *gorm.DB, now time.Time) (int64, error) {
+	var expired int64
+	today := now.Format("2006-01-02")
+	err := Transaction(db, func(tx *gorm.DB) error {
+		overdue := []AddressAssignment{}
+		err := tx.Debug().Model(&AddressAssignment{}).Where("status IN (?) AND end_date IS NOT NULL AND end_date <= ?", expirableStatus, today).Find(&overdue).Error
+		if err != nil {
 			return err
 		}
-		newEndDate := aa.EndDate
-		if newEndDate.Valid {
-			err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND id = ?", aa.UserID, priorAddress.ID).Updates(AddressAssignment{EndDate: newEndDate, UpdatedAt: time.Now()}).Error
+		for _, aa := range overdue {
+			transition := AddressStatusTransition{
+				AddressAssignmentID: aa.ID,
+				FromStatus:          aa.Status,
+				ToStatus:            Expired,
+				TransitionedAt:      now,
+			}
+			_, err = transition.SaveAddressStatusTransition(tx)
 			if err != nil {
-				if gorm.IsRecordNotFoundError(err) {
-					return errors.New("Address not found")
-				}
-				return db.Error
+				return err
 			}
-		} else {
-			err = db.Debug().Model(&AddressAssignment{}).Where("user_id = ? AND id = ?", aa.UserID, priorAddress.ID).Updates(map[string]interface{}{"end_date": nil, "updated_at": time.Now()}).Error
+			err = tx.Debug().Model(&AddressAssignment{}).Where("id = ?", aa.ID).Updates(map[string]interface{}{"status": Expired, "expired_from": aa.Status, "updated_at": now}).Error
 			if err != nil {
-				if gorm.IsRecordNotFoundError(err) {
-					return errors.New("Address not found")
-				}
-				return db.Error
+				return err
 			}
+			expired++
 		}
-
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-
-	return nil
+	return expired, nil
 }
 
 // DeleteAddressAssignment removes an address assignment from the DB (should never use this unless correcting an accidental addition)
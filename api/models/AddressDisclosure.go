@@ -0,0 +1,144 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// AddressDisclosure is the DB structure that records each time an API user resolves a SmartID to an address or zip code, or a
+// retailer is given a customer's zip code for an order. Exactly one of APIUserID and RetailerID is set.
+// Disclosures are only ever added, never updated or deleted
+type AddressDisclosure struct {
+	ID                  uint64        `gorm:"primary_key;auto_increment" json:"id"`
+	APIUser             APIUser       `json:"api_user"`
+	APIUserID           uuid.NullUUID `gorm:"type:uuid;index:ix_address_disclosures_api_user_id" sql:"type:uuid REFERENCES api_users(id)" json:"api_user_id"`
+	Retailer            User          `json:"-"`
+	RetailerID          uuid.NullUUID `gorm:"type:uuid;index:ix_address_disclosures_retailer_id" sql:"type:uuid REFERENCES users(id)" json:"retailer_id"`
+	UserID              uuid.UUID     `gorm:"type:uuid;not null;index:ix_address_disclosures_user_id" sql:"type:uuid REFERENCES users(id)" json:"user_id"`
+	AddressAssignmentID uint64        `gorm:"not null;" sql:"type:int REFERENCES address_assignments(id)" json:"address_assignment_id"`
+	Scope               Scope         `gorm:"size:30;not null;" json:"scope"`
+	Permission          Permission    `sql:"type:api_permission" json:"permission"`
+	Tracking            null.String   `gorm:"size:255;" json:"tracking"`
+	DeliveryTokenID     null.Int      `sql:"type:bigint REFERENCES delivery_tokens(id)" json:"delivery_token_id"`
+	DisclosedAt         time.Time     `gorm:"default:CURRENT_TIMESTAMP;not null;" json:"disclosed_at"`
+}
+
+// DisclosureFilter narrows an admin's disclosure query, unset fields match every disclosure
+type DisclosureFilter struct {
+	UserID              uuid.NullUUID
+	APIUserID           uuid.NullUUID
+	AddressAssignmentID null.Int
+	Scope               Scope
+	Tracking            null.String
+	From                null.Time
+	To                  null.Time
+}
+
+// NewAddressDisclosure records that the API user was shown the assignment, scope is ZipReadScope when only the zip code was returned
+func NewAddressDisclosure(aUser APIUser, aa AddressAssignment, scope Scope, tracking null.String) AddressDisclosure {
+	return AddressDisclosure{
+		APIUserID:           uuid.NullUUID{UUID: aUser.ID, Valid: true},
+		UserID:              aa.UserID,
+		AddressAssignmentID: aa.ID,
+		Scope:               scope,
+		Permission:          aUser.Permission,
+		Tracking:            tracking,
+		DisclosedAt:         time.Now(),
+	}
+}
+
+// NewRetailerDisclosure records that the retailer was given the zip code of the assignment for an order
+func NewRetailerDisclosure(retailer User, aa AddressAssignment) AddressDisclosure {
+	return AddressDisclosure{
+		RetailerID:          uuid.NullUUID{UUID: retailer.ID, Valid: true},
+		UserID:              aa.UserID,
+		AddressAssignmentID: aa.ID,
+		Scope:               ZipReadScope,
+		DisclosedAt:         time.Now(),
+	}
+}
+
+// Matches returns true if the disclosure passes every field that is set in the filter
+func (filter DisclosureFilter) Matches(ad AddressDisclosure) bool {
+	if filter.UserID.Valid && ad.UserID != filter.UserID.UUID {
+		return false
+	}
+	if filter.APIUserID.Valid && (!ad.APIUserID.Valid || ad.APIUserID.UUID != filter.APIUserID.UUID) {
+		return false
+	}
+	if filter.AddressAssignmentID.Valid && ad.AddressAssignmentID != uint64(filter.AddressAssignmentID.Int64) {
+		return false
+	}
+	if filter.Scope != "" && ad.Scope != filter.Scope {
+		return false
+	}
+	if filter.Tracking.Valid && (!ad.Tracking.Valid || ad.Tracking.String != filter.Tracking.String) {
+		return false
+	}
+	if filter.From.Valid && ad.DisclosedAt.Before(filter.From.Time) {
+		return false
+	}
+	if filter.To.Valid && !ad.DisclosedAt.Before(filter.To.Time) {
+		return false
+	}
+	return true
+}
+
+// SaveAddressDisclosure records a disclosure
+func (ad *AddressDisclosure) SaveAddressDisclosure(db *gorm.DB) (*AddressDisclosure, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&ad).Error
+	if err != nil {
+		return &AddressDisclosure{}, err
+	}
+	return ad, nil
+}
+
+// SaveAddressDisclosures records many disclosures with a single statement
+func SaveAddressDisclosures(db *gorm.DB, disclosures []AddressDisclosure) error {
+	if len(disclosures) == 0 {
+		return nil
+	}
+	rows := []string{}
+	values := []interface{}{}
+	for _, ad := range disclosures {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		values = append(values, ad.APIUserID, ad.RetailerID, ad.UserID, ad.AddressAssignmentID, ad.Scope, ad.Permission, ad.Tracking, ad.DeliveryTokenID, ad.DisclosedAt)
+	}
+	return db.Debug().Exec(`INSERT INTO address_disclosures (api_user_id, retailer_id, user_id, address_assignment_id, scope, permission, tracking, delivery_token_id, disclosed_at) VALUES `+strings.Join(rows, ", "), values...).Error
+}
+
+// FindAddressDisclosures pages through the disclosures that match the filter, newest first
+func FindAddressDisclosures(db *gorm.DB, filter DisclosureFilter, limit int64, offset int64) (count int64, disclosures []AddressDisclosure, err error) {
+	query := db.Debug().Model(&AddressDisclosure{})
+	if filter.UserID.Valid {
+		query = query.Where("user_id = ?", filter.UserID.UUID)
+	}
+	if filter.APIUserID.Valid {
+		query = query.Where("api_user_id = ?", filter.APIUserID.UUID)
+	}
+	if filter.AddressAssignmentID.Valid {
+		query = query.Where("address_assignment_id = ?", filter.AddressAssignmentID.Int64)
+	}
+	if filter.Scope != "" {
+		query = query.Where("scope = ?", filter.Scope)
+	}
+	if filter.Tracking.Valid {
+		query = query.Where("tracking = ?", filter.Tracking.String)
+	}
+	if filter.From.Valid {
+		query = query.Where("disclosed_at >= ?", filter.From.Time)
+	}
+	if filter.To.Valid {
+		query = query.Where("disclosed_at < ?", filter.To.Time)
+	}
+	err = query.Count(&count).Preload("APIUser").Preload("Retailer").Order("disclosed_at desc, id desc").Limit(limit).Offset(offset).Find(&disclosures).Error
+	if err != nil {
+		return 0, []AddressDisclosure{}, err
+	}
+	return count, disclosures, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AddressStatusTransition is the DB structure that records each time an address assignment's status is changed by the system
+type AddressStatusTransition struct {
+	ID                  uint64            `gorm:"primary_key;auto_increment" json:"id"`
+	AddressAssignment   AddressAssignment `json:"-"`
+	AddressAssignmentID uint64            `sql:"type:int REFERENCES address_assignments(id)" gorm:"index:ix_address_status_transitions_assignment_id" json:"address_assignment_id"`
+	FromStatus          Status            `sql:"type:status" json:"from_status"`
+	ToStatus            Status            `sql:"type:status" json:"to_status"`
+	TransitionedAt      time.Time         `gorm:"default:CURRENT_TIMESTAMP;not null;" json:"transitioned_at"`
+}
+
+// SaveAddressStatusTransition records a status change
+func (ast *AddressStatusTransition) SaveAddressStatusTransition(db *gorm.DB) (*AddressStatusTransition, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&ast).Error
+	if err != nil {
+		return &AddressStatusTransition{}, err
+	}
+	return ast, nil
+}
+
+// FindTransitionsForAddressAssignment retrieves the status history of an address assignment, oldest first
+func (ast *AddressStatusTransition) FindTransitionsForAddressAssignment(db *gorm.DB, aaid uint64) (*[]AddressStatusTransition, error) {
+	var err error
+	transitions := []AddressStatusTransition{}
+	err = db.Debug().Model(&AddressStatusTransition{}).Where("address_assignment_id = ?", aaid).Order("transitioned_at").Find(&transitions).Error
+	if err != nil {
+		return &[]AddressStatusTransition{}, err
+	}
+	return &transitions, nil
+}
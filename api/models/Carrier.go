@@ -0,0 +1,210 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TrackingPatterns is a list of regular expressions that a carrier's tracking numbers match. It is stored one pattern per line
+type TrackingPatterns []string
+
+// Scan reads the patterns from the DB
+func (tp *TrackingPatterns) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	}
+	*tp = TrackingPatterns{}
+	for _, pattern := range strings.Split(raw, "\n") {
+		if pattern != "" {
+			*tp = append(*tp, pattern)
+		}
+	}
+	return nil
+}
+
+// Value returns the patterns to store in the DB
+func (tp TrackingPatterns) Value() (driver.Value, error) {
+	return strings.Join(tp, "\n"), nil
+}
+
+// trackingPlaceholder is replaced by the tracking number in a carrier's TrackingURL
+const trackingPlaceholder = "{tracking}"
+
+// Carrier is the DB and json structure for a mail carrier. Each carrier is linked to the API user it makes requests as
+type Carrier struct {
+	ID               uint64           `gorm:"primary_key;auto_increment" json:"id"`
+	Code             string           `gorm:"size:30;not null;unique" json:"code"`
+	Name             string           `gorm:"size:100;not null;" json:"name"`
+	APIUser          APIUser          `json:"-"`
+	APIUserID        uuid.UUID        `gorm:"type:uuid;not null;unique" sql:"type:uuid REFERENCES api_users(id)" json:"api_user_id"`
+	TrackingPatterns TrackingPatterns `gorm:"type:text" json:"tracking_patterns"`
+	TrackingURL      string           `gorm:"size:255;" json:"tracking_url"`
+	CreatedAt        time.Time        `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt        time.Time        `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// CarrierRegistry holds every carrier keyed by the ID of its API user
+type CarrierRegistry map[uuid.UUID]Carrier
+
+// Prepare formats the Carrier object
+func (c *Carrier) Prepare() {
+	c.ID = 0
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	c.Name = strings.TrimSpace(c.Name)
+	c.APIUser = APIUser{}
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+}
+
+// Validate checks the input fields for a Carrier
+func (c *Carrier) Validate() error {
+	if c.Code == "" {
+		return errors.New("Required Code")
+	}
+	if c.Name == "" {
+		return errors.New("Required Name")
+	}
+	if c.APIUserID == uuid.Nil {
+		return errors.New("Required API User")
+	}
+	for _, pattern := range c.TrackingPatterns {
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid tracking pattern %s: %v", pattern, err)
+		}
+	}
+	if c.TrackingURL != "" && !strings.Contains(c.TrackingURL, trackingPlaceholder) {
+		return fmt.Errorf("Tracking URL must contain %s", trackingPlaceholder)
+	}
+	return nil
+}
+
+// MatchesTracking returns true if the tracking number matches one of the carrier's patterns
+func (c *Carrier) MatchesTracking(tracking string) bool {
+	for _, pattern := range c.TrackingPatterns {
+		matched, err := regexp.MatchString(pattern, tracking)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackingLink returns the carrier's tracking page for a tracking number
+func (c *Carrier) TrackingLink(tracking string) string {
+	if c.TrackingURL == "" || tracking == "" {
+		return ""
+	}
+	return strings.Replace(c.TrackingURL, trackingPlaceholder, tracking, -1)
+}
+
+// SaveCarrier adds a carrier to the registry
+func (c *Carrier) SaveCarrier(db *gorm.DB) (*Carrier, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&c).Error
+	if err != nil {
+		return &Carrier{}, err
+	}
+	return c, nil
+}
+
+// FindAllCarriers retrieves every carrier
+func (c *Carrier) FindAllCarriers(db *gorm.DB) (*[]Carrier, error) {
+	var err error
+	carriers := []Carrier{}
+	err = db.Debug().Model(&Carrier{}).Order("code").Find(&carriers).Error
+	if err != nil {
+		return &[]Carrier{}, err
+	}
+	return &carriers, nil
+}
+
+// FindCarrierByID retrieves a carrier using its ID
+func (c *Carrier) FindCarrierByID(db *gorm.DB, cid uint64) (*Carrier, error) {
+	var err error
+	err = db.Debug().Model(&Carrier{}).Where("id = ?", cid).Take(&c).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &Carrier{}, errors.New("Carrier not found")
+		}
+		return &Carrier{}, err
+	}
+	return c, nil
+}
+
+// FindCarrierByCode retrieves a carrier using its code (e.g. UPS), the code is not case sensitive
+func (c *Carrier) FindCarrierByCode(db *gorm.DB, code string) (*Carrier, error) {
+	var err error
+	err = db.Debug().Model(&Carrier{}).Where("code = ?", strings.ToUpper(strings.TrimSpace(code))).Take(&c).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &Carrier{}, errors.New("Invalid carrier")
+		}
+		return &Carrier{}, err
+	}
+	return c, nil
+}
+
+// FindCarrierByAPIUserID finds the carrier that makes requests as an API user
+func (c *Carrier) FindCarrierByAPIUserID(db *gorm.DB, apiUserID uuid.UUID) (*Carrier, error) {
+	var err error
+	err = db.Debug().Model(&Carrier{}).Where("api_user_id = ?", apiUserID).Take(&c).Error
+	if err != nil {
+		return &Carrier{}, err
+	}
+	return c, nil
+}
+
+// UpdateCarrier updates a carrier's details
+func (c *Carrier) UpdateCarrier(db *gorm.DB, cid uint64) (*Carrier, error) {
+	var err error
+	err = db.Debug().Model(&Carrier{}).Where("id = ?", cid).Updates(map[string]interface{}{
+		"code":              c.Code,
+		"name":              c.Name,
+		"api_user_id":       c.APIUserID,
+		"tracking_patterns": c.TrackingPatterns,
+		"tracking_url":      c.TrackingURL,
+		"updated_at":        time.Now(),
+	}).Error
+	if err != nil {
+		return &Carrier{}, err
+	}
+	return c.FindCarrierByID(db, cid)
+}
+
+// DeleteCarrier removes a carrier from the registry
+func (c *Carrier) DeleteCarrier(db *gorm.DB, cid uint64) (int64, error) {
+	db = db.Debug().Model(&Carrier{}).Where("id = ?", cid).Take(&Carrier{}).Delete(&Carrier{})
+	if db.Error != nil {
+		if gorm.IsRecordNotFoundError(db.Error) {
+			return 0, errors.New("Carrier not found")
+		}
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
+
+// LoadCarrierRegistry retrieves every carrier, keyed by the ID of its API user
+func LoadCarrierRegistry(db *gorm.DB) (CarrierRegistry, error) {
+	carrier := Carrier{}
+	carriers, err := carrier.FindAllCarriers(db)
+	if err != nil {
+		return CarrierRegistry{}, err
+	}
+	registry := CarrierRegistry{}
+	for _, c := range *carriers {
+		registry[c.APIUserID] = c
+	}
+	return registry, nil
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// FailureReason is why a carrier could not deliver to an address
+type FailureReason string
+
+const (
+	// FailureVacant means nobody lives or works at the address
+	FailureVacant FailureReason = "vacant"
+	// FailureNoSuchNumber means the street has no such number
+	FailureNoSuchNumber FailureReason = "no_such_number"
+	// FailureRefused means the delivery was refused at the address
+	FailureRefused FailureReason = "refused"
+	// FailureMoved means the user no longer lives at the address
+	FailureMoved FailureReason = "moved"
+)
+
+// failureWeights is how much each reason lowers an address's confidence. A refusal says little about the address itself
+var failureWeights = map[FailureReason]float64{
+	FailureVacant:       0.3,
+	FailureNoSuchNumber: 0.5,
+	FailureRefused:      0.1,
+	FailureMoved:        0.4,
+}
+
+// ReviewDecision is an admin's conclusion about an address carriers have reported
+type ReviewDecision string
+
+const (
+	// ReviewValid means the address was checked and can be delivered to, the reports no longer count against it
+	ReviewValid ReviewDecision = "valid"
+	// ReviewInvalid means the address cannot be delivered to, the reports keep counting against it
+	ReviewInvalid ReviewDecision = "invalid"
+)
+
+const (
+	// FailureWindow is how long a report counts against an address's confidence
+	FailureWindow = 90 * 24 * time.Hour
+	// ReviewThreshold is the confidence at or below which an address is raised for an admin to review
+	ReviewThreshold = 0.5
+)
+
+// DeliveryFailure is the DB structure for a carrier's report that it could not deliver to an address a lookup returned
+type DeliveryFailure struct {
+	ID                  uint64            `gorm:"primary_key;auto_increment" json:"id"`
+	AddressAssignment   AddressAssignment `json:"-"`
+	AddressAssignmentID uint64            `gorm:"not null;index:ix_delivery_failures_address_assignment_id" sql:"type:bigint REFERENCES address_assignments(id)" json:"address_assignment_id"`
+	UserID              uuid.UUID         `gorm:"type:uuid;not null;index:ix_delivery_failures_user_id" sql:"type:uuid REFERENCES users(id)" json:"user_id"`
+	Carrier             Carrier           `json:"-"`
+	CarrierID           uint64            `gorm:"not null;" sql:"type:bigint REFERENCES carriers(id)" json:"carrier_id"`
+	Reason              FailureReason     `gorm:"size:20;not null;" json:"reason"`
+	Tracking            null.String       `gorm:"size:255;" json:"tracking"`
+	SeenAt              null.Time         `json:"seen_at"`
+	ReviewDecision      ReviewDecision    `gorm:"size:20;not null;default:''" json:"review_decision"`
+	ReviewedAt          null.Time         `json:"reviewed_at"`
+	ReviewedBy          uuid.NullUUID     `gorm:"type:uuid;" json:"reviewed_by"`
+	CreatedAt           time.Time         `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// NewDeliveryFailure creates the carrier's report against the assignment
+func NewDeliveryFailure(aa AddressAssignment, carrier Carrier, reason FailureReason, tracking null.String) DeliveryFailure {
+	return DeliveryFailure{
+		AddressAssignmentID: aa.ID,
+		UserID:              aa.UserID,
+		CarrierID:           carrier.ID,
+		Reason:              reason,
+		Tracking:            tracking,
+		CreatedAt:           time.Now(),
+	}
+}
+
+// Validate checks the input fields for a DeliveryFailure
+func (df *DeliveryFailure) Validate() error {
+	if _, ok := failureWeights[df.Reason]; !ok {
+		return errors.New("Reason must be vacant, no_such_number, refused or moved")
+	}
+	return nil
+}
+
+// DeliveryConfidence scores how likely an address is to be deliverable from the reports against it, from 1 with no reports down
+// to 0. Reports older than FailureWindow or reviewed as valid are ignored, and a carrier reporting the same reason again counts once
+func DeliveryConfidence(failures []DeliveryFailure, now time.Time) float64 {
+	type report struct {
+		carrierID uint64
+		reason    FailureReason
+	}
+	counted := map[report]bool{}
+	confidence := 1.0
+	for _, df := range failures {
+		key := report{df.CarrierID, df.Reason}
+		if df.ReviewDecision == ReviewValid || df.CreatedAt.Before(now.Add(-FailureWindow)) || counted[key] {
+			continue
+		}
+		counted[key] = true
+		confidence -= failureWeights[df.Reason]
+	}
+	return math.Max(0, math.Round(confidence*100)/100)
+}
+
+// NeedsReview returns true if the reports bring the address to ReviewThreshold and some have not been reviewed yet
+func NeedsReview(failures []DeliveryFailure, now time.Time) bool {
+	for _, df := range failures {
+		if df.ReviewDecision == "" {
+			return DeliveryConfidence(failures, now) <= ReviewThreshold
+		}
+	}
+	return false
+}
+
+// SaveDeliveryFailure saves a new report
+func (df *DeliveryFailure) SaveDeliveryFailure(db *gorm.DB) (*DeliveryFailure, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&df).Error
+	if err != nil {
+		return &DeliveryFailure{}, err
+	}
+	return df, nil
+}
+
+// FindDeliveryFailuresForAssignment retrieves the reports against an assignment made since the time, newest first
+func FindDeliveryFailuresForAssignment(db *gorm.DB, aaid uint64, since time.Time) (*[]DeliveryFailure, error) {
+	failures := []DeliveryFailure{}
+	err := db.Debug().Model(&DeliveryFailure{}).Where("address_assignment_id = ? AND created_at >= ?", aaid, since).Preload("Carrier").Order("created_at desc, id desc").Find(&failures).Error
+	if err != nil {
+		return &[]DeliveryFailure{}, err
+	}
+	return &failures, nil
+}
+
+// FindDeliveryFailuresForUser retrieves the reports against a user's assignments with their carriers and addresses, newest first.
+// Only the reports the user has not seen are returned when unseen is true
+func FindDeliveryFailuresForUser(db *gorm.DB, uid uuid.UUID, unseen bool) (*[]DeliveryFailure, error) {
+	failures := []DeliveryFailure{}
+	query := db.Debug().Model(&DeliveryFailure{}).Where("user_id = ?", uid)
+	if unseen {
+		query = query.Where("seen_at IS NULL")
+	}
+	err := query.Preload("Carrier").Preload("AddressAssignment").Preload("AddressAssignment.Address").Order("created_at desc, id desc").Find(&failures).Error
+	if err != nil {
+		return &[]DeliveryFailure{}, err
+	}
+	return &failures, nil
+}
+
+// MarkDeliveryFailuresSeen marks every report against a user's assignments as seen
+func MarkDeliveryFailuresSeen(db *gorm.DB, uid uuid.UUID, now time.Time) (int64, error) {
+	db = db.Debug().Model(&DeliveryFailure{}).Where("user_id = ? AND seen_at IS NULL", uid).UpdateColumn("seen_at", now)
+	return db.RowsAffected, db.Error
+}
+
+// FindUnreviewedDeliveryFailures retrieves the reports made since the time that an admin has not reviewed
+func FindUnreviewedDeliveryFailures(db *gorm.DB, since time.Time) (*[]DeliveryFailure, error) {
+	failures := []DeliveryFailure{}
+	err := db.Debug().Model(&DeliveryFailure{}).Where("review_decision = '' AND created_at >= ?", since).Order("created_at, id").Find(&failures).Error
+	if err != nil {
+		return &[]DeliveryFailure{}, err
+	}
+	return &failures, nil
+}
+
+// ReviewDeliveryFailures saves an admin's decision on every unreviewed report against the assignment
+func ReviewDeliveryFailures(db *gorm.DB, aaid uint64, decision ReviewDecision, reviewerID uuid.UUID, now time.Time) (int64, error) {
+	db = db.Debug().Model(&DeliveryFailure{}).Where("address_assignment_id = ? AND review_decision = ''", aaid).Updates(map[string]interface{}{
+		"review_decision": decision,
+		"reviewed_at":     now,
+		"reviewed_by":     reviewerID,
+	})
+	return db.RowsAffected, db.Error
+}
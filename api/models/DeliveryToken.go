@@ -0,0 +1,180 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// DeliveryKind is what a delivery token can be used for
+type DeliveryKind string
+
+const (
+	// MailDelivery tokens work with the mailing address routes
+	MailDelivery DeliveryKind = "mail"
+	// PackageDelivery tokens work with the package address routes
+	PackageDelivery DeliveryKind = "package"
+)
+
+// deliveryTokenPrefix starts every delivery token so that it cannot be mistaken for a SmartID
+const deliveryTokenPrefix = "dt_"
+
+// ErrDeliveryTokenInvalid is returned when a delivery token does not exist, belongs to another shipper or kind of delivery,
+// or has been used up, has expired or was revoked. The cases are not told apart so that a token cannot be probed
+var ErrDeliveryTokenInvalid = errors.New("Delivery token is invalid, used up, expired or revoked")
+
+// DeliveryToken is the DB structure for a token a user gives one shipper in place of their SmartID.
+// It is limited to mail or packages and stops working after MaxUses lookups, at ExpiresAt, or when it is revoked
+type DeliveryToken struct {
+	ID        uint64       `gorm:"primary_key;auto_increment" json:"id"`
+	Token     string       `gorm:"size:40;not null;unique" json:"token"`
+	UserID    uuid.UUID    `gorm:"type:uuid;not null;index:ix_delivery_tokens_user_id" sql:"type:uuid REFERENCES users(id)" json:"user_id"`
+	APIUser   APIUser      `json:"-"`
+	APIUserID uuid.UUID    `gorm:"type:uuid;not null;" sql:"type:uuid REFERENCES api_users(id)" json:"api_user_id"`
+	Kind      DeliveryKind `gorm:"size:10;not null;" json:"kind"`
+	MaxUses   null.Int     `json:"max_uses"`
+	Uses      int64        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt null.Time    `json:"expires_at"`
+	RevokedAt null.Time    `json:"revoked_at"`
+	CreatedAt time.Time    `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time    `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// IsDeliveryToken returns true if a value supplied in place of a SmartID is a delivery token
+func IsDeliveryToken(value string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), deliveryTokenPrefix)
+}
+
+// NormalizeDeliveryToken trims and lower-cases a delivery token supplied by a client
+func NormalizeDeliveryToken(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// GenerateDeliveryToken creates a random delivery token
+func GenerateDeliveryToken() (string, error) {
+	random := make([]byte, 16)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return deliveryTokenPrefix + hex.EncodeToString(random), nil
+}
+
+// Prepare formats the DeliveryToken object
+func (dt *DeliveryToken) Prepare() {
+	dt.ID = 0
+	dt.Token = ""
+	dt.APIUser = APIUser{}
+	dt.Uses = 0
+	dt.RevokedAt = null.Time{}
+	dt.CreatedAt = time.Now()
+	dt.UpdatedAt = time.Now()
+}
+
+// Validate checks the input fields for a DeliveryToken
+func (dt *DeliveryToken) Validate() error {
+	if dt.APIUserID == uuid.Nil {
+		return errors.New("Required API User")
+	}
+	if dt.Kind != MailDelivery && dt.Kind != PackageDelivery {
+		return errors.New("Kind must be mail or package")
+	}
+	if !dt.MaxUses.Valid && !dt.ExpiresAt.Valid {
+		return errors.New("Either max uses or an expiry is required")
+	}
+	if dt.MaxUses.Valid && dt.MaxUses.Int64 < 1 {
+		return errors.New("Max uses must be at least 1")
+	}
+	if dt.ExpiresAt.Valid && !dt.ExpiresAt.Time.After(time.Now()) {
+		return errors.New("Expiry must be in the future")
+	}
+	return nil
+}
+
+// Usable returns true if the token can still be used by the API user for the kind of delivery
+func (dt *DeliveryToken) Usable(apiUserID uuid.UUID, kind DeliveryKind, now time.Time) bool {
+	if dt.APIUserID != apiUserID || dt.Kind != kind || dt.RevokedAt.Valid {
+		return false
+	}
+	if dt.ExpiresAt.Valid && !now.Before(dt.ExpiresAt.Time) {
+		return false
+	}
+	return !dt.MaxUses.Valid || dt.Uses < dt.MaxUses.Int64
+}
+
+// SaveDeliveryToken issues the token a random value and saves it
+func (dt *DeliveryToken) SaveDeliveryToken(db *gorm.DB) (*DeliveryToken, error) {
+	token, err := GenerateDeliveryToken()
+	if err != nil {
+		return &DeliveryToken{}, err
+	}
+	dt.Token = token
+	err = db.Debug().Set("gorm:save_associations", false).Create(&dt).Error
+	if err != nil {
+		return &DeliveryToken{}, err
+	}
+	return dt, nil
+}
+
+// FindDeliveryTokensForUser retrieves every token a user has minted, newest first
+func (dt *DeliveryToken) FindDeliveryTokensForUser(db *gorm.DB, uid uuid.UUID) (*[]DeliveryToken, error) {
+	var err error
+	tokens := []DeliveryToken{}
+	err = db.Debug().Preload("APIUser").Model(&DeliveryToken{}).Where("user_id = ?", uid).Order("created_at desc").Find(&tokens).Error
+	if err != nil {
+		return &[]DeliveryToken{}, err
+	}
+	return &tokens, nil
+}
+
+// FindUsableDeliveryToken retrieves a token the API user can use for the kind of delivery without counting a use.
+// ErrDeliveryTokenInvalid is returned if the token is not usable
+func (dt *DeliveryToken) FindUsableDeliveryToken(db *gorm.DB, token string, apiUserID uuid.UUID, kind DeliveryKind) (*DeliveryToken, error) {
+	err := db.Debug().Model(&DeliveryToken{}).Where("token = ?", token).Take(&dt).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &DeliveryToken{}, ErrDeliveryTokenInvalid
+	}
+	if err != nil {
+		return &DeliveryToken{}, err
+	}
+	if !dt.Usable(apiUserID, kind, time.Now()) {
+		return &DeliveryToken{}, ErrDeliveryTokenInvalid
+	}
+	return dt, nil
+}
+
+// UseDeliveryToken counts a lookup against a token. The check and the count are one UPDATE,
+// so two lookups at once cannot both use the last use. ErrDeliveryTokenInvalid is returned if the token is not usable
+func (dt *DeliveryToken) UseDeliveryToken(db *gorm.DB, token string, apiUserID uuid.UUID, kind DeliveryKind) (*DeliveryToken, error) {
+	now := time.Now()
+	result := db.Debug().Model(&DeliveryToken{}).Where("token = ? AND api_user_id = ? AND kind = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?) AND (max_uses IS NULL OR uses < max_uses)", token, apiUserID, kind, now).UpdateColumns(map[string]interface{}{"uses": gorm.Expr("uses + 1"), "updated_at": now})
+	if result.Error != nil {
+		return &DeliveryToken{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &DeliveryToken{}, ErrDeliveryTokenInvalid
+	}
+	err := db.Debug().Model(&DeliveryToken{}).Where("token = ?", token).Take(&dt).Error
+	if err != nil {
+		return &DeliveryToken{}, err
+	}
+	return dt, nil
+}
+
+// RevokeDeliveryToken stops a user's token from working
+func (dt *DeliveryToken) RevokeDeliveryToken(db *gorm.DB, uid uuid.UUID, dtid uint64) error {
+	db = db.Debug().Model(&DeliveryToken{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", dtid, uid).Updates(map[string]interface{}{"revoked_at": time.Now(), "updated_at": time.Now()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("Delivery token not found")
+	}
+	return nil
+}
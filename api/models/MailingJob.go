@@ -0,0 +1,375 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// MailingJobStatus is where a mailing job is in its processing
+type MailingJobStatus string
+
+const (
+	// JobQueued jobs are waiting for the worker
+	JobQueued MailingJobStatus = "queued"
+	// JobRunning jobs are being resolved by the worker holding their lease
+	JobRunning MailingJobStatus = "running"
+	// JobCompleted jobs have a result file ready to download
+	JobCompleted MailingJobStatus = "completed"
+	// JobCancelled jobs were stopped by the API user
+	JobCancelled MailingJobStatus = "cancelled"
+	// JobFailed jobs could not be finished, Error says why
+	JobFailed MailingJobStatus = "failed"
+)
+
+// MaxMailingJobRows is the most rows a mailing list upload can have, not counting its header
+const MaxMailingJobRows = 100000
+
+// MailingJobRetention is how long a job and its result are kept once it has finished. Jobs that never finish are kept this long after they are uploaded
+const MailingJobRetention = 7 * 24 * time.Hour
+
+// ErrMailingJobCancelled is returned when the worker saves progress on a job that has been cancelled
+var ErrMailingJobCancelled = errors.New("Mailing job was cancelled")
+
+// CSVRecord is one row of a mailing list, stored CSV encoded
+type CSVRecord []string
+
+// Scan reads the record from the DB
+func (cr *CSVRecord) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	}
+	*cr = CSVRecord{}
+	if raw == "" {
+		return nil
+	}
+	record, err := csv.NewReader(strings.NewReader(raw)).Read()
+	if err != nil {
+		return err
+	}
+	*cr = record
+	return nil
+}
+
+// Value returns the record to store in the DB
+func (cr CSVRecord) Value() (driver.Value, error) {
+	if len(cr) == 0 {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(cr)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// MailingJob is the DB structure for a mailing list an API user uploaded to be resolved in the background. The rows are kept in
+// mailing_job_rows, so a job survives a restart: the worker holds a job until ClaimedUntil and another worker picks it up after that
+type MailingJob struct {
+	ID            uuid.UUID        `gorm:"type:uuid;primary_key;" json:"id"`
+	APIUser       APIUser          `json:"-"`
+	APIUserID     uuid.UUID        `gorm:"type:uuid;not null;index:ix_mailing_jobs_api_user_id" sql:"type:uuid REFERENCES api_users(id)" json:"api_user_id"`
+	FileName      string           `gorm:"size:255;not null;" json:"file_name"`
+	Header        CSVRecord        `gorm:"type:text;not null;" json:"header"`
+	SmartIDColumn int              `gorm:"not null;" json:"smart_id_column"`
+	DateColumn    null.Int         `json:"date_column"`
+	MailDate      time.Time        `gorm:"not null;" json:"mail_date"`
+	Status        MailingJobStatus `gorm:"size:20;not null;" json:"status"`
+	TotalRows     int              `gorm:"not null;default:0" json:"total_rows"`
+	ProcessedRows int              `gorm:"not null;default:0" json:"processed_rows"`
+	FailedRows    int              `gorm:"not null;default:0" json:"failed_rows"`
+	Error         null.String      `gorm:"type:text" json:"error"`
+	ClaimedUntil  null.Time        `json:"claimed_until"`
+	StartedAt     null.Time        `json:"started_at"`
+	FinishedAt    null.Time        `json:"finished_at"`
+	ExpiresAt     time.Time        `gorm:"not null;" json:"expires_at"`
+	CreatedAt     time.Time        `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt     time.Time        `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// MailingJobRow is the DB structure for one row of a mailing job, with the columns that are appended to it once it is resolved
+type MailingJobRow struct {
+	ID          uint64      `gorm:"primary_key;auto_increment" json:"id"`
+	JobID       uuid.UUID   `gorm:"type:uuid;not null;" sql:"type:uuid REFERENCES mailing_jobs(id) ON DELETE CASCADE" json:"job_id"`
+	RowNumber   int         `gorm:"not null;" json:"row_number"`
+	Record      CSVRecord   `gorm:"type:text;not null;" json:"record"`
+	Result      CSVRecord   `gorm:"type:text" json:"result"`
+	Error       null.String `gorm:"type:text" json:"error"`
+	ProcessedAt null.Time   `json:"processed_at"`
+}
+
+// NewMailingJob creates a queued job for an upload, mailDate is used for rows without a date of their own
+func NewMailingJob(apiUserID uuid.UUID, fileName string, mailDate time.Time) MailingJob {
+	now := time.Now()
+	return MailingJob{
+		ID:        uuid.NewV4(),
+		APIUserID: apiUserID,
+		FileName:  strings.TrimSpace(fileName),
+		MailDate:  mailDate,
+		Status:    JobQueued,
+		ExpiresAt: now.Add(MailingJobRetention),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// ReadCSV reads the mailing list into the job's header and rows. The header must have a smart_id column and may have a date
+// column (YYYY-MM-DD) to override the job's mail date, every other column is kept as is and returned in the result
+func (mj *MailingJob) ReadCSV(r io.Reader) ([]MailingJobRow, error) {
+	// Every row must have as many fields as the header, so the SmartID and date columns are in every row
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 0
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("Mailing list is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Mailing list is not valid CSV: %v", err)
+	}
+	mj.SmartIDColumn = -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "smart_id":
+			mj.SmartIDColumn = i
+		case "date":
+			mj.DateColumn = null.IntFrom(int64(i))
+		}
+	}
+	if mj.SmartIDColumn < 0 {
+		return nil, errors.New("Required smart_id column")
+	}
+	mj.Header = header
+
+	rows := []MailingJobRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Mailing list is not valid CSV: %v", err)
+		}
+		if len(rows) == MaxMailingJobRows {
+			return nil, fmt.Errorf("A mailing list is limited to %d rows", MaxMailingJobRows)
+		}
+		rows = append(rows, MailingJobRow{JobID: mj.ID, RowNumber: len(rows) + 1, Record: record})
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("Mailing list has no rows")
+	}
+	mj.TotalRows = len(rows)
+	return rows, nil
+}
+
+// RowSmartID returns the row's SmartID column
+func (mj *MailingJob) RowSmartID(row MailingJobRow) string {
+	return strings.TrimSpace(row.Record[mj.SmartIDColumn])
+}
+
+// RowDate returns the date the row's mail is sent, its own date column if the list has one and it is filled in
+func (mj *MailingJob) RowDate(row MailingJobRow) (time.Time, error) {
+	if !mj.DateColumn.Valid || strings.TrimSpace(row.Record[mj.DateColumn.Int64]) == "" {
+		return mj.MailDate, nil
+	}
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(row.Record[mj.DateColumn.Int64]))
+	if err != nil {
+		return time.Time{}, errors.New("Date must be formatted YYYY-MM-DD")
+	}
+	return date, nil
+}
+
+// Finished returns true once the job will not be worked on again
+func (mj *MailingJob) Finished() bool {
+	return mj.Status == JobCompleted || mj.Status == JobCancelled || mj.Status == JobFailed
+}
+
+// Finish marks the job completed, cancelled or failed at now and starts its retention period
+func (mj *MailingJob) Finish(status MailingJobStatus, now time.Time, reason string) {
+	mj.Status = status
+	mj.Error = null.NewString(reason, reason != "")
+	mj.ClaimedUntil = null.Time{}
+	mj.FinishedAt = null.TimeFrom(now)
+	mj.ExpiresAt = now.Add(MailingJobRetention)
+	mj.UpdatedAt = now
+}
+
+// SaveMailingJob saves the job, its rows are saved with SaveMailingJobRows
+func (mj *MailingJob) SaveMailingJob(db *gorm.DB) (*MailingJob, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&mj).Error
+	if err != nil {
+		return &MailingJob{}, err
+	}
+	return mj, nil
+}
+
+// mailingJobRowBatch is how many rows are inserted with each statement
+const mailingJobRowBatch = 1000
+
+// SaveMailingJobRows inserts the rows of a job, a thousand to a statement
+func SaveMailingJobRows(db *gorm.DB, rows []MailingJobRow) error {
+	for start := 0; start < len(rows); start += mailingJobRowBatch {
+		end := start + mailingJobRowBatch
+		if end > len(rows) {
+			end = len(rows)
+		}
+		placeholders := []string{}
+		values := []interface{}{}
+		for _, row := range rows[start:end] {
+			placeholders = append(placeholders, "(?, ?, ?)")
+			values = append(values, row.JobID, row.RowNumber, row.Record)
+		}
+		err := db.Debug().Exec(`INSERT INTO mailing_job_rows (job_id, row_number, record) VALUES `+strings.Join(placeholders, ", "), values...).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindMailingJob retrieves one of an API user's jobs
+func (mj *MailingJob) FindMailingJob(db *gorm.DB, apiUserID uuid.UUID, jid uuid.UUID) (*MailingJob, error) {
+	var err error
+	err = db.Debug().Model(&MailingJob{}).Where("id = ? AND api_user_id = ?", jid, apiUserID).Take(&mj).Error
+	if err != nil {
+		return &MailingJob{}, err
+	}
+	return mj, nil
+}
+
+// CancelMailingJob stops one of an API user's jobs that has not finished. Rows that were already resolved are kept until the job expires
+func CancelMailingJob(db *gorm.DB, apiUserID uuid.UUID, jid uuid.UUID, now time.Time) error {
+	db = db.Debug().Model(&MailingJob{}).Where("id = ? AND api_user_id = ? AND status IN (?)", jid, apiUserID, []MailingJobStatus{JobQueued, JobRunning}).Updates(map[string]interface{}{
+		"status":        JobCancelled,
+		"claimed_until": nil,
+		"finished_at":   now,
+		"expires_at":    now.Add(MailingJobRetention),
+		"updated_at":    now,
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("Mailing job not found or already finished")
+	}
+	return nil
+}
+
+// ClaimMailingJob takes the oldest queued job, or a running job whose worker stopped before its lease ran out, and holds it until now plus lease.
+// It returns gorm.ErrRecordNotFound when there is nothing to do
+func ClaimMailingJob(db *gorm.DB, now time.Time, lease time.Duration) (*MailingJob, error) {
+	var err error
+	jobs := []MailingJob{}
+	err = db.Debug().Raw(`UPDATE mailing_jobs SET status = ?, claimed_until = ?, started_at = COALESCE(started_at, ?), updated_at = ? WHERE id = (
+		SELECT id FROM mailing_jobs WHERE status = ? OR (status = ? AND claimed_until <= ?) ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+	) RETURNING *`, JobRunning, now.Add(lease), now, now, JobQueued, JobRunning, now).Scan(&jobs).Error
+	if err != nil {
+		return &MailingJob{}, err
+	}
+	if len(jobs) == 0 {
+		return &MailingJob{}, gorm.ErrRecordNotFound
+	}
+	return &jobs[0], nil
+}
+
+// FindPendingMailingJobRows returns up to limit of a job's rows that have not been resolved, in file order
+func FindPendingMailingJobRows(db *gorm.DB, jid uuid.UUID, limit int64) (*[]MailingJobRow, error) {
+	var err error
+	rows := []MailingJobRow{}
+	err = db.Debug().Model(&MailingJobRow{}).Where("job_id = ? AND processed_at IS NULL", jid).Order("row_number").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return &[]MailingJobRow{}, err
+	}
+	return &rows, nil
+}
+
+// SaveMailingJobResults records the resolved rows with a single statement
+func SaveMailingJobResults(db *gorm.DB, rows []MailingJobRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	placeholders := []string{}
+	values := []interface{}{}
+	for _, row := range rows {
+		placeholders = append(placeholders, "(?::bigint, ?, ?, ?::timestamptz)")
+		values = append(values, row.ID, row.Result, row.Error, row.ProcessedAt)
+	}
+	return db.Debug().Exec(`UPDATE mailing_job_rows SET result = v.result, error = v.error, processed_at = v.processed_at
+		FROM (VALUES `+strings.Join(placeholders, ", ")+`) AS v (id, result, error, processed_at) WHERE mailing_job_rows.id = v.id`, values...).Error
+}
+
+// UpdateMailingJobProgress adds the resolved rows to the job's counts and renews its lease until claimedUntil.
+// It returns ErrMailingJobCancelled if the job is no longer running
+func (mj *MailingJob) UpdateMailingJobProgress(db *gorm.DB, processed int, failed int, claimedUntil time.Time) error {
+	db = db.Debug().Model(&MailingJob{}).Where("id = ? AND status = ?", mj.ID, JobRunning).Updates(map[string]interface{}{
+		"processed_rows": gorm.Expr("processed_rows + ?", processed),
+		"failed_rows":    gorm.Expr("failed_rows + ?", failed),
+		"claimed_until":  claimedUntil,
+		"updated_at":     time.Now(),
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrMailingJobCancelled
+	}
+	mj.ProcessedRows += processed
+	mj.FailedRows += failed
+	mj.ClaimedUntil = null.TimeFrom(claimedUntil)
+	return nil
+}
+
+// FinishMailingJob saves the status set by Finish, unless the job was cancelled while the worker had it
+func (mj *MailingJob) FinishMailingJob(db *gorm.DB) error {
+	db = db.Debug().Model(&MailingJob{}).Where("id = ? AND status = ?", mj.ID, JobRunning).Updates(map[string]interface{}{
+		"status":        mj.Status,
+		"error":         mj.Error,
+		"claimed_until": nil,
+		"finished_at":   mj.FinishedAt,
+		"expires_at":    mj.ExpiresAt,
+		"updated_at":    mj.UpdatedAt,
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrMailingJobCancelled
+	}
+	return nil
+}
+
+// FindMailingJobRows pages through a job's rows in file order
+func FindMailingJobRows(db *gorm.DB, jid uuid.UUID, limit int64, offset int64) (*[]MailingJobRow, error) {
+	var err error
+	rows := []MailingJobRow{}
+	err = db.Debug().Model(&MailingJobRow{}).Where("job_id = ?", jid).Order("row_number").Limit(limit).Offset(offset).Find(&rows).Error
+	if err != nil {
+		return &[]MailingJobRow{}, err
+	}
+	return &rows, nil
+}
+
+// DeleteExpiredMailingJobs removes every job past its retention period along with its rows
+func DeleteExpiredMailingJobs(db *gorm.DB, now time.Time) (int64, error) {
+	db = db.Debug().Where("expires_at <= ?", now).Delete(&MailingJob{})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// OrderStatus is where a retailer's order is in its handoff to a carrier
+type OrderStatus string
+
+const (
+	// OrderCreated orders have a zip code for rating and no carrier yet
+	OrderCreated OrderStatus = "created"
+	// OrderHandedOff orders have been given to a carrier, which can resolve the full address
+	OrderHandedOff OrderStatus = "handed_off"
+	// OrderShipped orders have had their address resolved by the carrier and are saved as a package
+	OrderShipped OrderStatus = "shipped"
+)
+
+// ErrOrderHandedOff is returned when an order that was already given to a carrier is handed off again
+var ErrOrderHandedOff = errors.New("Order has already been handed off")
+
+// ErrOrderShipped is returned when an order was shipped by another lookup first
+var ErrOrderShipped = errors.New("Order has already shipped")
+
+// Order is the DB structure for an order a retailer took for a user. The retailer is only ever given the zip code,
+// the full address is resolved by the carrier the order is handed off to
+type Order struct {
+	ID          uuid.UUID   `gorm:"type:uuid;primary_key;" json:"id"`
+	Retailer    User        `json:"-"`
+	RetailerID  uuid.UUID   `gorm:"type:uuid;not null;index:ix_orders_retailer_id" sql:"type:uuid REFERENCES users(id)" json:"retailer_id"`
+	Recipient   User        `json:"-"`
+	RecipientID uuid.UUID   `gorm:"type:uuid;not null;" sql:"type:uuid REFERENCES users(id)" json:"recipient_id"`
+	Reference   string      `gorm:"size:100;not null;default:''" json:"reference"`
+	ShipDate    time.Time   `gorm:"not null;" json:"ship_date"`
+	ZipCode     string      `gorm:"size:20;not null;" json:"zip_code"`
+	Status      OrderStatus `gorm:"size:20;not null;" json:"status"`
+	Carrier     Carrier     `json:"-"`
+	CarrierID   null.Int    `sql:"type:bigint REFERENCES carriers(id)" json:"carrier_id"`
+	Tracking    null.String `gorm:"size:255;" json:"tracking"`
+	PackageID   null.Int    `sql:"type:bigint REFERENCES packages(id)" json:"package_id"`
+	HandedOffAt null.Time   `json:"handed_off_at"`
+	ShippedAt   null.Time   `json:"shipped_at"`
+	CreatedAt   time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt   time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// NewOrder creates an order for the recipient, zipCode is the zip code of their package address on the ship date
+func NewOrder(retailerID uuid.UUID, recipientID uuid.UUID, reference string, shipDate time.Time, zipCode string) Order {
+	now := time.Now()
+	return Order{
+		ID:          uuid.NewV4(),
+		RetailerID:  retailerID,
+		RecipientID: recipientID,
+		Reference:   strings.TrimSpace(reference),
+		ShipDate:    shipDate,
+		ZipCode:     zipCode,
+		Status:      OrderCreated,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// Validate checks the input fields for an Order
+func (o *Order) Validate() error {
+	if len(o.Reference) > 100 {
+		return errors.New("Reference must be at most 100 characters")
+	}
+	return nil
+}
+
+// HandOff gives the order to the carrier with its tracking number
+func (o *Order) HandOff(carrier Carrier, tracking string, now time.Time) error {
+	if o.Status != OrderCreated {
+		return ErrOrderHandedOff
+	}
+	o.Status = OrderHandedOff
+	o.CarrierID = null.IntFrom(int64(carrier.ID))
+	o.Tracking = null.StringFrom(tracking)
+	o.HandedOffAt = null.TimeFrom(now)
+	o.UpdatedAt = now
+	return nil
+}
+
+// Ship records the package the carrier saved for the order, an order that has already shipped keeps its first package
+func (o *Order) Ship(packageID uint64, now time.Time) {
+	if o.Status == OrderShipped {
+		return
+	}
+	o.Status = OrderShipped
+	o.PackageID = null.IntFrom(int64(packageID))
+	o.ShippedAt = null.TimeFrom(now)
+	o.UpdatedAt = now
+}
+
+// SaveOrder saves a new order
+func (o *Order) SaveOrder(db *gorm.DB) (*Order, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&o).Error
+	if err != nil {
+		return &Order{}, err
+	}
+	return o, nil
+}
+
+// FindOrderByID retrieves an order
+func (o *Order) FindOrderByID(db *gorm.DB, oid uuid.UUID) (*Order, error) {
+	var err error
+	err = db.Debug().Model(&Order{}).Where("id = ?", oid).Take(&o).Error
+	if err != nil {
+		return &Order{}, err
+	}
+	return o, nil
+}
+
+// HandOffOrder saves the carrier set by HandOff. The order must still be created, so an order cannot be handed to two carriers at once
+func (o *Order) HandOffOrder(db *gorm.DB) error {
+	db = db.Debug().Model(&Order{}).Where("id = ? AND status = ?", o.ID, OrderCreated).Updates(map[string]interface{}{
+		"status":        o.Status,
+		"carrier_id":    o.CarrierID,
+		"tracking":      o.Tracking,
+		"handed_off_at": o.HandedOffAt,
+		"updated_at":    o.UpdatedAt,
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrOrderHandedOff
+	}
+	return nil
+}
+
+// ShipOrder saves the package set by Ship. ErrOrderShipped is returned if the order has already shipped
+func (o *Order) ShipOrder(db *gorm.DB) error {
+	db = db.Debug().Model(&Order{}).Where("id = ? AND status = ?", o.ID, OrderHandedOff).Updates(map[string]interface{}{
+		"status":     o.Status,
+		"package_id": o.PackageID,
+		"shipped_at": o.ShippedAt,
+		"updated_at": o.UpdatedAt,
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrOrderShipped
+	}
+	return nil
+}
@@ -11,11 +11,15 @@ import (
 	"gopkg.in/guregu/null.v3"
 )
 
-// Package is the DB table structure and json input structure for an address assignment. It is a one to many relationship table. One user can have many addresses
+// Package is the DB table structure and json input structure for an address assignment. It is a one to many relationship table. One user can have many addresses.
+// Delivered, DeliveredOn and EstimatedDelivery are derived from the package's Events, see SavePackageEvent.
+// AddressAssignmentID is the recipient's assignment the package was shipped to. AddressChanged is set when the recipient
+// adds an address that the package should now go to instead, RerouteAssignmentID, until the carrier acknowledges it
 type Package struct {
 	ID                   uint64             `gorm:"primary_key;auto_increment" json:"id"`
 	MailCarrier          APIUser            `json:"mail_carrier"`
-	MailCarrierID        uuid.UUID          `gorm:"type:uuid;not null;" sql:"type:uuid REFERENCES mail_carriers(id)" json:"mail_carrier_id"`
+	MailCarrierID        uuid.UUID          `gorm:"type:uuid;not null;" sql:"type:uuid REFERENCES api_users(id)" json:"mail_carrier_id"`
+	CarrierID            null.Int           `sql:"type:bigint REFERENCES carriers(id)" json:"carrier_id"`
 	Sender               User               `json:"sender"`
 	SenderID             uuid.NullUUID      `gorm:"type:uuid;" sql:"type:uuid REFERENCES users(id)" json:"sender_id"`
 	Recipient            User               `json:"recipient"`
@@ -23,9 +27,13 @@ type Package struct {
 	Tracking             null.String        `gorm:"size:255;" json:"tracking"`
 	PackageDescription   PackageDescription `json:"package_description"`
 	PackageDescriptionID uint64             `sql:"type:bigint REFERENCES package_descriptions(id)" json:"package_description_id"`
+	AddressAssignmentID  null.Int           `sql:"type:bigint REFERENCES address_assignments(id)" json:"address_assignment_id"`
+	AddressChanged       bool               `gorm:"not null;default:false" json:"address_changed"`
+	RerouteAssignmentID  null.Int           `sql:"type:bigint REFERENCES address_assignments(id)" json:"reroute_assignment_id"`
 	EstimatedDelivery    null.Time          `gorm:"default:null" json:"estimated_delivery"`
 	Delivered            bool               `json:"delivered"`
 	DeliveredOn          null.Time          `gorm:"default:null" json:"delivered_on"`
+	Events               []PackageEvent     `json:"events"`
 	CreatedAt            time.Time          `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt            time.Time          `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
@@ -36,8 +44,11 @@ func (p *Package) Prepare() {
 	p.MailCarrier = APIUser{}
 	p.Sender = User{}
 	p.Recipient = User{}
+	p.AddressChanged = false
+	p.RerouteAssignmentID = null.IntFromPtr(nil)
 	p.Delivered = false
 	p.DeliveredOn = null.TimeFromPtr(nil)
+	p.Events = nil
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = time.Now()
 }
@@ -45,20 +56,87 @@ func (p *Package) Prepare() {
 // SavePackage is used to save a package. It is called when a mail carrier makes a new shipping API request
 func (p *Package) SavePackage(db *gorm.DB) error {
 	newPackage := Package{}
-	err := db.Debug().Model(&Package{}).Where("sender_id = ? AND recipient_id = ? AND tracking = ? AND tracking IS NOT NULL", p.SenderID, p.RecipientID, p.Tracking).Attrs(Package{MailCarrierID: p.MailCarrierID, SenderID: p.SenderID, RecipientID: p.RecipientID, Tracking: p.Tracking, PackageDescriptionID: p.PackageDescriptionID, CreatedAt: time.Now()}).FirstOrCreate(&newPackage).Error
+	err := db.Debug().Model(&Package{}).Where("sender_id = ? AND recipient_id = ? AND tracking = ? AND tracking IS NOT NULL", p.SenderID, p.RecipientID, p.Tracking).Attrs(Package{MailCarrierID: p.MailCarrierID, CarrierID: p.CarrierID, SenderID: p.SenderID, RecipientID: p.RecipientID, Tracking: p.Tracking, PackageDescriptionID: p.PackageDescriptionID, AddressAssignmentID: p.AddressAssignmentID, CreatedAt: time.Now()}).FirstOrCreate(&newPackage).Error
 	if err != nil {
 		return err
 	}
+	p.ID = newPackage.ID
 	return nil
 }
 
-// UpdatePackage is used to update the delivered status
+// UpdatePackage is used by a sender or recipient to update the delivered status or the estimated delivery. The update is
+// recorded as an event with no source, see UpdateEvent
 func (p *Package) UpdatePackage(db *gorm.DB, uid uuid.UUID, tracking string, delivered bool, deliveredOn null.Time, estimatedDelivery null.Time) (*Package, error) {
 	var err error
-	err = db.Debug().Model(&Package{}).Where("tracking = ? AND (sender_id = ? OR recipient_id = ?)", tracking, uid, uid).Updates(Package{Delivered: delivered, DeliveredOn: deliveredOn, EstimatedDelivery: estimatedDelivery, UpdatedAt: time.Now()}).Error
+	_, err = p.FindPackageByTrackingForUser(db, uid, tracking)
 	if err != nil {
 		return &Package{}, err
 	}
+
+	event, changed := p.UpdateEvent(delivered, deliveredOn, estimatedDelivery)
+	if changed {
+		_, err = event.SavePackageEvent(db)
+		if err != nil {
+			return &Package{}, err
+		}
+		p.Events = append(p.Events, event)
+	}
+
+	state := DerivePackageState(p.Events)
+	p.Delivered = state.Delivered
+	p.DeliveredOn = state.DeliveredOn
+	p.EstimatedDelivery = state.EstimatedDelivery
+	return p, nil
+}
+
+// UpdateEvent returns the event that records a sender or recipient's update of the package, and false when nothing changed.
+// Only a change of the delivered flag is a status event, an estimate on its own is an EstimateUpdated event so that it
+// does not change the status the carrier reported
+func (p *Package) UpdateEvent(delivered bool, deliveredOn null.Time, estimatedDelivery null.Time) (PackageEvent, bool) {
+	state := DerivePackageState(p.Events)
+	event := PackageEvent{
+		PackageID:         p.ID,
+		Status:            EstimateUpdated,
+		EstimatedDelivery: estimatedDelivery,
+		OccurredAt:        time.Now(),
+	}
+	switch {
+	case delivered && !state.Delivered:
+		event.Status = PackageDelivered
+		if deliveredOn.Valid {
+			event.OccurredAt = deliveredOn.Time
+		}
+	case !delivered && state.Delivered:
+		event.Status = InTransit
+	case !estimatedDelivery.Valid || (state.EstimatedDelivery.Valid && state.EstimatedDelivery.Time.Equal(estimatedDelivery.Time)):
+		return PackageEvent{}, false
+	}
+	return event, true
+}
+
+// FindPackageByTrackingForUser retrieves a package that the user sent or received, along with its events
+func (p *Package) FindPackageByTrackingForUser(db *gorm.DB, uid uuid.UUID, tracking string) (*Package, error) {
+	var err error
+	err = db.Debug().Preload("Events").Model(Package{}).Where("tracking = ? AND (sender_id = ? OR recipient_id = ?)", tracking, uid, uid).Take(&p).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &Package{}, errors.New("Package not found")
+		}
+		return &Package{}, err
+	}
+	return p, nil
+}
+
+// FindPackageByTrackingAndCarrier retrieves a package handled by a mail carrier
+func (p *Package) FindPackageByTrackingAndCarrier(db *gorm.DB, carrierID uuid.UUID, tracking string) (*Package, error) {
+	var err error
+	err = db.Debug().Model(Package{}).Where("tracking = ? AND mail_carrier_id = ?", tracking, carrierID).Take(&p).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &Package{}, errors.New("Package not found")
+		}
+		return &Package{}, err
+	}
 	return p, nil
 }
 
@@ -159,6 +237,118 @@ func (p *Package) FindPackagesForUser(db *gorm.DB, userID uuid.UUID, packageType
 	return count, requestedPackages, nil
 }
 
+// PackageReroute is a package whose recipient's address changed after it was shipped, From is the assignment it was shipped to and To is where it should go now
+type PackageReroute struct {
+	Package Package
+	From    AddressAssignment
+	To      AddressAssignment
+}
+
+// NeedsReroute returns true if the open package was shipped elsewhere and will now be delivered to the new temporary or permanent
+// assignment aa. assignments are the recipient's assignments, including aa, and decide which one covers the estimated delivery
+func (p *Package) NeedsReroute(assignments []AddressAssignment, aa AddressAssignment) bool {
+	if p.Delivered || !p.EstimatedDelivery.Valid || !p.AddressAssignmentID.Valid || !p.RecipientID.Valid || p.RecipientID.UUID != aa.UserID {
+		return false
+	}
+	if !aa.IsTemporary() && !aa.IsPermanent() {
+		return false
+	}
+	destination := p.AddressAssignmentID
+	if p.AddressChanged {
+		destination = p.RerouteAssignmentID
+	}
+	if uint64(destination.Int64) == aa.ID {
+		return false
+	}
+	covering, ok := PackageAddressOn(assignments, p.EstimatedDelivery.Time)
+	return ok && covering.ID == aa.ID
+}
+
+// FlagPackageReroutes flags the recipient's open packages that will now be delivered to the assignment. Returns the number of packages flagged
+func FlagPackageReroutes(db *gorm.DB, aa *AddressAssignment) (int64, error) {
+	if !aa.IsTemporary() && !aa.IsPermanent() {
+		return 0, nil
+	}
+	packages := []Package{}
+	err := db.Debug().Model(&Package{}).Where("recipient_id = ? AND delivered = false AND estimated_delivery IS NOT NULL AND address_assignment_id IS NOT NULL", aa.UserID).Find(&packages).Error
+	if err != nil || len(packages) == 0 {
+		return 0, err
+	}
+	// Every assignment that could cover an estimated delivery decides, so they are loaded for the whole window without a limit
+	from, to := packages[0].EstimatedDelivery.Time, packages[0].EstimatedDelivery.Time
+	for _, p := range packages {
+		if p.EstimatedDelivery.Time.Before(from) {
+			from = p.EstimatedDelivery.Time
+		}
+		if p.EstimatedDelivery.Time.After(to) {
+			to = p.EstimatedDelivery.Time
+		}
+	}
+	assignments, err := FindAddressAssignmentsForUsers(db, []uuid.UUID{aa.UserID}, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, err
+	}
+
+	var flagged int64
+	for _, p := range packages {
+		if !p.NeedsReroute(*assignments, *aa) {
+			continue
+		}
+		err = db.Debug().Model(&Package{}).Where("id = ?", p.ID).Updates(map[string]interface{}{"address_changed": true, "reroute_assignment_id": aa.ID, "updated_at": time.Now()}).Error
+		if err != nil {
+			return flagged, err
+		}
+		flagged++
+	}
+	return flagged, nil
+}
+
+// FindPackageReroutes retrieves the mail carrier's open packages that need rerouting, along with the old and new assignments
+func FindPackageReroutes(db *gorm.DB, carrierID uuid.UUID) ([]PackageReroute, error) {
+	packages := []Package{}
+	err := db.Debug().Set("gorm:auto_preload", true).Model(&Package{}).Where("mail_carrier_id = ? AND address_changed = true AND delivered = false", carrierID).Order("estimated_delivery, id").Limit(250).Find(&packages).Error
+	if err != nil || len(packages) == 0 {
+		return []PackageReroute{}, err
+	}
+
+	ids := []int64{}
+	for _, p := range packages {
+		ids = append(ids, p.AddressAssignmentID.Int64, p.RerouteAssignmentID.Int64)
+	}
+	assignments := []AddressAssignment{}
+	err = db.Debug().Preload("Address").Preload("User").Model(&AddressAssignment{}).Where("id IN (?)", ids).Find(&assignments).Error
+	if err != nil {
+		return []PackageReroute{}, err
+	}
+	byID := map[uint64]AddressAssignment{}
+	for _, aa := range assignments {
+		byID[aa.ID] = aa
+	}
+
+	reroutes := []PackageReroute{}
+	for _, p := range packages {
+		reroutes = append(reroutes, PackageReroute{
+			Package: p,
+			From:    byID[uint64(p.AddressAssignmentID.Int64)],
+			To:      byID[uint64(p.RerouteAssignmentID.Int64)],
+		})
+	}
+	return reroutes, nil
+}
+
+// AcknowledgePackageReroute records that the mail carrier has rerouted the package, it is now being delivered to the new assignment
+func AcknowledgePackageReroute(db *gorm.DB, carrierID uuid.UUID, tracking string) error {
+	p := Package{}
+	err := db.Debug().Model(&Package{}).Where("tracking = ? AND mail_carrier_id = ? AND address_changed = true", tracking, carrierID).Take(&p).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return errors.New("Package reroute not found")
+		}
+		return err
+	}
+	return db.Debug().Model(&Package{}).Where("id = ?", p.ID).Updates(map[string]interface{}{"address_assignment_id": p.RerouteAssignmentID, "address_changed": false, "reroute_assignment_id": nil, "updated_at": time.Now()}).Error
+}
+
 // DeletePackage removes an address assignment from the DB (should never use this unless correcting an accidental addition)
 func (p *Package) DeletePackage(db *gorm.DB, did uint64) (int64, error) {
 
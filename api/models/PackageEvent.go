@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// PackageStatus is the status reported by a tracking event
+type PackageStatus string
+
+const (
+	// LabelCreated means the shipper has created a label but the carrier does not have the package yet
+	LabelCreated PackageStatus = "label_created"
+	// InTransit means the carrier has the package
+	InTransit PackageStatus = "in_transit"
+	// OutForDelivery means the package is on the delivery vehicle
+	OutForDelivery PackageStatus = "out_for_delivery"
+	// PackageDelivered means the package was delivered
+	PackageDelivered PackageStatus = "delivered"
+	// DeliveryException means the carrier could not deliver the package
+	DeliveryException PackageStatus = "exception"
+	// ReturnedToSender means the package is being sent back
+	ReturnedToSender PackageStatus = "returned"
+	// EstimateUpdated means the sender or recipient changed the estimated delivery. It is not a status carriers can report
+	// and it leaves the package's status as it was
+	EstimateUpdated PackageStatus = "estimate_updated"
+)
+
+var packageStatuses = []PackageStatus{
+	LabelCreated,
+	InTransit,
+	OutForDelivery,
+	PackageDelivered,
+	DeliveryException,
+	ReturnedToSender,
+}
+
+// PackageEvent is the DB structure for a single tracking event. A package's delivery state is derived from its events
+type PackageEvent struct {
+	ID                uint64        `gorm:"primary_key;auto_increment" json:"id"`
+	PackageID         uint64        `gorm:"not null;index:ix_package_events_package_id" sql:"type:bigint REFERENCES packages(id)" json:"package_id"`
+	Status            PackageStatus `gorm:"size:30;not null;" json:"status"`
+	Location          null.String   `gorm:"size:255;" json:"location"`
+	EstimatedDelivery null.Time     `json:"estimated_delivery"`
+	OccurredAt        time.Time     `gorm:"not null;" json:"occurred_at"`
+	Source            APIUser       `json:"source"`
+	SourceID          uuid.NullUUID `gorm:"type:uuid;" sql:"type:uuid REFERENCES api_users(id)" json:"source_id"`
+	CreatedAt         time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// PackageState is the delivery state of a package, derived from its events
+type PackageState struct {
+	Status            PackageStatus
+	Delivered         bool
+	DeliveredOn       null.Time
+	EstimatedDelivery null.Time
+}
+
+// Prepare formats the PackageEvent object
+func (pe *PackageEvent) Prepare() {
+	pe.ID = 0
+	pe.Source = APIUser{}
+	if pe.OccurredAt.IsZero() {
+		pe.OccurredAt = time.Now()
+	}
+	pe.CreatedAt = time.Now()
+}
+
+// Validate checks the input fields for a PackageEvent
+func (pe *PackageEvent) Validate() error {
+	for _, status := range packageStatuses {
+		if pe.Status == status {
+			return nil
+		}
+	}
+	return errors.New("Invalid package status")
+}
+
+// SortPackageEvents returns the events oldest first
+func SortPackageEvents(events []PackageEvent) []PackageEvent {
+	sorted := append([]PackageEvent{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].OccurredAt.Before(sorted[j].OccurredAt)
+	})
+	return sorted
+}
+
+// LatestPackageEvent returns the most recent status event, if there are any. Estimate updates are skipped
+func LatestPackageEvent(events []PackageEvent) (PackageEvent, bool) {
+	sorted := SortPackageEvents(events)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if sorted[i].Status != EstimateUpdated {
+			return sorted[i], true
+		}
+	}
+	return PackageEvent{}, false
+}
+
+// DerivePackageState works out a package's delivery state from its events. The latest status event sets the status,
+// and the latest estimate that was given sets the estimated delivery
+func DerivePackageState(events []PackageEvent) PackageState {
+	state := PackageState{}
+	for _, event := range SortPackageEvents(events) {
+		if event.EstimatedDelivery.Valid {
+			state.EstimatedDelivery = event.EstimatedDelivery
+		}
+		if event.Status == EstimateUpdated {
+			continue
+		}
+		state.Status = event.Status
+		state.Delivered = event.Status == PackageDelivered
+		state.DeliveredOn = null.Time{}
+		if state.Delivered {
+			state.DeliveredOn = null.TimeFrom(event.OccurredAt)
+		}
+	}
+	return state
+}
+
+// SavePackageEvent appends an event to a package and updates the package's delivery state. The caller runs it in a transaction
+// so that the event and the state derived from it are saved together. The package row is locked first, so events saved at the
+// same time are derived one after the other and each sees the events saved before it
+func (pe *PackageEvent) SavePackageEvent(db *gorm.DB) (*PackageEvent, error) {
+	locked := Package{}
+	err := db.Debug().Raw("SELECT id FROM packages WHERE id = ? FOR UPDATE", pe.PackageID).Scan(&locked).Error
+	if err != nil {
+		return &PackageEvent{}, err
+	}
+	err = db.Debug().Set("gorm:save_associations", false).Create(&pe).Error
+	if err != nil {
+		return &PackageEvent{}, err
+	}
+	events := []PackageEvent{}
+	err = db.Debug().Model(&PackageEvent{}).Where("package_id = ?", pe.PackageID).Find(&events).Error
+	if err != nil {
+		return &PackageEvent{}, err
+	}
+	state := DerivePackageState(events)
+	err = db.Debug().Model(&Package{}).Where("id = ?", pe.PackageID).Updates(map[string]interface{}{
+		"delivered":          state.Delivered,
+		"delivered_on":       state.DeliveredOn,
+		"estimated_delivery": state.EstimatedDelivery,
+		"updated_at":         time.Now(),
+	}).Error
+	if err != nil {
+		return &PackageEvent{}, err
+	}
+	return pe, nil
+}
+
+// FindEventsForPackage retrieves the tracking timeline of a package, oldest first
+func (pe *PackageEvent) FindEventsForPackage(db *gorm.DB, packageID uint64) (*[]PackageEvent, error) {
+	var err error
+	events := []PackageEvent{}
+	err = db.Debug().Preload("Source").Model(&PackageEvent{}).Where("package_id = ?", packageID).Order("occurred_at").Find(&events).Error
+	if err != nil {
+		return &[]PackageEvent{}, err
+	}
+	return &events, nil
+}
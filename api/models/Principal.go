@@ -0,0 +1,76 @@
+package models
+
+import (
+	uuid "github.com/satori/go.uuid"
+)
+
+// Scope is a permission a route can require
+type Scope string
+
+const (
+	// ZipReadScope allows a user to get zip code information
+	ZipReadScope Scope = "zip:read"
+	// AddressReadScope allows a user to get full address information
+	AddressReadScope Scope = "address:read"
+	// PackageReadScope allows a user to get package information
+	PackageReadScope Scope = "package:read"
+	// PackageWriteScope allows a user to create and update packages
+	PackageWriteScope Scope = "package:write"
+	// OrderWriteScope allows a retailer to take orders and hand them to carriers
+	OrderWriteScope Scope = "order:write"
+	// AdminScope allows a user to manage other users
+	AdminScope Scope = "admin"
+)
+
+// permissionScopes lists the scopes granted to each API user permission
+var permissionScopes = map[Permission][]Scope{
+	NoPermission:       {},
+	LimitedPermission:  {ZipReadScope},
+	FullPermission:     {ZipReadScope, AddressReadScope, PackageReadScope, PackageWriteScope},
+	AdminPermission:    {ZipReadScope, AddressReadScope, PackageReadScope, PackageWriteScope, AdminScope},
+	EngineerPermission: {ZipReadScope, AddressReadScope, PackageReadScope, PackageWriteScope, AdminScope},
+}
+
+// authorityScopes lists the scopes granted to each UI user authority
+var authorityScopes = map[authority][]Scope{
+	UserAuth:     {},
+	RetailerAuth: {ZipReadScope, OrderWriteScope},
+	AdminAuth:    {AdminScope},
+	EngineerAuth: {AdminScope},
+}
+
+// HasScope returns true if the permission grants the scope
+func (p Permission) HasScope(scope Scope) bool {
+	return containsScope(permissionScopes[p], scope)
+}
+
+func containsScope(scopes []Scope, scope Scope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the authenticated UI user or API user making a request. Exactly one of User and APIUser is set
+type Principal struct {
+	User    *User
+	APIUser *APIUser
+}
+
+// ID returns the ID of the UI user or API user
+func (p *Principal) ID() uuid.UUID {
+	if p.APIUser != nil {
+		return p.APIUser.ID
+	}
+	return p.User.ID
+}
+
+// HasScope returns true if the principal is allowed to use the scope
+func (p *Principal) HasScope(scope Scope) bool {
+	if p.APIUser != nil {
+		return p.APIUser.Permission.HasScope(scope)
+	}
+	return containsScope(authorityScopes[p.User.Authority], scope)
+}
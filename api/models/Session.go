@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	// UISession is the session kind for UI users
+	UISession = "ui"
+	// APISession is the session kind for API users
+	APISession = "api"
+)
+
+// Session is the DB structure for a login session. A session's ID is the jti claim of both its refresh token and the access tokens issued with it,
+// so revoking a session revokes all of them
+type Session struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:ix_sessions_user_id" json:"user_id"`
+	Kind      string    `gorm:"size:10;not null;" json:"kind"`
+	ExpiresAt time.Time `gorm:"not null;" json:"expires_at"`
+	RevokedAt null.Time `json:"revoked_at"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (s *Session) BeforeCreate(scope *gorm.Scope) error {
+	uuid := uuid.NewV4()
+	return scope.SetColumn("ID", uuid)
+}
+
+// Active returns true if the session has not been revoked and has not expired
+func (s *Session) Active() bool {
+	return !s.RevokedAt.Valid && time.Now().Before(s.ExpiresAt)
+}
+
+// SaveSession starts a new session for a UI or API user
+func (s *Session) SaveSession(db *gorm.DB) (*Session, error) {
+	var err error
+	err = db.Debug().Create(&s).Error
+	if err != nil {
+		return &Session{}, err
+	}
+	return s, nil
+}
+
+// FindSessionByID retrieves a session using its ID
+func (s *Session) FindSessionByID(db *gorm.DB, sid uuid.UUID) (*Session, error) {
+	var err error
+	err = db.Debug().Model(&Session{}).Where("id = ?", sid).Take(&s).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &Session{}, errors.New("Session not found")
+		}
+		return &Session{}, err
+	}
+	return s, nil
+}
+
+// RevokeSession revokes a single session (used on logout and when a refresh token is exchanged). Returns 0 if the session was
+// already revoked, so that a refresh token exchanged twice at the same time is only honoured once
+func (s *Session) RevokeSession(db *gorm.DB, sid uuid.UUID) (int64, error) {
+	db = db.Debug().Model(&Session{}).Where("id = ? AND revoked_at IS NULL", sid).Updates(map[string]interface{}{"revoked_at": time.Now(), "updated_at": time.Now()})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
+
+// RevokeAllSessionsForUser revokes every active session for a UI or API user
+func (s *Session) RevokeAllSessionsForUser(db *gorm.DB, uid uuid.UUID) (int64, error) {
+	db = db.Debug().Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", uid).Updates(map[string]interface{}{"revoked_at": time.Now(), "updated_at": time.Now()})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
+
+// SessionRevocationStore checks token jti claims against the sessions table
+type SessionRevocationStore struct {
+	DB *gorm.DB
+}
+
+// IsRevoked returns true if the session with the provided ID is missing, revoked or expired
+func (store SessionRevocationStore) IsRevoked(jti string) bool {
+	sid, err := uuid.FromString(jti)
+	if err != nil {
+		return true
+	}
+	session := Session{}
+	_, err = session.FindSessionByID(store.DB, sid)
+	if err != nil {
+		return true
+	}
+	return !session.Active()
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// GrantDecision is what a user decided about sharing their full address with an API user
+type GrantDecision string
+
+const (
+	// GrantAllow lets the API user see the user's full address
+	GrantAllow GrantDecision = "allow"
+	// GrantDeny gives the API user the zip code only
+	GrantDeny GrantDecision = "deny"
+	// GrantAsk gives the API user the zip code only and leaves the request pending until the user allows or denies it
+	GrantAsk GrantDecision = "ask"
+)
+
+// ShareGrant is the DB structure for a user's consent to share their full address with a shipper or retailer.
+// A user has at most one grant per API user, a lookup without one adds an ask grant as a pending approval request
+type ShareGrant struct {
+	ID          uint64        `gorm:"primary_key;auto_increment" json:"id"`
+	UserID      uuid.UUID     `gorm:"type:uuid;not null;unique_index:ux_share_grants_user_id_api_user_id" sql:"type:uuid REFERENCES users(id)" json:"user_id"`
+	APIUser     APIUser       `json:"-"`
+	APIUserID   uuid.UUID     `gorm:"type:uuid;not null;unique_index:ux_share_grants_user_id_api_user_id" sql:"type:uuid REFERENCES api_users(id)" json:"api_user_id"`
+	Decision    GrantDecision `gorm:"size:10;not null;" json:"decision"`
+	ExpiresAt   null.Time     `json:"expires_at"`
+	RequestedAt null.Time     `json:"requested_at"`
+	CreatedAt   time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt   time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// Prepare formats the ShareGrant object
+func (sg *ShareGrant) Prepare() {
+	sg.ID = 0
+	sg.APIUser = APIUser{}
+	sg.RequestedAt = null.Time{}
+	sg.CreatedAt = time.Now()
+	sg.UpdatedAt = time.Now()
+}
+
+// Validate checks the input fields for a ShareGrant
+func (sg *ShareGrant) Validate() error {
+	if sg.APIUserID == uuid.Nil {
+		return errors.New("Required API User")
+	}
+	if sg.Decision != GrantAllow && sg.Decision != GrantDeny && sg.Decision != GrantAsk {
+		return errors.New("Decision must be allow, deny or ask")
+	}
+	if sg.ExpiresAt.Valid && !sg.ExpiresAt.Time.After(time.Now()) {
+		return errors.New("Expiry must be in the future")
+	}
+	return nil
+}
+
+// Expired returns true if the grant had an expiry and it has passed
+func (sg *ShareGrant) Expired(now time.Time) bool {
+	return sg.ExpiresAt.Valid && !now.Before(sg.ExpiresAt.Time)
+}
+
+// Effective returns the decision that applies now, an expired grant is treated as missing and asks again
+func (sg *ShareGrant) Effective(now time.Time) GrantDecision {
+	if sg.ID == 0 || sg.Expired(now) {
+		return GrantAsk
+	}
+	return sg.Decision
+}
+
+// SaveShareGrant adds a grant, or replaces the decision and expiry of the user's grant for the API user
+func (sg *ShareGrant) SaveShareGrant(db *gorm.DB) (*ShareGrant, error) {
+	existing := ShareGrant{}
+	err := db.Debug().Model(&ShareGrant{}).Where("user_id = ? AND api_user_id = ?", sg.UserID, sg.APIUserID).Take(&existing).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return &ShareGrant{}, err
+	}
+	if err == nil {
+		err = db.Debug().Model(&ShareGrant{}).Where("id = ?", existing.ID).Updates(map[string]interface{}{"decision": sg.Decision, "expires_at": sg.ExpiresAt, "updated_at": time.Now()}).Error
+		if err != nil {
+			return &ShareGrant{}, err
+		}
+		return sg.FindShareGrantByID(db, existing.ID)
+	}
+	err = db.Debug().Set("gorm:save_associations", false).Create(&sg).Error
+	if err != nil {
+		return &ShareGrant{}, err
+	}
+	return sg, nil
+}
+
+// FindShareGrantByID retrieves a grant using its ID
+func (sg *ShareGrant) FindShareGrantByID(db *gorm.DB, gid uint64) (*ShareGrant, error) {
+	var err error
+	err = db.Debug().Preload("APIUser").Model(&ShareGrant{}).Where("id = ?", gid).Take(&sg).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &ShareGrant{}, errors.New("Grant not found")
+		}
+		return &ShareGrant{}, err
+	}
+	return sg, nil
+}
+
+// FindShareGrantsForUser retrieves a user's grants, only the pending ones when pending is true
+func (sg *ShareGrant) FindShareGrantsForUser(db *gorm.DB, uid uuid.UUID, pending bool) (*[]ShareGrant, error) {
+	var err error
+	grants := []ShareGrant{}
+	query := db.Debug().Preload("APIUser").Model(&ShareGrant{}).Where("user_id = ?", uid)
+	if pending {
+		query = query.Where("decision = ? OR expires_at <= ?", GrantAsk, time.Now())
+	}
+	err = query.Order("updated_at desc").Find(&grants).Error
+	if err != nil {
+		return &[]ShareGrant{}, err
+	}
+	return &grants, nil
+}
+
+// RequestShareGrant returns the user's grant for the API user. If there is none, or it has expired, it is saved as a pending ask grant
+func (sg *ShareGrant) RequestShareGrant(db *gorm.DB, uid uuid.UUID, apiUserID uuid.UUID) (*ShareGrant, error) {
+	now := time.Now()
+	err := db.Debug().Model(&ShareGrant{}).Where("user_id = ? AND api_user_id = ?", uid, apiUserID).Take(&sg).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return &ShareGrant{}, err
+	}
+	if err != nil {
+		// A grant added at the same time by another request is kept, and read back in place of the one that was not inserted
+		err = db.Debug().Exec(`INSERT INTO share_grants (user_id, api_user_id, decision, requested_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (user_id, api_user_id) DO NOTHING`, uid, apiUserID, GrantAsk, now, now, now).Error
+		if err != nil {
+			return &ShareGrant{}, err
+		}
+		*sg = ShareGrant{}
+		err = db.Debug().Model(&ShareGrant{}).Where("user_id = ? AND api_user_id = ?", uid, apiUserID).Take(&sg).Error
+		if err != nil {
+			return &ShareGrant{}, err
+		}
+		return sg, nil
+	}
+	if sg.Effective(now) != GrantAsk {
+		return sg, nil
+	}
+	sg.Decision = GrantAsk
+	sg.ExpiresAt = null.Time{}
+	sg.RequestedAt = null.TimeFrom(now)
+	err = db.Debug().Model(&ShareGrant{}).Where("id = ?", sg.ID).Updates(map[string]interface{}{"decision": sg.Decision, "expires_at": sg.ExpiresAt, "requested_at": sg.RequestedAt, "updated_at": now}).Error
+	if err != nil {
+		return &ShareGrant{}, err
+	}
+	return sg, nil
+}
+
+// RequestShareGrants is RequestShareGrant for many users at once. Missing grants are added and expired ones asked again with one statement each.
+// A grant added at the same time by another request is left out of the result, which the caller treats as ask
+func RequestShareGrants(db *gorm.DB, uids []uuid.UUID, apiUserID uuid.UUID) (map[uuid.UUID]ShareGrant, error) {
+	now := time.Now()
+	byUser := map[uuid.UUID]ShareGrant{}
+	if len(uids) == 0 {
+		return byUser, nil
+	}
+	existing := []ShareGrant{}
+	err := db.Debug().Model(&ShareGrant{}).Where("api_user_id = ? AND user_id IN (?)", apiUserID, uids).Find(&existing).Error
+	if err != nil {
+		return byUser, err
+	}
+
+	asking := []uint64{}
+	for _, sg := range existing {
+		if sg.Effective(now) == GrantAsk {
+			sg.Decision = GrantAsk
+			sg.ExpiresAt = null.Time{}
+			sg.RequestedAt = null.TimeFrom(now)
+			asking = append(asking, sg.ID)
+		}
+		byUser[sg.UserID] = sg
+	}
+	if len(asking) > 0 {
+		err = db.Debug().Model(&ShareGrant{}).Where("id IN (?)", asking).Updates(map[string]interface{}{"decision": GrantAsk, "expires_at": null.Time{}, "requested_at": null.TimeFrom(now), "updated_at": now}).Error
+		if err != nil {
+			return byUser, err
+		}
+	}
+
+	rows := []string{}
+	values := []interface{}{}
+	adding := map[uuid.UUID]bool{}
+	for _, uid := range uids {
+		if _, ok := byUser[uid]; ok || adding[uid] {
+			continue
+		}
+		adding[uid] = true
+		rows = append(rows, "(?, ?, ?, ?, ?, ?)")
+		values = append(values, uid, apiUserID, GrantAsk, now, now, now)
+	}
+	if len(rows) == 0 {
+		return byUser, nil
+	}
+	added := []ShareGrant{}
+	err = db.Debug().Raw(`INSERT INTO share_grants (user_id, api_user_id, decision, requested_at, created_at, updated_at) VALUES `+strings.Join(rows, ", ")+`
+		ON CONFLICT (user_id, api_user_id) DO NOTHING RETURNING *`, values...).Scan(&added).Error
+	if err != nil {
+		return byUser, err
+	}
+	for _, sg := range added {
+		byUser[sg.UserID] = sg
+	}
+	return byUser, nil
+}
+
+// DeleteShareGrant removes a user's grant, the API user's next lookup asks the user again
+func (sg *ShareGrant) DeleteShareGrant(db *gorm.DB, uid uuid.UUID, gid uint64) (int64, error) {
+	db = db.Debug().Model(&ShareGrant{}).Where("id = ? AND user_id = ?", gid, uid).Delete(&ShareGrant{})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return 0, errors.New("Grant not found")
+	}
+	return db.RowsAffected, nil
+}
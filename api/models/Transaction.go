@@ -0,0 +1,26 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// Transaction runs fn inside a DB transaction. Every model method called by fn must be given the tx handle.
+// The transaction is committed if fn returns nil and rolled back if fn returns an error or panics
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
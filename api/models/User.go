@@ -4,11 +4,13 @@ import (
 	"database/sql/driver"
 	"errors"
 	"log"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/badoux/checkmail"
 	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/guregu/null.v3"
@@ -16,14 +18,7 @@ import (
 
 type authority string
 
-/*
-postgres command to create enum:
-CREATE TYPE authority AS ENUM (
-	'user',
-	'retailer',
-	'admin',
-	'engineer');
-*/
+// The authority enum is created in migration 0001_create_enums
 
 const (
 	// UserAuth is the user authority type
@@ -32,6 +27,8 @@ const (
 	AdminAuth authority = "admin"
 	// EngineerAuth is the engineer authority type
 	EngineerAuth authority = "engineer"
+	// RetailerAuth is the retailer authority type, retailers take orders and hand them to carriers
+	RetailerAuth authority = "retailer"
 )
 
 func (a *authority) Scan(value interface{}) error {
@@ -44,21 +41,23 @@ func (a authority) Value() (driver.Value, error) {
 	return string(a), nil
 }
 
-// User is the DB and json structure for a user
+// User is the DB and json structure for a user. LegacySmartID is set for users who picked their SmartID before the server
+// issued them, those SmartIDs have no check character
 type User struct {
-	ID          uuid.UUID   `gorm:"type:uuid;primary_key" json:"id"`
-	SmartID     string      `gorm:"size:8;not null;unique;unique_index:ix_smart_id" json:"smart_id"`
-	Email       string      `gorm:"size:100;not null;unique" json:"email"`
-	FirstName   string      `gorm:"size:30;not null;" json:"first_name"`
-	LastName    string      `gorm:"size:30;not null;" json:"last_name"`
-	Phone       string      `gorm:"size:30;not null;" json:"phone"`
-	Authority   authority   `sql:"type:authority" json:"authority"`
-	Password    string      `gorm:"size:100;not null;" json:"password"`
-	SmallLogo   null.String `gorm:"size:100;" json:"small_logo"`
-	LargeLogo   null.String `gorm:"size:100;" json:"large_logo"`
-	RedirectURL null.String `gorm:"size:100;" json:"redirect_url"`
-	CreatedAt   time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt   time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID            uuid.UUID   `gorm:"type:uuid;primary_key" json:"id"`
+	SmartID       string      `gorm:"size:8;not null;unique;unique_index:ix_smart_id" json:"smart_id"`
+	LegacySmartID bool        `gorm:"not null;default:false" json:"-"`
+	Email         string      `gorm:"size:100;not null;unique" json:"email"`
+	FirstName     string      `gorm:"size:30;not null;" json:"first_name"`
+	LastName      string      `gorm:"size:30;not null;" json:"last_name"`
+	Phone         string      `gorm:"size:30;not null;" json:"phone"`
+	Authority     authority   `sql:"type:authority" json:"authority"`
+	Password      string      `gorm:"size:100;not null;" json:"password"`
+	SmallLogo     null.String `gorm:"size:100;" json:"small_logo"`
+	LargeLogo     null.String `gorm:"size:100;" json:"large_logo"`
+	RedirectURL   null.String `gorm:"size:100;" json:"redirect_url"`
+	CreatedAt     time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt     time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
 // BeforeCreate will set a UUID rather than numeric ID.
@@ -178,10 +177,52 @@ func (u *User) Validate(action string) error {
 	}
 }
 
-// SaveUser saves a user to the DB. Almost always done in conjunction with saving a user's first address and address assignment
+// ValidateRetailer checks the branding a retailer shows their customers. The redirect URL is where customers are sent back to
+// after sharing their SmartID at checkout
+func (u *User) ValidateRetailer() error {
+	if u.RedirectURL.String == "" {
+		return errors.New("Required redirect URL")
+	}
+	parsed, err := url.Parse(u.RedirectURL.String)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New("Redirect URL must be an https URL")
+	}
+	return nil
+}
+
+// maxSmartIDAttempts is the number of times GenerateSmartID will try to find an unused SmartID before giving up
+const maxSmartIDAttempts = 10
+
+// GenerateSmartID issues a new SmartID (with a check character) that is not already assigned to a user
+func GenerateSmartID(db *gorm.DB) (string, error) {
+	for attempt := 0; attempt < maxSmartIDAttempts; attempt++ {
+		newSmartID, err := smartid.Generate()
+		if err != nil {
+			return "", err
+		}
+		var count int
+		err = db.Debug().Model(&User{}).Where("smart_id = ?", newSmartID).Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return newSmartID, nil
+		}
+	}
+	return "", errors.New("Unable to generate a unique smartID")
+}
+
+// SaveUser saves a user to the DB. Almost always done in conjunction with saving a user's first address and address assignment.
+// SmartIDs are issued by the server, any SmartID supplied by the client is replaced unless a legacy user is being imported
 func (u *User) SaveUser(db *gorm.DB) (*User, error) {
 
 	var err error
+	if !u.LegacySmartID {
+		u.SmartID, err = GenerateSmartID(db)
+		if err != nil {
+			return &User{}, err
+		}
+	}
 	err = db.Debug().Create(&u).Error
 	if err != nil {
 		return &User{}, err
@@ -268,6 +309,27 @@ func (u *User) UpdateAUserBasic(db *gorm.DB, uid uuid.UUID) (*User, error) {
 	return u, nil
 }
 
+// UpdateRetailer makes the user a retailer with the branding shown to their customers
+func (u *User) UpdateRetailer(db *gorm.DB, uid uuid.UUID) (*User, error) {
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+		map[string]interface{}{
+			"authority":    RetailerAuth,
+			"small_logo":   u.SmallLogo,
+			"large_logo":   u.LargeLogo,
+			"redirect_url": u.RedirectURL,
+			"updated_at":   time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &User{}, db.Error
+	}
+	err := db.Debug().Model(&User{}).Where("id = ?", uid).Take(&u).Error
+	if err != nil {
+		return &User{}, err
+	}
+	return u, nil
+}
+
 // DeleteUser removes a user from the DB
 func (u *User) DeleteUser(db *gorm.DB, uid uuid.UUID) (int64, error) {
 
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// WebhookEvent is the kind of address change a webhook delivery reports
+type WebhookEvent string
+
+const (
+	// AddressChangedEvent is sent when a user saves a new permanent address
+	AddressChangedEvent WebhookEvent = "address.changed"
+	// AddressTemporaryEvent is sent when a user saves a temporary move
+	AddressTemporaryEvent WebhookEvent = "address.temporary"
+	// AddressHoldEvent is sent when a user holds their mail or packages
+	AddressHoldEvent WebhookEvent = "address.hold"
+	// AddressUndeliverableEvent is sent to the API users linked to a user when a carrier reports one of their addresses undeliverable
+	AddressUndeliverableEvent WebhookEvent = "address.undeliverable"
+)
+
+// AssignmentEvent returns the webhook event for a saved assignment, false if the assignment does not produce one
+func AssignmentEvent(aa *AddressAssignment) (WebhookEvent, bool) {
+	switch {
+	case aa.IsHold():
+		return AddressHoldEvent, true
+	case aa.IsTemporary():
+		return AddressTemporaryEvent, true
+	case aa.IsPermanent():
+		return AddressChangedEvent, true
+	}
+	return "", false
+}
+
+// WebhookEvents is the list of events a webhook is subscribed to, an empty list subscribes to every event. It is stored one event per line
+type WebhookEvents []WebhookEvent
+
+// Scan reads the events from the DB
+func (we *WebhookEvents) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	}
+	*we = WebhookEvents{}
+	for _, event := range strings.Split(raw, "\n") {
+		if event != "" {
+			*we = append(*we, WebhookEvent(event))
+		}
+	}
+	return nil
+}
+
+// Value returns the events to store in the DB
+func (we WebhookEvents) Value() (driver.Value, error) {
+	events := []string{}
+	for _, event := range we {
+		events = append(events, string(event))
+	}
+	return strings.Join(events, "\n"), nil
+}
+
+// webhookSecretPrefix starts every webhook signing secret
+const webhookSecretPrefix = "whsec_"
+
+// Webhook is the DB structure for an endpoint an API user registers to be told about address changes.
+// Every delivery to it is signed with Secret, see the webhooks package
+type Webhook struct {
+	ID        uint64        `gorm:"primary_key;auto_increment" json:"id"`
+	APIUser   APIUser       `json:"-"`
+	APIUserID uuid.UUID     `gorm:"type:uuid;not null;index:ix_webhooks_api_user_id" sql:"type:uuid REFERENCES api_users(id)" json:"api_user_id"`
+	URL       string        `gorm:"size:2048;not null;" json:"url"`
+	Secret    string        `gorm:"size:80;not null;" json:"secret"`
+	Events    WebhookEvents `gorm:"type:text" json:"events"`
+	CreatedAt time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// GenerateWebhookSecret creates a random signing secret
+func GenerateWebhookSecret() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(random), nil
+}
+
+// Prepare formats the Webhook object
+func (wh *Webhook) Prepare() {
+	wh.ID = 0
+	wh.APIUser = APIUser{}
+	wh.Secret = ""
+	wh.URL = strings.TrimSpace(wh.URL)
+	wh.CreatedAt = time.Now()
+	wh.UpdatedAt = time.Now()
+}
+
+// Validate checks the input fields for a Webhook
+func (wh *Webhook) Validate() error {
+	if wh.URL == "" {
+		return errors.New("Required URL")
+	}
+	endpoint, err := url.Parse(wh.URL)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Hostname() == "" {
+		return errors.New("URL must be an absolute https URL")
+	}
+	host := strings.TrimSuffix(strings.ToLower(endpoint.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("URL must not point to a local or private address")
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return errors.New("URL must not point to a local or private address")
+	}
+	for _, event := range wh.Events {
+		if event != AddressChangedEvent && event != AddressTemporaryEvent && event != AddressHoldEvent && event != AddressUndeliverableEvent {
+			return errors.New("Events must be address.changed, address.temporary, address.hold or address.undeliverable")
+		}
+	}
+	return nil
+}
+
+// privateNetworks are the IPv4 and IPv6 ranges that are not reachable from the internet
+var privateNetworks = []*net.IPNet{
+	parseCIDR("10.0.0.0/8"),
+	parseCIDR("100.64.0.0/10"),
+	parseCIDR("172.16.0.0/12"),
+	parseCIDR("192.168.0.0/16"),
+	parseCIDR("fc00::/7"),
+}
+
+func parseCIDR(cidr string) *net.IPNet {
+	_, network, _ := net.ParseCIDR(cidr)
+	return network
+}
+
+// PublicIP returns false for loopback, link-local, private and unspecified addresses, which webhooks may not be sent to
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscribes returns true if the webhook is sent the event
+func (wh *Webhook) Subscribes(event WebhookEvent) bool {
+	if len(wh.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range wh.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveWebhook issues the webhook a signing secret and saves it
+func (wh *Webhook) SaveWebhook(db *gorm.DB) (*Webhook, error) {
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		return &Webhook{}, err
+	}
+	wh.Secret = secret
+	err = db.Debug().Set("gorm:save_associations", false).Create(&wh).Error
+	if err != nil {
+		return &Webhook{}, err
+	}
+	return wh, nil
+}
+
+// FindWebhooksForAPIUser retrieves every webhook an API user has registered
+func (wh *Webhook) FindWebhooksForAPIUser(db *gorm.DB, apiUserID uuid.UUID) (*[]Webhook, error) {
+	var err error
+	webhooks := []Webhook{}
+	err = db.Debug().Model(&Webhook{}).Where("api_user_id = ?", apiUserID).Order("id").Find(&webhooks).Error
+	if err != nil {
+		return &[]Webhook{}, err
+	}
+	return &webhooks, nil
+}
+
+// DeleteWebhook removes an API user's webhook along with its deliveries
+func (wh *Webhook) DeleteWebhook(db *gorm.DB, apiUserID uuid.UUID, wid uint64) (int64, error) {
+	db = db.Debug().Model(&Webhook{}).Where("id = ? AND api_user_id = ?", wid, apiUserID).Delete(&Webhook{})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return 0, errors.New("Webhook not found")
+	}
+	return db.RowsAffected, nil
+}
+
+// MaxWebhookAttempts is how many times a delivery is tried before it is moved to the dead-letter list
+const MaxWebhookAttempts = 8
+
+const (
+	// webhookBackoffBase is the wait after the first failed attempt, it doubles after each attempt after that
+	webhookBackoffBase = 30 * time.Second
+	// webhookBackoffMax is the longest wait between attempts
+	webhookBackoffMax = 6 * time.Hour
+)
+
+// WebhookBackoff returns how long to wait before retrying a delivery that has failed attempts times
+func WebhookBackoff(attempts int) time.Duration {
+	backoff := webhookBackoffBase
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookBackoffMax {
+			return webhookBackoffMax
+		}
+	}
+	return backoff
+}
+
+// WebhookDelivery is the DB structure for one event sent to one webhook. Deliveries are saved in the same transaction as the
+// change they report and sent by the webhooks dispatcher. A delivery that fails MaxWebhookAttempts times is dead (DeadAt is set)
+// and is not tried again unless the API user asks for it to be redelivered
+type WebhookDelivery struct {
+	ID            uint64       `gorm:"primary_key;auto_increment" json:"id"`
+	Webhook       Webhook      `json:"-"`
+	WebhookID     uint64       `gorm:"not null;index:ix_webhook_deliveries_webhook_id" sql:"type:bigint REFERENCES webhooks(id) ON DELETE CASCADE" json:"webhook_id"`
+	Event         WebhookEvent `gorm:"size:30;not null;" json:"event"`
+	Payload       string       `gorm:"type:text;not null;" json:"payload"`
+	Attempts      int          `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time    `gorm:"not null;" json:"next_attempt_at"`
+	LastStatus    null.Int     `json:"last_status"`
+	LastError     null.String  `gorm:"type:text" json:"last_error"`
+	DeliveredAt   null.Time    `json:"delivered_at"`
+	DeadAt        null.Time    `json:"dead_at"`
+	CreatedAt     time.Time    `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt     time.Time    `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// RecordAttempt updates the delivery after it was sent. status is the receiver's HTTP status, or 0 when err is set because no response was received.
+// Any 2xx status is a success, after a failure the next attempt is scheduled with WebhookBackoff or the delivery is dead
+func (wd *WebhookDelivery) RecordAttempt(now time.Time, status int, err error) {
+	wd.Attempts++
+	wd.UpdatedAt = now
+	wd.LastStatus = null.NewInt(int64(status), status != 0)
+	if err == nil && status >= 200 && status < 300 {
+		wd.LastError = null.String{}
+		wd.DeliveredAt = null.TimeFrom(now)
+		return
+	}
+	if err != nil {
+		wd.LastError = null.StringFrom(err.Error())
+	} else {
+		wd.LastError = null.StringFrom("Unexpected response status")
+	}
+	if wd.Attempts >= MaxWebhookAttempts {
+		wd.DeadAt = null.TimeFrom(now)
+		return
+	}
+	wd.NextAttemptAt = now.Add(WebhookBackoff(wd.Attempts))
+}
+
+// SaveWebhookDelivery queues a delivery
+func (wd *WebhookDelivery) SaveWebhookDelivery(db *gorm.DB) (*WebhookDelivery, error) {
+	var err error
+	err = db.Debug().Set("gorm:save_associations", false).Create(&wd).Error
+	if err != nil {
+		return &WebhookDelivery{}, err
+	}
+	return wd, nil
+}
+
+// ClaimDueWebhookDeliveries returns up to limit deliveries whose next attempt is due, with their webhooks. Each claimed delivery's
+// next attempt is pushed back by lease in the same statement, so a delivery is only claimed by one dispatcher at a time
+func ClaimDueWebhookDeliveries(db *gorm.DB, now time.Time, lease time.Duration, limit int64) (*[]WebhookDelivery, error) {
+	var err error
+	deliveries := []WebhookDelivery{}
+	err = db.Debug().Raw(`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE delivered_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED
+	) RETURNING *`, now.Add(lease), now, limit).Scan(&deliveries).Error
+	if err != nil {
+		return &[]WebhookDelivery{}, err
+	}
+	for i := range deliveries {
+		err = db.Debug().Model(&Webhook{}).Where("id = ?", deliveries[i].WebhookID).Take(&deliveries[i].Webhook).Error
+		if err != nil {
+			return &[]WebhookDelivery{}, err
+		}
+	}
+	return &deliveries, nil
+}
+
+// UpdateWebhookDelivery saves the result of an attempt
+func (wd *WebhookDelivery) UpdateWebhookDelivery(db *gorm.DB) error {
+	return db.Debug().Model(&WebhookDelivery{}).Where("id = ?", wd.ID).Updates(map[string]interface{}{
+		"attempts":        wd.Attempts,
+		"next_attempt_at": wd.NextAttemptAt,
+		"last_status":     wd.LastStatus,
+		"last_error":      wd.LastError,
+		"delivered_at":    wd.DeliveredAt,
+		"dead_at":         wd.DeadAt,
+		"updated_at":      wd.UpdatedAt,
+	}).Error
+}
+
+// FindDeadWebhookDeliveries retrieves the dead-letter list of an API user's webhooks, newest first
+func (wd *WebhookDelivery) FindDeadWebhookDeliveries(db *gorm.DB, apiUserID uuid.UUID) (*[]WebhookDelivery, error) {
+	var err error
+	deliveries := []WebhookDelivery{}
+	err = db.Debug().Model(&WebhookDelivery{}).Joins("JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id").Where("webhooks.api_user_id = ? AND webhook_deliveries.dead_at IS NOT NULL", apiUserID).Order("webhook_deliveries.dead_at desc").Find(&deliveries).Error
+	if err != nil {
+		return &[]WebhookDelivery{}, err
+	}
+	return &deliveries, nil
+}
+
+// RedeliverWebhookDelivery takes a dead delivery off the dead-letter list and queues it to be sent again with a fresh set of attempts
+func (wd *WebhookDelivery) RedeliverWebhookDelivery(db *gorm.DB, apiUserID uuid.UUID, did uint64) error {
+	db = db.Debug().Model(&WebhookDelivery{}).Where("id = ? AND dead_at IS NOT NULL AND webhook_id IN (SELECT id FROM webhooks WHERE api_user_id = ?)", did, apiUserID).Updates(map[string]interface{}{"attempts": 0, "dead_at": nil, "next_attempt_at": time.Now(), "updated_at": time.Now()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("Dead delivery not found")
+	}
+	return nil
+}
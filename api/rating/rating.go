@@ -0,0 +1,215 @@
+// Package rating estimates what carriers charge to ship a package, using rate tables loaded from a file. Only the zone and
+// distance between two locations are used, so a quote never needs more of an address than its coordinates or zip code
+package rating
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/models"
+)
+
+var (
+	// ErrNoRateTable is returned when a carrier has no rate table
+	ErrNoRateTable = errors.New("Carrier has no rate table")
+	// ErrTooHeavy is returned when a package weighs more than the heaviest band of a carrier's rate table
+	ErrTooHeavy = errors.New("Package is heavier than the carrier's rate table allows")
+	// ErrUnknownZip is returned when a location has no coordinates and its zip code is not in the centroid table
+	ErrUnknownZip = errors.New("Unable to locate zip code")
+)
+
+// ZoneBand is a carrier zone covering distances up to MaxMiles. The last band has no MaxMiles and covers any distance
+type ZoneBand struct {
+	Zone     int     `json:"zone"`
+	MaxMiles float64 `json:"max_miles"`
+}
+
+// WeightBand is the price in each zone for packages up to MaxWeight pounds. Prices are in the same order as the zones
+type WeightBand struct {
+	MaxWeight float64   `json:"max_weight"`
+	Prices    []float64 `json:"prices"`
+}
+
+// Table is a carrier's rate table. DimDivisor converts cubic inches to a dimensional weight, dimensions are ignored when it is 0
+type Table struct {
+	DimDivisor float64      `json:"dim_divisor"`
+	Zones      []ZoneBand   `json:"zones"`
+	Rates      []WeightBand `json:"rates"`
+}
+
+// Package is the weight in pounds and dimensions in inches of the package being rated
+type Package struct {
+	Weight float64 `json:"weight"`
+	Length float64 `json:"length"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Quote is a carrier's estimated price to ship a package
+type Quote struct {
+	Zone           int     `json:"zone"`
+	BillableWeight float64 `json:"billable_weight"`
+	Price          float64 `json:"price"`
+}
+
+// Rater quotes prices from carrier rate tables, locating addresses without coordinates by their zip code centroid
+type Rater struct {
+	tables    map[string]Table
+	centroids *geocode.Offline
+}
+
+// NewFromEnv loads the rate tables from RATE_TABLES_FILE and the zip code centroids from RATE_CENTROID_FILE.
+// Rating is disabled and nil is returned when RATE_TABLES_FILE is not set
+func NewFromEnv() (*Rater, error) {
+	if os.Getenv("RATE_TABLES_FILE") == "" {
+		return nil, nil
+	}
+	return NewFromFiles(os.Getenv("RATE_TABLES_FILE"), os.Getenv("RATE_CENTROID_FILE"))
+}
+
+// NewFromFiles loads the rate tables and, when centroidPath is set, a centroid table in the offline geocoder's format
+func NewFromFiles(tablePath string, centroidPath string) (*Rater, error) {
+	tables, err := os.Open(tablePath)
+	if err != nil {
+		return nil, err
+	}
+	defer tables.Close()
+
+	var centroids io.Reader
+	if centroidPath != "" {
+		file, err := os.Open(centroidPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		centroids = file
+	}
+	return New(tables, centroids)
+}
+
+// New loads rate tables from JSON keyed by carrier code. centroids is optional CSV data in the offline geocoder's format
+func New(tables io.Reader, centroids io.Reader) (*Rater, error) {
+	raw := map[string]Table{}
+	err := json.NewDecoder(tables).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid rate tables: %v", err)
+	}
+	rater := &Rater{tables: map[string]Table{}}
+	for code, table := range raw {
+		err = table.validate()
+		if err != nil {
+			return nil, fmt.Errorf("Rate table %s: %v", code, err)
+		}
+		rater.tables[strings.ToUpper(strings.TrimSpace(code))] = table
+	}
+	if centroids != nil {
+		rater.centroids, err = geocode.NewOffline(centroids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rater, nil
+}
+
+// validate checks the bands of a table are in increasing order and every weight band has a price for each zone
+func (t Table) validate() error {
+	if len(t.Zones) == 0 {
+		return errors.New("at least one zone is required")
+	}
+	if len(t.Rates) == 0 {
+		return errors.New("at least one weight band is required")
+	}
+	if t.DimDivisor < 0 {
+		return errors.New("dim_divisor must not be negative")
+	}
+	last := len(t.Zones) - 1
+	for i, zone := range t.Zones[:last] {
+		if zone.MaxMiles <= 0 || (i > 0 && zone.MaxMiles <= t.Zones[i-1].MaxMiles) {
+			return fmt.Errorf("zone %d needs a max_miles greater than the zone before it", zone.Zone)
+		}
+	}
+	if t.Zones[last].MaxMiles != 0 {
+		return errors.New("the last zone must not have a max_miles")
+	}
+	for i, band := range t.Rates {
+		if band.MaxWeight <= 0 || (i > 0 && band.MaxWeight <= t.Rates[i-1].MaxWeight) {
+			return errors.New("weight bands must be in increasing order of max_weight")
+		}
+		if len(band.Prices) != len(t.Zones) {
+			return fmt.Errorf("weight band %g needs %d prices, got %d", band.MaxWeight, len(t.Zones), len(band.Prices))
+		}
+	}
+	return nil
+}
+
+// Validate checks the package has a weight and no negative dimensions
+func (p Package) Validate() error {
+	if p.Weight <= 0 {
+		return errors.New("Weight must be greater than 0")
+	}
+	if p.Length < 0 || p.Width < 0 || p.Height < 0 {
+		return errors.New("Dimensions must not be negative")
+	}
+	return nil
+}
+
+// HasTable returns true if the carrier has a rate table
+func (r *Rater) HasTable(code string) bool {
+	_, ok := r.tables[strings.ToUpper(code)]
+	return ok
+}
+
+// Locate returns an address's stored coordinates, or its zip code centroid when it was never geocoded
+func (r *Rater) Locate(address models.Address) (geocode.Location, error) {
+	if address.Latitude != 0 || address.Longitude != 0 {
+		return geocode.Location{Latitude: address.Latitude, Longitude: address.Longitude}, nil
+	}
+	return r.LocateZip(address.ZipCode)
+}
+
+// LocateZip returns the centroid of a zip code
+func (r *Rater) LocateZip(zip string) (geocode.Location, error) {
+	if r.centroids == nil {
+		return geocode.Location{}, ErrUnknownZip
+	}
+	location, ok := r.centroids.LookupZip(zip)
+	if !ok {
+		return geocode.Location{}, ErrUnknownZip
+	}
+	return location, nil
+}
+
+// Quote prices a package sent the distance with the carrier. The billable weight is the greater of the actual and dimensional
+// weights, rounded up to the next pound like carriers do
+func (r *Rater) Quote(code string, miles float64, pkg Package) (Quote, error) {
+	table, ok := r.tables[strings.ToUpper(code)]
+	if !ok {
+		return Quote{}, ErrNoRateTable
+	}
+
+	zone := len(table.Zones) - 1
+	for i, band := range table.Zones[:len(table.Zones)-1] {
+		if miles <= band.MaxMiles {
+			zone = i
+			break
+		}
+	}
+
+	weight := pkg.Weight
+	if table.DimDivisor > 0 {
+		weight = math.Max(weight, pkg.Length*pkg.Width*pkg.Height/table.DimDivisor)
+	}
+	weight = math.Ceil(weight)
+	for _, band := range table.Rates {
+		if weight <= band.MaxWeight {
+			return Quote{Zone: table.Zones[zone].Zone, BillableWeight: weight, Price: band.Prices[zone]}, nil
+		}
+	}
+	return Quote{}, ErrTooHeavy
+}
@@ -0,0 +1,641 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/models"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Gorm is the Store backed by Postgres. Its repositories call the model methods
+type Gorm struct {
+	DB *gorm.DB
+}
+
+// NewGorm creates a Store that uses the DB connection
+func NewGorm(db *gorm.DB) *Gorm {
+	return &Gorm{DB: db}
+}
+
+// Users returns the users repository
+func (g *Gorm) Users() Users { return gormUsers{g.DB} }
+
+// APIUsers returns the API users repository
+func (g *Gorm) APIUsers() APIUsers { return gormAPIUsers{g.DB} }
+
+// Addresses returns the addresses repository
+func (g *Gorm) Addresses() Addresses { return gormAddresses{g.DB} }
+
+// Assignments returns the address assignments repository
+func (g *Gorm) Assignments() Assignments { return gormAssignments{g.DB} }
+
+// Contacts returns the contacts repository
+func (g *Gorm) Contacts() Contacts { return gormContacts{g.DB} }
+
+// Packages returns the packages repository
+func (g *Gorm) Packages() Packages { return gormPackages{g.DB} }
+
+// Sessions returns the sessions repository
+func (g *Gorm) Sessions() Sessions { return gormSessions{g.DB} }
+
+// Carriers returns the carrier registry
+func (g *Gorm) Carriers() Carriers { return gormCarriers{g.DB} }
+
+// Disclosures returns the address disclosure log
+func (g *Gorm) Disclosures() Disclosures { return gormDisclosures{g.DB} }
+
+// Grants returns the address sharing grants repository
+func (g *Gorm) Grants() Grants { return gormGrants{g.DB} }
+
+// DeliveryTokens returns the delivery tokens repository
+func (g *Gorm) DeliveryTokens() DeliveryTokens { return gormDeliveryTokens{g.DB} }
+
+// Webhooks returns the webhooks repository
+func (g *Gorm) Webhooks() Webhooks { return gormWebhooks{g.DB} }
+
+// MailingJobs returns the mailing jobs repository
+func (g *Gorm) MailingJobs() MailingJobs { return gormMailingJobs{g.DB} }
+
+// Orders returns the retailer orders repository
+func (g *Gorm) Orders() Orders { return gormOrders{g.DB} }
+
+// DeliveryFailures returns the delivery failure reports repository
+func (g *Gorm) DeliveryFailures() DeliveryFailures { return gormDeliveryFailures{g.DB} }
+
+// Transaction runs fn inside a DB transaction
+func (g *Gorm) Transaction(fn func(store Store) error) error {
+	return models.Transaction(g.DB, func(tx *gorm.DB) error {
+		return fn(NewGorm(tx))
+	})
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r gormUsers) Save(user *models.User) (*models.User, error) {
+	return user.SaveUser(r.db)
+}
+
+func (r gormUsers) FindAll() (*[]models.User, error) {
+	user := models.User{}
+	return user.FindAllUsers(r.db)
+}
+
+func (r gormUsers) FindByID(uid uuid.UUID) (*models.User, error) {
+	user := &models.User{}
+	return user.FindUserByID(r.db, uid)
+}
+
+func (r gormUsers) FindBySmartID(smartID string) (*models.User, error) {
+	user := models.User{}
+	err := r.db.Debug().Model(models.User{}).Where("smart_id = ?", smartID).Take(&user).Error
+	if err != nil {
+		return &models.User{}, err
+	}
+	return &user, nil
+}
+
+func (r gormUsers) FindBySmartIDs(smartIDs []string) (*[]models.User, error) {
+	users := []models.User{}
+	if len(smartIDs) == 0 {
+		return &users, nil
+	}
+	err := r.db.Debug().Model(models.User{}).Where("smart_id IN (?)", smartIDs).Find(&users).Error
+	if err != nil {
+		return &[]models.User{}, err
+	}
+	return &users, nil
+}
+
+func (r gormUsers) FindByEmail(email string) (*models.User, error) {
+	user := models.User{}
+	err := r.db.Debug().Model(models.User{}).Where("email = ?", email).Take(&user).Error
+	if err != nil {
+		return &models.User{}, err
+	}
+	return &user, nil
+}
+
+func (r gormUsers) FindContact(smartID string, email string, phone string) (*models.User, error) {
+	user := models.User{}
+	query := r.db.Debug().Model(models.User{}).Where("smart_id = ?", smartID)
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	if phone != "" {
+		query = query.Where("phone = ?", phone)
+	}
+	err := query.Take(&user).Error
+	if err != nil {
+		return &models.User{}, err
+	}
+	return &user, nil
+}
+
+func (r gormUsers) Update(user *models.User, uid uuid.UUID) (*models.User, error) {
+	return user.UpdateAUser(r.db, uid)
+}
+
+func (r gormUsers) UpdateBasic(user *models.User, uid uuid.UUID) (*models.User, error) {
+	return user.UpdateAUserBasic(r.db, uid)
+}
+
+func (r gormUsers) UpdateRetailer(user *models.User, uid uuid.UUID) (*models.User, error) {
+	return user.UpdateRetailer(r.db, uid)
+}
+
+func (r gormUsers) Delete(uid uuid.UUID) (int64, error) {
+	user := models.User{}
+	return user.DeleteUser(r.db, uid)
+}
+
+type gormAPIUsers struct {
+	db *gorm.DB
+}
+
+func (r gormAPIUsers) Save(aUser *models.APIUser) (*models.APIUser, error) {
+	return aUser.SaveAPIUser(r.db)
+}
+
+func (r gormAPIUsers) FindAll() (*[]models.APIUser, error) {
+	aUser := models.APIUser{}
+	return aUser.FindAllAPIUsers(r.db)
+}
+
+func (r gormAPIUsers) FindByID(uid uuid.UUID) (*models.APIUser, error) {
+	aUser := &models.APIUser{}
+	return aUser.FindAPIUserByID(r.db, uid)
+}
+
+func (r gormAPIUsers) FindByUsername(username string) (*models.APIUser, error) {
+	aUser := models.APIUser{}
+	err := r.db.Debug().Model(models.APIUser{}).Where("username = ?", username).Take(&aUser).Error
+	if err != nil {
+		return &models.APIUser{}, err
+	}
+	return &aUser, nil
+}
+
+func (r gormAPIUsers) FindForUser(uid uuid.UUID) (*[]models.APIUser, error) {
+	aUser := models.APIUser{}
+	return aUser.FindAPIUsersForUser(r.db, uid)
+}
+
+func (r gormAPIUsers) Update(aUser *models.APIUser, uid uuid.UUID) (*models.APIUser, error) {
+	return aUser.UpdateAPIUser(r.db, uid)
+}
+
+func (r gormAPIUsers) Delete(uid uuid.UUID) (int64, error) {
+	aUser := models.APIUser{}
+	return aUser.DeleteAPIUser(r.db, uid)
+}
+
+type gormAddresses struct {
+	db *gorm.DB
+}
+
+func (r gormAddresses) Save(address *models.Address) (*models.Address, error) {
+	return address.SaveAddress(r.db)
+}
+
+func (r gormAddresses) FindByID(aid uint64) (*models.Address, error) {
+	address := &models.Address{}
+	return address.FindAddressByID(r.db, aid)
+}
+
+func (r gormAddresses) Update(address *models.Address, aid uint64) error {
+	return address.Update(r.db, aid)
+}
+
+type gormAssignments struct {
+	db *gorm.DB
+}
+
+func (r gormAssignments) Save(aa *models.AddressAssignment) (*models.AddressAssignment, error) {
+	return aa.SaveAddressAssignment(r.db)
+}
+
+func (r gormAssignments) FindByID(aaid uint64) (*models.AddressAssignment, error) {
+	aa := models.AddressAssignment{}
+	err := r.db.Debug().Model(models.AddressAssignment{}).Where("id = ?", aaid).Take(&aa).Error
+	if err != nil {
+		return &models.AddressAssignment{}, err
+	}
+	return &aa, nil
+}
+
+func (r gormAssignments) Update(aa *models.AddressAssignment, originalStart time.Time) error {
+	return aa.UpdateAddress(r.db, aa.ID, originalStart)
+}
+
+func (r gormAssignments) Delete(aa *models.AddressAssignment) error {
+	return aa.DeleteAddress(r.db, aa.ID)
+}
+
+func (r gormAssignments) Expire(now time.Time) (int64, error) {
+	return models.ExpireAddressAssignments(r.db, now)
+}
+
+func (r gormAssignments) FindTransitions(aaid uint64) (*[]models.AddressStatusTransition, error) {
+	ast := models.AddressStatusTransition{}
+	return ast.FindTransitionsForAddressAssignment(r.db, aaid)
+}
+
+func (r gormAssignments) FindMailingAddress(user models.User, targetDate time.Time) (*models.AddressAssignment, error) {
+	aa := models.AddressAssignment{}
+	return aa.FindMailingAddressWithSmartID(r.db, user, targetDate)
+}
+
+func (r gormAssignments) FindPackageAddress(user models.User, targetDate time.Time) (*models.AddressAssignment, error) {
+	aa := models.AddressAssignment{}
+	return aa.FindPackageAddressWithSmartID(r.db, user, targetDate)
+}
+
+func (r gormAssignments) FindAllActiveForUser(uid uuid.UUID) (*[]models.AddressAssignment, error) {
+	aa := models.AddressAssignment{}
+	return aa.FindAllActiveAddressesForUser(r.db, uid)
+}
+
+func (r gormAssignments) FindForUsers(uids []uuid.UUID, from time.Time, to time.Time) (*[]models.AddressAssignment, error) {
+	return models.FindAddressAssignmentsForUsers(r.db, uids, from, to)
+}
+
+type gormContacts struct {
+	db *gorm.DB
+}
+
+func (r gormContacts) SaveBoth(userID uuid.UUID, contactID uuid.UUID) error {
+	contact := models.Contact{}
+	return contact.SaveContacts(r.db, userID, contactID)
+}
+
+func (r gormContacts) Save(userID uuid.UUID, contactID uuid.UUID) (models.Contact, error) {
+	contact := models.Contact{}
+	return contact.SaveContact(r.db, userID, contactID)
+}
+
+func (r gormContacts) FindForUser(userID uuid.UUID, limit int64, offset int64, sortPref string) (int64, []models.Contact, error) {
+	return models.GetContacts(r.db, userID, limit, offset, sortPref)
+}
+
+func (r gormContacts) Search(userID uuid.UUID, limit int64, offset int64, search string) (int64, []models.Contact, error) {
+	return models.SearchContacts(r.db, userID, limit, offset, search)
+}
+
+type gormPackages struct {
+	db *gorm.DB
+}
+
+func (r gormPackages) Save(p *models.Package) error {
+	return p.SavePackage(r.db)
+}
+
+func (r gormPackages) Update(uid uuid.UUID, tracking string, delivered bool, deliveredOn null.Time, estimatedDelivery null.Time) (*models.Package, error) {
+	p := models.Package{}
+	return p.UpdatePackage(r.db, uid, tracking, delivered, deliveredOn, estimatedDelivery)
+}
+
+func (r gormPackages) FindByTrackingForUser(uid uuid.UUID, tracking string) (*models.Package, error) {
+	p := &models.Package{}
+	return p.FindPackageByTrackingForUser(r.db, uid, tracking)
+}
+
+func (r gormPackages) FindByTrackingAndCarrier(carrierID uuid.UUID, tracking string) (*models.Package, error) {
+	p := &models.Package{}
+	return p.FindPackageByTrackingAndCarrier(r.db, carrierID, tracking)
+}
+
+func (r gormPackages) FindByTrackingAndShipper(senderID uuid.UUID, tracking string) (*models.Package, error) {
+	p := &models.Package{}
+	return p.FindPackageByTrackingAndShipper(r.db, senderID, tracking)
+}
+
+func (r gormPackages) FindAllOpenForUser(uid uuid.UUID) (*[]models.Package, error) {
+	p := models.Package{}
+	return p.FindAllOpenPackagesForUser(r.db, uid)
+}
+
+func (r gormPackages) FindPreviewForUser(uid uuid.UUID) (*[]models.Package, *[]models.Package, error) {
+	p := models.Package{}
+	return p.FindAllPackagesForUser(r.db, uid)
+}
+
+func (r gormPackages) FindForUser(userID uuid.UUID, packageType string, limit int64, offset int64, search null.String) (int64, []models.Package, error) {
+	p := models.Package{}
+	return p.FindPackagesForUser(r.db, userID, packageType, limit, offset, search)
+}
+
+func (r gormPackages) SetDescription(packageID uint64, packageDescriptionID uint64) error {
+	p := models.Package{}
+	return p.SetPackageDescription(r.db, packageID, packageDescriptionID)
+}
+
+func (r gormPackages) SaveDescription(pd *models.PackageDescription) (*models.PackageDescription, error) {
+	return pd.SavePackageDescription(r.db)
+}
+
+func (r gormPackages) UpdateDescription(pdid uint64, contents null.String, orderLink null.String, orderImage null.String) (*models.PackageDescription, error) {
+	pd := &models.PackageDescription{ID: pdid, Contents: contents, OrderLink: orderLink, OrderImage: orderImage}
+	return pd.UpdatePackageDescription(r.db, pdid, contents, orderLink, orderImage)
+}
+
+func (r gormPackages) SaveEvent(pe *models.PackageEvent) (*models.PackageEvent, error) {
+	return pe.SavePackageEvent(r.db)
+}
+
+func (r gormPackages) FindEvents(packageID uint64) (*[]models.PackageEvent, error) {
+	pe := models.PackageEvent{}
+	return pe.FindEventsForPackage(r.db, packageID)
+}
+
+func (r gormPackages) FlagReroutes(aa *models.AddressAssignment) (int64, error) {
+	return models.FlagPackageReroutes(r.db, aa)
+}
+
+func (r gormPackages) FindReroutes(carrierID uuid.UUID) ([]models.PackageReroute, error) {
+	return models.FindPackageReroutes(r.db, carrierID)
+}
+
+func (r gormPackages) AcknowledgeReroute(carrierID uuid.UUID, tracking string) error {
+	return models.AcknowledgePackageReroute(r.db, carrierID, tracking)
+}
+
+type gormSessions struct {
+	db *gorm.DB
+}
+
+func (r gormSessions) Save(session *models.Session) (*models.Session, error) {
+	return session.SaveSession(r.db)
+}
+
+func (r gormSessions) FindByID(sid uuid.UUID) (*models.Session, error) {
+	session := &models.Session{}
+	return session.FindSessionByID(r.db, sid)
+}
+
+func (r gormSessions) Revoke(sid uuid.UUID) (int64, error) {
+	session := models.Session{}
+	return session.RevokeSession(r.db, sid)
+}
+
+func (r gormSessions) RevokeAllForUser(uid uuid.UUID) (int64, error) {
+	session := models.Session{}
+	return session.RevokeAllSessionsForUser(r.db, uid)
+}
+
+func (r gormSessions) IsRevoked(jti string) bool {
+	return models.SessionRevocationStore{DB: r.db}.IsRevoked(jti)
+}
+
+type gormCarriers struct {
+	db *gorm.DB
+}
+
+func (r gormCarriers) Save(carrier *models.Carrier) (*models.Carrier, error) {
+	return carrier.SaveCarrier(r.db)
+}
+
+func (r gormCarriers) FindAll() (*[]models.Carrier, error) {
+	carrier := models.Carrier{}
+	return carrier.FindAllCarriers(r.db)
+}
+
+func (r gormCarriers) FindByID(cid uint64) (*models.Carrier, error) {
+	carrier := &models.Carrier{}
+	return carrier.FindCarrierByID(r.db, cid)
+}
+
+func (r gormCarriers) FindByCode(code string) (*models.Carrier, error) {
+	carrier := &models.Carrier{}
+	return carrier.FindCarrierByCode(r.db, code)
+}
+
+func (r gormCarriers) FindByAPIUserID(apiUserID uuid.UUID) (*models.Carrier, error) {
+	carrier := &models.Carrier{}
+	return carrier.FindCarrierByAPIUserID(r.db, apiUserID)
+}
+
+func (r gormCarriers) Update(carrier *models.Carrier, cid uint64) (*models.Carrier, error) {
+	return carrier.UpdateCarrier(r.db, cid)
+}
+
+func (r gormCarriers) Delete(cid uint64) (int64, error) {
+	carrier := models.Carrier{}
+	return carrier.DeleteCarrier(r.db, cid)
+}
+
+func (r gormCarriers) Registry() (models.CarrierRegistry, error) {
+	return models.LoadCarrierRegistry(r.db)
+}
+
+type gormDisclosures struct {
+	db *gorm.DB
+}
+
+func (r gormDisclosures) Save(ad *models.AddressDisclosure) (*models.AddressDisclosure, error) {
+	return ad.SaveAddressDisclosure(r.db)
+}
+
+func (r gormDisclosures) SaveAll(disclosures []models.AddressDisclosure) error {
+	return models.SaveAddressDisclosures(r.db, disclosures)
+}
+
+func (r gormDisclosures) Find(filter models.DisclosureFilter, limit int64, offset int64) (int64, []models.AddressDisclosure, error) {
+	return models.FindAddressDisclosures(r.db, filter, limit, offset)
+}
+
+type gormGrants struct {
+	db *gorm.DB
+}
+
+func (r gormGrants) Save(grant *models.ShareGrant) (*models.ShareGrant, error) {
+	return grant.SaveShareGrant(r.db)
+}
+
+func (r gormGrants) FindForUser(uid uuid.UUID, pending bool) (*[]models.ShareGrant, error) {
+	grant := models.ShareGrant{}
+	return grant.FindShareGrantsForUser(r.db, uid, pending)
+}
+
+func (r gormGrants) Request(uid uuid.UUID, apiUserID uuid.UUID) (*models.ShareGrant, error) {
+	grant := &models.ShareGrant{}
+	return grant.RequestShareGrant(r.db, uid, apiUserID)
+}
+
+func (r gormGrants) RequestAll(uids []uuid.UUID, apiUserID uuid.UUID) (map[uuid.UUID]models.ShareGrant, error) {
+	return models.RequestShareGrants(r.db, uids, apiUserID)
+}
+
+func (r gormGrants) Delete(uid uuid.UUID, gid uint64) (int64, error) {
+	grant := models.ShareGrant{}
+	return grant.DeleteShareGrant(r.db, uid, gid)
+}
+
+type gormDeliveryTokens struct {
+	db *gorm.DB
+}
+
+func (r gormDeliveryTokens) Save(dt *models.DeliveryToken) (*models.DeliveryToken, error) {
+	return dt.SaveDeliveryToken(r.db)
+}
+
+func (r gormDeliveryTokens) FindForUser(uid uuid.UUID) (*[]models.DeliveryToken, error) {
+	dt := models.DeliveryToken{}
+	return dt.FindDeliveryTokensForUser(r.db, uid)
+}
+
+func (r gormDeliveryTokens) Find(token string, apiUserID uuid.UUID, kind models.DeliveryKind) (*models.DeliveryToken, error) {
+	dt := &models.DeliveryToken{}
+	return dt.FindUsableDeliveryToken(r.db, token, apiUserID, kind)
+}
+
+func (r gormDeliveryTokens) Use(token string, apiUserID uuid.UUID, kind models.DeliveryKind) (*models.DeliveryToken, error) {
+	dt := &models.DeliveryToken{}
+	return dt.UseDeliveryToken(r.db, token, apiUserID, kind)
+}
+
+func (r gormDeliveryTokens) Revoke(uid uuid.UUID, dtid uint64) error {
+	dt := models.DeliveryToken{}
+	return dt.RevokeDeliveryToken(r.db, uid, dtid)
+}
+
+type gormWebhooks struct {
+	db *gorm.DB
+}
+
+func (r gormWebhooks) Save(webhook *models.Webhook) (*models.Webhook, error) {
+	return webhook.SaveWebhook(r.db)
+}
+
+func (r gormWebhooks) FindForAPIUser(apiUserID uuid.UUID) (*[]models.Webhook, error) {
+	webhook := models.Webhook{}
+	return webhook.FindWebhooksForAPIUser(r.db, apiUserID)
+}
+
+func (r gormWebhooks) Delete(apiUserID uuid.UUID, wid uint64) (int64, error) {
+	webhook := models.Webhook{}
+	return webhook.DeleteWebhook(r.db, apiUserID, wid)
+}
+
+func (r gormWebhooks) SaveDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	return delivery.SaveWebhookDelivery(r.db)
+}
+
+func (r gormWebhooks) ClaimDue(now time.Time, lease time.Duration, limit int64) (*[]models.WebhookDelivery, error) {
+	return models.ClaimDueWebhookDeliveries(r.db, now, lease, limit)
+}
+
+func (r gormWebhooks) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	return delivery.UpdateWebhookDelivery(r.db)
+}
+
+func (r gormWebhooks) FindDead(apiUserID uuid.UUID) (*[]models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{}
+	return delivery.FindDeadWebhookDeliveries(r.db, apiUserID)
+}
+
+func (r gormWebhooks) Redeliver(apiUserID uuid.UUID, did uint64) error {
+	delivery := models.WebhookDelivery{}
+	return delivery.RedeliverWebhookDelivery(r.db, apiUserID, did)
+}
+
+type gormMailingJobs struct {
+	db *gorm.DB
+}
+
+func (r gormMailingJobs) Save(job *models.MailingJob) (*models.MailingJob, error) {
+	return job.SaveMailingJob(r.db)
+}
+
+func (r gormMailingJobs) SaveRows(rows []models.MailingJobRow) error {
+	return models.SaveMailingJobRows(r.db, rows)
+}
+
+func (r gormMailingJobs) FindByID(apiUserID uuid.UUID, jid uuid.UUID) (*models.MailingJob, error) {
+	job := models.MailingJob{}
+	return job.FindMailingJob(r.db, apiUserID, jid)
+}
+
+func (r gormMailingJobs) Cancel(apiUserID uuid.UUID, jid uuid.UUID, now time.Time) error {
+	return models.CancelMailingJob(r.db, apiUserID, jid, now)
+}
+
+func (r gormMailingJobs) Claim(now time.Time, lease time.Duration) (*models.MailingJob, error) {
+	return models.ClaimMailingJob(r.db, now, lease)
+}
+
+func (r gormMailingJobs) FindPendingRows(jid uuid.UUID, limit int64) (*[]models.MailingJobRow, error) {
+	return models.FindPendingMailingJobRows(r.db, jid, limit)
+}
+
+func (r gormMailingJobs) SaveResults(rows []models.MailingJobRow) error {
+	return models.SaveMailingJobResults(r.db, rows)
+}
+
+func (r gormMailingJobs) UpdateProgress(job *models.MailingJob, processed int, failed int, claimedUntil time.Time) error {
+	return job.UpdateMailingJobProgress(r.db, processed, failed, claimedUntil)
+}
+
+func (r gormMailingJobs) Finish(job *models.MailingJob) error {
+	return job.FinishMailingJob(r.db)
+}
+
+func (r gormMailingJobs) FindRows(jid uuid.UUID, limit int64, offset int64) (*[]models.MailingJobRow, error) {
+	return models.FindMailingJobRows(r.db, jid, limit, offset)
+}
+
+func (r gormMailingJobs) DeleteExpired(now time.Time) (int64, error) {
+	return models.DeleteExpiredMailingJobs(r.db, now)
+}
+
+type gormOrders struct {
+	db *gorm.DB
+}
+
+func (r gormOrders) Save(order *models.Order) (*models.Order, error) {
+	return order.SaveOrder(r.db)
+}
+
+func (r gormOrders) FindByID(oid uuid.UUID) (*models.Order, error) {
+	order := models.Order{}
+	return order.FindOrderByID(r.db, oid)
+}
+
+func (r gormOrders) HandOff(order *models.Order) error {
+	return order.HandOffOrder(r.db)
+}
+
+func (r gormOrders) Ship(order *models.Order) error {
+	return order.ShipOrder(r.db)
+}
+
+type gormDeliveryFailures struct {
+	db *gorm.DB
+}
+
+func (r gormDeliveryFailures) Save(failure *models.DeliveryFailure) (*models.DeliveryFailure, error) {
+	return failure.SaveDeliveryFailure(r.db)
+}
+
+func (r gormDeliveryFailures) FindForAssignment(aaid uint64, since time.Time) (*[]models.DeliveryFailure, error) {
+	return models.FindDeliveryFailuresForAssignment(r.db, aaid, since)
+}
+
+func (r gormDeliveryFailures) FindForUser(uid uuid.UUID, unseen bool) (*[]models.DeliveryFailure, error) {
+	return models.FindDeliveryFailuresForUser(r.db, uid, unseen)
+}
+
+func (r gormDeliveryFailures) MarkSeen(uid uuid.UUID, now time.Time) (int64, error) {
+	return models.MarkDeliveryFailuresSeen(r.db, uid, now)
+}
+
+func (r gormDeliveryFailures) FindUnreviewed(since time.Time) (*[]models.DeliveryFailure, error) {
+	return models.FindUnreviewedDeliveryFailures(r.db, since)
+}
+
+func (r gormDeliveryFailures) Review(aaid uint64, decision models.ReviewDecision, reviewerID uuid.UUID, now time.Time) (int64, error) {
+	return models.ReviewDeliveryFailures(r.db, aaid, decision, reviewerID, now)
+}
@@ -0,0 +1,2365 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Memory is a Store that keeps every record in memory. It follows the same rules as the model methods and lets the handlers run without Postgres.
+// A failed transaction restores the records as they were when it began, transactions are run one at a time
+type Memory struct {
+	mu    sync.Mutex
+	txMu  sync.Mutex
+	state *memoryState
+}
+
+// memoryState is every table. Records are kept in the order they were created, like a table without an ORDER BY
+type memoryState struct {
+	ids          map[string]uint64
+	users        []models.User
+	apiUsers     []models.APIUser
+	addresses    []models.Address
+	assignments  []models.AddressAssignment
+	transitions  []models.AddressStatusTransition
+	contacts     []models.Contact
+	packages     []models.Package
+	descriptions []models.PackageDescription
+	events       []models.PackageEvent
+	sessions     []models.Session
+	carriers     []models.Carrier
+	disclosures  []models.AddressDisclosure
+	grants       []models.ShareGrant
+	tokens       []models.DeliveryToken
+	webhooks     []models.Webhook
+	deliveries   []models.WebhookDelivery
+	mailingJobs  []models.MailingJob
+	mailingRows  []models.MailingJobRow
+	orders       []models.Order
+	failures     []models.DeliveryFailure
+}
+
+// NewMemory creates an empty Store
+func NewMemory() *Memory {
+	return &Memory{state: &memoryState{ids: map[string]uint64{}}}
+}
+
+// nextID returns the next value of a table's ID sequence
+func (s *memoryState) nextID(table string) uint64 {
+	s.ids[table]++
+	return s.ids[table]
+}
+
+func (s *memoryState) clone() *memoryState {
+	ids := map[string]uint64{}
+	for table, id := range s.ids {
+		ids[table] = id
+	}
+	return &memoryState{
+		ids:          ids,
+		users:        append([]models.User{}, s.users...),
+		apiUsers:     append([]models.APIUser{}, s.apiUsers...),
+		addresses:    append([]models.Address{}, s.addresses...),
+		assignments:  append([]models.AddressAssignment{}, s.assignments...),
+		transitions:  append([]models.AddressStatusTransition{}, s.transitions...),
+		contacts:     append([]models.Contact{}, s.contacts...),
+		packages:     append([]models.Package{}, s.packages...),
+		descriptions: append([]models.PackageDescription{}, s.descriptions...),
+		events:       append([]models.PackageEvent{}, s.events...),
+		sessions:     append([]models.Session{}, s.sessions...),
+		carriers:     append([]models.Carrier{}, s.carriers...),
+		disclosures:  append([]models.AddressDisclosure{}, s.disclosures...),
+		grants:       append([]models.ShareGrant{}, s.grants...),
+		tokens:       append([]models.DeliveryToken{}, s.tokens...),
+		webhooks:     append([]models.Webhook{}, s.webhooks...),
+		deliveries:   append([]models.WebhookDelivery{}, s.deliveries...),
+		mailingJobs:  append([]models.MailingJob{}, s.mailingJobs...),
+		mailingRows:  append([]models.MailingJobRow{}, s.mailingRows...),
+		orders:       append([]models.Order{}, s.orders...),
+		failures:     append([]models.DeliveryFailure{}, s.failures...),
+	}
+}
+
+// duplicateKey is the error Postgres returns when a unique constraint is violated
+func duplicateKey(constraint string) error {
+	return fmt.Errorf("pq: duplicate key value violates unique constraint \"%s\"", constraint)
+}
+
+// Users returns the users repository
+func (m *Memory) Users() Users { return memoryUsers{m} }
+
+// APIUsers returns the API users repository
+func (m *Memory) APIUsers() APIUsers { return memoryAPIUsers{m} }
+
+// Addresses returns the addresses repository
+func (m *Memory) Addresses() Addresses { return memoryAddresses{m} }
+
+// Assignments returns the address assignments repository
+func (m *Memory) Assignments() Assignments { return memoryAssignments{m} }
+
+// Contacts returns the contacts repository
+func (m *Memory) Contacts() Contacts { return memoryContacts{m} }
+
+// Packages returns the packages repository
+func (m *Memory) Packages() Packages { return memoryPackages{m} }
+
+// Sessions returns the sessions repository
+func (m *Memory) Sessions() Sessions { return memorySessions{m} }
+
+// Carriers returns the carrier registry
+func (m *Memory) Carriers() Carriers { return memoryCarriers{m} }
+
+// Disclosures returns the address disclosure log
+func (m *Memory) Disclosures() Disclosures { return memoryDisclosures{m} }
+
+// Grants returns the address sharing grants repository
+func (m *Memory) Grants() Grants { return memoryGrants{m} }
+
+// DeliveryTokens returns the delivery tokens repository
+func (m *Memory) DeliveryTokens() DeliveryTokens { return memoryDeliveryTokens{m} }
+
+// Webhooks returns the webhooks repository
+func (m *Memory) Webhooks() Webhooks { return memoryWebhooks{m} }
+
+// MailingJobs returns the mailing jobs repository
+func (m *Memory) MailingJobs() MailingJobs { return memoryMailingJobs{m} }
+
+// Orders returns the retailer orders repository
+func (m *Memory) Orders() Orders { return memoryOrders{m} }
+
+// DeliveryFailures returns the delivery failure reports repository
+func (m *Memory) DeliveryFailures() DeliveryFailures { return memoryDeliveryFailures{m} }
+
+// Transaction runs fn and restores every record if it returns an error or panics
+func (m *Memory) Transaction(fn func(store Store) error) (err error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.Lock()
+	snapshot := m.state.clone()
+	m.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			m.restore(snapshot)
+			panic(r)
+		}
+	}()
+
+	err = fn(memoryTx{m})
+	if err != nil {
+		m.restore(snapshot)
+	}
+	return err
+}
+
+func (m *Memory) restore(snapshot *memoryState) {
+	m.mu.Lock()
+	m.state = snapshot
+	m.mu.Unlock()
+}
+
+// memoryTx is the store handed to a transaction. Nested transactions are part of the one they are run in
+type memoryTx struct {
+	*Memory
+}
+
+func (tx memoryTx) Transaction(fn func(store Store) error) error {
+	return fn(tx)
+}
+
+// user returns a copy of the user with the ID
+func (s *memoryState) user(uid uuid.UUID) (models.User, bool) {
+	for _, user := range s.users {
+		if user.ID == uid {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
+
+func (s *memoryState) apiUser(uid uuid.UUID) (models.APIUser, bool) {
+	for _, aUser := range s.apiUsers {
+		if aUser.ID == uid {
+			return aUser, true
+		}
+	}
+	return models.APIUser{}, false
+}
+
+func (s *memoryState) address(aid uint64) (models.Address, bool) {
+	for _, address := range s.addresses {
+		if address.ID == aid {
+			return address, true
+		}
+	}
+	return models.Address{}, false
+}
+
+func (s *memoryState) description(pdid uint64) (models.PackageDescription, bool) {
+	for _, pd := range s.descriptions {
+		if pd.ID == pdid {
+			return pd, true
+		}
+	}
+	return models.PackageDescription{}, false
+}
+
+func (s *memoryState) assignment(aaid uint64) (models.AddressAssignment, bool) {
+	for _, aa := range s.assignments {
+		if aa.ID == aaid {
+			return s.loadAssignment(aa), true
+		}
+	}
+	return models.AddressAssignment{}, false
+}
+
+func (s *memoryState) carrier(cid uint64) (models.Carrier, bool) {
+	for _, carrier := range s.carriers {
+		if carrier.ID == cid {
+			return carrier, true
+		}
+	}
+	return models.Carrier{}, false
+}
+
+func (s *memoryState) webhook(wid uint64) (models.Webhook, bool) {
+	for _, webhook := range s.webhooks {
+		if webhook.ID == wid {
+			return webhook, true
+		}
+	}
+	return models.Webhook{}, false
+}
+
+func (s *memoryState) mailingJob(jid uuid.UUID) (models.MailingJob, bool) {
+	for _, job := range s.mailingJobs {
+		if job.ID == jid {
+			return job, true
+		}
+	}
+	return models.MailingJob{}, false
+}
+
+// loadAssignment fills in an assignment's user and address, like gorm:auto_preload
+func (s *memoryState) loadAssignment(aa models.AddressAssignment) models.AddressAssignment {
+	aa.User, _ = s.user(aa.UserID)
+	aa.Address, _ = s.address(aa.AddressID)
+	return aa
+}
+
+// eventsFor returns a package's events in the order they were saved
+func (s *memoryState) eventsFor(packageID uint64) []models.PackageEvent {
+	events := []models.PackageEvent{}
+	for _, event := range s.events {
+		if event.PackageID == packageID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// loadPackage fills in every association of a package, like gorm:auto_preload
+func (s *memoryState) loadPackage(p models.Package) models.Package {
+	p.MailCarrier, _ = s.apiUser(p.MailCarrierID)
+	if p.SenderID.Valid {
+		p.Sender, _ = s.user(p.SenderID.UUID)
+	}
+	if p.RecipientID.Valid {
+		p.Recipient, _ = s.user(p.RecipientID.UUID)
+	}
+	p.PackageDescription, _ = s.description(p.PackageDescriptionID)
+	p.Events = s.eventsFor(p.ID)
+	return p
+}
+
+type memoryUsers struct {
+	m *Memory
+}
+
+func (r memoryUsers) Save(user *models.User) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+
+	var err error
+	if !user.LegacySmartID {
+		user.SmartID, err = r.generateSmartID()
+		if err != nil {
+			return &models.User{}, err
+		}
+	}
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return &models.User{}, duplicateKey("users_email_key")
+		}
+		if existing.SmartID == user.SmartID {
+			return &models.User{}, duplicateKey("users_smart_id_key")
+		}
+	}
+	err = user.BeforeSave()
+	if err != nil {
+		return &models.User{}, err
+	}
+	user.ID = uuid.NewV4()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = time.Now()
+	}
+	s.users = append(s.users, *user)
+	return user, nil
+}
+
+// generateSmartID issues a SmartID that is not assigned to a user, like models.GenerateSmartID
+func (r memoryUsers) generateSmartID() (string, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		newSmartID, err := smartid.Generate()
+		if err != nil {
+			return "", err
+		}
+		taken := false
+		for _, existing := range r.m.state.users {
+			taken = taken || existing.SmartID == newSmartID
+		}
+		if !taken {
+			return newSmartID, nil
+		}
+	}
+	return "", errors.New("Unable to generate a unique smartID")
+}
+
+func (r memoryUsers) FindAll() (*[]models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	users := []models.User{}
+	for _, user := range r.m.state.users {
+		if len(users) == 100 {
+			break
+		}
+		users = append(users, user)
+	}
+	return &users, nil
+}
+
+func (r memoryUsers) FindByID(uid uuid.UUID) (*models.User, error) {
+	return r.find(func(user models.User) bool { return user.ID == uid })
+}
+
+func (r memoryUsers) FindBySmartID(smartID string) (*models.User, error) {
+	return r.find(func(user models.User) bool { return user.SmartID == smartID })
+}
+
+func (r memoryUsers) FindBySmartIDs(smartIDs []string) (*[]models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	wanted := map[string]bool{}
+	for _, smartID := range smartIDs {
+		wanted[smartID] = true
+	}
+	users := []models.User{}
+	for _, user := range r.m.state.users {
+		if wanted[user.SmartID] {
+			users = append(users, user)
+		}
+	}
+	return &users, nil
+}
+
+func (r memoryUsers) FindByEmail(email string) (*models.User, error) {
+	return r.find(func(user models.User) bool { return user.Email == email })
+}
+
+func (r memoryUsers) FindContact(smartID string, email string, phone string) (*models.User, error) {
+	return r.find(func(user models.User) bool {
+		return user.SmartID == smartID && (email == "" || user.Email == email) && (phone == "" || user.Phone == phone)
+	})
+}
+
+func (r memoryUsers) find(match func(user models.User) bool) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, user := range r.m.state.users {
+		if match(user) {
+			return &user, nil
+		}
+	}
+	return &models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) Update(user *models.User, uid uuid.UUID) (*models.User, error) {
+	err := user.BeforeSave()
+	if err != nil {
+		return &models.User{}, err
+	}
+	return r.update(uid, func(existing *models.User) {
+		existing.Password = user.Password
+		existing.FirstName = user.FirstName
+		existing.LastName = user.LastName
+		existing.Phone = user.Phone
+		existing.Email = user.Email
+		existing.LargeLogo = user.LargeLogo
+		existing.SmallLogo = user.SmallLogo
+		existing.RedirectURL = user.RedirectURL
+	})
+}
+
+func (r memoryUsers) UpdateBasic(user *models.User, uid uuid.UUID) (*models.User, error) {
+	return r.update(uid, func(existing *models.User) {
+		existing.FirstName = user.FirstName
+		existing.LastName = user.LastName
+		existing.Phone = user.Phone
+		existing.Email = user.Email
+	})
+}
+
+func (r memoryUsers) UpdateRetailer(user *models.User, uid uuid.UUID) (*models.User, error) {
+	return r.update(uid, func(existing *models.User) {
+		existing.Authority = models.RetailerAuth
+		existing.SmallLogo = user.SmallLogo
+		existing.LargeLogo = user.LargeLogo
+		existing.RedirectURL = user.RedirectURL
+	})
+}
+
+func (r memoryUsers) update(uid uuid.UUID, apply func(existing *models.User)) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.users {
+		if s.users[i].ID != uid {
+			continue
+		}
+		updated := s.users[i]
+		apply(&updated)
+		for _, existing := range s.users {
+			if existing.ID != uid && existing.Email == updated.Email {
+				return &models.User{}, duplicateKey("users_email_key")
+			}
+		}
+		updated.UpdatedAt = time.Now()
+		s.users[i] = updated
+		return &updated, nil
+	}
+	return &models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) Delete(uid uuid.UUID) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.users {
+		if s.users[i].ID == uid {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+type memoryAPIUsers struct {
+	m *Memory
+}
+
+func (r memoryAPIUsers) Save(aUser *models.APIUser) (*models.APIUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+
+	for _, existing := range s.apiUsers {
+		if existing.Username == aUser.Username {
+			return &models.APIUser{}, duplicateKey("api_users_username_key")
+		}
+		if existing.Email == aUser.Email {
+			return &models.APIUser{}, duplicateKey("api_users_email_key")
+		}
+	}
+	err := aUser.BeforeSave()
+	if err != nil {
+		return &models.APIUser{}, err
+	}
+	aUser.ID = uuid.NewV4()
+	if aUser.Permission == "" {
+		aUser.Permission = models.NoPermission
+	}
+	if aUser.CreatedAt.IsZero() {
+		aUser.CreatedAt = time.Now()
+	}
+	if aUser.UpdatedAt.IsZero() {
+		aUser.UpdatedAt = time.Now()
+	}
+	stored := *aUser
+	stored.SmartmailUser = models.User{}
+	s.apiUsers = append(s.apiUsers, stored)
+	return aUser, nil
+}
+
+func (r memoryAPIUsers) FindAll() (*[]models.APIUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	aUsers := []models.APIUser{}
+	for _, aUser := range r.m.state.apiUsers {
+		if len(aUsers) == 100 {
+			break
+		}
+		aUsers = append(aUsers, aUser)
+	}
+	return &aUsers, nil
+}
+
+func (r memoryAPIUsers) FindByID(uid uuid.UUID) (*models.APIUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	aUser, ok := r.m.state.apiUser(uid)
+	if !ok {
+		return &models.APIUser{}, ErrNotFound
+	}
+	if aUser.SmartmailUserID.Valid {
+		aUser.SmartmailUser, _ = r.m.state.user(aUser.SmartmailUserID.UUID)
+	}
+	return &aUser, nil
+}
+
+func (r memoryAPIUsers) FindByUsername(username string) (*models.APIUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, aUser := range r.m.state.apiUsers {
+		if aUser.Username == username {
+			return &aUser, nil
+		}
+	}
+	return &models.APIUser{}, ErrNotFound
+}
+
+func (r memoryAPIUsers) FindForUser(uid uuid.UUID) (*[]models.APIUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	aUsers := []models.APIUser{}
+	for _, aUser := range r.m.state.apiUsers {
+		if aUser.SmartmailUserID.Valid && aUser.SmartmailUserID.UUID == uid {
+			aUsers = append(aUsers, aUser)
+		}
+	}
+	return &aUsers, nil
+}
+
+func (r memoryAPIUsers) Update(aUser *models.APIUser, uid uuid.UUID) (*models.APIUser, error) {
+	err := aUser.BeforeSave()
+	if err != nil {
+		return &models.APIUser{}, err
+	}
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.apiUsers {
+		if s.apiUsers[i].ID != uid {
+			continue
+		}
+		s.apiUsers[i].Password = aUser.Password
+		s.apiUsers[i].Name = aUser.Name
+		s.apiUsers[i].Username = aUser.Username
+		s.apiUsers[i].Phone = aUser.Phone
+		s.apiUsers[i].Email = aUser.Email
+		s.apiUsers[i].UpdatedAt = time.Now()
+		updated := s.apiUsers[i]
+		return &updated, nil
+	}
+	return &models.APIUser{}, ErrNotFound
+}
+
+func (r memoryAPIUsers) Delete(uid uuid.UUID) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.apiUsers {
+		if s.apiUsers[i].ID == uid {
+			s.apiUsers = append(s.apiUsers[:i], s.apiUsers[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+type memoryAddresses struct {
+	m *Memory
+}
+
+func (r memoryAddresses) Save(address *models.Address) (*models.Address, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	address.ID = r.m.state.nextID("addresses")
+	if address.CreatedAt.IsZero() {
+		address.CreatedAt = time.Now()
+	}
+	if address.UpdatedAt.IsZero() {
+		address.UpdatedAt = time.Now()
+	}
+	r.m.state.addresses = append(r.m.state.addresses, *address)
+	return address, nil
+}
+
+func (r memoryAddresses) FindByID(aid uint64) (*models.Address, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	address, ok := r.m.state.address(aid)
+	if !ok {
+		return &models.Address{}, ErrNotFound
+	}
+	return &address, nil
+}
+
+// Update only changes the fields that are set, like a gorm Updates with a struct
+func (r memoryAddresses) Update(address *models.Address, aid uint64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.addresses {
+		if s.addresses[i].ID != aid {
+			continue
+		}
+		existing := &s.addresses[i]
+		setString := func(field *null.String, value null.String) {
+			if value != (null.String{}) {
+				*field = value
+			}
+		}
+		setString(&existing.Nickname, address.Nickname)
+		setString(&existing.LineTwo, address.LineTwo)
+		setString(&existing.BusinessName, address.BusinessName)
+		setString(&existing.AttentionTo, address.AttentionTo)
+		setString(&existing.Phone, address.Phone)
+		setString(&existing.DeliveryInstructions, address.DeliveryInstructions)
+		for field, value := range map[*string]string{&existing.LineOne: address.LineOne, &existing.City: address.City, &existing.State: address.State, &existing.ZipCode: address.ZipCode, &existing.Country: address.Country} {
+			if value != "" {
+				*field = value
+			}
+		}
+		if address.Latitude != 0 {
+			existing.Latitude = address.Latitude
+		}
+		if address.Longitude != 0 {
+			existing.Longitude = address.Longitude
+		}
+		existing.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+type memoryAssignments struct {
+	m *Memory
+}
+
+func (r memoryAssignments) Save(aa *models.AddressAssignment) (*models.AddressAssignment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+
+	if aa.IsTemporary() {
+		for i := range s.assignments {
+			existing := s.assignments[i]
+			if existing.UserID != aa.UserID || (existing.Status != models.Temporary && existing.Status != aa.Status) {
+				continue
+			}
+			if existing.EndDate.Valid && existing.EndDate.Time.Before(aa.StartDate) {
+				continue
+			}
+			if aa.EndDate.Valid && existing.StartDate.After(aa.EndDate.Time) {
+				continue
+			}
+			if aa.Overlaps(&existing) {
+				return &models.AddressAssignment{}, models.ErrTemporaryConflict
+			}
+		}
+	}
+
+	user, ok := s.user(aa.UserID)
+	if !ok {
+		return &models.AddressAssignment{}, ErrNotFound
+	}
+	address, ok := s.address(aa.AddressID)
+	if !ok {
+		return &models.AddressAssignment{}, ErrNotFound
+	}
+	aa.ID = s.nextID("address_assignments")
+	if aa.StartDate.IsZero() {
+		aa.StartDate = time.Now()
+	}
+	if aa.CreatedAt.IsZero() {
+		aa.CreatedAt = time.Now()
+	}
+	if aa.UpdatedAt.IsZero() {
+		aa.UpdatedAt = time.Now()
+	}
+	stored := *aa
+	stored.User = models.User{}
+	stored.Address = models.Address{}
+	s.assignments = append(s.assignments, stored)
+	aa.User = user
+	aa.Address = address
+
+	if aa.IsPermanent() {
+		for i := range s.assignments {
+			existing := &s.assignments[i]
+			if existing.ID != aa.ID && existing.UserID == aa.UserID && existing.IsPermanent() && !existing.EndDate.Valid {
+				existing.EndDate = null.TimeFrom(aa.StartDate.AddDate(0, 0, -1))
+				existing.UpdatedAt = time.Now()
+			}
+		}
+	}
+	return aa, nil
+}
+
+func (r memoryAssignments) FindByID(aaid uint64) (*models.AddressAssignment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, aa := range r.m.state.assignments {
+		if aa.ID == aaid {
+			return &aa, nil
+		}
+	}
+	return &models.AddressAssignment{}, ErrNotFound
+}
+
+// priorPermanent returns the user's permanent assignment that ends the day before start, it may have been expired since
+func (s *memoryState) priorPermanent(uid uuid.UUID, start time.Time) (*models.AddressAssignment, bool) {
+	end := start.AddDate(0, 0, -1)
+	for i := range s.assignments {
+		existing := &s.assignments[i]
+		if existing.UserID == uid && existing.WasPermanent(models.Permanent) && existing.EndDate.Valid && existing.EndDate.Time.Equal(end) {
+			return existing, true
+		}
+	}
+	return nil, false
+}
+
+func (r memoryAssignments) Update(aa *models.AddressAssignment, originalStart time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.assignments {
+		if s.assignments[i].ID != aa.ID {
+			continue
+		}
+		if !aa.StartDate.IsZero() {
+			s.assignments[i].StartDate = aa.StartDate
+		}
+		if aa.EndDate != (null.Time{}) {
+			s.assignments[i].EndDate = aa.EndDate
+		}
+		s.assignments[i].UpdatedAt = time.Now()
+	}
+
+	if aa.Status == models.Permanent && aa.StartDate.Format("2006-01-02") != originalStart.Format("2006-01-02") {
+		prior, ok := s.priorPermanent(aa.UserID, originalStart)
+		if !ok {
+			return errors.New("Could not find previous address")
+		}
+		prior.MoveEnd(null.TimeFrom(aa.StartDate.AddDate(0, 0, -1)), time.Now())
+	}
+	return nil
+}
+
+func (r memoryAssignments) Delete(aa *models.AddressAssignment) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.assignments {
+		if s.assignments[i].ID == aa.ID {
+			s.assignments[i].Status = models.Deleted
+			s.assignments[i].UpdatedAt = time.Now()
+		}
+	}
+
+	if aa.Status == models.Permanent {
+		prior, ok := s.priorPermanent(aa.UserID, aa.StartDate)
+		if !ok {
+			return errors.New("Could not find previous address")
+		}
+		prior.MoveEnd(aa.EndDate, time.Now())
+	}
+	return nil
+}
+
+func (r memoryAssignments) FindMailingAddress(user models.User, targetDate time.Time) (*models.AddressAssignment, error) {
+	return r.findAddress(user, targetDate, models.MailingAddressOn)
+}
+
+func (r memoryAssignments) FindPackageAddress(user models.User, targetDate time.Time) (*models.AddressAssignment, error) {
+	return r.findAddress(user, targetDate, models.PackageAddressOn)
+}
+
+func (r memoryAssignments) findAddress(user models.User, targetDate time.Time, pick func(assignments []models.AddressAssignment, targetDate time.Time) (*models.AddressAssignment, bool)) (*models.AddressAssignment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	assignments := []models.AddressAssignment{}
+	for _, aa := range s.assignments {
+		if aa.UserID == user.ID {
+			assignments = append(assignments, s.loadAssignment(aa))
+		}
+	}
+	aa, ok := pick(assignments, targetDate)
+	if !ok {
+		return &models.AddressAssignment{}, ErrNotFound
+	}
+	return aa, nil
+}
+
+func (r memoryAssignments) FindAllActiveForUser(uid uuid.UUID) (*[]models.AddressAssignment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	assignments := []models.AddressAssignment{}
+	for _, aa := range s.assignments {
+		if len(assignments) == 100 {
+			break
+		}
+		if aa.UserID != uid || aa.Status == models.Expired || aa.Status == models.Deleted {
+			continue
+		}
+		if aa.EndDate.Valid && !aa.EndDate.Time.After(today) {
+			continue
+		}
+		assignments = append(assignments, s.loadAssignment(aa))
+	}
+	return &assignments, nil
+}
+
+func (r memoryAssignments) FindForUsers(uids []uuid.UUID, from time.Time, to time.Time) (*[]models.AddressAssignment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	wanted := map[uuid.UUID]bool{}
+	for _, uid := range uids {
+		wanted[uid] = true
+	}
+	assignments := []models.AddressAssignment{}
+	for _, aa := range s.assignments {
+		if !wanted[aa.UserID] || (aa.Status == models.Expired && !aa.IsSuperseded()) || aa.Status == models.Deleted {
+			continue
+		}
+		if !aa.StartDate.Before(to) || (aa.EndDate.Valid && !aa.EndDate.Time.After(from)) {
+			continue
+		}
+		assignments = append(assignments, s.loadAssignment(aa))
+	}
+	return &assignments, nil
+}
+
+func (r memoryAssignments) Expire(now time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	var expired int64
+	for i := range s.assignments {
+		if s.assignments[i].IsOverdue(now) {
+			s.transitions = append(s.transitions, models.AddressStatusTransition{
+				ID:                  s.nextID("address_status_transitions"),
+				AddressAssignmentID: s.assignments[i].ID,
+				FromStatus:          s.assignments[i].Status,
+				ToStatus:            models.Expired,
+				TransitionedAt:      now,
+			})
+			s.assignments[i].Expire(now)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r memoryAssignments) FindTransitions(aaid uint64) (*[]models.AddressStatusTransition, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	transitions := []models.AddressStatusTransition{}
+	for _, transition := range r.m.state.transitions {
+		if transition.AddressAssignmentID == aaid {
+			transitions = append(transitions, transition)
+		}
+	}
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].TransitionedAt.Before(transitions[j].TransitionedAt)
+	})
+	return &transitions, nil
+}
+
+type memoryContacts struct {
+	m *Memory
+}
+
+// firstOrCreate returns the contact linking the users, adding it if needed
+func (s *memoryState) firstOrCreateContact(userID uuid.UUID, contactID uuid.UUID) models.Contact {
+	for _, contact := range s.contacts {
+		if contact.UserID == userID && contact.ContactID == contactID {
+			return contact
+		}
+	}
+	contact := models.Contact{ID: s.nextID("contacts"), UserID: userID, ContactID: contactID, CreatedAt: time.Now()}
+	s.contacts = append(s.contacts, contact)
+	return contact
+}
+
+func (r memoryContacts) SaveBoth(userID uuid.UUID, contactID uuid.UUID) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.state.firstOrCreateContact(userID, contactID)
+	r.m.state.firstOrCreateContact(contactID, userID)
+	return nil
+}
+
+func (r memoryContacts) Save(userID uuid.UUID, contactID uuid.UUID) (models.Contact, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	contact := s.firstOrCreateContact(userID, contactID)
+	contact.User, _ = s.user(contact.UserID)
+	contact.Contact, _ = s.user(contact.ContactID)
+	return contact, nil
+}
+
+func (r memoryContacts) FindForUser(userID uuid.UUID, limit int64, offset int64, sortPref string) (int64, []models.Contact, error) {
+	return r.page(userID, limit, offset, sortPref == "recent", func(contact models.User) bool { return true })
+}
+
+func (r memoryContacts) Search(userID uuid.UUID, limit int64, offset int64, search string) (int64, []models.Contact, error) {
+	matches := searchMatcher(search)
+	return r.page(userID, limit, offset, false, func(contact models.User) bool {
+		return matches([]string{contact.FirstName, contact.LastName}, []string{contact.SmartID})
+	})
+}
+
+// page sorts a user's matching contacts by name, or newest first, and returns the total along with one page
+func (r memoryContacts) page(userID uuid.UUID, limit int64, offset int64, recent bool, match func(contact models.User) bool) (int64, []models.Contact, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	contacts := []models.Contact{}
+	for _, contact := range s.contacts {
+		if contact.UserID != userID {
+			continue
+		}
+		contact.Contact, _ = s.user(contact.ContactID)
+		if match(contact.Contact) {
+			contacts = append(contacts, contact)
+		}
+	}
+	sort.SliceStable(contacts, func(i, j int) bool {
+		if recent {
+			return contacts[i].CreatedAt.After(contacts[j].CreatedAt)
+		}
+		if contacts[i].Contact.FirstName != contacts[j].Contact.FirstName {
+			return contacts[i].Contact.FirstName < contacts[j].Contact.FirstName
+		}
+		return contacts[i].Contact.LastName < contacts[j].Contact.LastName
+	})
+	start, end := bounds(len(contacts), limit, offset)
+	return int64(len(contacts)), contacts[start:end], nil
+}
+
+// bounds applies a LIMIT and OFFSET to a result, a negative limit or offset is ignored like it is by gorm
+func bounds(total int, limit int64, offset int64) (int, int) {
+	start := 0
+	if offset > 0 {
+		start = int(offset)
+	}
+	if start > total {
+		start = total
+	}
+	end := total
+	if limit >= 0 && start+int(limit) < total {
+		end = start + int(limit)
+	}
+	return start, end
+}
+
+// searchMatcher matches a search like models.SearchContacts and models.FindPackagesForUser do. A single term matches part of a name
+// or a whole SmartID, several terms each match a whole name or SmartID
+func searchMatcher(search string) func(names []string, smartIDs []string) bool {
+	terms := []string{}
+	for _, term := range strings.Fields(search) {
+		terms = append(terms, strings.ToLower(term))
+	}
+	return func(names []string, smartIDs []string) bool {
+		for _, term := range terms {
+			for _, name := range names {
+				if (len(terms) == 1 && strings.Contains(strings.ToLower(name), term)) || (len(terms) > 1 && strings.ToLower(name) == term) {
+					return true
+				}
+			}
+			for _, smartID := range smartIDs {
+				if strings.ToLower(smartID) == term {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+type memoryPackages struct {
+	m *Memory
+}
+
+func (r memoryPackages) Save(p *models.Package) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for _, existing := range s.packages {
+		if p.SenderID.Valid && p.RecipientID.Valid && p.Tracking.Valid && existing.SenderID == p.SenderID && existing.RecipientID == p.RecipientID && existing.Tracking == p.Tracking {
+			p.ID = existing.ID
+			return nil
+		}
+	}
+	p.ID = s.nextID("packages")
+	s.packages = append(s.packages, models.Package{
+		ID:                   p.ID,
+		MailCarrierID:        p.MailCarrierID,
+		CarrierID:            p.CarrierID,
+		SenderID:             p.SenderID,
+		RecipientID:          p.RecipientID,
+		Tracking:             p.Tracking,
+		PackageDescriptionID: p.PackageDescriptionID,
+		AddressAssignmentID:  p.AddressAssignmentID,
+		CreatedAt:            time.Now(),
+		UpdatedAt:            time.Now(),
+	})
+	return nil
+}
+
+func (r memoryPackages) Update(uid uuid.UUID, tracking string, delivered bool, deliveredOn null.Time, estimatedDelivery null.Time) (*models.Package, error) {
+	p, err := r.FindByTrackingForUser(uid, tracking)
+	if err != nil {
+		return &models.Package{}, err
+	}
+
+	event, changed := p.UpdateEvent(delivered, deliveredOn, estimatedDelivery)
+	if changed {
+		_, err = r.SaveEvent(&event)
+		if err != nil {
+			return &models.Package{}, err
+		}
+		p.Events = append(p.Events, event)
+	}
+
+	state := models.DerivePackageState(p.Events)
+	p.Delivered = state.Delivered
+	p.DeliveredOn = state.DeliveredOn
+	p.EstimatedDelivery = state.EstimatedDelivery
+	return p, nil
+}
+
+func (r memoryPackages) find(match func(p models.Package) bool) (models.Package, bool) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, p := range r.m.state.packages {
+		if match(p) {
+			return p, true
+		}
+	}
+	return models.Package{}, false
+}
+
+func (r memoryPackages) FindByTrackingForUser(uid uuid.UUID, tracking string) (*models.Package, error) {
+	p, ok := r.find(func(p models.Package) bool {
+		return p.Tracking.Valid && p.Tracking.String == tracking && ((p.SenderID.Valid && p.SenderID.UUID == uid) || (p.RecipientID.Valid && p.RecipientID.UUID == uid))
+	})
+	if !ok {
+		return &models.Package{}, errors.New("Package not found")
+	}
+	r.m.mu.Lock()
+	p.Events = r.m.state.eventsFor(p.ID)
+	r.m.mu.Unlock()
+	return &p, nil
+}
+
+func (r memoryPackages) FindByTrackingAndCarrier(carrierID uuid.UUID, tracking string) (*models.Package, error) {
+	p, ok := r.find(func(p models.Package) bool {
+		return p.Tracking.Valid && p.Tracking.String == tracking && p.MailCarrierID == carrierID
+	})
+	if !ok {
+		return &models.Package{}, errors.New("Package not found")
+	}
+	return &p, nil
+}
+
+func (r memoryPackages) FindByTrackingAndShipper(senderID uuid.UUID, tracking string) (*models.Package, error) {
+	p, ok := r.find(func(p models.Package) bool {
+		return p.Tracking.Valid && p.Tracking.String == tracking && p.SenderID.Valid && p.SenderID.UUID == senderID
+	})
+	if !ok {
+		return &models.Package{}, ErrNotFound
+	}
+	return &p, nil
+}
+
+// tracked returns the user's packages that have a tracking number, loaded and in delivery order when ordered is set
+func (r memoryPackages) tracked(uid uuid.UUID, delivered bool, ordered bool) []models.Package {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	packages := []models.Package{}
+	for _, p := range s.packages {
+		if !(p.SenderID.Valid && p.SenderID.UUID == uid) && !(p.RecipientID.Valid && p.RecipientID.UUID == uid) {
+			continue
+		}
+		if !p.Tracking.Valid || p.Tracking.String == "" || p.Delivered != delivered {
+			continue
+		}
+		packages = append(packages, s.loadPackage(p))
+	}
+	if ordered {
+		// ORDER BY delivered_on desc, estimated_delivery desc, Postgres puts NULLs first when descending
+		sort.SliceStable(packages, func(i, j int) bool {
+			if packages[i].DeliveredOn != packages[j].DeliveredOn {
+				return newerOrNull(packages[i].DeliveredOn, packages[j].DeliveredOn)
+			}
+			return newerOrNull(packages[i].EstimatedDelivery, packages[j].EstimatedDelivery)
+		})
+	}
+	return packages
+}
+
+// newerOrNull returns true if a sorts before b in a descending order with NULLs first
+func newerOrNull(a null.Time, b null.Time) bool {
+	if !a.Valid || !b.Valid {
+		return !a.Valid && b.Valid
+	}
+	return a.Time.After(b.Time)
+}
+
+func limitPackages(packages []models.Package, limit int) *[]models.Package {
+	if len(packages) > limit {
+		packages = packages[:limit]
+	}
+	return &packages
+}
+
+func (r memoryPackages) FindAllOpenForUser(uid uuid.UUID) (*[]models.Package, error) {
+	return limitPackages(r.tracked(uid, false, false), 250), nil
+}
+
+func (r memoryPackages) FindPreviewForUser(uid uuid.UUID) (*[]models.Package, *[]models.Package, error) {
+	return limitPackages(r.tracked(uid, false, true), 20), limitPackages(r.tracked(uid, true, true), 5), nil
+}
+
+func (r memoryPackages) FindForUser(userID uuid.UUID, packageType string, limit int64, offset int64, search null.String) (int64, []models.Package, error) {
+	packages := r.tracked(userID, packageType == "delivered", true)
+	if search.Valid {
+		matches := searchMatcher(search.String)
+		contents := strings.ToLower(search.String)
+		if len(strings.Fields(search.String)) == 1 {
+			contents = strings.ToLower(strings.Fields(search.String)[0])
+		}
+		found := []models.Package{}
+		for _, p := range packages {
+			names := []string{p.Sender.FirstName, p.Sender.LastName, p.Recipient.FirstName, p.Recipient.LastName}
+			smartIDs := []string{p.Sender.SmartID, p.Recipient.SmartID}
+			if matches(names, smartIDs) || (contents != "" && strings.Contains(strings.ToLower(p.PackageDescription.Contents.String), contents)) {
+				found = append(found, p)
+			}
+		}
+		packages = found
+	}
+	start, end := bounds(len(packages), limit, offset)
+	return int64(len(packages)), packages[start:end], nil
+}
+
+func (r memoryPackages) SetDescription(packageID uint64, packageDescriptionID uint64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.packages {
+		if s.packages[i].ID == packageID && packageDescriptionID != 0 {
+			s.packages[i].PackageDescriptionID = packageDescriptionID
+			s.packages[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (r memoryPackages) SaveDescription(pd *models.PackageDescription) (*models.PackageDescription, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	pd.ID = r.m.state.nextID("package_descriptions")
+	if pd.CreatedAt.IsZero() {
+		pd.CreatedAt = time.Now()
+	}
+	if pd.UpdatedAt.IsZero() {
+		pd.UpdatedAt = time.Now()
+	}
+	r.m.state.descriptions = append(r.m.state.descriptions, *pd)
+	return pd, nil
+}
+
+func (r memoryPackages) UpdateDescription(pdid uint64, contents null.String, orderLink null.String, orderImage null.String) (*models.PackageDescription, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.descriptions {
+		if s.descriptions[i].ID != pdid {
+			continue
+		}
+		if contents != (null.String{}) {
+			s.descriptions[i].Contents = contents
+		}
+		if orderLink != (null.String{}) {
+			s.descriptions[i].OrderLink = orderLink
+		}
+		if orderImage != (null.String{}) {
+			s.descriptions[i].OrderImage = orderImage
+		}
+		s.descriptions[i].UpdatedAt = time.Now()
+		updated := s.descriptions[i]
+		return &updated, nil
+	}
+	return &models.PackageDescription{ID: pdid, Contents: contents, OrderLink: orderLink, OrderImage: orderImage}, nil
+}
+
+// SaveEvent adds the event and derives the package's state under one lock, like the row lock models.SavePackageEvent takes
+func (r memoryPackages) SaveEvent(pe *models.PackageEvent) (*models.PackageEvent, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.packages {
+		if s.packages[i].ID != pe.PackageID {
+			continue
+		}
+		pe.ID = s.nextID("package_events")
+		if pe.CreatedAt.IsZero() {
+			pe.CreatedAt = time.Now()
+		}
+		stored := *pe
+		stored.Source = models.APIUser{}
+		s.events = append(s.events, stored)
+
+		state := models.DerivePackageState(s.eventsFor(pe.PackageID))
+		s.packages[i].Delivered = state.Delivered
+		s.packages[i].DeliveredOn = state.DeliveredOn
+		s.packages[i].EstimatedDelivery = state.EstimatedDelivery
+		s.packages[i].UpdatedAt = time.Now()
+		return pe, nil
+	}
+	return &models.PackageEvent{}, ErrNotFound
+}
+
+func (r memoryPackages) FindEvents(packageID uint64) (*[]models.PackageEvent, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	events := s.eventsFor(packageID)
+	for i := range events {
+		if events[i].SourceID.Valid {
+			events[i].Source, _ = s.apiUser(events[i].SourceID.UUID)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+	return &events, nil
+}
+
+func (r memoryPackages) FlagReroutes(aa *models.AddressAssignment) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	assignments := []models.AddressAssignment{}
+	for _, existing := range s.assignments {
+		if existing.UserID == aa.UserID && existing.Status != models.Deleted {
+			assignments = append(assignments, existing)
+		}
+	}
+	var flagged int64
+	for i := range s.packages {
+		if !s.packages[i].NeedsReroute(assignments, *aa) {
+			continue
+		}
+		s.packages[i].AddressChanged = true
+		s.packages[i].RerouteAssignmentID = null.IntFrom(int64(aa.ID))
+		s.packages[i].UpdatedAt = time.Now()
+		flagged++
+	}
+	return flagged, nil
+}
+
+func (r memoryPackages) FindReroutes(carrierID uuid.UUID) ([]models.PackageReroute, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	reroutes := []models.PackageReroute{}
+	for _, p := range s.packages {
+		if p.MailCarrierID != carrierID || !p.AddressChanged || p.Delivered {
+			continue
+		}
+		reroute := models.PackageReroute{Package: s.loadPackage(p)}
+		reroute.From, _ = s.assignment(uint64(p.AddressAssignmentID.Int64))
+		reroute.To, _ = s.assignment(uint64(p.RerouteAssignmentID.Int64))
+		reroutes = append(reroutes, reroute)
+	}
+	// ORDER BY estimated_delivery, id, Postgres puts NULLs last when ascending
+	sort.SliceStable(reroutes, func(i, j int) bool {
+		a, b := reroutes[i].Package.EstimatedDelivery, reroutes[j].Package.EstimatedDelivery
+		if a.Valid != b.Valid {
+			return a.Valid
+		}
+		return a.Time.Before(b.Time)
+	})
+	if len(reroutes) > 250 {
+		reroutes = reroutes[:250]
+	}
+	return reroutes, nil
+}
+
+func (r memoryPackages) AcknowledgeReroute(carrierID uuid.UUID, tracking string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i, p := range s.packages {
+		if p.MailCarrierID != carrierID || !p.AddressChanged || !p.Tracking.Valid || p.Tracking.String != tracking {
+			continue
+		}
+		s.packages[i].AddressAssignmentID = p.RerouteAssignmentID
+		s.packages[i].AddressChanged = false
+		s.packages[i].RerouteAssignmentID = null.IntFromPtr(nil)
+		s.packages[i].UpdatedAt = time.Now()
+		return nil
+	}
+	return errors.New("Package reroute not found")
+}
+
+type memorySessions struct {
+	m *Memory
+}
+
+func (r memorySessions) Save(session *models.Session) (*models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	session.ID = uuid.NewV4()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	if session.UpdatedAt.IsZero() {
+		session.UpdatedAt = time.Now()
+	}
+	r.m.state.sessions = append(r.m.state.sessions, *session)
+	return session, nil
+}
+
+func (r memorySessions) FindByID(sid uuid.UUID) (*models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, session := range r.m.state.sessions {
+		if session.ID == sid {
+			return &session, nil
+		}
+	}
+	return &models.Session{}, errors.New("Session not found")
+}
+
+func (r memorySessions) Revoke(sid uuid.UUID) (int64, error) {
+	return r.revoke(func(session models.Session) bool { return session.ID == sid }), nil
+}
+
+func (r memorySessions) RevokeAllForUser(uid uuid.UUID) (int64, error) {
+	return r.revoke(func(session models.Session) bool { return session.UserID == uid }), nil
+}
+
+func (r memorySessions) revoke(match func(session models.Session) bool) int64 {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	var revoked int64
+	for i := range s.sessions {
+		if match(s.sessions[i]) && !s.sessions[i].RevokedAt.Valid {
+			s.sessions[i].RevokedAt = null.TimeFrom(time.Now())
+			s.sessions[i].UpdatedAt = time.Now()
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// IsRevoked returns true if the session with the provided ID is missing, revoked or expired
+func (r memorySessions) IsRevoked(jti string) bool {
+	sid, err := uuid.FromString(jti)
+	if err != nil {
+		return true
+	}
+	session, err := r.FindByID(sid)
+	if err != nil {
+		return true
+	}
+	return !session.Active()
+}
+
+type memoryCarriers struct {
+	m *Memory
+}
+
+func (r memoryCarriers) Save(carrier *models.Carrier) (*models.Carrier, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	err := s.checkCarrier(carrier, 0)
+	if err != nil {
+		return &models.Carrier{}, err
+	}
+	carrier.ID = s.nextID("carriers")
+	if carrier.CreatedAt.IsZero() {
+		carrier.CreatedAt = time.Now()
+	}
+	if carrier.UpdatedAt.IsZero() {
+		carrier.UpdatedAt = time.Now()
+	}
+	stored := *carrier
+	stored.APIUser = models.APIUser{}
+	s.carriers = append(s.carriers, stored)
+	return carrier, nil
+}
+
+// checkCarrier enforces the unique code and API user, and the API user foreign key
+func (s *memoryState) checkCarrier(carrier *models.Carrier, cid uint64) error {
+	for _, existing := range s.carriers {
+		if existing.ID == cid {
+			continue
+		}
+		if existing.Code == carrier.Code {
+			return duplicateKey("carriers_code_key")
+		}
+		if existing.APIUserID == carrier.APIUserID {
+			return duplicateKey("carriers_api_user_id_key")
+		}
+	}
+	_, ok := s.apiUser(carrier.APIUserID)
+	if !ok {
+		return errors.New("pq: insert or update on table \"carriers\" violates foreign key constraint \"carriers_api_user_id_fkey\"")
+	}
+	return nil
+}
+
+func (r memoryCarriers) FindAll() (*[]models.Carrier, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	carriers := append([]models.Carrier{}, r.m.state.carriers...)
+	sort.SliceStable(carriers, func(i, j int) bool {
+		return carriers[i].Code < carriers[j].Code
+	})
+	return &carriers, nil
+}
+
+func (r memoryCarriers) find(match func(carrier models.Carrier) bool) (*models.Carrier, bool) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, carrier := range r.m.state.carriers {
+		if match(carrier) {
+			return &carrier, true
+		}
+	}
+	return &models.Carrier{}, false
+}
+
+func (r memoryCarriers) FindByID(cid uint64) (*models.Carrier, error) {
+	carrier, ok := r.find(func(carrier models.Carrier) bool { return carrier.ID == cid })
+	if !ok {
+		return carrier, errors.New("Carrier not found")
+	}
+	return carrier, nil
+}
+
+func (r memoryCarriers) FindByCode(code string) (*models.Carrier, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	carrier, ok := r.find(func(carrier models.Carrier) bool { return carrier.Code == code })
+	if !ok {
+		return carrier, errors.New("Invalid carrier")
+	}
+	return carrier, nil
+}
+
+func (r memoryCarriers) FindByAPIUserID(apiUserID uuid.UUID) (*models.Carrier, error) {
+	carrier, ok := r.find(func(carrier models.Carrier) bool { return carrier.APIUserID == apiUserID })
+	if !ok {
+		return carrier, ErrNotFound
+	}
+	return carrier, nil
+}
+
+func (r memoryCarriers) Update(carrier *models.Carrier, cid uint64) (*models.Carrier, error) {
+	r.m.mu.Lock()
+	s := r.m.state
+	err := s.checkCarrier(carrier, cid)
+	if err != nil {
+		r.m.mu.Unlock()
+		return &models.Carrier{}, err
+	}
+	for i := range s.carriers {
+		if s.carriers[i].ID == cid {
+			s.carriers[i].Code = carrier.Code
+			s.carriers[i].Name = carrier.Name
+			s.carriers[i].APIUserID = carrier.APIUserID
+			s.carriers[i].TrackingPatterns = carrier.TrackingPatterns
+			s.carriers[i].TrackingURL = carrier.TrackingURL
+			s.carriers[i].UpdatedAt = time.Now()
+		}
+	}
+	r.m.mu.Unlock()
+	return r.FindByID(cid)
+}
+
+func (r memoryCarriers) Delete(cid uint64) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.carriers {
+		if s.carriers[i].ID == cid {
+			s.carriers = append(s.carriers[:i], s.carriers[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, errors.New("Carrier not found")
+}
+
+func (r memoryCarriers) Registry() (models.CarrierRegistry, error) {
+	carriers, err := r.FindAll()
+	if err != nil {
+		return models.CarrierRegistry{}, err
+	}
+	registry := models.CarrierRegistry{}
+	for _, carrier := range *carriers {
+		registry[carrier.APIUserID] = carrier
+	}
+	return registry, nil
+}
+
+type memoryDisclosures struct {
+	m *Memory
+}
+
+// checkDisclosure applies the constraints of the address_disclosures table
+func (s *memoryState) checkDisclosure(ad *models.AddressDisclosure) error {
+	if ad.APIUserID.Valid == ad.RetailerID.Valid {
+		return errors.New("pq: new row for relation \"address_disclosures\" violates check constraint \"address_disclosures_requester_check\"")
+	}
+	if _, ok := s.apiUser(ad.APIUserID.UUID); ad.APIUserID.Valid && !ok {
+		return errors.New("pq: insert or update on table \"address_disclosures\" violates foreign key constraint \"address_disclosures_api_user_id_fkey\"")
+	}
+	if _, ok := s.user(ad.RetailerID.UUID); ad.RetailerID.Valid && !ok {
+		return errors.New("pq: insert or update on table \"address_disclosures\" violates foreign key constraint \"address_disclosures_retailer_id_fkey\"")
+	}
+	if _, ok := s.user(ad.UserID); !ok {
+		return errors.New("pq: insert or update on table \"address_disclosures\" violates foreign key constraint \"address_disclosures_user_id_fkey\"")
+	}
+	return nil
+}
+
+func (r memoryDisclosures) Save(ad *models.AddressDisclosure) (*models.AddressDisclosure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	err := s.checkDisclosure(ad)
+	if err != nil {
+		return &models.AddressDisclosure{}, err
+	}
+	ad.ID = s.nextID("address_disclosures")
+	if ad.DisclosedAt.IsZero() {
+		ad.DisclosedAt = time.Now()
+	}
+	stored := *ad
+	stored.APIUser = models.APIUser{}
+	stored.Retailer = models.User{}
+	s.disclosures = append(s.disclosures, stored)
+	return ad, nil
+}
+
+func (r memoryDisclosures) SaveAll(disclosures []models.AddressDisclosure) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	// One statement, so nothing is saved if any disclosure fails
+	for i := range disclosures {
+		err := s.checkDisclosure(&disclosures[i])
+		if err != nil {
+			return err
+		}
+	}
+	for _, ad := range disclosures {
+		ad.ID = s.nextID("address_disclosures")
+		if ad.DisclosedAt.IsZero() {
+			ad.DisclosedAt = time.Now()
+		}
+		ad.APIUser = models.APIUser{}
+		ad.Retailer = models.User{}
+		s.disclosures = append(s.disclosures, ad)
+	}
+	return nil
+}
+
+func (r memoryDisclosures) Find(filter models.DisclosureFilter, limit int64, offset int64) (int64, []models.AddressDisclosure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	matched := []models.AddressDisclosure{}
+	for _, ad := range s.disclosures {
+		if filter.Matches(ad) {
+			if ad.APIUserID.Valid {
+				ad.APIUser, _ = s.apiUser(ad.APIUserID.UUID)
+			}
+			if ad.RetailerID.Valid {
+				ad.Retailer, _ = s.user(ad.RetailerID.UUID)
+			}
+			matched = append(matched, ad)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].DisclosedAt.Equal(matched[j].DisclosedAt) {
+			return matched[i].DisclosedAt.After(matched[j].DisclosedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+	start, end := bounds(len(matched), limit, offset)
+	return int64(len(matched)), matched[start:end], nil
+}
+
+type memoryGrants struct {
+	m *Memory
+}
+
+// grantIndex returns the position of the user's grant for the API user, or -1
+func (s *memoryState) grantIndex(uid uuid.UUID, apiUserID uuid.UUID) int {
+	for i, grant := range s.grants {
+		if grant.UserID == uid && grant.APIUserID == apiUserID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r memoryGrants) Save(grant *models.ShareGrant) (*models.ShareGrant, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	_, ok := s.apiUser(grant.APIUserID)
+	if !ok {
+		return &models.ShareGrant{}, errors.New("pq: insert or update on table \"share_grants\" violates foreign key constraint \"share_grants_api_user_id_fkey\"")
+	}
+	i := s.grantIndex(grant.UserID, grant.APIUserID)
+	if i >= 0 {
+		s.grants[i].Decision = grant.Decision
+		s.grants[i].ExpiresAt = grant.ExpiresAt
+		s.grants[i].UpdatedAt = time.Now()
+		saved := s.grants[i]
+		saved.APIUser, _ = s.apiUser(saved.APIUserID)
+		return &saved, nil
+	}
+	grant.ID = s.nextID("share_grants")
+	if grant.CreatedAt.IsZero() {
+		grant.CreatedAt = time.Now()
+	}
+	if grant.UpdatedAt.IsZero() {
+		grant.UpdatedAt = time.Now()
+	}
+	stored := *grant
+	stored.APIUser = models.APIUser{}
+	s.grants = append(s.grants, stored)
+	return grant, nil
+}
+
+func (r memoryGrants) FindForUser(uid uuid.UUID, pending bool) (*[]models.ShareGrant, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	now := time.Now()
+	grants := []models.ShareGrant{}
+	for _, grant := range s.grants {
+		if grant.UserID != uid || (pending && grant.Effective(now) != models.GrantAsk) {
+			continue
+		}
+		grant.APIUser, _ = s.apiUser(grant.APIUserID)
+		grants = append(grants, grant)
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].UpdatedAt.After(grants[j].UpdatedAt)
+	})
+	return &grants, nil
+}
+
+func (r memoryGrants) Request(uid uuid.UUID, apiUserID uuid.UUID) (*models.ShareGrant, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	now := time.Now()
+	i := s.grantIndex(uid, apiUserID)
+	if i < 0 {
+		grant := models.ShareGrant{ID: s.nextID("share_grants"), UserID: uid, APIUserID: apiUserID, Decision: models.GrantAsk, RequestedAt: null.TimeFrom(now), CreatedAt: now, UpdatedAt: now}
+		s.grants = append(s.grants, grant)
+		return &grant, nil
+	}
+	if s.grants[i].Effective(now) == models.GrantAsk {
+		s.grants[i].Decision = models.GrantAsk
+		s.grants[i].ExpiresAt = null.Time{}
+		s.grants[i].RequestedAt = null.TimeFrom(now)
+		s.grants[i].UpdatedAt = now
+	}
+	grant := s.grants[i]
+	return &grant, nil
+}
+
+func (r memoryGrants) RequestAll(uids []uuid.UUID, apiUserID uuid.UUID) (map[uuid.UUID]models.ShareGrant, error) {
+	byUser := map[uuid.UUID]models.ShareGrant{}
+	for _, uid := range uids {
+		grant, err := r.Request(uid, apiUserID)
+		if err != nil {
+			return byUser, err
+		}
+		byUser[uid] = *grant
+	}
+	return byUser, nil
+}
+
+func (r memoryGrants) Delete(uid uuid.UUID, gid uint64) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.grants {
+		if s.grants[i].ID == gid && s.grants[i].UserID == uid {
+			s.grants = append(s.grants[:i], s.grants[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, errors.New("Grant not found")
+}
+
+type memoryDeliveryTokens struct {
+	m *Memory
+}
+
+func (r memoryDeliveryTokens) Save(dt *models.DeliveryToken) (*models.DeliveryToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	_, ok := s.apiUser(dt.APIUserID)
+	if !ok {
+		return &models.DeliveryToken{}, errors.New("pq: insert or update on table \"delivery_tokens\" violates foreign key constraint \"delivery_tokens_api_user_id_fkey\"")
+	}
+	token, err := models.GenerateDeliveryToken()
+	if err != nil {
+		return &models.DeliveryToken{}, err
+	}
+	dt.Token = token
+	dt.ID = s.nextID("delivery_tokens")
+	if dt.CreatedAt.IsZero() {
+		dt.CreatedAt = time.Now()
+	}
+	if dt.UpdatedAt.IsZero() {
+		dt.UpdatedAt = time.Now()
+	}
+	stored := *dt
+	stored.APIUser = models.APIUser{}
+	s.tokens = append(s.tokens, stored)
+	return dt, nil
+}
+
+func (r memoryDeliveryTokens) FindForUser(uid uuid.UUID) (*[]models.DeliveryToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	tokens := []models.DeliveryToken{}
+	for _, dt := range s.tokens {
+		if dt.UserID == uid {
+			dt.APIUser, _ = s.apiUser(dt.APIUserID)
+			tokens = append(tokens, dt)
+		}
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return &tokens, nil
+}
+
+func (r memoryDeliveryTokens) Find(token string, apiUserID uuid.UUID, kind models.DeliveryKind) (*models.DeliveryToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, dt := range r.m.state.tokens {
+		if dt.Token == token && dt.Usable(apiUserID, kind, time.Now()) {
+			return &dt, nil
+		}
+	}
+	return &models.DeliveryToken{}, models.ErrDeliveryTokenInvalid
+}
+
+func (r memoryDeliveryTokens) Use(token string, apiUserID uuid.UUID, kind models.DeliveryKind) (*models.DeliveryToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	now := time.Now()
+	for i := range s.tokens {
+		if s.tokens[i].Token != token {
+			continue
+		}
+		if !s.tokens[i].Usable(apiUserID, kind, now) {
+			break
+		}
+		s.tokens[i].Uses++
+		s.tokens[i].UpdatedAt = now
+		dt := s.tokens[i]
+		return &dt, nil
+	}
+	return &models.DeliveryToken{}, models.ErrDeliveryTokenInvalid
+}
+
+func (r memoryDeliveryTokens) Revoke(uid uuid.UUID, dtid uint64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.tokens {
+		if s.tokens[i].ID == dtid && s.tokens[i].UserID == uid && !s.tokens[i].RevokedAt.Valid {
+			s.tokens[i].RevokedAt = null.TimeFrom(time.Now())
+			s.tokens[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return errors.New("Delivery token not found")
+}
+
+type memoryWebhooks struct {
+	m *Memory
+}
+
+func (r memoryWebhooks) Save(webhook *models.Webhook) (*models.Webhook, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	_, ok := s.apiUser(webhook.APIUserID)
+	if !ok {
+		return &models.Webhook{}, errors.New("pq: insert or update on table \"webhooks\" violates foreign key constraint \"webhooks_api_user_id_fkey\"")
+	}
+	secret, err := models.GenerateWebhookSecret()
+	if err != nil {
+		return &models.Webhook{}, err
+	}
+	webhook.Secret = secret
+	webhook.ID = s.nextID("webhooks")
+	if webhook.CreatedAt.IsZero() {
+		webhook.CreatedAt = time.Now()
+	}
+	if webhook.UpdatedAt.IsZero() {
+		webhook.UpdatedAt = time.Now()
+	}
+	stored := *webhook
+	stored.APIUser = models.APIUser{}
+	s.webhooks = append(s.webhooks, stored)
+	return webhook, nil
+}
+
+func (r memoryWebhooks) FindForAPIUser(apiUserID uuid.UUID) (*[]models.Webhook, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	webhooks := []models.Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.APIUserID == apiUserID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return &webhooks, nil
+}
+
+func (r memoryWebhooks) Delete(apiUserID uuid.UUID, wid uint64) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i, webhook := range s.webhooks {
+		if webhook.ID != wid || webhook.APIUserID != apiUserID {
+			continue
+		}
+		s.webhooks = append(s.webhooks[:i:i], s.webhooks[i+1:]...)
+		deliveries := []models.WebhookDelivery{}
+		for _, delivery := range s.deliveries {
+			if delivery.WebhookID != wid {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		s.deliveries = deliveries
+		return 1, nil
+	}
+	return 0, errors.New("Webhook not found")
+}
+
+func (r memoryWebhooks) SaveDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	_, ok := s.webhook(delivery.WebhookID)
+	if !ok {
+		return &models.WebhookDelivery{}, errors.New("pq: insert or update on table \"webhook_deliveries\" violates foreign key constraint \"webhook_deliveries_webhook_id_fkey\"")
+	}
+	delivery.ID = s.nextID("webhook_deliveries")
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = time.Now()
+	}
+	if delivery.UpdatedAt.IsZero() {
+		delivery.UpdatedAt = time.Now()
+	}
+	stored := *delivery
+	stored.Webhook = models.Webhook{}
+	s.deliveries = append(s.deliveries, stored)
+	return delivery, nil
+}
+
+func (r memoryWebhooks) ClaimDue(now time.Time, lease time.Duration, limit int64) (*[]models.WebhookDelivery, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	due := []int{}
+	for i, delivery := range s.deliveries {
+		if !delivery.DeliveredAt.Valid && !delivery.DeadAt.Valid && !delivery.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return s.deliveries[due[i]].NextAttemptAt.Before(s.deliveries[due[j]].NextAttemptAt)
+	})
+	deliveries := []models.WebhookDelivery{}
+	for _, i := range due {
+		if int64(len(deliveries)) == limit {
+			break
+		}
+		s.deliveries[i].NextAttemptAt = now.Add(lease)
+		delivery := s.deliveries[i]
+		delivery.Webhook, _ = s.webhook(delivery.WebhookID)
+		deliveries = append(deliveries, delivery)
+	}
+	return &deliveries, nil
+}
+
+func (r memoryWebhooks) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == delivery.ID {
+			s.deliveries[i].Attempts = delivery.Attempts
+			s.deliveries[i].NextAttemptAt = delivery.NextAttemptAt
+			s.deliveries[i].LastStatus = delivery.LastStatus
+			s.deliveries[i].LastError = delivery.LastError
+			s.deliveries[i].DeliveredAt = delivery.DeliveredAt
+			s.deliveries[i].DeadAt = delivery.DeadAt
+			s.deliveries[i].UpdatedAt = delivery.UpdatedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r memoryWebhooks) FindDead(apiUserID uuid.UUID) (*[]models.WebhookDelivery, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		webhook, _ := s.webhook(delivery.WebhookID)
+		if delivery.DeadAt.Valid && webhook.APIUserID == apiUserID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].DeadAt.Time.After(deliveries[j].DeadAt.Time)
+	})
+	return &deliveries, nil
+}
+
+func (r memoryWebhooks) Redeliver(apiUserID uuid.UUID, did uint64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i, delivery := range s.deliveries {
+		webhook, _ := s.webhook(delivery.WebhookID)
+		if delivery.ID == did && delivery.DeadAt.Valid && webhook.APIUserID == apiUserID {
+			s.deliveries[i].Attempts = 0
+			s.deliveries[i].DeadAt = null.Time{}
+			s.deliveries[i].NextAttemptAt = time.Now()
+			s.deliveries[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return errors.New("Dead delivery not found")
+}
+
+type memoryMailingJobs struct {
+	m *Memory
+}
+
+func (r memoryMailingJobs) Save(job *models.MailingJob) (*models.MailingJob, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	_, ok := s.apiUser(job.APIUserID)
+	if !ok {
+		return &models.MailingJob{}, errors.New("pq: insert or update on table \"mailing_jobs\" violates foreign key constraint \"mailing_jobs_api_user_id_fkey\"")
+	}
+	stored := *job
+	stored.APIUser = models.APIUser{}
+	s.mailingJobs = append(s.mailingJobs, stored)
+	return job, nil
+}
+
+func (r memoryMailingJobs) SaveRows(rows []models.MailingJobRow) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for _, row := range rows {
+		if _, ok := s.mailingJob(row.JobID); !ok {
+			return errors.New("pq: insert or update on table \"mailing_job_rows\" violates foreign key constraint \"mailing_job_rows_job_id_fkey\"")
+		}
+	}
+	for _, row := range rows {
+		row.ID = s.nextID("mailing_job_rows")
+		s.mailingRows = append(s.mailingRows, row)
+	}
+	return nil
+}
+
+func (r memoryMailingJobs) FindByID(apiUserID uuid.UUID, jid uuid.UUID) (*models.MailingJob, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	job, ok := s.mailingJob(jid)
+	if !ok || job.APIUserID != apiUserID {
+		return &models.MailingJob{}, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (r memoryMailingJobs) Cancel(apiUserID uuid.UUID, jid uuid.UUID, now time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i, job := range s.mailingJobs {
+		if job.ID == jid && job.APIUserID == apiUserID && !job.Finished() {
+			s.mailingJobs[i].Finish(models.JobCancelled, now, "")
+			return nil
+		}
+	}
+	return errors.New("Mailing job not found or already finished")
+}
+
+func (r memoryMailingJobs) Claim(now time.Time, lease time.Duration) (*models.MailingJob, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	claim := -1
+	for i, job := range s.mailingJobs {
+		waiting := job.Status == models.JobQueued || (job.Status == models.JobRunning && !job.ClaimedUntil.Time.After(now))
+		if waiting && (claim < 0 || job.CreatedAt.Before(s.mailingJobs[claim].CreatedAt)) {
+			claim = i
+		}
+	}
+	if claim < 0 {
+		return &models.MailingJob{}, ErrNotFound
+	}
+	job := &s.mailingJobs[claim]
+	job.Status = models.JobRunning
+	job.ClaimedUntil = null.TimeFrom(now.Add(lease))
+	if !job.StartedAt.Valid {
+		job.StartedAt = null.TimeFrom(now)
+	}
+	job.UpdatedAt = now
+	claimed := *job
+	return &claimed, nil
+}
+
+func (r memoryMailingJobs) FindPendingRows(jid uuid.UUID, limit int64) (*[]models.MailingJobRow, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	rows := []models.MailingJobRow{}
+	for _, row := range s.mailingRows {
+		if int64(len(rows)) == limit {
+			break
+		}
+		if row.JobID == jid && !row.ProcessedAt.Valid {
+			rows = append(rows, row)
+		}
+	}
+	return &rows, nil
+}
+
+func (r memoryMailingJobs) SaveResults(rows []models.MailingJobRow) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for _, row := range rows {
+		for i := range s.mailingRows {
+			if s.mailingRows[i].ID == row.ID {
+				s.mailingRows[i].Result = row.Result
+				s.mailingRows[i].Error = row.Error
+				s.mailingRows[i].ProcessedAt = row.ProcessedAt
+			}
+		}
+	}
+	return nil
+}
+
+func (r memoryMailingJobs) UpdateProgress(job *models.MailingJob, processed int, failed int, claimedUntil time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.mailingJobs {
+		if s.mailingJobs[i].ID == job.ID && s.mailingJobs[i].Status == models.JobRunning {
+			s.mailingJobs[i].ProcessedRows += processed
+			s.mailingJobs[i].FailedRows += failed
+			s.mailingJobs[i].ClaimedUntil = null.TimeFrom(claimedUntil)
+			s.mailingJobs[i].UpdatedAt = time.Now()
+			job.ProcessedRows += processed
+			job.FailedRows += failed
+			job.ClaimedUntil = null.TimeFrom(claimedUntil)
+			return nil
+		}
+	}
+	return models.ErrMailingJobCancelled
+}
+
+func (r memoryMailingJobs) Finish(job *models.MailingJob) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i := range s.mailingJobs {
+		if s.mailingJobs[i].ID == job.ID && s.mailingJobs[i].Status == models.JobRunning {
+			s.mailingJobs[i].Status = job.Status
+			s.mailingJobs[i].Error = job.Error
+			s.mailingJobs[i].ClaimedUntil = null.Time{}
+			s.mailingJobs[i].FinishedAt = job.FinishedAt
+			s.mailingJobs[i].ExpiresAt = job.ExpiresAt
+			s.mailingJobs[i].UpdatedAt = job.UpdatedAt
+			return nil
+		}
+	}
+	return models.ErrMailingJobCancelled
+}
+
+func (r memoryMailingJobs) FindRows(jid uuid.UUID, limit int64, offset int64) (*[]models.MailingJobRow, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	rows := []models.MailingJobRow{}
+	for _, row := range s.mailingRows {
+		if row.JobID == jid {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].RowNumber < rows[j].RowNumber
+	})
+	if offset >= int64(len(rows)) {
+		return &[]models.MailingJobRow{}, nil
+	}
+	rows = rows[offset:]
+	if int64(len(rows)) > limit {
+		rows = rows[:limit]
+	}
+	return &rows, nil
+}
+
+func (r memoryMailingJobs) DeleteExpired(now time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	jobs := []models.MailingJob{}
+	expired := map[uuid.UUID]bool{}
+	for _, job := range s.mailingJobs {
+		if job.ExpiresAt.After(now) {
+			jobs = append(jobs, job)
+			continue
+		}
+		expired[job.ID] = true
+	}
+	rows := []models.MailingJobRow{}
+	for _, row := range s.mailingRows {
+		if !expired[row.JobID] {
+			rows = append(rows, row)
+		}
+	}
+	s.mailingJobs = jobs
+	s.mailingRows = rows
+	return int64(len(expired)), nil
+}
+
+type memoryOrders struct {
+	m *Memory
+}
+
+func (r memoryOrders) Save(order *models.Order) (*models.Order, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	_, retailer := s.user(order.RetailerID)
+	_, recipient := s.user(order.RecipientID)
+	if !retailer || !recipient {
+		return &models.Order{}, errors.New("pq: insert or update on table \"orders\" violates foreign key constraint \"orders_retailer_id_fkey\"")
+	}
+	stored := *order
+	stored.Retailer = models.User{}
+	stored.Recipient = models.User{}
+	s.orders = append(s.orders, stored)
+	return order, nil
+}
+
+func (r memoryOrders) FindByID(oid uuid.UUID) (*models.Order, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, order := range r.m.state.orders {
+		if order.ID == oid {
+			return &order, nil
+		}
+	}
+	return &models.Order{}, ErrNotFound
+}
+
+func (r memoryOrders) HandOff(order *models.Order) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i, existing := range s.orders {
+		if existing.ID != order.ID || existing.Status != models.OrderCreated {
+			continue
+		}
+		s.orders[i].Status = order.Status
+		s.orders[i].CarrierID = order.CarrierID
+		s.orders[i].Tracking = order.Tracking
+		s.orders[i].HandedOffAt = order.HandedOffAt
+		s.orders[i].UpdatedAt = order.UpdatedAt
+		return nil
+	}
+	return models.ErrOrderHandedOff
+}
+
+func (r memoryOrders) Ship(order *models.Order) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	for i, existing := range s.orders {
+		if existing.ID != order.ID || existing.Status != models.OrderHandedOff {
+			continue
+		}
+		s.orders[i].Status = order.Status
+		s.orders[i].PackageID = order.PackageID
+		s.orders[i].ShippedAt = order.ShippedAt
+		s.orders[i].UpdatedAt = order.UpdatedAt
+		return nil
+	}
+	return models.ErrOrderShipped
+}
+
+type memoryDeliveryFailures struct {
+	m *Memory
+}
+
+func (r memoryDeliveryFailures) Save(failure *models.DeliveryFailure) (*models.DeliveryFailure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	if _, ok := s.assignment(failure.AddressAssignmentID); !ok {
+		return &models.DeliveryFailure{}, errors.New("pq: insert or update on table \"delivery_failures\" violates foreign key constraint \"delivery_failures_address_assignment_id_fkey\"")
+	}
+	if _, ok := s.carrier(failure.CarrierID); !ok {
+		return &models.DeliveryFailure{}, errors.New("pq: insert or update on table \"delivery_failures\" violates foreign key constraint \"delivery_failures_carrier_id_fkey\"")
+	}
+	failure.ID = s.nextID("delivery_failures")
+	stored := *failure
+	stored.AddressAssignment = models.AddressAssignment{}
+	stored.Carrier = models.Carrier{}
+	s.failures = append(s.failures, stored)
+	return failure, nil
+}
+
+// newestFirst loads the carriers of the matching reports, newest first
+func (r memoryDeliveryFailures) newestFirst(match func(df models.DeliveryFailure) bool) *[]models.DeliveryFailure {
+	s := r.m.state
+	failures := []models.DeliveryFailure{}
+	for _, df := range s.failures {
+		if match(df) {
+			df.Carrier, _ = s.carrier(df.CarrierID)
+			failures = append(failures, df)
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		if !failures[i].CreatedAt.Equal(failures[j].CreatedAt) {
+			return failures[i].CreatedAt.After(failures[j].CreatedAt)
+		}
+		return failures[i].ID > failures[j].ID
+	})
+	return &failures
+}
+
+func (r memoryDeliveryFailures) FindForAssignment(aaid uint64, since time.Time) (*[]models.DeliveryFailure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return r.newestFirst(func(df models.DeliveryFailure) bool {
+		return df.AddressAssignmentID == aaid && !df.CreatedAt.Before(since)
+	}), nil
+}
+
+func (r memoryDeliveryFailures) FindForUser(uid uuid.UUID, unseen bool) (*[]models.DeliveryFailure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	failures := r.newestFirst(func(df models.DeliveryFailure) bool {
+		return df.UserID == uid && (!unseen || !df.SeenAt.Valid)
+	})
+	for i := range *failures {
+		(*failures)[i].AddressAssignment, _ = r.m.state.assignment((*failures)[i].AddressAssignmentID)
+	}
+	return failures, nil
+}
+
+func (r memoryDeliveryFailures) MarkSeen(uid uuid.UUID, now time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	var seen int64
+	for i, df := range s.failures {
+		if df.UserID == uid && !df.SeenAt.Valid {
+			s.failures[i].SeenAt = null.TimeFrom(now)
+			seen++
+		}
+	}
+	return seen, nil
+}
+
+func (r memoryDeliveryFailures) FindUnreviewed(since time.Time) (*[]models.DeliveryFailure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	failures := []models.DeliveryFailure{}
+	for _, df := range r.m.state.failures {
+		if df.ReviewDecision == "" && !df.CreatedAt.Before(since) {
+			failures = append(failures, df)
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].CreatedAt.Before(failures[j].CreatedAt)
+	})
+	return &failures, nil
+}
+
+func (r memoryDeliveryFailures) Review(aaid uint64, decision models.ReviewDecision, reviewerID uuid.UUID, now time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s := r.m.state
+	var reviewed int64
+	for i, df := range s.failures {
+		if df.AddressAssignmentID == aaid && df.ReviewDecision == "" {
+			s.failures[i].ReviewDecision = decision
+			s.failures[i].ReviewedAt = null.TimeFrom(now)
+			s.failures[i].ReviewedBy = uuid.NullUUID{UUID: reviewerID, Valid: true}
+			reviewed++
+		}
+	}
+	return reviewed, nil
+}
@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/models"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Users stores UI users
+type Users interface {
+	// Save issues the user a SmartID (unless a legacy user is being imported), hashes their password and saves them
+	Save(user *models.User) (*models.User, error)
+	FindAll() (*[]models.User, error)
+	FindByID(uid uuid.UUID) (*models.User, error)
+	FindBySmartID(smartID string) (*models.User, error)
+	// FindBySmartIDs returns the users with any of the SmartIDs, SmartIDs that are not found are left out
+	FindBySmartIDs(smartIDs []string) (*[]models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	// FindContact finds a user by SmartID along with their email and phone, an empty email or phone is not checked
+	FindContact(smartID string, email string, phone string) (*models.User, error)
+	// Update replaces every editable field, including the password
+	Update(user *models.User, uid uuid.UUID) (*models.User, error)
+	// UpdateBasic replaces the name, phone and email
+	UpdateBasic(user *models.User, uid uuid.UUID) (*models.User, error)
+	// UpdateRetailer makes the user a retailer with the user's logos and redirect URL
+	UpdateRetailer(user *models.User, uid uuid.UUID) (*models.User, error)
+	Delete(uid uuid.UUID) (int64, error)
+}
+
+// APIUsers stores API users
+type APIUsers interface {
+	Save(aUser *models.APIUser) (*models.APIUser, error)
+	FindAll() (*[]models.APIUser, error)
+	FindByID(uid uuid.UUID) (*models.APIUser, error)
+	FindByUsername(username string) (*models.APIUser, error)
+	// FindForUser returns the API users linked to a smartmail user
+	FindForUser(uid uuid.UUID) (*[]models.APIUser, error)
+	Update(aUser *models.APIUser, uid uuid.UUID) (*models.APIUser, error)
+	Delete(uid uuid.UUID) (int64, error)
+}
+
+// Addresses stores addresses
+type Addresses interface {
+	Save(address *models.Address) (*models.Address, error)
+	FindByID(aid uint64) (*models.Address, error)
+	// Update corrects an address, it does not change which address a user is assigned
+	Update(address *models.Address, aid uint64) error
+}
+
+// Assignments stores the address assignments that link users to addresses
+type Assignments interface {
+	// Save rejects temporary assignments that overlap and ends the user's previous permanent assignment
+	Save(aa *models.AddressAssignment) (*models.AddressAssignment, error)
+	FindByID(aaid uint64) (*models.AddressAssignment, error)
+	// Update changes an assignment's dates, moving the end of the previous permanent assignment with its start
+	Update(aa *models.AddressAssignment, originalStart time.Time) error
+	// Delete marks an assignment deleted, restoring the previous permanent assignment
+	Delete(aa *models.AddressAssignment) error
+	FindMailingAddress(user models.User, targetDate time.Time) (*models.AddressAssignment, error)
+	FindPackageAddress(user models.User, targetDate time.Time) (*models.AddressAssignment, error)
+	FindAllActiveForUser(uid uuid.UUID) (*[]models.AddressAssignment, error)
+	// FindForUsers returns the users' assignments that are in effect at some point between from and to, with their users and addresses
+	FindForUsers(uids []uuid.UUID, from time.Time, to time.Time) (*[]models.AddressAssignment, error)
+	// Expire moves the temporary assignments, holds and superseded permanent assignments past their end date to expired and returns
+	// how many were expired
+	Expire(now time.Time) (int64, error)
+	// FindTransitions returns the status changes the system made to an assignment, oldest first
+	FindTransitions(aaid uint64) (*[]models.AddressStatusTransition, error)
+}
+
+// Contacts stores the users each user has exchanged mail with
+type Contacts interface {
+	// SaveBoth adds each user to the other's contacts
+	SaveBoth(userID uuid.UUID, contactID uuid.UUID) error
+	Save(userID uuid.UUID, contactID uuid.UUID) (models.Contact, error)
+	// FindForUser pages through a user's contacts, sorted by name or by "recent"
+	FindForUser(userID uuid.UUID, limit int64, offset int64, sortPref string) (int64, []models.Contact, error)
+	Search(userID uuid.UUID, limit int64, offset int64, search string) (int64, []models.Contact, error)
+}
+
+// Packages stores packages along with their descriptions and tracking events
+type Packages interface {
+	// Save adds a package unless the sender and recipient already have one with the same tracking number, and sets p.ID to the saved package
+	Save(p *models.Package) error
+	// Update records a delivery update from the package's sender or recipient as an event
+	Update(uid uuid.UUID, tracking string, delivered bool, deliveredOn null.Time, estimatedDelivery null.Time) (*models.Package, error)
+	FindByTrackingForUser(uid uuid.UUID, tracking string) (*models.Package, error)
+	FindByTrackingAndCarrier(carrierID uuid.UUID, tracking string) (*models.Package, error)
+	FindByTrackingAndShipper(senderID uuid.UUID, tracking string) (*models.Package, error)
+	FindAllOpenForUser(uid uuid.UUID) (*[]models.Package, error)
+	// FindPreviewForUser returns a user's latest open and delivered packages
+	FindPreviewForUser(uid uuid.UUID) (*[]models.Package, *[]models.Package, error)
+	FindForUser(userID uuid.UUID, packageType string, limit int64, offset int64, search null.String) (int64, []models.Package, error)
+	SetDescription(packageID uint64, packageDescriptionID uint64) error
+	SaveDescription(pd *models.PackageDescription) (*models.PackageDescription, error)
+	UpdateDescription(pdid uint64, contents null.String, orderLink null.String, orderImage null.String) (*models.PackageDescription, error)
+	// SaveEvent adds a tracking event and derives the package's delivery state again, callers run it in a Transaction
+	SaveEvent(pe *models.PackageEvent) (*models.PackageEvent, error)
+	FindEvents(packageID uint64) (*[]models.PackageEvent, error)
+	// FlagReroutes flags the recipient's open packages that the new assignment now covers, see Package.NeedsReroute
+	FlagReroutes(aa *models.AddressAssignment) (int64, error)
+	FindReroutes(carrierID uuid.UUID) ([]models.PackageReroute, error)
+	// AcknowledgeReroute moves a flagged package over to its new assignment
+	AcknowledgeReroute(carrierID uuid.UUID, tracking string) error
+}
+
+// Sessions stores login sessions. It is also the auth package's RevocationStore
+type Sessions interface {
+	Save(session *models.Session) (*models.Session, error)
+	FindByID(sid uuid.UUID) (*models.Session, error)
+	// Revoke returns the number of sessions revoked, 0 if the session was already revoked
+	Revoke(sid uuid.UUID) (int64, error)
+	RevokeAllForUser(uid uuid.UUID) (int64, error)
+	IsRevoked(jti string) bool
+}
+
+// Carriers stores the carrier registry
+type Carriers interface {
+	Save(carrier *models.Carrier) (*models.Carrier, error)
+	FindAll() (*[]models.Carrier, error)
+	FindByID(cid uint64) (*models.Carrier, error)
+	FindByCode(code string) (*models.Carrier, error)
+	// FindByAPIUserID returns ErrNotFound if the API user is not a carrier
+	FindByAPIUserID(apiUserID uuid.UUID) (*models.Carrier, error)
+	Update(carrier *models.Carrier, cid uint64) (*models.Carrier, error)
+	Delete(cid uint64) (int64, error)
+	Registry() (models.CarrierRegistry, error)
+}
+
+// Disclosures stores the append-only log of addresses and zip codes shown to API users
+type Disclosures interface {
+	Save(ad *models.AddressDisclosure) (*models.AddressDisclosure, error)
+	// SaveAll records many disclosures at once
+	SaveAll(disclosures []models.AddressDisclosure) error
+	// Find pages through the disclosures that match the filter, newest first
+	Find(filter models.DisclosureFilter, limit int64, offset int64) (int64, []models.AddressDisclosure, error)
+}
+
+// Grants stores each user's consent to share their full address with API users
+type Grants interface {
+	// Save adds a grant or replaces the user's grant for the same API user
+	Save(grant *models.ShareGrant) (*models.ShareGrant, error)
+	// FindForUser returns a user's grants, only the pending ones when pending is true
+	FindForUser(uid uuid.UUID, pending bool) (*[]models.ShareGrant, error)
+	// Request returns the user's grant for the API user, saving a pending ask grant if there is none or it has expired
+	Request(uid uuid.UUID, apiUserID uuid.UUID) (*models.ShareGrant, error)
+	// RequestAll is Request for many users at once, keyed by user. A user may be missing if their grant was added concurrently
+	RequestAll(uids []uuid.UUID, apiUserID uuid.UUID) (map[uuid.UUID]models.ShareGrant, error)
+	Delete(uid uuid.UUID, gid uint64) (int64, error)
+}
+
+// DeliveryTokens stores the tokens users give shippers in place of their SmartID
+type DeliveryTokens interface {
+	// Save issues the token a random value and saves it
+	Save(dt *models.DeliveryToken) (*models.DeliveryToken, error)
+	FindForUser(uid uuid.UUID) (*[]models.DeliveryToken, error)
+	// Find returns a token the API user can use without counting a use, models.ErrDeliveryTokenInvalid is returned if it cannot be used
+	Find(token string, apiUserID uuid.UUID, kind models.DeliveryKind) (*models.DeliveryToken, error)
+	// Use counts a lookup against a token, models.ErrDeliveryTokenInvalid is returned if the token cannot be used
+	Use(token string, apiUserID uuid.UUID, kind models.DeliveryKind) (*models.DeliveryToken, error)
+	Revoke(uid uuid.UUID, dtid uint64) error
+}
+
+// Webhooks stores the endpoints API users register to be told about address changes, and the deliveries queued for them
+type Webhooks interface {
+	// Save issues the webhook a signing secret and saves it
+	Save(webhook *models.Webhook) (*models.Webhook, error)
+	FindForAPIUser(apiUserID uuid.UUID) (*[]models.Webhook, error)
+	// Delete removes the webhook along with its deliveries
+	Delete(apiUserID uuid.UUID, wid uint64) (int64, error)
+	SaveDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error)
+	// ClaimDue returns up to limit deliveries that are due to be sent, with their webhooks, and pushes their next attempt back by lease
+	ClaimDue(now time.Time, lease time.Duration, limit int64) (*[]models.WebhookDelivery, error)
+	UpdateDelivery(delivery *models.WebhookDelivery) error
+	// FindDead returns the dead-letter list of the API user's webhooks
+	FindDead(apiUserID uuid.UUID) (*[]models.WebhookDelivery, error)
+	Redeliver(apiUserID uuid.UUID, did uint64) error
+}
+
+// MailingJobs stores mailing list uploads and their rows while they are resolved in the background
+type MailingJobs interface {
+	Save(job *models.MailingJob) (*models.MailingJob, error)
+	SaveRows(rows []models.MailingJobRow) error
+	FindByID(apiUserID uuid.UUID, jid uuid.UUID) (*models.MailingJob, error)
+	// Cancel stops a job that has not finished
+	Cancel(apiUserID uuid.UUID, jid uuid.UUID, now time.Time) error
+	// Claim returns the next job to work on and holds it for lease, ErrNotFound if there is none
+	Claim(now time.Time, lease time.Duration) (*models.MailingJob, error)
+	FindPendingRows(jid uuid.UUID, limit int64) (*[]models.MailingJobRow, error)
+	SaveResults(rows []models.MailingJobRow) error
+	// UpdateProgress adds to the job's counts and renews its lease, it returns models.ErrMailingJobCancelled if the job is no longer running
+	UpdateProgress(job *models.MailingJob, processed int, failed int, claimedUntil time.Time) error
+	// Finish saves the job's final status, it returns models.ErrMailingJobCancelled if the job is no longer running
+	Finish(job *models.MailingJob) error
+	FindRows(jid uuid.UUID, limit int64, offset int64) (*[]models.MailingJobRow, error)
+	// DeleteExpired removes the jobs past their retention period with their rows
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// Orders stores the orders retailers take until they are handed to a carrier and shipped
+type Orders interface {
+	Save(order *models.Order) (*models.Order, error)
+	FindByID(oid uuid.UUID) (*models.Order, error)
+	// HandOff saves the carrier the order was handed to, it returns models.ErrOrderHandedOff if the order was already handed off
+	HandOff(order *models.Order) error
+	// Ship saves the package the carrier saved for the order, models.ErrOrderShipped is returned if the order has already shipped
+	Ship(order *models.Order) error
+}
+
+// DeliveryFailures stores the failed deliveries carriers report against the addresses lookups returned
+type DeliveryFailures interface {
+	Save(failure *models.DeliveryFailure) (*models.DeliveryFailure, error)
+	// FindForAssignment returns the reports against an assignment made since the time with their carriers, newest first
+	FindForAssignment(aaid uint64, since time.Time) (*[]models.DeliveryFailure, error)
+	// FindForUser returns the reports against a user's assignments with their carriers and addresses, newest first.
+	// Only the reports the user has not seen are returned when unseen is true
+	FindForUser(uid uuid.UUID, unseen bool) (*[]models.DeliveryFailure, error)
+	// MarkSeen marks every report against a user's assignments as seen
+	MarkSeen(uid uuid.UUID, now time.Time) (int64, error)
+	// FindUnreviewed returns the reports made since the time that an admin has not reviewed, oldest first
+	FindUnreviewed(since time.Time) (*[]models.DeliveryFailure, error)
+	// Review saves an admin's decision on every unreviewed report against the assignment
+	Review(aaid uint64, decision models.ReviewDecision, reviewerID uuid.UUID, now time.Time) (int64, error)
+}
+
+// Store is every repository the API uses. NewGorm stores everything in Postgres, NewMemory keeps it in memory for tests
+type Store interface {
+	Users() Users
+	APIUsers() APIUsers
+	Addresses() Addresses
+	Assignments() Assignments
+	Contacts() Contacts
+	Packages() Packages
+	Sessions() Sessions
+	Carriers() Carriers
+	Disclosures() Disclosures
+	Grants() Grants
+	DeliveryTokens() DeliveryTokens
+	Webhooks() Webhooks
+	MailingJobs() MailingJobs
+	Orders() Orders
+	DeliveryFailures() DeliveryFailures
+	// Transaction runs fn with a store whose changes are all kept if fn returns nil and all discarded otherwise
+	Transaction(fn func(store Store) error) error
+}
+
+// ErrNotFound is returned when a lookup finds nothing. It is gorm's error so both stores can be checked with gorm.IsRecordNotFoundError
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// LoadPrincipal retrieves the UI user or API user a token was issued to. permission is the token's claim, "ui" for UI users.
+// An API user's token is rejected if their permission has changed since it was issued
+func LoadPrincipal(store Store, uid uuid.UUID, permission string) (*models.Principal, error) {
+	if permission == "ui" {
+		user, err := store.Users().FindByID(uid)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Principal{User: user}, nil
+	}
+
+	aUser, err := store.APIUsers().FindByID(uid)
+	if err != nil {
+		return nil, err
+	}
+	if string(aUser.Permission) != permission {
+		return nil, errors.New("Token permission does not match the API user's permission")
+	}
+	return &models.Principal{APIUser: aUser}, nil
+}
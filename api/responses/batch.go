@@ -0,0 +1,114 @@
+package responses
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// BatchFormat is how the results of a batch lookup are streamed back
+type BatchFormat string
+
+const (
+	// JSONLines writes one JSON object per line
+	JSONLines BatchFormat = "jsonl"
+	// CSV writes a header row and then one row per result
+	CSV BatchFormat = "csv"
+)
+
+// BatchAddressResult is the result of one lookup in a batch, either the address or the reason it could not be resolved
+type BatchAddressResult struct {
+	Index   int                     `json:"index"`
+	SmartID string                  `json:"smart_id"`
+	Date    string                  `json:"date"`
+	Address *AddressSmartIDResponse `json:"address,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// mailingColumns are the address columns of a batch CSV row, and the columns appended to each row of a mailing job result
+var mailingColumns = []string{"first_name", "last_name", "business_name", "attention_to", "line_one", "line_two", "city", "state", "zip_code", "country", "held", "held_until", "pickup_location", "consent", "error"}
+
+var batchCSVHeader = append([]string{"index", "smart_id", "date"}, mailingColumns...)
+
+// MailingColumns returns the names of the columns MailingRecord fills in
+func MailingColumns() []string {
+	return append([]string{}, mailingColumns...)
+}
+
+// MailingRecord flattens an address, or the reason there is none, into the MailingColumns
+func MailingRecord(address *AddressSmartIDResponse, reason string) []string {
+	if address == nil {
+		address = &AddressSmartIDResponse{}
+	}
+	held := ""
+	if address.Held {
+		held = "true"
+	}
+	return []string{address.FirstName, address.LastName, address.BusinessName, address.AttentionTo, address.LineOne, address.LineTwo, address.City,
+		address.State, address.ZipCode, address.Country, held, address.HeldUntil, address.PickupLocation, string(address.Consent), reason}
+}
+
+// csvRecord flattens the result into a row matching batchCSVHeader
+func (result BatchAddressResult) csvRecord() []string {
+	return append([]string{strconv.Itoa(result.Index), result.SmartID, result.Date}, MailingRecord(result.Address, result.Error)...)
+}
+
+// BatchWriter streams batch results to the client, flushing after every Write so that the client sees each chunk as it is resolved
+type BatchWriter struct {
+	w       http.ResponseWriter
+	format  BatchFormat
+	csv     *csv.Writer
+	started bool
+}
+
+// NewBatchWriter creates a writer for the format, nothing is written until the first Write
+func NewBatchWriter(w http.ResponseWriter, format BatchFormat) *BatchWriter {
+	return &BatchWriter{w: w, format: format, csv: csv.NewWriter(w)}
+}
+
+// Started returns true once the status and headers have been sent, after which errors can only be reported with Fail
+func (bw *BatchWriter) Started() bool {
+	return bw.started
+}
+
+// Write sends the results, starting the response if needed
+func (bw *BatchWriter) Write(results ...BatchAddressResult) error {
+	if !bw.started {
+		bw.started = true
+		if bw.format == CSV {
+			bw.w.Header().Set("Content-Type", "text/csv")
+			bw.w.WriteHeader(http.StatusOK)
+			bw.csv.Write(batchCSVHeader)
+		} else {
+			bw.w.Header().Set("Content-Type", "application/x-ndjson")
+			bw.w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	if bw.format == CSV {
+		for _, result := range results {
+			bw.csv.Write(result.csvRecord())
+		}
+		bw.csv.Flush()
+		if err := bw.csv.Error(); err != nil {
+			return err
+		}
+	} else {
+		encoder := json.NewEncoder(bw.w)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		}
+	}
+	if flusher, ok := bw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Fail ends a response that has already started with a final result that carries the error and no index
+func (bw *BatchWriter) Fail(err error) error {
+	return bw.Write(BatchAddressResult{Index: -1, Error: err.Error()})
+}
@@ -1,8 +1,11 @@
 package responses
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
+	"github.com/nmelhado/smartmail-api/api/auth"
 	"github.com/nmelhado/smartmail-api/api/models"
 	uuid "github.com/satori/go.uuid"
 	"gopkg.in/guregu/null.v3"
@@ -26,8 +29,21 @@ type UserResponse struct {
 
 // TokenResponse is the struct returned when a token request
 type TokenResponse struct {
-	Token   string    `json:"token"`
-	Expires time.Time `json:"expires"`
+	Token          string    `json:"token"`
+	Expires        time.Time `json:"expires"`
+	RefreshToken   string    `json:"refresh_token"`
+	RefreshExpires time.Time `json:"refresh_expires"`
+}
+
+// LogoutResponse is the struct returned when a user logs out
+type LogoutResponse struct {
+	Success bool `json:"success"`
+}
+
+// RevokeSessionsResponse is the struct returned when all of a user's sessions are revoked
+type RevokeSessionsResponse struct {
+	Success bool  `json:"success"`
+	Revoked int64 `json:"revoked"`
 }
 
 // PasswordResetRequest is the struct returned when a user requests a password reset
@@ -45,10 +61,12 @@ type PasswordReset struct {
 
 // UserAndAddressResponse is the struct returned when a new user and address are simultaneously created
 type UserAndAddressResponse struct {
-	User      CreateUserResponse `json:"user"`
-	Addresses []BasicAddress     `json:"addresses"`
-	Token     string             `json:"token"`
-	Expires   time.Time          `json:"expires"`
+	User           CreateUserResponse `json:"user"`
+	Addresses      []BasicAddress     `json:"addresses"`
+	Token          string             `json:"token"`
+	Expires        time.Time          `json:"expires"`
+	RefreshToken   string             `json:"refresh_token"`
+	RefreshExpires time.Time          `json:"refresh_expires"`
 }
 
 // AddressesResponse is the struct returned when a new user and address are simultaneously created
@@ -63,6 +81,8 @@ type BasicAddress struct {
 	Status               models.Status `json:"address_type"`
 	StartDate            time.Time     `json:"start_date"`
 	EndDate              string        `json:"end_date,omitempty"`
+	Recurrence           string        `json:"recurrence,omitempty"`
+	PickupLocation       string        `json:"pickup_location,omitempty"`
 	BusinessName         string        `json:"business_name,omitempty"`
 	AttentionTo          string        `json:"attention_to,omitempty"`
 	LineOne              string        `json:"line_one"`
@@ -82,6 +102,7 @@ type AddressResponse struct {
 	Status       models.Status `json:"address_type"`
 	StartDate    time.Time     `json:"start_date"`
 	EndDate      string        `json:"end_date,omitempty"`
+	Recurrence   string        `json:"recurrence,omitempty"`
 	FirstName    string        `json:"first_name"`
 	LastName     string        `json:"last_name"`
 	BusinessName string        `json:"business_name,omitempty"`
@@ -105,6 +126,7 @@ type ToAndFromAddressSmartIDResponse struct {
 // AddressSmartIDResponse is used for creating, updating, and retrieving a single address
 type AddressSmartIDResponse struct {
 	SmartID              string      `json:"smart_id"`
+	DeliveryToken        string      `json:"delivery_token,omitempty"`
 	FirstName            string      `json:"first_name"`
 	LastName             string      `json:"last_name"`
 	BusinessName         string      `json:"business_name,omitempty"`
@@ -117,12 +139,23 @@ type AddressSmartIDResponse struct {
 	Country              string      `json:"country"`
 	Phone                null.String `json:"phone,omitempty"`
 	DeliveryInstructions string      `json:"delivery_instructions,omitempty"`
+	Held                 bool        `json:"held,omitempty"`
+	HeldUntil            string      `json:"held_until,omitempty"`
+	PickupLocation       string      `json:"pickup_location,omitempty"`
+	// AddressAssignmentID is what a carrier reports a failed delivery against, it is left out when only the zip code is returned
+	AddressAssignmentID uint64 `json:"address_assignment_id,omitempty"`
+	// Consent is set when the user has not allowed the requester to see their full address, only the zip code is returned
+	Consent models.GrantDecision `json:"consent,omitempty"`
+	// Warning is set when carriers have reported the address as undeliverable
+	Warning string `json:"warning,omitempty"`
 }
 
 // ZipResponse is to return a zip code to a retailer or mailer
 type ZipResponse struct {
-	SmartID string `json:"smart_id"`
-	ZipCode string `json:"zip_code"`
+	SmartID   string `json:"smart_id"`
+	ZipCode   string `json:"zip_code,omitempty"`
+	Held      bool   `json:"held,omitempty"`
+	HeldUntil string `json:"held_until,omitempty"`
 }
 
 // Contacts is the array of contacts response for a contact request
@@ -162,9 +195,45 @@ type SinglePackage struct {
 	Sender             SenderRecipient    `json:"sender"`
 	Recipient          SenderRecipient    `json:"recipient"`
 	Tracking           string             `json:"tracking"`
+	TrackingURL        string             `json:"tracking_url,omitempty"`
 	EstimatedDelivery  null.Time          `json:"estimatedDelivery"`
 	DeliveredOn        null.Time          `json:"delivered_on"`
 	PackageDescription PackageDescription `json:"package_description"`
+	LatestEvent        *PackageEvent      `json:"latest_event,omitempty"`
+	// AddressChanged is set when the recipient's address changed after the package was shipped and the carrier has yet to reroute it
+	AddressChanged bool `json:"address_changed"`
+}
+
+// PackageReroutesResponse lists a mail carrier's packages that need to be sent on to the recipient's new address
+type PackageReroutesResponse struct {
+	Reroutes []PackageReroute `json:"reroutes"`
+	Success  bool             `json:"success"`
+}
+
+// PackageReroute is a package with the address it was shipped to and the address it should go to now
+type PackageReroute struct {
+	Tracking          string                 `json:"tracking"`
+	EstimatedDelivery null.Time              `json:"estimated_delivery"`
+	From              AddressSmartIDResponse `json:"from"`
+	To                AddressSmartIDResponse `json:"to"`
+}
+
+// PackageEvent is a single tracking event
+type PackageEvent struct {
+	Status            models.PackageStatus `json:"status"`
+	Location          null.String          `json:"location,omitempty"`
+	EstimatedDelivery null.Time            `json:"estimated_delivery,omitempty"`
+	OccurredAt        time.Time            `json:"occurred_at"`
+	Source            string               `json:"source,omitempty"`
+}
+
+// PackageEventsResponse is the tracking timeline of a package
+type PackageEventsResponse struct {
+	Tracking  string               `json:"tracking"`
+	Status    models.PackageStatus `json:"status"`
+	Delivered bool                 `json:"delivered"`
+	Events    []PackageEvent       `json:"events"`
+	Success   bool                 `json:"success"`
 }
 
 // SenderRecipient contains information about the sender or recipient
@@ -206,6 +275,7 @@ func TranslateAddressResponse(originalAddress *models.AddressAssignment, reply *
 	if originalAddress.EndDate.Valid {
 		reply.EndDate = originalAddress.EndDate.Time.String()
 	}
+	reply.Recurrence = originalAddress.Recurrence.String
 	reply.FirstName = originalAddress.User.FirstName
 	reply.LastName = originalAddress.User.LastName
 	reply.BusinessName = originalAddress.Address.BusinessName.String
@@ -231,8 +301,16 @@ func TranslateToAndFromSmartAddressResponse(senderAddress *models.AddressAssignm
 // TranslateSmartAddressResponse converts an AddressAssignments into an AddressSmartIDResponse
 func TranslateSmartAddressResponse(originalAddress *models.AddressAssignment, reply *AddressSmartIDResponse) {
 	reply.SmartID = originalAddress.User.SmartID
+	reply.AddressAssignmentID = originalAddress.ID
 	reply.FirstName = originalAddress.User.FirstName
 	reply.LastName = originalAddress.User.LastName
+	// No address is given out while delivery is held, only when it resumes and where it can be picked up
+	if originalAddress.IsHold() {
+		reply.Held = true
+		reply.HeldUntil = originalAddress.EndDate.Time.Format("2006-01-02")
+		reply.PickupLocation = originalAddress.PickupLocation.String
+		return
+	}
 	reply.BusinessName = originalAddress.Address.BusinessName.String
 	reply.AttentionTo = originalAddress.Address.AttentionTo.String
 	reply.LineOne = originalAddress.Address.LineOne
@@ -248,9 +326,24 @@ func TranslateSmartAddressResponse(originalAddress *models.AddressAssignment, re
 	reply.DeliveryInstructions = originalAddress.Address.DeliveryInstructions.String
 }
 
+// TranslateTokenResponse converts a pair of auth tokens into a TokenResponse
+func TranslateTokenResponse(tokens auth.TokenPair) TokenResponse {
+	return TokenResponse{
+		Token:          tokens.Token,
+		Expires:        tokens.Expires,
+		RefreshToken:   tokens.RefreshToken,
+		RefreshExpires: tokens.RefreshExpires,
+	}
+}
+
 // TranslateZipResponse converts an AddressAssignment into a ZipResponse
 func TranslateZipResponse(originalAddress *models.AddressAssignment, reply *ZipResponse) {
 	reply.SmartID = originalAddress.User.SmartID
+	if originalAddress.IsHold() {
+		reply.Held = true
+		reply.HeldUntil = originalAddress.EndDate.Time.Format("2006-01-02")
+		return
+	}
 	reply.ZipCode = originalAddress.Address.ZipCode
 }
 
@@ -269,6 +362,8 @@ func TranslateUserAndAddressResponse(originalAddress *models.AddressAssignment,
 		Nickname:             originalAddress.Address.Nickname,
 		Status:               originalAddress.Status,
 		StartDate:            originalAddress.StartDate,
+		Recurrence:           originalAddress.Recurrence.String,
+		PickupLocation:       originalAddress.PickupLocation.String,
 		BusinessName:         originalAddress.Address.BusinessName.String,
 		AttentionTo:          originalAddress.Address.AttentionTo.String,
 		LineOne:              originalAddress.Address.LineOne,
@@ -298,6 +393,8 @@ func TranslateAddress(originalAddress *models.AddressAssignment) (address BasicA
 		Nickname:             originalAddress.Address.Nickname,
 		Status:               originalAddress.Status,
 		StartDate:            originalAddress.StartDate,
+		Recurrence:           originalAddress.Recurrence.String,
+		PickupLocation:       originalAddress.PickupLocation.String,
 		BusinessName:         originalAddress.Address.BusinessName.String,
 		AttentionTo:          originalAddress.Address.AttentionTo.String,
 		LineOne:              originalAddress.Address.LineOne,
@@ -349,16 +446,16 @@ func TranslateContact(originalContact models.Contact) (contact Contact) {
 }
 
 // TranslatePackagesResponse converts an array of packages into an array of package responses
-func TranslatePreviewPackagesResponse(openPackages []models.Package, deliveredPackages []models.Package) (userPackages PackagesPreviewResponse) {
+func TranslatePreviewPackagesResponse(openPackages []models.Package, deliveredPackages []models.Package, carriers models.CarrierRegistry) (userPackages PackagesPreviewResponse) {
 	// translate open packages
 	for _, singlePackage := range openPackages {
-		nextPackage := TranslatePackage(singlePackage)
+		nextPackage := TranslatePackage(singlePackage, carriers)
 		userPackages.OpenPackages = append(userPackages.OpenPackages, nextPackage)
 	}
 
 	// translate delivered packages
 	for _, singlePackage := range deliveredPackages {
-		nextPackage := TranslatePackage(singlePackage)
+		nextPackage := TranslatePackage(singlePackage, carriers)
 		userPackages.DeliveredPackages = append(userPackages.DeliveredPackages, nextPackage)
 	}
 
@@ -368,10 +465,10 @@ func TranslatePreviewPackagesResponse(openPackages []models.Package, deliveredPa
 }
 
 // TranslatePackagesResponse converts an array of packages into an array of package responses
-func TranslatePackagesResponse(userPackages []models.Package) (requestedPackages PackagesResponse) {
+func TranslatePackagesResponse(userPackages []models.Package, carriers models.CarrierRegistry) (requestedPackages PackagesResponse) {
 	// translate packages
 	for _, singlePackage := range userPackages {
-		nextPackage := TranslatePackage(singlePackage)
+		nextPackage := TranslatePackage(singlePackage, carriers)
 		requestedPackages.RequestedPackages = append(requestedPackages.RequestedPackages, nextPackage)
 	}
 
@@ -380,9 +477,13 @@ func TranslatePackagesResponse(userPackages []models.Package) (requestedPackages
 	return
 }
 
-// TranslatePackage converts a single package into a package response
-func TranslatePackage(originalPackage models.Package) (newPackage SinglePackage) {
+// TranslatePackage converts a single package into a package response. The carrier's name and tracking link come from the carrier registry
+func TranslatePackage(originalPackage models.Package, carriers models.CarrierRegistry) (newPackage SinglePackage) {
 	newPackage.MailCarrier = originalPackage.MailCarrier.Name
+	if carrier, ok := carriers[originalPackage.MailCarrierID]; ok {
+		newPackage.MailCarrier = carrier.Name
+		newPackage.TrackingURL = carrier.TrackingLink(originalPackage.Tracking.String)
+	}
 	if originalPackage.SenderID.Valid {
 		newPackage.Sender = SenderRecipient{
 			Name:        null.StringFrom(originalPackage.Sender.FirstName + " " + originalPackage.Sender.LastName),
@@ -415,6 +516,47 @@ func TranslatePackage(originalPackage models.Package) (newPackage SinglePackage)
 			OrderLink:  originalPackage.PackageDescription.OrderLink,
 		}
 	}
+	if latestEvent, ok := models.LatestPackageEvent(originalPackage.Events); ok {
+		event := TranslatePackageEvent(latestEvent)
+		newPackage.LatestEvent = &event
+	}
+	newPackage.AddressChanged = originalPackage.AddressChanged
+	return
+}
+
+// TranslatePackageReroute converts a package reroute into a reroute response, both addresses are limited to the zip code unless the decision allows the full address
+func TranslatePackageReroute(reroute models.PackageReroute, decision models.GrantDecision) (newReroute PackageReroute) {
+	newReroute.Tracking = reroute.Package.Tracking.String
+	newReroute.EstimatedDelivery = reroute.Package.EstimatedDelivery
+	TranslateSmartAddressResponse(&reroute.From, &newReroute.From)
+	RestrictToZip(&newReroute.From, decision)
+	TranslateSmartAddressResponse(&reroute.To, &newReroute.To)
+	RestrictToZip(&newReroute.To, decision)
+	return
+}
+
+// TranslatePackageEvent converts a single package event into a package event response
+func TranslatePackageEvent(originalEvent models.PackageEvent) PackageEvent {
+	return PackageEvent{
+		Status:            originalEvent.Status,
+		Location:          originalEvent.Location,
+		EstimatedDelivery: originalEvent.EstimatedDelivery,
+		OccurredAt:        originalEvent.OccurredAt,
+		Source:            originalEvent.Source.Name,
+	}
+}
+
+// TranslatePackageEventsResponse converts a package's events into its tracking timeline
+func TranslatePackageEventsResponse(originalPackage models.Package, events []models.PackageEvent) (timeline PackageEventsResponse) {
+	timeline.Tracking = originalPackage.Tracking.String
+	state := models.DerivePackageState(events)
+	timeline.Status = state.Status
+	timeline.Delivered = state.Delivered
+	timeline.Events = []PackageEvent{}
+	for _, event := range models.SortPackageEvents(events) {
+		timeline.Events = append(timeline.Events, TranslatePackageEvent(event))
+	}
+	timeline.Success = true
 	return
 }
 
@@ -429,3 +571,437 @@ func TranslateSingleUser(originalUser models.User) (user UserResponse) {
 	user.User.CreatedAt = originalUser.CreatedAt
 	return
 }
+
+// CarriersResponse is the list of carriers in the carrier registry
+type CarriersResponse struct {
+	Carriers []models.Carrier `json:"carriers"`
+	Success  bool             `json:"success"`
+}
+
+// AddressDisclosuresResponse is a page of the address disclosure log
+type AddressDisclosuresResponse struct {
+	Success     bool                `json:"success"`
+	Count       int64               `json:"count"`
+	Disclosures []AddressDisclosure `json:"disclosures"`
+}
+
+// AddressDisclosure is a single time an API user was shown a user's address or zip code, or a retailer their zip code.
+// Only one of APIUserID and RetailerID is set
+type AddressDisclosure struct {
+	ID                  uint64            `json:"id"`
+	RequestedBy         string            `json:"requested_by"`
+	APIUserID           *uuid.UUID        `json:"api_user_id,omitempty"`
+	RetailerID          *uuid.UUID        `json:"retailer_id,omitempty"`
+	UserID              uuid.UUID         `json:"user_id"`
+	AddressAssignmentID uint64            `json:"address_assignment_id"`
+	Scope               models.Scope      `json:"scope"`
+	Permission          models.Permission `json:"permission"`
+	Tracking            null.String       `json:"tracking"`
+	DeliveryTokenID     null.Int          `json:"delivery_token_id"`
+	DisclosedAt         time.Time         `json:"disclosed_at"`
+}
+
+// TranslateAddressDisclosures converts a page of disclosures into a disclosures response
+func TranslateAddressDisclosures(count int64, originalDisclosures []models.AddressDisclosure) (reply AddressDisclosuresResponse) {
+	reply.Disclosures = []AddressDisclosure{}
+	for _, disclosure := range originalDisclosures {
+		translated := AddressDisclosure{
+			ID:                  disclosure.ID,
+			UserID:              disclosure.UserID,
+			AddressAssignmentID: disclosure.AddressAssignmentID,
+			Scope:               disclosure.Scope,
+			Permission:          disclosure.Permission,
+			Tracking:            disclosure.Tracking,
+			DeliveryTokenID:     disclosure.DeliveryTokenID,
+			DisclosedAt:         disclosure.DisclosedAt,
+		}
+		if disclosure.APIUserID.Valid {
+			apiUserID := disclosure.APIUserID.UUID
+			translated.APIUserID = &apiUserID
+			translated.RequestedBy = disclosure.APIUser.Name
+		}
+		if disclosure.RetailerID.Valid {
+			retailerID := disclosure.RetailerID.UUID
+			translated.RetailerID = &retailerID
+			translated.RequestedBy = strings.TrimSpace(disclosure.Retailer.FirstName + " " + disclosure.Retailer.LastName)
+		}
+		reply.Disclosures = append(reply.Disclosures, translated)
+	}
+	reply.Count = count
+	reply.Success = true
+	return
+}
+
+// RestrictToZip removes everything but the SmartID, zip code and hold dates from an address the requester may not see in full
+func RestrictToZip(reply *AddressSmartIDResponse, decision models.GrantDecision) {
+	if decision == models.GrantAllow {
+		return
+	}
+	*reply = AddressSmartIDResponse{
+		SmartID:   reply.SmartID,
+		ZipCode:   reply.ZipCode,
+		Held:      reply.Held,
+		HeldUntil: reply.HeldUntil,
+		Consent:   decision,
+	}
+}
+
+// HideSmartID replaces the SmartID in a reply with the delivery token the address was looked up with,
+// so that a shipper given a token never learns the user's SmartID
+func HideSmartID(reply *AddressSmartIDResponse, token *models.DeliveryToken) {
+	if token == nil {
+		return
+	}
+	reply.SmartID = ""
+	reply.DeliveryToken = token.Token
+}
+
+// ShareGrantsResponse is the list of a user's address sharing grants
+type ShareGrantsResponse struct {
+	Success bool         `json:"success"`
+	Grants  []ShareGrant `json:"grants"`
+}
+
+// ShareGrant is a user's decision about sharing their full address with a shipper or retailer
+type ShareGrant struct {
+	ID          uint64               `json:"id"`
+	APIUserID   uuid.UUID            `json:"api_user_id"`
+	Name        string               `json:"name"`
+	Decision    models.GrantDecision `json:"decision"`
+	Pending     bool                 `json:"pending"`
+	ExpiresAt   null.Time            `json:"expires_at"`
+	RequestedAt null.Time            `json:"requested_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+}
+
+// TranslateShareGrant converts a grant into a grant response
+func TranslateShareGrant(originalGrant models.ShareGrant) ShareGrant {
+	return ShareGrant{
+		ID:          originalGrant.ID,
+		APIUserID:   originalGrant.APIUserID,
+		Name:        originalGrant.APIUser.Name,
+		Decision:    originalGrant.Decision,
+		Pending:     originalGrant.Effective(time.Now()) == models.GrantAsk,
+		ExpiresAt:   originalGrant.ExpiresAt,
+		RequestedAt: originalGrant.RequestedAt,
+		UpdatedAt:   originalGrant.UpdatedAt,
+	}
+}
+
+// TranslateShareGrants converts a user's grants into a grants response
+func TranslateShareGrants(originalGrants []models.ShareGrant) (reply ShareGrantsResponse) {
+	reply.Grants = []ShareGrant{}
+	for _, grant := range originalGrants {
+		reply.Grants = append(reply.Grants, TranslateShareGrant(grant))
+	}
+	reply.Success = true
+	return
+}
+
+// DeliveryTokensResponse is the list of delivery tokens a user has minted
+type DeliveryTokensResponse struct {
+	Success bool            `json:"success"`
+	Tokens  []DeliveryToken `json:"delivery_tokens"`
+}
+
+// DeliveryToken is a token a user has given one shipper in place of their SmartID
+type DeliveryToken struct {
+	ID        uint64              `json:"id"`
+	Token     string              `json:"token"`
+	APIUserID uuid.UUID           `json:"api_user_id"`
+	Name      string              `json:"name"`
+	Kind      models.DeliveryKind `json:"kind"`
+	MaxUses   null.Int            `json:"max_uses"`
+	Uses      int64               `json:"uses"`
+	ExpiresAt null.Time           `json:"expires_at"`
+	RevokedAt null.Time           `json:"revoked_at"`
+	Active    bool                `json:"active"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// TranslateDeliveryToken converts a delivery token into a delivery token response
+func TranslateDeliveryToken(originalToken models.DeliveryToken) DeliveryToken {
+	return DeliveryToken{
+		ID:        originalToken.ID,
+		Token:     originalToken.Token,
+		APIUserID: originalToken.APIUserID,
+		Name:      originalToken.APIUser.Name,
+		Kind:      originalToken.Kind,
+		MaxUses:   originalToken.MaxUses,
+		Uses:      originalToken.Uses,
+		ExpiresAt: originalToken.ExpiresAt,
+		RevokedAt: originalToken.RevokedAt,
+		Active:    originalToken.Usable(originalToken.APIUserID, originalToken.Kind, time.Now()),
+		CreatedAt: originalToken.CreatedAt,
+	}
+}
+
+// TranslateDeliveryTokens converts a user's delivery tokens into a delivery tokens response
+func TranslateDeliveryTokens(originalTokens []models.DeliveryToken) (reply DeliveryTokensResponse) {
+	reply.Tokens = []DeliveryToken{}
+	for _, token := range originalTokens {
+		reply.Tokens = append(reply.Tokens, TranslateDeliveryToken(token))
+	}
+	reply.Success = true
+	return
+}
+
+// WebhooksResponse is the list of an API user's webhooks
+type WebhooksResponse struct {
+	Success  bool      `json:"success"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// Webhook is an endpoint an API user is sent address changes at. Secret is only set when the webhook is created
+type Webhook struct {
+	ID        uint64               `json:"id"`
+	URL       string               `json:"url"`
+	Events    models.WebhookEvents `json:"events"`
+	Secret    string               `json:"secret,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// TranslateWebhook converts a webhook into a webhook response, leaving out its secret
+func TranslateWebhook(originalWebhook models.Webhook) Webhook {
+	events := originalWebhook.Events
+	if events == nil {
+		events = models.WebhookEvents{}
+	}
+	return Webhook{
+		ID:        originalWebhook.ID,
+		URL:       originalWebhook.URL,
+		Events:    events,
+		CreatedAt: originalWebhook.CreatedAt,
+	}
+}
+
+// TranslateWebhooks converts an API user's webhooks into a webhooks response
+func TranslateWebhooks(originalWebhooks []models.Webhook) (reply WebhooksResponse) {
+	reply.Webhooks = []Webhook{}
+	for _, webhook := range originalWebhooks {
+		reply.Webhooks = append(reply.Webhooks, TranslateWebhook(webhook))
+	}
+	reply.Success = true
+	return
+}
+
+// WebhookDeliveriesResponse is a list of webhook deliveries
+type WebhookDeliveriesResponse struct {
+	Success    bool              `json:"success"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookDelivery is one event sent to a webhook and the result of its last attempt
+type WebhookDelivery struct {
+	ID          uint64              `json:"id"`
+	WebhookID   uint64              `json:"webhook_id"`
+	Event       models.WebhookEvent `json:"event"`
+	Payload     json.RawMessage     `json:"payload"`
+	Attempts    int                 `json:"attempts"`
+	LastStatus  null.Int            `json:"last_status"`
+	LastError   null.String         `json:"last_error"`
+	DeliveredAt null.Time           `json:"delivered_at"`
+	DeadAt      null.Time           `json:"dead_at"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+// TranslateWebhookDeliveries converts webhook deliveries into a deliveries response
+func TranslateWebhookDeliveries(originalDeliveries []models.WebhookDelivery) (reply WebhookDeliveriesResponse) {
+	reply.Deliveries = []WebhookDelivery{}
+	for _, delivery := range originalDeliveries {
+		reply.Deliveries = append(reply.Deliveries, WebhookDelivery{
+			ID:          delivery.ID,
+			WebhookID:   delivery.WebhookID,
+			Event:       delivery.Event,
+			Payload:     json.RawMessage(delivery.Payload),
+			Attempts:    delivery.Attempts,
+			LastStatus:  delivery.LastStatus,
+			LastError:   delivery.LastError,
+			DeliveredAt: delivery.DeliveredAt,
+			DeadAt:      delivery.DeadAt,
+			CreatedAt:   delivery.CreatedAt,
+		})
+	}
+	reply.Success = true
+	return
+}
+
+// MailingJob is the status of a mailing list upload. Progress is the percentage of rows resolved
+type MailingJob struct {
+	ID            uuid.UUID               `json:"id"`
+	FileName      string                  `json:"file_name"`
+	Status        models.MailingJobStatus `json:"status"`
+	TotalRows     int                     `json:"total_rows"`
+	ProcessedRows int                     `json:"processed_rows"`
+	FailedRows    int                     `json:"failed_rows"`
+	Progress      float64                 `json:"progress"`
+	Error         null.String             `json:"error"`
+	CreatedAt     time.Time               `json:"created_at"`
+	StartedAt     null.Time               `json:"started_at"`
+	FinishedAt    null.Time               `json:"finished_at"`
+	ExpiresAt     time.Time               `json:"expires_at"`
+}
+
+// TranslateMailingJob converts a mailing job into a mailing job response
+func TranslateMailingJob(originalJob models.MailingJob) MailingJob {
+	progress := 0.0
+	if originalJob.TotalRows > 0 {
+		progress = float64(originalJob.ProcessedRows*100) / float64(originalJob.TotalRows)
+	}
+	return MailingJob{
+		ID:            originalJob.ID,
+		FileName:      originalJob.FileName,
+		Status:        originalJob.Status,
+		TotalRows:     originalJob.TotalRows,
+		ProcessedRows: originalJob.ProcessedRows,
+		FailedRows:    originalJob.FailedRows,
+		Progress:      progress,
+		Error:         originalJob.Error,
+		CreatedAt:     originalJob.CreatedAt,
+		StartedAt:     originalJob.StartedAt,
+		FinishedAt:    originalJob.FinishedAt,
+		ExpiresAt:     originalJob.ExpiresAt,
+	}
+}
+
+// Order is a retailer's order. Recipient is only given to the carrier the order was handed off to, the retailer gets the zip code
+type Order struct {
+	ID          uuid.UUID               `json:"id"`
+	Reference   string                  `json:"reference"`
+	ShipDate    string                  `json:"ship_date"`
+	ZipCode     string                  `json:"zip_code"`
+	Status      models.OrderStatus      `json:"status"`
+	Carrier     string                  `json:"carrier,omitempty"`
+	Tracking    null.String             `json:"tracking"`
+	HandedOffAt null.Time               `json:"handed_off_at"`
+	ShippedAt   null.Time               `json:"shipped_at"`
+	CreatedAt   time.Time               `json:"created_at"`
+	Recipient   *AddressSmartIDResponse `json:"recipient,omitempty"`
+}
+
+// TranslateOrder converts an order into an order response, carrier is the code of the carrier it was handed off to
+func TranslateOrder(originalOrder models.Order, carrier string) Order {
+	return Order{
+		ID:          originalOrder.ID,
+		Reference:   originalOrder.Reference,
+		ShipDate:    originalOrder.ShipDate.Format("2006-01-02"),
+		ZipCode:     originalOrder.ZipCode,
+		Status:      originalOrder.Status,
+		Carrier:     carrier,
+		Tracking:    originalOrder.Tracking,
+		HandedOffAt: originalOrder.HandedOffAt,
+		ShippedAt:   originalOrder.ShippedAt,
+		CreatedAt:   originalOrder.CreatedAt,
+	}
+}
+
+// RatesResponse is the estimated price of a package with each carrier that has a rate table. Only the zip codes of the origin
+// and destination are given, the distance between them is left out so it cannot be used to narrow down the street address
+type RatesResponse struct {
+	OriginZip      string `json:"origin_zip"`
+	DestinationZip string `json:"destination_zip"`
+	ShipDate       string `json:"ship_date"`
+	Rates          []Rate `json:"rates"`
+}
+
+// Rate is a carrier's estimated price, Error is set instead when the carrier cannot take the package
+type Rate struct {
+	Carrier        string  `json:"carrier"`
+	Name           string  `json:"name"`
+	Zone           int     `json:"zone,omitempty"`
+	BillableWeight float64 `json:"billable_weight,omitempty"`
+	Price          float64 `json:"price,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// DeliveryRoute is the order a carrier should deliver its stops in, starting and ending at its depot. Miles includes the
+// drive back to the depot. Stops that cannot be delivered to are listed in Unroutable with the reason
+type DeliveryRoute struct {
+	Date        string           `json:"date"`
+	Miles       float64          `json:"miles"`
+	ReturnMiles float64          `json:"return_miles"`
+	Stops       []RouteStop      `json:"stops"`
+	Unroutable  []UnroutableStop `json:"unroutable"`
+}
+
+// RouteStop is a stop on a delivery route. Index is its position in the request and LegMiles the distance from the stop before it
+type RouteStop struct {
+	Index    int                     `json:"index"`
+	Tracking string                  `json:"tracking,omitempty"`
+	SmartID  string                  `json:"smart_id,omitempty"`
+	LegMiles float64                 `json:"leg_miles"`
+	Address  *AddressSmartIDResponse `json:"address"`
+}
+
+// UnroutableStop is a stop left off a delivery route. Address is set when one was found, e.g. a hold's pickup location
+type UnroutableStop struct {
+	Index    int                     `json:"index"`
+	Tracking string                  `json:"tracking,omitempty"`
+	SmartID  string                  `json:"smart_id,omitempty"`
+	Error    string                  `json:"error"`
+	Address  *AddressSmartIDResponse `json:"address,omitempty"`
+}
+
+// DeliveryFailuresResponse is the list of failed deliveries carriers have reported against a user's addresses
+type DeliveryFailuresResponse struct {
+	Success  bool              `json:"success"`
+	Failures []DeliveryFailure `json:"failures"`
+}
+
+// DeliveryFailure is a carrier's report that it could not deliver to an address. Address is only set for the user it belongs to
+type DeliveryFailure struct {
+	ID                  uint64               `json:"id"`
+	AddressAssignmentID uint64               `json:"address_assignment_id"`
+	Carrier             string               `json:"carrier"`
+	Reason              models.FailureReason `json:"reason"`
+	Tracking            null.String          `json:"tracking"`
+	Seen                bool                 `json:"seen"`
+	ReviewDecision      string               `json:"review_decision,omitempty"`
+	CreatedAt           time.Time            `json:"created_at"`
+	Address             *BasicAddress        `json:"address,omitempty"`
+}
+
+// TranslateDeliveryFailure converts a delivery failure report into a delivery failure response
+func TranslateDeliveryFailure(originalFailure models.DeliveryFailure) DeliveryFailure {
+	return DeliveryFailure{
+		ID:                  originalFailure.ID,
+		AddressAssignmentID: originalFailure.AddressAssignmentID,
+		Carrier:             originalFailure.Carrier.Name,
+		Reason:              originalFailure.Reason,
+		Tracking:            originalFailure.Tracking,
+		Seen:                originalFailure.SeenAt.Valid,
+		ReviewDecision:      string(originalFailure.ReviewDecision),
+		CreatedAt:           originalFailure.CreatedAt,
+	}
+}
+
+// TranslateDeliveryFailures converts delivery failure reports into a list of delivery failure responses
+func TranslateDeliveryFailures(originalFailures *[]models.DeliveryFailure) []DeliveryFailure {
+	failures := []DeliveryFailure{}
+	for _, df := range *originalFailures {
+		failures = append(failures, TranslateDeliveryFailure(df))
+	}
+	return failures
+}
+
+// DeliveryConfidence is an address's confidence score after a carrier reported a failed delivery to it
+type DeliveryConfidence struct {
+	Failure     DeliveryFailure `json:"failure"`
+	Confidence  float64         `json:"confidence"`
+	UnderReview bool            `json:"under_review"`
+}
+
+// DeliveryReview is an address in the admin review queue, with the reports that brought it there
+type DeliveryReview struct {
+	AddressAssignmentID uint64            `json:"address_assignment_id"`
+	UserID              uuid.UUID         `json:"user_id"`
+	SmartID             string            `json:"smart_id"`
+	Confidence          float64           `json:"confidence"`
+	Address             BasicAddress      `json:"address"`
+	Failures            []DeliveryFailure `json:"failures"`
+}
+
+// DeliveryReviewsResponse is the admin review queue, lowest confidence first
+type DeliveryReviewsResponse struct {
+	Reviews []DeliveryReview `json:"reviews"`
+}
@@ -0,0 +1,94 @@
+// Package routing orders a carrier's delivery stops into a short tour from a depot. The tour is built with the nearest
+// neighbour heuristic and then improved with 2-opt, which is fast enough for a few hundred stops per request
+package routing
+
+import (
+	"github.com/nmelhado/smartmail-api/api/geocode"
+)
+
+// maxTwoOptPasses bounds how many times 2-opt sweeps the tour, each pass is O(n²). It rarely needs more than a few
+const maxTwoOptPasses = 50
+
+// Route is the order to visit the stops in, starting and ending at the depot. Order holds indexes into the stops that were
+// optimized, Legs[i] is the distance in miles to the stop at Order[i] from the one before it, and Return is the distance back
+// to the depot from the last stop
+type Route struct {
+	Order  []int
+	Legs   []float64
+	Return float64
+	Miles  float64
+}
+
+// Optimize returns a short tour of the stops that starts and ends at the depot
+func Optimize(depot geocode.Location, stops []geocode.Location) Route {
+	if len(stops) == 0 {
+		return Route{Order: []int{}, Legs: []float64{}}
+	}
+
+	// Position 0 of the distance matrix and the tour is the depot, stop i is at position i+1
+	points := append([]geocode.Location{depot}, stops...)
+	distances := make([][]float64, len(points))
+	for i := range points {
+		distances[i] = make([]float64, len(points))
+		for j := 0; j < i; j++ {
+			distances[i][j] = geocode.Miles(points[i], points[j])
+			distances[j][i] = distances[i][j]
+		}
+	}
+
+	tour := nearestNeighbour(distances)
+	twoOpt(tour, distances)
+
+	route := Route{Order: make([]int, len(stops)), Legs: make([]float64, len(stops))}
+	for i, point := range tour[1:] {
+		route.Order[i] = point - 1
+		route.Legs[i] = distances[tour[i]][point]
+		route.Miles += route.Legs[i]
+	}
+	route.Return = distances[tour[len(tour)-1]][0]
+	route.Miles += route.Return
+	return route
+}
+
+// nearestNeighbour builds a tour from the depot by always driving to the closest stop that has not been visited
+func nearestNeighbour(distances [][]float64) []int {
+	tour := make([]int, 1, len(distances))
+	visited := make([]bool, len(distances))
+	visited[0] = true
+	for len(tour) < len(distances) {
+		current := tour[len(tour)-1]
+		next := -1
+		for candidate, seen := range visited {
+			if !seen && (next == -1 || distances[current][candidate] < distances[current][next]) {
+				next = candidate
+			}
+		}
+		visited[next] = true
+		tour = append(tour, next)
+	}
+	return tour
+}
+
+// twoOpt reverses sections of the tour while doing so shortens it. The tour returns to the depot, which stays first
+func twoOpt(tour []int, distances [][]float64) {
+	n := len(tour)
+	for pass := 0; pass < maxTwoOptPasses; pass++ {
+		improved := false
+		for i := 1; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				a, b := tour[i-1], tour[i]
+				c, d := tour[j], tour[(j+1)%n]
+				// Swapping the edges a-b and c-d for a-c and b-d reverses the stops from b to c
+				if distances[a][c]+distances[b][d] < distances[a][b]+distances[c][d]-1e-9 {
+					for left, right := i, j; left < right; left, right = left+1, right-1 {
+						tour[left], tour[right] = tour[right], tour[left]
+					}
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a task that the scheduler runs on an interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// Scheduler runs background jobs while the server is up. Each job runs once when the scheduler starts and then every Interval
+type Scheduler struct {
+	jobs []Job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a scheduler for the given jobs
+func New(jobs ...Job) *Scheduler {
+	return &Scheduler{jobs: jobs}
+}
+
+// Start runs every job in its own goroutine until Stop is called
+func (s *Scheduler) Start() {
+	s.stop = make(chan struct{})
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// Stop signals every job to stop and waits for any job that is running to finish
+func (s *Scheduler) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.stop = nil
+}
+
+func (s *Scheduler) loop(job Job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		err := job.Run()
+		if err != nil {
+			log.Printf("Scheduled job %s failed: %v", job.Name, err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/nmelhado/smartmail-api/api/controllers"
@@ -19,6 +21,7 @@ func init() {
 	}
 }
 
+// Run starts the API server, or runs an admin command if one is given (e.g. `smartmail-api expire-addresses` or `smartmail-api migrate up`)
 func Run() {
 
 	if os.Getenv("APP_ENV") != "production" {
@@ -31,6 +34,11 @@ func Run() {
 		}
 	}
 
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	server.Initialize(os.Getenv("DB_DRIVER"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_PORT"), os.Getenv("DB_HOST"), os.Getenv("PROD_DB_HOST"), os.Getenv("DB_NAME"))
 
 	// seed.Load(server.DB)
@@ -38,3 +46,74 @@ func Run() {
 	server.Run(":8080")
 
 }
+
+// runCommand runs a one-shot admin command against the DB and exits
+func runCommand(args []string) {
+	server.Connect(os.Getenv("DB_DRIVER"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_PORT"), os.Getenv("DB_HOST"), os.Getenv("PROD_DB_HOST"), os.Getenv("DB_NAME"))
+	defer server.DB.Close()
+
+	switch args[0] {
+	case "expire-addresses":
+		_, err := server.ExpireAddresses()
+		if err != nil {
+			log.Fatal("Unable to expire addresses: ", err)
+		}
+	case "migrate":
+		runMigrate(args[1:])
+	default:
+		log.Fatalf("Unknown command: %s\nAvailable commands: expire-addresses, migrate", args[0])
+	}
+}
+
+// runMigrate applies, rolls back or lists the DB migrations (`migrate up`, `migrate down [steps]` or `migrate status`)
+func runMigrate(args []string) {
+	migrator, err := server.Migrator()
+	if err != nil {
+		log.Fatal("Unable to load the migrations: ", err)
+	}
+
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Applied %d migrations\n", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps: %s", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		log.Fatalf("Unknown migrate command: %s\nUsage: migrate up|down [steps]|status", args[0])
+	}
+}
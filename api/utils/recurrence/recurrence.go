@@ -0,0 +1,96 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rules are written as strings so they can be stored on an address assignment:
+//   yearly:11-01/04-30   every year from Nov 1 through Apr 30 (ranges may wrap the new year)
+//   weekly:sat,sun       every Saturday and Sunday
+
+// ErrInvalidRule is returned when a recurrence rule can not be parsed
+var ErrInvalidRule = errors.New("Invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Rule is a parsed recurrence rule
+type Rule struct {
+	yearly   bool
+	from     int // month*100 + day
+	to       int // month*100 + day
+	weekdays [7]bool
+	raw      string
+}
+
+// Parse reads a recurrence rule
+func Parse(rule string) (Rule, error) {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	parts := strings.SplitN(rule, ":", 2)
+	if len(parts) != 2 {
+		return Rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, rule)
+	}
+	switch parts[0] {
+	case "yearly":
+		days := strings.Split(parts[1], "/")
+		if len(days) != 2 {
+			return Rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, rule)
+		}
+		from, err := parseMonthDay(days[0])
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, rule)
+		}
+		to, err := parseMonthDay(days[1])
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, rule)
+		}
+		return Rule{yearly: true, from: from, to: to, raw: rule}, nil
+	case "weekly":
+		r := Rule{raw: rule}
+		for _, day := range strings.Split(parts[1], ",") {
+			weekday, ok := weekdays[strings.TrimSpace(day)]
+			if !ok {
+				return Rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, rule)
+			}
+			r.weekdays[weekday] = true
+		}
+		return r, nil
+	}
+	return Rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, rule)
+}
+
+// parseMonthDay reads an MM-DD date. Feb 29 is allowed
+func parseMonthDay(monthDay string) (int, error) {
+	date, err := time.Parse("2006-01-02", "2000-"+strings.TrimSpace(monthDay))
+	if err != nil {
+		return 0, err
+	}
+	return int(date.Month())*100 + date.Day(), nil
+}
+
+// Matches returns true if the rule is in effect on the date
+func (r Rule) Matches(date time.Time) bool {
+	if !r.yearly {
+		return r.weekdays[date.Weekday()]
+	}
+	monthDay := int(date.Month())*100 + date.Day()
+	if r.from <= r.to {
+		return monthDay >= r.from && monthDay <= r.to
+	}
+	return monthDay >= r.from || monthDay <= r.to
+}
+
+// String returns the rule as it was parsed
+func (r Rule) String() string {
+	return r.raw
+}
@@ -0,0 +1,79 @@
+package smartid
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Alphabet is the set of characters a SmartID can be made of. I, S, Z and O are left out because they
+// are too easily confused with 1, 5, 2 and 0 (see sanitizeSmartID in the controllers package)
+const Alphabet = "0123456789ABCDEFGHJKLMNPQRTUVWXY"
+
+// Length is the total length of a SmartID, including the trailing check character
+const Length = 8
+
+var (
+	// ErrInvalidFormat is returned when a SmartID has the wrong length or contains characters outside of the Alphabet
+	ErrInvalidFormat = errors.New("Invalid smartID format")
+	// ErrInvalidCheckDigit is returned when a SmartID is well formed but its check character does not match (usually a typo)
+	ErrInvalidCheckDigit = errors.New("Invalid check digit")
+)
+
+// Generate creates a random SmartID made of Length-1 random characters followed by a check character.
+// Generate does not check for collisions, see models.GenerateSmartID
+func Generate() (string, error) {
+	max := big.NewInt(int64(len(Alphabet)))
+	var payload strings.Builder
+	for i := 0; i < Length-1; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		payload.WriteByte(Alphabet[n.Int64()])
+	}
+	check, err := CheckCharacter(payload.String())
+	if err != nil {
+		return "", err
+	}
+	payload.WriteByte(check)
+	return payload.String(), nil
+}
+
+// CheckCharacter calculates the check character for a SmartID payload using the Luhn mod N algorithm.
+// This catches every single character typo and most swaps of two adjacent characters
+func CheckCharacter(payload string) (byte, error) {
+	n := len(Alphabet)
+	factor := 2
+	sum := 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		codePoint := strings.IndexByte(Alphabet, payload[i])
+		if codePoint < 0 {
+			return 0, ErrInvalidFormat
+		}
+		addend := factor * codePoint
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+		sum += addend/n + addend%n
+	}
+	return Alphabet[(n-sum%n)%n], nil
+}
+
+// Validate confirms that a sanitized SmartID is well formed and that its check character is correct
+func Validate(smartID string) error {
+	if len(smartID) != Length {
+		return ErrInvalidFormat
+	}
+	check, err := CheckCharacter(smartID[:Length-1])
+	if err != nil {
+		return err
+	}
+	if check != smartID[Length-1] {
+		return ErrInvalidCheckDigit
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package tracking
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Carrier codes match the codes in the carrier registry
+const (
+	UPS       = "UPS"
+	USPS      = "USPS"
+	FedEx     = "FEDEX"
+	LaserShip = "LASERSHIP"
+)
+
+// ErrInvalidTracking is returned when a tracking number does not match its carrier's format or check digit
+var ErrInvalidTracking = errors.New("Invalid tracking number")
+
+// ErrUnknownCarrier is returned when no carrier's format matches a tracking number
+var ErrUnknownCarrier = errors.New("Unable to detect the carrier for tracking number")
+
+var (
+	upsFormat       = regexp.MustCompile(`^1Z[0-9A-Z]{16}$`)
+	uspsFormat      = regexp.MustCompile(`^(420[0-9]{5}([0-9]{4})?)?(9[1-5][0-9]{20})$`)
+	s10Format       = regexp.MustCompile(`^[A-Z]{2}[0-9]{9}US$`)
+	fedExFormat     = regexp.MustCompile(`^([0-9]{12}|[0-9]{15}|96[0-9]{20})$`)
+	laserShipFormat = regexp.MustCompile(`^(1LS[0-9A-Z]{12,}|L[A-Z][0-9]{8})$`)
+)
+
+// validators check the format and check digit of each carrier's tracking numbers, in the order carriers are detected
+var validators = []struct {
+	carrier  string
+	validate func(tracking string) bool
+}{
+	{UPS, validUPS},
+	{USPS, validUSPS},
+	{FedEx, validFedEx},
+	{LaserShip, validLaserShip},
+}
+
+// Normalize upper-cases a tracking number and removes the spaces and dashes people add when copying it
+func Normalize(tracking string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(strings.TrimSpace(tracking)))
+}
+
+// Supports returns true if this package knows the tracking number format for the carrier
+func Supports(carrier string) bool {
+	for _, v := range validators {
+		if v.carrier == strings.ToUpper(carrier) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a normalized tracking number against a carrier's format and check digit
+func Validate(carrier string, tracking string) error {
+	for _, v := range validators {
+		if v.carrier == strings.ToUpper(carrier) {
+			if !v.validate(tracking) {
+				return fmt.Errorf("%w for %s: %s", ErrInvalidTracking, v.carrier, tracking)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported carrier: %s", carrier)
+}
+
+// Detect returns the carrier whose format and check digit a normalized tracking number matches
+func Detect(tracking string) (string, error) {
+	for _, v := range validators {
+		if v.validate(tracking) {
+			return v.carrier, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownCarrier, tracking)
+}
+
+// validUPS checks a 1Z tracking number. Letters count as (ASCII - 63) mod 10, odd positions are weighted 1 and even positions 2
+func validUPS(tracking string) bool {
+	if !upsFormat.MatchString(tracking) {
+		return false
+	}
+	payload := tracking[2:17]
+	sum := 0
+	for i, c := range payload {
+		value := int(c - '0')
+		if c >= 'A' && c <= 'Z' {
+			value = int(c-63) % 10
+		}
+		if i%2 == 1 {
+			value *= 2
+		}
+		sum += value
+	}
+	return (10-sum%10)%10 == int(tracking[17]-'0')
+}
+
+// validUSPS checks an Intelligent Mail package barcode, with or without its 420 ZIP routing prefix,
+// or an international S10 number
+func validUSPS(tracking string) bool {
+	if s10Format.MatchString(tracking) {
+		return validS10(tracking)
+	}
+	match := uspsFormat.FindStringSubmatch(tracking)
+	if match == nil {
+		return false
+	}
+	return mod10(match[3])
+}
+
+// validS10 checks the UPU S10 check digit of the 8 digit serial number, weighted 8, 6, 4, 2, 3, 5, 9, 7 mod 11
+func validS10(tracking string) bool {
+	weights := []int{8, 6, 4, 2, 3, 5, 9, 7}
+	sum := 0
+	for i, weight := range weights {
+		sum += int(tracking[2+i]-'0') * weight
+	}
+	check := 11 - sum%11
+	switch check {
+	case 10:
+		check = 0
+	case 11:
+		check = 5
+	}
+	return check == int(tracking[10]-'0')
+}
+
+// validFedEx checks 12 digit Express numbers (weights 1, 3, 7 mod 11), 15 digit Ground numbers,
+// and 22 digit Ground numbers that end in a 15 digit Ground number
+func validFedEx(tracking string) bool {
+	if !fedExFormat.MatchString(tracking) {
+		return false
+	}
+	switch len(tracking) {
+	case 12:
+		weights := []int{1, 3, 7}
+		sum := 0
+		for i := 10; i >= 0; i-- {
+			sum += int(tracking[i]-'0') * weights[(10-i)%3]
+		}
+		return sum%11%10 == int(tracking[11]-'0')
+	case 15:
+		return mod10(tracking)
+	}
+	return mod10(tracking[7:])
+}
+
+// validLaserShip checks the format of a LaserShip number, they have no check digit
+func validLaserShip(tracking string) bool {
+	return laserShipFormat.MatchString(tracking)
+}
+
+// mod10 checks the last digit of a number using weights of 3 and 1, starting with 3 next to the check digit
+func mod10(digits string) bool {
+	sum := 0
+	last := len(digits) - 1
+	for i := last - 1; i >= 0; i-- {
+		value := int(digits[i] - '0')
+		if (last-i)%2 == 1 {
+			value *= 3
+		}
+		sum += value
+	}
+	return (10-sum%10)%10 == int(digits[last]-'0')
+}
@@ -0,0 +1,125 @@
+package webhooks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+)
+
+const (
+	// DefaultTimeout is how long a receiver has to respond to a delivery
+	DefaultTimeout = 10 * time.Second
+	// DefaultLease is how long a claimed delivery is held before another dispatcher may send it, it must outlast DefaultTimeout
+	DefaultLease = time.Minute
+	// DefaultBatchSize is the most deliveries sent by one call to Deliver
+	DefaultBatchSize = 100
+)
+
+// Dispatcher sends queued webhook deliveries and schedules retries for the ones that fail
+type Dispatcher struct {
+	Store     repository.Store
+	Client    *http.Client
+	Lease     time.Duration
+	BatchSize int64
+}
+
+// NewDispatcher creates a dispatcher with the default timeout, lease and batch size
+func NewDispatcher(store repository.Store) *Dispatcher {
+	return &Dispatcher{
+		Store:     store,
+		Client:    NewClient(),
+		Lease:     DefaultLease,
+		BatchSize: DefaultBatchSize,
+	}
+}
+
+// NewClient creates the HTTP client deliveries are sent with. It only connects to public addresses, so a webhook host that
+// resolves to a private or local address is refused when it is sent to, and it does not follow redirects
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: DefaultTimeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !models.PublicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   DefaultTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Deliver sends up to BatchSize deliveries that are due and returns how many were delivered. now is when the batch starts, the
+// clock runs on from it while the batch is sent. Deliveries are claimed one at a time so that the lease only has to cover one
+// send, and each is signed and recorded when it is sent so that the last deliveries of a slow batch are not signed too early
+func (d *Dispatcher) Deliver(now time.Time) (int, error) {
+	start := time.Now()
+	delivered := 0
+	for claimed := int64(0); claimed < d.BatchSize; claimed++ {
+		deliveries, err := d.Store.Webhooks().ClaimDue(now.Add(time.Since(start)), d.Lease, 1)
+		if err != nil {
+			return delivered, err
+		}
+		if len(*deliveries) == 0 {
+			break
+		}
+		delivery := &(*deliveries)[0]
+		status, err := d.send(delivery, now.Add(time.Since(start)))
+		delivery.RecordAttempt(now.Add(time.Since(start)), status, err)
+		if delivery.DeliveredAt.Valid {
+			delivered++
+		}
+		if delivery.DeadAt.Valid {
+			log.Printf("Webhook delivery %d to %s is dead after %d attempts: %s", delivery.ID, delivery.Webhook.URL, delivery.Attempts, delivery.LastError.String)
+		}
+		err = d.Store.Webhooks().UpdateDelivery(delivery)
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// send posts the signed delivery to its webhook and returns the response status
+func (d *Dispatcher) send(delivery *models.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SmartMail-Webhooks/1.0")
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, fmt.Sprintf("%d", delivery.ID))
+	req.Header.Set(SignatureHeader, Sign(delivery.Webhook.Secret, now, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, nil
+}
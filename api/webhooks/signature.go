@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the delivery's signature, see Sign
+	SignatureHeader = "X-SmartMail-Signature"
+	// EventHeader carries the delivery's event
+	EventHeader = "X-SmartMail-Event"
+	// DeliveryHeader carries the delivery's ID, a receiver sees the same ID again when a delivery is retried
+	DeliveryHeader = "X-SmartMail-Delivery"
+)
+
+// DefaultTolerance is how old a signature Verify accepts by default
+const DefaultTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned when a signature header is malformed, does not match the body, or is too old
+var ErrInvalidSignature = errors.New("Invalid webhook signature")
+
+// Sign returns the signature header for a body sent at timestamp, `t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">`.
+// Signing the timestamp with the body lets receivers reject replayed deliveries
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", unix, signature(secret, unix, body))
+}
+
+// Verify checks a signature header against the body and rejects signatures made more than tolerance before now
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var unix, signed string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignature
+		}
+		switch kv[0] {
+		case "t":
+			unix = kv[1]
+		case "v1":
+			signed = kv[1]
+		}
+	}
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || signed == "" {
+		return ErrInvalidSignature
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signed), []byte(signature(secret, unix, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func signature(secret string, unix string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
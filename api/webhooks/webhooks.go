@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Payload is the JSON body of a delivery. It leaves out the address so that receivers look it up again and the user's
+// sharing grants still apply. Tracking lists the receiver's in-flight packages for the user, Reason is set on address.undeliverable
+type Payload struct {
+	Event      models.WebhookEvent  `json:"event"`
+	Status     models.Status        `json:"status"`
+	StartDate  time.Time            `json:"start_date"`
+	EndDate    null.Time            `json:"end_date"`
+	Tracking   []string             `json:"tracking"`
+	Reason     models.FailureReason `json:"reason,omitempty"`
+	OccurredAt time.Time            `json:"occurred_at"`
+}
+
+const (
+	// lookupWindow is how far back a lookup with a tracking number makes a shipper or carrier a receiver of the user's changes
+	lookupWindow = 30 * 24 * time.Hour
+	// maxLookups is the most recent lookups that are read when finding receivers
+	maxLookups = 1000
+)
+
+// Enqueue queues the assignment's event for every webhook of each API user holding an in-flight package for the user:
+// carriers with an open package, and shippers and carriers that looked the user up with a tracking number recently.
+// It is called in the transaction that saves the assignment, so deliveries are only queued for changes that are saved
+func Enqueue(store repository.Store, aa *models.AddressAssignment, now time.Time) (int, error) {
+	event, ok := models.AssignmentEvent(aa)
+	if !ok {
+		return 0, nil
+	}
+	receivers, tracking, err := inFlight(store, aa.UserID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, apiUserID := range receivers {
+		n, err := queue(store, apiUserID, Payload{
+			Event:      event,
+			Status:     aa.Status,
+			StartDate:  aa.StartDate,
+			EndDate:    aa.EndDate,
+			Tracking:   tracking[apiUserID],
+			OccurredAt: now,
+		}, now)
+		queued += n
+		if err != nil {
+			return queued, err
+		}
+	}
+	return queued, nil
+}
+
+// EnqueueFailure queues address.undeliverable for every webhook of the API users linked to the user whose address a carrier
+// reported. It is called in the transaction that saves the report
+func EnqueueFailure(store repository.Store, failure models.DeliveryFailure, aa *models.AddressAssignment, now time.Time) (int, error) {
+	apiUsers, err := store.APIUsers().FindForUser(aa.UserID)
+	if err != nil {
+		return 0, err
+	}
+	tracking := []string{}
+	if failure.Tracking.Valid {
+		tracking = append(tracking, failure.Tracking.String)
+	}
+
+	queued := 0
+	for _, apiUser := range *apiUsers {
+		n, err := queue(store, apiUser.ID, Payload{
+			Event:      models.AddressUndeliverableEvent,
+			Status:     aa.Status,
+			StartDate:  aa.StartDate,
+			EndDate:    aa.EndDate,
+			Tracking:   tracking,
+			Reason:     failure.Reason,
+			OccurredAt: now,
+		}, now)
+		queued += n
+		if err != nil {
+			return queued, err
+		}
+	}
+	return queued, nil
+}
+
+// queue saves a delivery of the payload for each of the API user's webhooks subscribed to its event
+func queue(store repository.Store, apiUserID uuid.UUID, payload Payload, now time.Time) (int, error) {
+	webhooks, err := store.Webhooks().FindForAPIUser(apiUserID)
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, webhook := range *webhooks {
+		if !webhook.Subscribes(payload.Event) {
+			continue
+		}
+		delivery := models.WebhookDelivery{WebhookID: webhook.ID, Event: payload.Event, Payload: string(body), NextAttemptAt: now, CreatedAt: now, UpdatedAt: now}
+		_, err = store.Webhooks().SaveDelivery(&delivery)
+		if err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// inFlight returns the API users holding in-flight packages for the user, in the order they were found, and their tracking numbers
+func inFlight(store repository.Store, uid uuid.UUID, now time.Time) ([]uuid.UUID, map[uuid.UUID][]string, error) {
+	receivers := []uuid.UUID{}
+	tracking := map[uuid.UUID][]string{}
+	seen := map[uuid.UUID]map[string]bool{}
+	add := func(apiUserID uuid.UUID, trackingNumber string) {
+		if seen[apiUserID] == nil {
+			seen[apiUserID] = map[string]bool{}
+			receivers = append(receivers, apiUserID)
+			tracking[apiUserID] = []string{}
+		}
+		if !seen[apiUserID][trackingNumber] {
+			seen[apiUserID][trackingNumber] = true
+			tracking[apiUserID] = append(tracking[apiUserID], trackingNumber)
+		}
+	}
+
+	packages, err := store.Packages().FindAllOpenForUser(uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range *packages {
+		add(p.MailCarrierID, p.Tracking.String)
+	}
+
+	filter := models.DisclosureFilter{
+		UserID: uuid.NullUUID{UUID: uid, Valid: true},
+		From:   null.TimeFrom(now.Add(-lookupWindow)),
+	}
+	_, disclosures, err := store.Disclosures().Find(filter, maxLookups, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, disclosure := range disclosures {
+		if disclosure.APIUserID.Valid && disclosure.Tracking.Valid {
+			add(disclosure.APIUserID.UUID, disclosure.Tracking.String)
+		}
+	}
+	return receivers, tracking, nil
+}
@@ -0,0 +1,92 @@
+package authtests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestPermissionScopes(t *testing.T) {
+	samples := []struct {
+		permission models.Permission
+		scope      models.Scope
+		allowed    bool
+	}{
+		{models.NoPermission, models.ZipReadScope, false},
+		{models.LimitedPermission, models.ZipReadScope, true},
+		{models.LimitedPermission, models.AddressReadScope, false},
+		{models.FullPermission, models.AddressReadScope, true},
+		{models.FullPermission, models.PackageWriteScope, true},
+		{models.FullPermission, models.AdminScope, false},
+		{models.AdminPermission, models.AdminScope, true},
+		{models.EngineerPermission, models.PackageReadScope, true},
+	}
+	for _, v := range samples {
+		assert.Equal(t, v.permission.HasScope(v.scope), v.allowed)
+	}
+}
+
+func scopedRequest(t *testing.T, permission models.Permission, tokenPermission string, scope models.Scope) (*httptest.ResponseRecorder, *models.Principal) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("this is the error opening a mock DB: %v\n", err)
+	}
+	gormDB, err := gorm.Open("postgres", db)
+	if err != nil {
+		t.Fatalf("this is the error opening gorm: %v\n", err)
+	}
+	gormDB.LogMode(false)
+
+	uid := uuid.NewV4()
+	mock.ExpectQuery(`SELECT \* FROM "api_users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "username", "permission"}).AddRow(uid.String(), "carrier", []byte(permission)))
+
+	token, err := auth.CreateToken(uid, tokenPermission, uuid.NewV4())
+	if err != nil {
+		t.Fatalf("this is the error creating a token: %v\n", err)
+	}
+	req := bearerRequest(t, token)
+
+	var principal *models.Principal
+	rr := httptest.NewRecorder()
+	middlewares.SetMiddlewareScope(repository.NewGorm(gormDB), func(w http.ResponseWriter, r *http.Request) {
+		principal = middlewares.PrincipalFromContext(r)
+	}, scope).ServeHTTP(rr, req)
+	return rr, principal
+}
+
+func TestSetMiddlewareScope(t *testing.T) {
+	rr, principal := scopedRequest(t, models.FullPermission, "full", models.AddressReadScope)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NotEqual(t, principal, nil)
+	assert.Equal(t, principal.APIUser.Username, "carrier")
+
+	rr, principal = scopedRequest(t, models.LimitedPermission, "limited", models.AddressReadScope)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	assert.Equal(t, principal == nil, true)
+
+	// A token issued before the API user's permission changed is rejected
+	rr, principal = scopedRequest(t, models.LimitedPermission, "full", models.ZipReadScope)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	assert.Equal(t, principal == nil, true)
+}
+
+func TestSetMiddlewareScopeWithoutToken(t *testing.T) {
+	req, err := http.NewRequest("GET", "/zip/mail/NC4LL93K/2020-01-01", nil)
+	if err != nil {
+		t.Fatalf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	middlewares.SetMiddlewareScope(nil, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler should not be called without a token")
+	}, models.ZipReadScope).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
@@ -0,0 +1,84 @@
+package authtests
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/auth"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+type revokedSessions map[string]bool
+
+func (r revokedSessions) IsRevoked(jti string) bool {
+	return r[jti]
+}
+
+func TestMain(m *testing.M) {
+	os.Setenv("API_SECRET", "auth-test-secret")
+	os.Exit(m.Run())
+}
+
+func bearerRequest(t *testing.T, token string) *http.Request {
+	req, err := http.NewRequest("POST", "/logout", nil)
+	if err != nil {
+		t.Fatalf("this is the error: %v\n", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestTokenPair(t *testing.T) {
+	auth.SetRevocationStore(revokedSessions{})
+	defer auth.SetRevocationStore(nil)
+
+	userID := uuid.NewV4()
+	sessionID := uuid.NewV4()
+	pair, err := auth.CreateTokenPair(userID, "ui", sessionID, time.Now().Add(auth.RefreshTokenDuration))
+	if err != nil {
+		t.Fatalf("this is the error creating a token pair: %v\n", err)
+	}
+
+	uid, err := auth.ExtractUITokenID(bearerRequest(t, pair.Token))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uid, userID)
+
+	sid, err := auth.ExtractSessionID(bearerRequest(t, pair.Token))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sid, sessionID)
+
+	uid, permission, sid, err := auth.ExtractRefreshToken(pair.RefreshToken)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uid, userID)
+	assert.Equal(t, permission, "ui")
+	assert.Equal(t, sid, sessionID)
+
+	// Access and refresh tokens can not be used in place of each other
+	assert.NotEqual(t, auth.TokenValid(bearerRequest(t, pair.RefreshToken)), nil)
+	_, _, _, err = auth.ExtractRefreshToken(pair.Token)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestRevokedSession(t *testing.T) {
+	sessionID := uuid.NewV4()
+	revoked := revokedSessions{}
+	auth.SetRevocationStore(revoked)
+	defer auth.SetRevocationStore(nil)
+
+	pair, err := auth.CreateTokenPair(uuid.NewV4(), "admin", sessionID, time.Now().Add(auth.RefreshTokenDuration))
+	if err != nil {
+		t.Fatalf("this is the error creating a token pair: %v\n", err)
+	}
+	assert.Equal(t, auth.TokenValid(bearerRequest(t, pair.Token)), nil)
+
+	revoked[sessionID.String()] = true
+
+	assert.Equal(t, auth.TokenValid(bearerRequest(t, pair.Token)), auth.ErrRevokedToken)
+	_, _, err = auth.ExtractAPIUserTokenID(bearerRequest(t, pair.Token))
+	assert.NotEqual(t, err, nil)
+	_, _, _, err = auth.ExtractRefreshToken(pair.RefreshToken)
+	assert.Equal(t, err, auth.ErrRevokedToken)
+}
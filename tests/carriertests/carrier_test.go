@@ -0,0 +1,82 @@
+package carriertests
+
+import (
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func regionalCarrier() models.Carrier {
+	return models.Carrier{
+		Code:             " ontrac ",
+		Name:             "OnTrac",
+		APIUserID:        uuid.NewV4(),
+		TrackingPatterns: models.TrackingPatterns{`^C[0-9]{14}$`, `^D[0-9]{14}$`},
+		TrackingURL:      "https://www.ontrac.com/tracking/?number={tracking}",
+	}
+}
+
+func TestCarrierValidate(t *testing.T) {
+	carrier := regionalCarrier()
+	carrier.Prepare()
+	assert.Equal(t, carrier.Code, "ONTRAC")
+	assert.Equal(t, carrier.Validate(), nil)
+
+	badPattern := regionalCarrier()
+	badPattern.TrackingPatterns = models.TrackingPatterns{`^C[0-9`}
+	assert.NotEqual(t, badPattern.Validate(), nil)
+
+	badURL := regionalCarrier()
+	badURL.TrackingURL = "https://www.ontrac.com/tracking"
+	assert.NotEqual(t, badURL.Validate(), nil)
+
+	noUser := regionalCarrier()
+	noUser.APIUserID = uuid.Nil
+	assert.NotEqual(t, noUser.Validate(), nil)
+}
+
+func TestCarrierTracking(t *testing.T) {
+	carrier := regionalCarrier()
+	assert.Equal(t, carrier.MatchesTracking("C10999911320231"), true)
+	assert.Equal(t, carrier.MatchesTracking("1Z999AA10123456784"), false)
+	assert.Equal(t, carrier.TrackingLink("C10999911320231"), "https://www.ontrac.com/tracking/?number=C10999911320231")
+	assert.Equal(t, carrier.TrackingLink(""), "")
+}
+
+func TestTrackingPatternsRoundTrip(t *testing.T) {
+	patterns := models.TrackingPatterns{`^C[0-9]{14}$`, `^D[0-9]{14}$`}
+	value, err := patterns.Value()
+	assert.Equal(t, err, nil)
+
+	scanned := models.TrackingPatterns{}
+	err = scanned.Scan([]byte(value.(string)))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, scanned, patterns)
+}
+
+func TestTranslatePackageUsesRegistry(t *testing.T) {
+	carrier := regionalCarrier()
+	registry := models.CarrierRegistry{carrier.APIUserID: carrier}
+
+	registered := models.Package{
+		MailCarrierID: carrier.APIUserID,
+		MailCarrier:   models.APIUser{Name: "ontrac api user"},
+		Tracking:      null.StringFrom("C10999911320231"),
+	}
+	translated := responses.TranslatePackage(registered, registry)
+	assert.Equal(t, translated.MailCarrier, "OnTrac")
+	assert.Equal(t, translated.TrackingURL, "https://www.ontrac.com/tracking/?number=C10999911320231")
+
+	unregistered := models.Package{
+		MailCarrierID: uuid.NewV4(),
+		MailCarrier:   models.APIUser{Name: "Local Courier"},
+		Tracking:      null.StringFrom("12345"),
+	}
+	translated = responses.TranslatePackage(unregistered, registry)
+	assert.Equal(t, translated.MailCarrier, "Local Courier")
+	assert.Equal(t, translated.TrackingURL, "")
+}
@@ -0,0 +1,126 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestGetMailingZipBySmartID(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	_, limited := apiUserToken(t, server, "gotham", models.LimitedPermission)
+	_, none := apiUserToken(t, server, "metropolis", models.NoPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingZipBySmartID, models.ZipReadScope)
+
+	samples := []struct {
+		token      string
+		smartID    string
+		date       string
+		statusCode int
+	}{
+		{token: limited, smartID: bruce.User.SmartID, date: "2020-06-01", statusCode: http.StatusOK},
+		{token: none, smartID: bruce.User.SmartID, date: "2020-06-01", statusCode: http.StatusForbidden},
+		{token: "", smartID: bruce.User.SmartID, date: "2020-06-01", statusCode: http.StatusUnauthorized},
+		{token: limited, smartID: bruce.User.SmartID, date: "06/01/2020", statusCode: http.StatusBadRequest},
+	}
+
+	for _, v := range samples {
+		rr := serve(handler, "GET", "", v.token, map[string]string{"smart_id": v.smartID, "date": v.date})
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusOK {
+			reply := responses.ZipResponse{}
+			decode(t, rr, &reply)
+			assert.Equal(t, reply.SmartID, bruce.User.SmartID)
+			assert.Equal(t, reply.ZipCode, "10674")
+		}
+	}
+}
+
+func TestLegacySmartIDsStillResolve(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	_, limited := apiUserToken(t, server, "gotham", models.LimitedPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingZipBySmartID, models.ZipReadScope)
+
+	// Users who picked their SmartIDs before they were issued keep them, without a check character
+	for _, legacy := range []string{"BATMAN01", "WAYNE"} {
+		assert.NotEqual(t, smartid.Validate(legacy), nil)
+		user, err := server.Store.Users().Save(&models.User{SmartID: legacy, LegacySmartID: true, FirstName: "Bruce", LastName: "Wayne", Phone: "2125478965", Email: legacy + "@wayne.com", Password: "BigScAryBats!"})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, user.SmartID, legacy)
+		address, err := server.Store.Addresses().Save(&models.Address{LineOne: "1007 Mountain Drive", City: "Gotham", State: "NY", ZipCode: "10674", Country: "United States"})
+		assert.Equal(t, err, nil)
+		_, err = server.Store.Assignments().Save(&models.AddressAssignment{UserID: user.ID, AddressID: address.ID, Status: models.Permanent, StartDate: bruce.Addresses[0].StartDate})
+		assert.Equal(t, err, nil)
+
+		rr := serve(handler, "GET", "", limited, map[string]string{"smart_id": " " + legacy, "date": "2020-06-01"})
+		assert.Equal(t, rr.Code, http.StatusOK)
+	}
+
+	// Anything else without a valid check character is still rejected, including mistyped SmartIDs that were issued
+	typo := []byte(bruce.User.SmartID)
+	typo[0] = smartid.Alphabet[(strings.IndexByte(smartid.Alphabet, typo[0])+1)%len(smartid.Alphabet)]
+	for _, smartID := range []string{"BATMAN02", string(typo)} {
+		rr := serve(handler, "GET", "", limited, map[string]string{"smart_id": smartID, "date": "2020-06-01"})
+		assert.Equal(t, rr.Code, http.StatusBadRequest)
+		assert.Equal(t, strings.HasPrefix(errorMessage(t, rr), "Invalid check digit"), true)
+	}
+}
+
+func TestCreateAddressEndsPriorPermanent(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressBySmartID, models.AddressReadScope)
+
+	rr := serve(server.CreateAddress, "POST", `{
+	"user_id": "`+bruce.User.ID.String()+`",
+	"address": {"line_one": "1 Martha Boulevard", "city": "Gotham", "state": "NY", "zip_code": "10675", "country": "United States"},
+	"status": "permanent",
+	"start_date": "2020-06-01T00:00:00Z"
+}`, bruce.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	// Lookups are exclusive of both the start and end dates, like the SQL they mirror
+	before := serve(handler, "GET", "", full, map[string]string{"smart_id": bruce.User.SmartID, "date": "2020-05-30"})
+	assert.Equal(t, before.Code, http.StatusOK)
+	after := serve(handler, "GET", "", full, map[string]string{"smart_id": bruce.User.SmartID, "date": "2020-06-02"})
+	assert.Equal(t, after.Code, http.StatusOK)
+
+	beforeAddress := responses.AddressResponse{}
+	decode(t, before, &beforeAddress)
+	afterAddress := responses.AddressResponse{}
+	decode(t, after, &afterAddress)
+	assert.Equal(t, beforeAddress.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, afterAddress.LineOne, "1 Martha Boulevard")
+}
+
+func TestUpdateAddressRollsBackTheCorrection(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	permanent := bruce.Addresses[0]
+
+	// Bruce has no earlier permanent address to move, so the new start date fails and the street is not corrected either
+	rr := serve(server.UpdateAddress, "PUT", `{
+	"address": {"line_one": "1007 Mountain Drive North"},
+	"status": "permanent",
+	"start_date": "2019-02-01T00:00:00Z"
+}`, bruce.Token, map[string]string{"id": fmt.Sprintf("%d", permanent.ID)})
+	assert.Equal(t, rr.Code, http.StatusInternalServerError)
+
+	aa, err := server.Store.Assignments().FindByID(permanent.ID)
+	assert.Equal(t, err, nil)
+	address, err := server.Store.Addresses().FindByID(aa.AddressID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, address.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, aa.StartDate.Format("2006-01-02"), "2019-01-01")
+}
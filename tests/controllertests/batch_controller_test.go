@@ -0,0 +1,145 @@
+package controllertests
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// batchResults reads a JSON lines batch response
+func batchResults(t *testing.T, body string) []responses.BatchAddressResult {
+	results := []responses.BatchAddressResult{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		result := responses.BatchAddressResult{}
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		if err != nil {
+			t.Fatalf("cannot decode the batch result %q: %v\n", scanner.Text(), err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestBatchMailingAddresses(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	selina := signup(t, server, "Selina", "selina@kyle.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	grant(t, server, selina, gotham.ID, models.GrantAllow)
+	moveUser(t, server, selina, models.Hold, `, "end_date": "2020-07-01T00:00:00Z"`)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressesBySmartIDs, models.AddressReadScope)
+
+	unknown, err := smartid.Generate()
+	assert.Equal(t, err, nil)
+	inputJSON := fmt.Sprintf(`{"lookups": [
+		{"smart_id": %q, "date": "2020-06-02"},
+		{"smart_id": %q, "date": "2020-06-02"},
+		{"smart_id": %q, "date": "2020-06-15"},
+		{"smart_id": %q, "date": "2020-06-02"},
+		{"smart_id": "NOT-A-SMARTID", "date": "2020-06-02"},
+		{"smart_id": %q, "date": "02/06/2020"},
+		{"smart_id": %q, "date": "2018-06-02"}
+	]}`, bruce.User.SmartID, strings.ToLower(alfred.User.SmartID), selina.User.SmartID, unknown, bruce.User.SmartID, bruce.User.SmartID)
+
+	rr := serve(handler, "POST", inputJSON, full, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "application/x-ndjson")
+	results := batchResults(t, rr.Body.String())
+	assert.Equal(t, len(results), 7)
+	for i, result := range results {
+		assert.Equal(t, result.Index, i)
+	}
+
+	assert.Equal(t, results[0].Address.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, results[0].Error, "")
+	// Without a grant only the zip code is given, like a single lookup
+	assert.Equal(t, results[1].Address.LineOne, "")
+	assert.Equal(t, results[1].Address.ZipCode, "10674")
+	assert.Equal(t, results[1].Address.Consent, models.GrantAsk)
+	assert.Equal(t, results[2].Address.Held, true)
+	assert.Equal(t, results[2].Address.HeldUntil, "2020-07-01")
+	assert.Equal(t, results[3].Error, "Unable to find smartID: "+unknown)
+	assert.Equal(t, results[3].Address == nil, true)
+	assert.Equal(t, results[4].Address == nil, true)
+	assert.NotEqual(t, results[4].Error, "")
+	assert.Equal(t, results[5].Error, "Date must be formatted YYYY-MM-DD")
+	assert.Equal(t, results[6].Error, "No active address")
+
+	// Every address or zip code shown is recorded, and Alfred is asked about sharing
+	count, _, err := server.Store.Disclosures().Find(models.DisclosureFilter{}, 10, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, int64(3))
+	pending, err := server.Store.Grants().FindForUser(alfred.User.ID, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*pending), 1)
+}
+
+func TestBatchMailingAddressesCSV(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressesBySmartIDs, models.AddressReadScope)
+
+	req := httptest.NewRequest("POST", "/address/mail/batch", strings.NewReader(fmt.Sprintf(`{"lookups": [{"smart_id": %q, "date": "2020-06-02"}, {"smart_id": "NOT-A-SMARTID"}]}`, bruce.User.SmartID)))
+	req.Header.Set("Authorization", "Bearer "+full)
+	req.Header.Set("Accept", "text/csv")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "text/csv")
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(records), 3)
+	assert.Equal(t, records[0][7], "line_one")
+	assert.Equal(t, records[1][1], bruce.User.SmartID)
+	assert.Equal(t, records[1][7], "1007 Mountain Drive")
+	assert.Equal(t, records[1][17], "")
+	assert.NotEqual(t, records[2][17], "")
+}
+
+func TestBatchMailingAddressesLimits(t *testing.T) {
+	server := newServer()
+	_, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressesBySmartIDs, models.AddressReadScope)
+
+	lookups := []string{}
+	for i := 0; i <= controllers.MaxBatchLookups; i++ {
+		lookups = append(lookups, `{"smart_id": "NC4LL93K"}`)
+	}
+	samples := []struct {
+		inputJSON    string
+		errorMessage string
+	}{
+		{inputJSON: `{"lookups": []}`, errorMessage: "Required lookups"},
+		{inputJSON: `{"lookups": [` + strings.Join(lookups, ",") + `]}`, errorMessage: fmt.Sprintf("A batch is limited to %d lookups", controllers.MaxBatchLookups)},
+	}
+	for _, v := range samples {
+		rr := serve(handler, "POST", v.inputJSON, full, nil)
+		assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+
+	// A full batch is resolved a chunk at a time
+	rr := serve(handler, "POST", `{"lookups": [`+strings.Join(lookups[1:], ",")+`]}`, full, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	results := batchResults(t, rr.Body.String())
+	assert.Equal(t, len(results), controllers.MaxBatchLookups)
+	assert.Equal(t, results[controllers.MaxBatchLookups-1].Index, controllers.MaxBatchLookups-1)
+}
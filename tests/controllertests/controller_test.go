@@ -1,105 +1,114 @@
 package controllertests
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
-	"github.com/jinzhu/gorm"
-	"github.com/joho/godotenv"
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/auth"
 	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/geocode"
 	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
 )
 
-var server = controllers.Server{}
-var uInstance = models.User{}
-var aInstance = models.Address{}
-var aAInstance = models.AddressAssignment{}
+type stubGeocoder struct{}
 
-func TestMain(m *testing.M) {
-	err := godotenv.Load(os.ExpandEnv("../../.env"))
-	if err != nil {
-		log.Fatalf("Error getting env %v\n", err)
-	}
-	Database()
+func (stubGeocoder) Geocode(address models.Address) (geocode.Location, error) {
+	return geocode.Location{Latitude: 40.769, Longitude: -73.9584}, nil
+}
 
+func TestMain(m *testing.M) {
+	os.Setenv("API_SECRET", "controller-test-secret")
 	os.Exit(m.Run())
-
 }
 
-func Database() {
-
-	var err error
-
-	TestDbDriver := os.Getenv("TestDbDriver")
+// newServer returns a server backed by an empty in-memory store
+func newServer() *controllers.Server {
+	store := repository.NewMemory()
+	auth.SetRevocationStore(store.Sessions())
+	return &controllers.Server{Store: store, Geocoder: stubGeocoder{}}
+}
 
-	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("TestDbHost"), os.Getenv("TestDbPort"), os.Getenv("TestDbUser"), os.Getenv("TestDbName"), os.Getenv("TestDbPassword"))
-	server.DB, err = gorm.Open(TestDbDriver, DBURL)
-	if err != nil {
-		fmt.Printf("Cannot connect to %s database\n", TestDbDriver)
-		log.Fatal("This is the error:", err)
-	} else {
-		fmt.Printf("We are connected to the %s database\n", TestDbDriver)
+// serve calls a handler with the route variables a mux router would have set
+func serve(handler http.HandlerFunc, method string, body string, token string, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
-
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
 }
 
-func refreshTables() error {
-	err := server.DB.DropTableIfExists(&models.AddressAssignment{}, &models.User{}, &models.Address{}).Error
-	if err != nil {
-		return err
-	}
-	err = server.DB.AutoMigrate(&models.User{}, &models.Address{}, &models.AddressAssignment{}).Error
+func decode(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	err := json.Unmarshal(rr.Body.Bytes(), v)
 	if err != nil {
-		return err
+		t.Fatalf("cannot decode the response %q: %v\n", rr.Body.String(), err)
 	}
+}
 
-	log.Printf("Successfully refreshed tables")
-	return nil
+func errorMessage(t *testing.T, rr *httptest.ResponseRecorder) string {
+	reply := map[string]string{}
+	decode(t, rr, &reply)
+	return reply["error"]
 }
 
-func seedUsersAndAddresses() ([]models.User, []models.Address, []models.AddressAssignment, error) {
+func signupJSON(firstName string, email string, lineOne string) string {
+	return fmt.Sprintf(`{
+	"user": {"first_name": %q, "last_name": "Wayne", "phone": "2125478965", "email": %q, "password": "BigScAryBats!"},
+	"address": {"line_one": %q, "city": "Gotham", "state": "NY", "zip_code": "10674", "country": "United States"},
+	"status": "permanent",
+	"start_date": "2019-01-01T00:00:00Z"
+}`, firstName, email, lineOne)
+}
 
-	var err error
+// signup creates a user with a permanent address through the signup handler
+func signup(t *testing.T, server *controllers.Server, firstName string, email string) responses.UserAndAddressResponse {
+	rr := serve(server.CreateUserAndAddress, "POST", signupJSON(firstName, email, "1007 Mountain Drive"), "", nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot sign up %s: %d %s\n", email, rr.Code, rr.Body.String())
+	}
+	reply := responses.UserAndAddressResponse{}
+	decode(t, rr, &reply)
+	return reply
+}
 
+// apiUserToken creates an API user with the permission and returns an access token for them
+func apiUserToken(t *testing.T, server *controllers.Server, username string, permission models.Permission) (*models.APIUser, string) {
+	aUser, err := server.Store.APIUsers().Save(&models.APIUser{Name: username, Username: username, Email: username + "@carrier.com", Phone: "2125478965", Password: "password", Permission: permission})
 	if err != nil {
-		return []models.User{}, []models.Address{}, []models.AddressAssignment{}, err
+		t.Fatalf("cannot save the API user: %v\n", err)
 	}
-	var users = []models.User{
-		models.User{
-			Nickname: "Steven victor",
-			Email:    "steven@gmail.com",
-			Password: "password",
-		},
-		models.User{
-			Nickname: "Magu Frank",
-			Email:    "magu@gmail.com",
-			Password: "password",
-		},
+	session, err := server.Store.Sessions().Save(&models.Session{UserID: aUser.ID, Kind: models.APISession, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("cannot save the session: %v\n", err)
 	}
-	var addresses = []models.Address{
-		models.Address{
-			Title:   "Title 1",
-			Content: "Hello world 1",
-		},
-		models.Address{
-			Title:   "Title 2",
-			Content: "Hello world 2",
-		},
+	token, err := auth.CreateToken(aUser.ID, string(permission), session.ID)
+	if err != nil {
+		t.Fatalf("cannot create the token: %v\n", err)
 	}
+	return aUser, token
+}
 
-	for i, _ := range users {
-		err = server.DB.Model(&models.User{}).Create(&users[i]).Error
-		if err != nil {
-			log.Fatalf("cannot seed users table: %v", err)
-		}
-		posts[i].AuthorID = users[i].ID
-
-		err = server.DB.Model(&models.Post{}).Create(&posts[i]).Error
-		if err != nil {
-			log.Fatalf("cannot seed posts table: %v", err)
-		}
+// grant saves the user's decision about sharing their full address with the API user through the grants handler
+func grant(t *testing.T, server *controllers.Server, user responses.UserAndAddressResponse, apiUserID uuid.UUID, decision models.GrantDecision) responses.ShareGrant {
+	rr := serve(server.SaveShareGrant, "POST", fmt.Sprintf(`{"api_user_id": %q, "decision": %q}`, apiUserID, decision), user.Token, map[string]string{"id": user.User.ID.String()})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cannot save the grant: %d %s\n", rr.Code, rr.Body.String())
 	}
-	return users, posts, nil
+	reply := responses.ShareGrant{}
+	decode(t, rr, &reply)
+	return reply
 }
@@ -0,0 +1,295 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+// reportFailure reports a failed delivery through the carrier handler and returns the address's new confidence
+func reportFailure(t *testing.T, server *controllers.Server, token string, body string) responses.DeliveryConfidence {
+	handler := middlewares.SetMiddlewareScope(server.Store, server.ReportDeliveryFailure, models.PackageWriteScope)
+	rr := serve(handler, "POST", body, token, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot report the failed delivery: %d %s\n", rr.Code, rr.Body.String())
+	}
+	reply := responses.DeliveryConfidence{}
+	decode(t, rr, &reply)
+	return reply
+}
+
+// carrierLookup signs up the users, allows a carrier to see their addresses and looks each of them up once
+func carrierLookup(t *testing.T, server *controllers.Server, users ...responses.UserAndAddressResponse) (*models.APIUser, string) {
+	gotham, token := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, err := server.Store.Carriers().Save(&models.Carrier{Code: "UPS", Name: "UPS", APIUserID: gotham.ID})
+	if err != nil {
+		t.Fatalf("cannot save the carrier: %v\n", err)
+	}
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressBySmartID, models.AddressReadScope)
+	for _, user := range users {
+		grant(t, server, user, gotham.ID, models.GrantAllow)
+		lookupAddress(t, handler, token, user.User.SmartID)
+	}
+	return gotham, token
+}
+
+func TestReportDeliveryFailure(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	selina := signup(t, server, "Selina", "selina@kyle.com")
+	gotham, token := carrierLookup(t, server, bruce, alfred)
+	aaid := bruce.Addresses[0].ID
+
+	reply := reportFailure(t, server, token, fmt.Sprintf(`{"address_assignment_id": %d, "reason": "vacant"}`, aaid))
+	assert.Equal(t, reply.Confidence, 0.7)
+	assert.Equal(t, reply.UnderReview, false)
+	assert.Equal(t, reply.Failure.AddressAssignmentID, aaid)
+	assert.Equal(t, reply.Failure.Carrier, "UPS")
+	assert.Equal(t, reply.Failure.Reason, models.FailureVacant)
+
+	// A carrier reporting the same reason again does not lower the confidence further
+	reply = reportFailure(t, server, token, fmt.Sprintf(`{"address_assignment_id": %d, "reason": "vacant"}`, aaid))
+	assert.Equal(t, reply.Confidence, 0.7)
+
+	// Lookups warn about the address and return the assignment to report against
+	mail := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressToAndFromBySmartID, models.AddressReadScope)
+	rr := serve(mail, "GET", "", token, map[string]string{"sender_smart_id": alfred.User.SmartID, "recipient_smart_id": bruce.User.SmartID, "date": "2020-06-02"})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	lookup := responses.ToAndFromAddressSmartIDResponse{}
+	decode(t, rr, &lookup)
+	assert.Equal(t, lookup.Warning, "Carriers have reported the recipient's address as undeliverable (confidence 0.70).")
+	assert.Equal(t, lookup.Recipient.AddressAssignmentID, aaid)
+	assert.Equal(t, lookup.Sender.AddressAssignmentID, alfred.Addresses[0].ID)
+	assert.Equal(t, lookup.Recipient.Warning, "Carriers have reported the recipient's address as undeliverable (confidence 0.70).")
+	assert.Equal(t, lookup.Sender.Warning, "")
+
+	// A package the carrier is carrying can be reported by its tracking number
+	err := server.Store.Packages().Save(&models.Package{MailCarrierID: gotham.ID, RecipientID: uuid.NullUUID{UUID: bruce.User.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784"), AddressAssignmentID: null.IntFrom(int64(aaid))})
+	assert.Equal(t, err, nil)
+	reply = reportFailure(t, server, token, `{"tracking": "1z999aa1 0123456784", "reason": "moved"}`)
+	assert.Equal(t, reply.Confidence, 0.3)
+	assert.Equal(t, reply.UnderReview, true)
+	assert.Equal(t, reply.Failure.Tracking.String, "1Z999AA10123456784")
+
+	handler := middlewares.SetMiddlewareScope(server.Store, server.ReportDeliveryFailure, models.PackageWriteScope)
+	samples := []struct {
+		body         string
+		code         int
+		errorMessage string
+	}{
+		{body: fmt.Sprintf(`{"address_assignment_id": %d, "reason": "lost"}`, aaid), code: http.StatusUnprocessableEntity, errorMessage: "Reason must be vacant, no_such_number, refused or moved"},
+		{body: `{"reason": "vacant"}`, code: http.StatusUnprocessableEntity, errorMessage: "Required address_assignment_id or tracking"},
+		{body: fmt.Sprintf(`{"address_assignment_id": %d, "reason": "vacant"}`, selina.Addresses[0].ID), code: http.StatusNotFound, errorMessage: "Address not found"},
+		{body: `{"address_assignment_id": 9999, "reason": "vacant"}`, code: http.StatusNotFound, errorMessage: "Address not found"},
+		{body: `{"tracking": "1Z999AA10123456785", "reason": "vacant"}`, code: http.StatusNotFound, errorMessage: "Address not found"},
+	}
+
+	for _, v := range samples {
+		rr := serve(handler, "POST", v.body, token, nil)
+		assert.Equal(t, rr.Code, v.code)
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+
+	// Only carriers report failed deliveries
+	_, other := apiUserToken(t, server, "metropolis", models.FullPermission)
+	rr = serve(handler, "POST", fmt.Sprintf(`{"address_assignment_id": %d, "reason": "vacant"}`, aaid), other, nil)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	assert.Equal(t, errorMessage(t, rr), "Only carriers can report failed deliveries")
+}
+
+func TestReportDeliveryFailureNotifiesUser(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	_, token := carrierLookup(t, server, bruce)
+
+	// Only Bruce can link an API user to his account
+	link := fmt.Sprintf(`{"name": "Wayne", "username": "wayne", "email": "app@wayne.com", "phone": "2125478965", "password": "password", "smartmail_user_id": {"UUID": %q, "Valid": true}}`, bruce.User.ID)
+	for _, userToken := range []string{"", alfred.Token} {
+		rr := serve(server.CreateAPIUser, "POST", link, userToken, nil)
+		assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	}
+	rr := serve(server.CreateAPIUser, "POST", link, bruce.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	wayne, err := server.Store.APIUsers().FindByUsername("WAYNE")
+	assert.Equal(t, err, nil)
+
+	receivers := newEndpoints()
+	defer receivers.close()
+	wayneReceiver := &receiver{}
+	webhook, err := server.Store.Webhooks().Save(&models.Webhook{APIUserID: wayne.ID, URL: receivers.add("wayne", wayneReceiver)})
+	assert.Equal(t, err, nil)
+	wayneReceiver.secret = webhook.Secret
+
+	reportFailure(t, server, token, fmt.Sprintf(`{"address_assignment_id": %d, "reason": "refused"}`, bruce.Addresses[0].ID))
+	delivered, err := receivers.dispatcher(server).Deliver(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 1)
+	assert.Equal(t, wayneReceiver.events, []string{string(models.AddressUndeliverableEvent)})
+	assert.Equal(t, wayneReceiver.received[0].Reason, models.FailureRefused)
+	assert.Equal(t, wayneReceiver.received[0].Status, models.Permanent)
+}
+
+func TestSingleLookupsWarn(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	_, token := carrierLookup(t, server, bruce, alfred)
+	reportFailure(t, server, token, fmt.Sprintf(`{"address_assignment_id": %d, "reason": "vacant"}`, bruce.Addresses[0].ID))
+
+	samples := []struct {
+		handler  http.HandlerFunc
+		user     responses.UserAndAddressResponse
+		tracking string
+		warning  string
+	}{
+		{handler: server.GetMailingAddressBySmartID, user: bruce, warning: "Carriers have reported the recipient's address as undeliverable (confidence 0.70)."},
+		{handler: server.GetMailingAddressBySmartID, user: alfred, warning: ""},
+		{handler: server.GetPackageSenderAddressBySmartID, user: bruce, tracking: "1Z999AA10123456702", warning: "Carriers have reported the sender's address as undeliverable (confidence 0.70)."},
+		{handler: server.GetPackageRecipientAddressBySmartID, user: bruce, tracking: "1Z999AA10123456711", warning: "Carriers have reported the recipient's address as undeliverable (confidence 0.70)."},
+		{handler: server.GetPackageRecipientAddressBySmartID, user: alfred, tracking: "1Z999AA10123456720", warning: ""},
+	}
+
+	for _, v := range samples {
+		handler := middlewares.SetMiddlewareScope(server.Store, v.handler, models.AddressReadScope, models.PackageWriteScope)
+		rr := serve(handler, "GET", "", token, map[string]string{"smart_id": v.user.User.SmartID, "date": "2020-06-02", "tracking": v.tracking})
+		assert.Equal(t, rr.Code, http.StatusOK)
+		reply := responses.AddressSmartIDResponse{}
+		decode(t, rr, &reply)
+		assert.Equal(t, reply.Warning, v.warning)
+	}
+
+	// Shipments warn about the sender and recipient separately
+	provide := middlewares.SetMiddlewareScope(server.Store, server.ProvidePackageAddressToAndFromBySmartID, models.AddressReadScope, models.PackageWriteScope)
+	rr := serve(provide, "POST", fmt.Sprintf(`{"sender_smart_id": %q, "recipient_smart_id": %q, "tracking": "1Z999AA10123456739", "target_date": "2020-06-02"}`, bruce.User.SmartID, alfred.User.SmartID), token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	shipment := responses.ToAndFromAddressSmartIDResponse{}
+	decode(t, rr, &shipment)
+	assert.Equal(t, shipment.Sender.Warning, "Carriers have reported the sender's address as undeliverable (confidence 0.70).")
+	assert.Equal(t, shipment.Recipient.Warning, "")
+	assert.Equal(t, shipment.Warning, "Carriers have reported the sender's address as undeliverable (confidence 0.70).")
+}
+
+func TestGetDeliveryFailures(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	_, token := carrierLookup(t, server, bruce)
+	reportFailure(t, server, token, fmt.Sprintf(`{"address_assignment_id": %d, "reason": "refused"}`, bruce.Addresses[0].ID))
+
+	list := func(userToken string, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+userToken)
+		req = mux.SetURLVars(req, map[string]string{"id": bruce.User.ID.String()})
+		rr := httptest.NewRecorder()
+		server.GetDeliveryFailures(rr, req)
+		return rr
+	}
+
+	rr := list(bruce.Token, "/?unseen=true")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reply := responses.DeliveryFailuresResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, len(reply.Failures), 1)
+	assert.Equal(t, reply.Failures[0].Carrier, "UPS")
+	assert.Equal(t, reply.Failures[0].Reason, models.FailureRefused)
+	assert.Equal(t, reply.Failures[0].Seen, false)
+	assert.Equal(t, reply.Failures[0].Address.LineOne, "1007 Mountain Drive")
+
+	rr = serve(server.MarkDeliveryFailuresSeen, "POST", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	rr = list(bruce.Token, "/?unseen=true")
+	reply = responses.DeliveryFailuresResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, len(reply.Failures), 0)
+
+	rr = list(bruce.Token, "/")
+	reply = responses.DeliveryFailuresResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, len(reply.Failures), 1)
+	assert.Equal(t, reply.Failures[0].Seen, true)
+
+	// Users only see the reports against their own addresses
+	rr = list(alfred.Token, "/")
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestDeliveryReviews(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	dick := signup(t, server, "Dick", "dick@wayne.com")
+	_, token := carrierLookup(t, server, bruce, alfred, dick)
+	_, admin := apiUserToken(t, server, "watchtower", models.AdminPermission)
+	reports := map[uint64][]models.FailureReason{
+		bruce.Addresses[0].ID:  {models.FailureNoSuchNumber, models.FailureVacant},
+		alfred.Addresses[0].ID: {models.FailureMoved, models.FailureRefused},
+		dick.Addresses[0].ID:   {models.FailureRefused},
+	}
+	for aaid, reasons := range reports {
+		for _, reason := range reasons {
+			reportFailure(t, server, token, fmt.Sprintf(`{"address_assignment_id": %d, "reason": %q}`, aaid, reason))
+		}
+	}
+
+	queue := middlewares.SetMiddlewareScope(server.Store, server.GetDeliveryReviews, models.AdminScope)
+	rr := serve(queue, "GET", "", admin, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reply := responses.DeliveryReviewsResponse{}
+	decode(t, rr, &reply)
+	// Dick's address is above the threshold, the rest are lowest confidence first
+	assert.Equal(t, len(reply.Reviews), 2)
+	assert.Equal(t, reply.Reviews[0].AddressAssignmentID, bruce.Addresses[0].ID)
+	assert.Equal(t, reply.Reviews[0].SmartID, bruce.User.SmartID)
+	assert.Equal(t, reply.Reviews[0].Confidence, 0.2)
+	assert.Equal(t, reply.Reviews[0].Address.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, len(reply.Reviews[0].Failures), 2)
+	assert.Equal(t, reply.Reviews[1].AddressAssignmentID, alfred.Addresses[0].ID)
+	assert.Equal(t, reply.Reviews[1].Confidence, 0.5)
+
+	review := middlewares.SetMiddlewareScope(server.Store, server.ReviewDeliveryFailures, models.AdminScope)
+	decide := func(aaid uint64, decision string) *httptest.ResponseRecorder {
+		return serve(review, "POST", fmt.Sprintf(`{"decision": %q}`, decision), admin, map[string]string{"id": fmt.Sprintf("%d", aaid)})
+	}
+
+	// An address checked as deliverable gets its confidence back, one that is not keeps it
+	rr = decide(bruce.Addresses[0].ID, "valid")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	decided := map[string]interface{}{}
+	decode(t, rr, &decided)
+	assert.Equal(t, decided["confidence"], 1.0)
+	rr = decide(alfred.Addresses[0].ID, "invalid")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	decided = map[string]interface{}{}
+	decode(t, rr, &decided)
+	assert.Equal(t, decided["confidence"], 0.5)
+
+	rr = serve(queue, "GET", "", admin, nil)
+	reply = responses.DeliveryReviewsResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, len(reply.Reviews), 0)
+
+	rr = decide(bruce.Addresses[0].ID, "valid")
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	assert.Equal(t, errorMessage(t, rr), "No reports to review")
+	rr = decide(dick.Addresses[0].ID, "maybe")
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, errorMessage(t, rr), "Decision must be valid or invalid")
+
+	// Carriers cannot see the queue
+	rr = serve(queue, "GET", "", token, nil)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+}
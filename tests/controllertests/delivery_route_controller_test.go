@@ -0,0 +1,120 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+// streetGeocoder places each street along a road north of the depot, other addresses are left without coordinates
+type streetGeocoder map[string]geocode.Location
+
+func (g streetGeocoder) Geocode(address models.Address) (geocode.Location, error) {
+	return g[address.LineOne], nil
+}
+
+// signupOn creates a user with a permanent address on the street through the signup handler
+func signupOn(t *testing.T, server *controllers.Server, firstName string, email string, lineOne string) responses.UserAndAddressResponse {
+	rr := serve(server.CreateUserAndAddress, "POST", signupJSON(firstName, email, lineOne), "", nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot sign up %s: %d %s\n", email, rr.Code, rr.Body.String())
+	}
+	reply := responses.UserAndAddressResponse{}
+	decode(t, rr, &reply)
+	return reply
+}
+
+func TestPlanDeliveryRoute(t *testing.T) {
+	server := newServer()
+	server.Geocoder = streetGeocoder{
+		"1 First Avenue":   {Latitude: 40.72, Longitude: -74.006},
+		"2 Second Avenue":  {Latitude: 40.76, Longitude: -74.006},
+		"3 Third Avenue":   {Latitude: 40.80, Longitude: -74.006},
+		"1007 Mountain Dr": {Latitude: 40.74, Longitude: -74.006},
+	}
+	bruce := signupOn(t, server, "Bruce", "bruce@wayne.com", "1 First Avenue")
+	alfred := signupOn(t, server, "Alfred", "alfred@wayne.com", "2 Second Avenue")
+	dick := signupOn(t, server, "Dick", "dick@wayne.com", "3 Third Avenue")
+	jason := signupOn(t, server, "Jason", "jason@wayne.com", "1007 Mountain Dr")
+	selina := signupOn(t, server, "Selina", "selina@kyle.com", "4 Fourth Avenue")
+	barbara := signupOn(t, server, "Barbara", "barbara@gordon.com", "5 Fifth Avenue")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	for _, user := range []responses.UserAndAddressResponse{bruce, alfred, dick, selina, barbara} {
+		grant(t, server, user, gotham.ID, models.GrantAllow)
+	}
+	moveUser(t, server, selina, models.Hold, `, "end_date": "2020-07-01T00:00:00Z"`)
+	err := server.Store.Packages().Save(&models.Package{MailCarrierID: gotham.ID, RecipientID: uuid.NullUUID{UUID: bruce.User.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784")})
+	assert.Equal(t, err, nil)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.PlanDeliveryRoute, models.AddressReadScope, models.PackageReadScope)
+
+	body := fmt.Sprintf(`{"depot": {"latitude": 40.7128, "longitude": -74.006}, "date": "2020-06-02", "stops": [
+		{"smart_id": %q}, {"tracking": "1z999aa1 0123456784"}, {"smart_id": %q}, {"smart_id": %q}, {"smart_id": %q},
+		{"smart_id": %q}, {}, {"tracking": "1Z999AA10123456785"}
+	]}`, dick.User.SmartID, alfred.User.SmartID, jason.User.SmartID, selina.User.SmartID, barbara.User.SmartID)
+	rr := serve(handler, "POST", body, gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reply := responses.DeliveryRoute{}
+	decode(t, rr, &reply)
+	assert.Equal(t, reply.Date, "2020-06-02")
+
+	// The stops are visited driving north from the depot
+	assert.Equal(t, len(reply.Stops), 3)
+	indexes := []int{}
+	miles := reply.ReturnMiles
+	for _, stop := range reply.Stops {
+		indexes = append(indexes, stop.Index)
+		miles += stop.LegMiles
+	}
+	assert.Equal(t, indexes, []int{1, 2, 0})
+	assert.Equal(t, reply.Stops[0].Address.LineOne, "1 First Avenue")
+	assert.Equal(t, reply.Stops[0].Tracking, "1z999aa1 0123456784")
+	assert.Equal(t, reply.Stops[2].Address.LineOne, "3 Third Avenue")
+	assert.Equal(t, reply.Miles-miles < 0.02 && miles-reply.Miles < 0.02, true)
+
+	unroutable := map[int]string{}
+	for _, stop := range reply.Unroutable {
+		unroutable[stop.Index] = stop.Error
+	}
+	assert.Equal(t, unroutable, map[int]string{
+		3: "Recipient has not allowed their address to be shared",
+		4: "Packages are held until 2020-07-01",
+		5: "Address has not been geocoded",
+		6: "Required tracking or smartID",
+		7: "Package not found",
+	})
+	// Jason's street is never given out, only his zip code
+	assert.Equal(t, reply.Unroutable[0].Address.LineOne, "")
+	assert.Equal(t, reply.Unroutable[0].Address.ZipCode, "10674")
+
+	samples := []struct {
+		body         string
+		code         int
+		errorMessage string
+	}{
+		{body: fmt.Sprintf(`{"stops": [{"smart_id": %q}]}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "Required depot latitude and longitude"},
+		{body: `{"depot": {"latitude": 40.7128, "longitude": -74.006}, "stops": []}`, code: http.StatusUnprocessableEntity, errorMessage: "Required stops"},
+		{body: fmt.Sprintf(`{"depot": {"latitude": 40.7128, "longitude": -74.006}, "date": "06/02/2020", "stops": [{"smart_id": %q}]}`, bruce.User.SmartID), code: http.StatusBadRequest, errorMessage: "Date must be formatted YYYY-MM-DD"},
+	}
+
+	for _, v := range samples {
+		rr := serve(handler, "POST", v.body, gothamToken, nil)
+		assert.Equal(t, rr.Code, v.code)
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+
+	// Limited API users only get zip codes and UI users are not carriers
+	_, limited := apiUserToken(t, server, "shopkeeper", models.LimitedPermission)
+	for _, token := range []string{limited, bruce.Token} {
+		rr = serve(handler, "POST", body, token, nil)
+		assert.Equal(t, rr.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,189 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// mintDeliveryToken creates a delivery token for the API user through the delivery tokens handler
+func mintDeliveryToken(t *testing.T, server *controllers.Server, user responses.UserAndAddressResponse, apiUserID uuid.UUID, kind models.DeliveryKind, maxUses int) responses.DeliveryToken {
+	rr := serve(server.CreateDeliveryToken, "POST", fmt.Sprintf(`{"api_user_id": %q, "kind": %q, "max_uses": %d}`, apiUserID, kind, maxUses), user.Token, map[string]string{"id": user.User.ID.String()})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot mint the delivery token: %d %s\n", rr.Code, rr.Body.String())
+	}
+	reply := responses.DeliveryToken{}
+	decode(t, rr, &reply)
+	return reply
+}
+
+func TestDeliveryTokenLookup(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetPackageRecipientAddressBySmartID, models.AddressReadScope, models.PackageWriteScope)
+
+	token := mintDeliveryToken(t, server, bruce, gotham.ID, models.PackageDelivery, 2)
+	assert.Equal(t, models.IsDeliveryToken(token.Token), true)
+	assert.Equal(t, token.Active, true)
+
+	// The token is the user's consent, so the full address is returned without a grant and the SmartID is hidden
+	reply := lookupAddress(t, handler, full, token.Token)
+	assert.Equal(t, reply.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, reply.SmartID, "")
+	assert.Equal(t, reply.DeliveryToken, token.Token)
+
+	_, disclosures, err := server.Store.Disclosures().Find(models.DisclosureFilter{}, 10, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, disclosures[0].DeliveryTokenID.Int64, int64(token.ID))
+
+	lookupAddress(t, handler, full, token.Token)
+
+	// Used up
+	rr := serve(handler, "GET", "", full, map[string]string{"smart_id": token.Token, "date": "2020-06-02"})
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, errorMessage(t, rr), models.ErrDeliveryTokenInvalid.Error())
+
+	rr = serve(server.GetDeliveryTokens, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	tokens := responses.DeliveryTokensResponse{}
+	decode(t, rr, &tokens)
+	assert.Equal(t, len(tokens.Tokens), 1)
+	assert.Equal(t, tokens.Tokens[0].Uses, int64(2))
+	assert.Equal(t, tokens.Tokens[0].Active, false)
+}
+
+func TestFailedLookupKeepsDeliveryTokenUse(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressBySmartID, models.AddressReadScope)
+	batch := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressesBySmartIDs, models.AddressReadScope)
+
+	token := mintDeliveryToken(t, server, bruce, gotham.ID, models.MailDelivery, 1)
+
+	// Bruce had no address yet, so neither lookup finds one
+	rr := serve(handler, "GET", "", full, map[string]string{"smart_id": token.Token, "date": "2018-06-02"})
+	assert.NotEqual(t, rr.Code, http.StatusOK)
+	rr = serve(batch, "POST", fmt.Sprintf(`{"lookups": [{"smart_id": %q, "date": "2018-06-02"}]}`, token.Token), full, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	results := batchResults(t, rr.Body.String())
+	assert.Equal(t, results[0].Error, "No active address")
+
+	rr = serve(server.GetDeliveryTokens, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	tokens := responses.DeliveryTokensResponse{}
+	decode(t, rr, &tokens)
+	assert.Equal(t, tokens.Tokens[0].Uses, int64(0))
+	assert.Equal(t, tokens.Tokens[0].Active, true)
+
+	// The token's only use is spent by the lookup that finds the address
+	reply := lookupAddress(t, handler, full, token.Token)
+	assert.Equal(t, reply.LineOne, "1007 Mountain Drive")
+	rr = serve(batch, "POST", fmt.Sprintf(`{"lookups": [{"smart_id": %q, "date": "2020-06-02"}]}`, token.Token), full, nil)
+	results = batchResults(t, rr.Body.String())
+	assert.Equal(t, results[0].Error, models.ErrDeliveryTokenInvalid.Error())
+}
+
+func TestDeliveryTokenRejections(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, other := apiUserToken(t, server, "metropolis", models.FullPermission)
+	packageHandler := middlewares.SetMiddlewareScope(server.Store, server.GetPackageSenderAddressBySmartID, models.AddressReadScope, models.PackageWriteScope)
+	mailHandler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressBySmartID, models.AddressReadScope)
+
+	token := mintDeliveryToken(t, server, bruce, gotham.ID, models.PackageDelivery, 5)
+
+	samples := []struct {
+		handler http.HandlerFunc
+		token   string
+	}{
+		// Another shipper
+		{handler: packageHandler, token: other},
+		// Another kind of delivery
+		{handler: mailHandler, token: full},
+	}
+
+	for _, v := range samples {
+		rr := serve(v.handler, "GET", "", v.token, map[string]string{"smart_id": token.Token, "date": "2020-06-02"})
+		assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+		assert.Equal(t, errorMessage(t, rr), models.ErrDeliveryTokenInvalid.Error())
+	}
+
+	lookupAddress(t, packageHandler, full, token.Token)
+
+	rr := serve(server.RevokeDeliveryToken, "DELETE", "", bruce.Token, map[string]string{"id": bruce.User.ID.String(), "token_id": fmt.Sprintf("%d", token.ID)})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = serve(packageHandler, "GET", "", full, map[string]string{"smart_id": token.Token, "date": "2020-06-02"})
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+
+	// A revoked token cannot be revoked again
+	rr = serve(server.RevokeDeliveryToken, "DELETE", "", bruce.Token, map[string]string{"id": bruce.User.ID.String(), "token_id": fmt.Sprintf("%d", token.ID)})
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestCreateDeliveryToken(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	gotham, _ := apiUserToken(t, server, "gotham", models.FullPermission)
+
+	samples := []struct {
+		token        string
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			token:      bruce.Token,
+			inputJSON:  fmt.Sprintf(`{"api_user_id": %q, "kind": "mail", "expires_at": %q}`, gotham.ID, time.Now().AddDate(0, 0, 7).Format(time.RFC3339)),
+			statusCode: http.StatusCreated,
+		},
+		{
+			token:        bruce.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "kind": "mail"}`, gotham.ID),
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Either max uses or an expiry is required",
+		},
+		{
+			token:        bruce.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "kind": "pigeon", "max_uses": 1}`, gotham.ID),
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Kind must be mail or package",
+		},
+		{
+			token:        bruce.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "kind": "mail", "max_uses": 1}`, bruce.User.ID),
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "API user not found",
+		},
+		{
+			token:        alfred.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "kind": "mail", "max_uses": 1}`, gotham.ID),
+			statusCode:   http.StatusUnauthorized,
+			errorMessage: "Unauthorized",
+		},
+	}
+
+	for _, v := range samples {
+		rr := serve(server.CreateDeliveryToken, "POST", v.inputJSON, v.token, map[string]string{"id": bruce.User.ID.String()})
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusCreated {
+			reply := responses.DeliveryToken{}
+			decode(t, rr, &reply)
+			assert.Equal(t, reply.Kind, models.MailDelivery)
+			assert.Equal(t, reply.Name, "gotham")
+			assert.Equal(t, reply.Active, true)
+			continue
+		}
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+}
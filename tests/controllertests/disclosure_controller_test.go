@@ -0,0 +1,144 @@
+package controllertests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestAddressLookupsAreDisclosed(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, limited := apiUserToken(t, server, "gotham", models.LimitedPermission)
+	metropolis, full := apiUserToken(t, server, "metropolis", models.FullPermission)
+	grant(t, server, bruce, metropolis.ID, models.GrantAllow)
+
+	zip := middlewares.SetMiddlewareScope(server.Store, server.GetMailingZipBySmartID, models.ZipReadScope)
+	rr := serve(zip, "GET", "", limited, map[string]string{"smart_id": bruce.User.SmartID, "date": "2020-06-01"})
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	recipient := middlewares.SetMiddlewareScope(server.Store, server.GetPackageRecipientAddressBySmartID, models.AddressReadScope, models.PackageWriteScope)
+	rr = serve(recipient, "GET", "", full, map[string]string{"smart_id": bruce.User.SmartID, "date": "2020-06-02", "tracking": "1Z999AA10123456784"})
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// A lookup that fails discloses nothing
+	rr = serve(zip, "GET", "", limited, map[string]string{"smart_id": bruce.User.SmartID, "date": "2018-01-01"})
+	assert.NotEqual(t, rr.Code, http.StatusOK)
+
+	rr = serve(server.GetUserDisclosures, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reply := responses.AddressDisclosuresResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, reply.Count, int64(2))
+
+	latest := reply.Disclosures[0]
+	assert.Equal(t, latest.RequestedBy, "metropolis")
+	assert.Equal(t, latest.Scope, models.AddressReadScope)
+	assert.Equal(t, latest.Permission, models.FullPermission)
+	assert.Equal(t, latest.Tracking.String, "1Z999AA10123456784")
+	assert.Equal(t, latest.AddressAssignmentID, bruce.Addresses[0].ID)
+
+	first := reply.Disclosures[1]
+	assert.Equal(t, *first.APIUserID, gotham.ID)
+	assert.Equal(t, first.RetailerID == nil, true)
+	assert.Equal(t, first.Scope, models.ZipReadScope)
+	assert.Equal(t, first.Permission, models.LimitedPermission)
+	assert.Equal(t, first.Tracking.Valid, false)
+}
+
+func TestRetailerZipLookupsAreDisclosed(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	shop := signup(t, server, "Lucius", "lucius@wayne.com")
+	_, admin := apiUserToken(t, server, "admin", models.AdminPermission)
+	retailerHandler := middlewares.SetMiddlewareScope(server.Store, server.MakeRetailer, models.AdminScope)
+	rr := serve(retailerHandler, "PUT", `{"redirect_url": "https://shop.wayne.com/checkout"}`, admin, map[string]string{"id": shop.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// A retailer can look up a zip code without an order, the lookup is disclosed like an order's
+	for _, handler := range []http.HandlerFunc{server.GetMailingZipBySmartID, server.GetPackageZipBySmartID} {
+		zip := middlewares.SetMiddlewareScope(server.Store, handler, models.ZipReadScope)
+		rr = serve(zip, "GET", "", shop.Token, map[string]string{"smart_id": bruce.User.SmartID, "date": "2020-06-02"})
+		assert.Equal(t, rr.Code, http.StatusOK)
+	}
+
+	rr = serve(server.GetUserDisclosures, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reply := responses.AddressDisclosuresResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, reply.Count, int64(2))
+	for _, disclosure := range reply.Disclosures {
+		assert.Equal(t, *disclosure.RetailerID, shop.User.ID)
+		assert.Equal(t, disclosure.APIUserID == nil, true)
+		assert.Equal(t, disclosure.RequestedBy, "Lucius Wayne")
+		assert.Equal(t, disclosure.Scope, models.ZipReadScope)
+		assert.Equal(t, disclosure.AddressAssignmentID, bruce.Addresses[0].ID)
+	}
+}
+
+func TestGetUserDisclosuresIsPrivate(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+
+	rr := serve(server.GetUserDisclosures, "GET", "", alfred.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestGetDisclosuresFilters(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	_, limited := apiUserToken(t, server, "gotham", models.LimitedPermission)
+	_, admin := apiUserToken(t, server, "watchtower", models.AdminPermission)
+
+	zip := middlewares.SetMiddlewareScope(server.Store, server.GetMailingZipBySmartID, models.ZipReadScope)
+	for _, smartID := range []string{bruce.User.SmartID, alfred.User.SmartID, bruce.User.SmartID} {
+		rr := serve(zip, "GET", "", limited, map[string]string{"smart_id": smartID, "date": "2020-06-01"})
+		assert.Equal(t, rr.Code, http.StatusOK)
+	}
+
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetDisclosures, models.AdminScope)
+	query := func(token string, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	samples := []struct {
+		target     string
+		statusCode int
+		count      int64
+		returned   int
+	}{
+		{target: "/disclosures", statusCode: http.StatusOK, count: 3, returned: 3},
+		{target: "/disclosures?smart_id=" + bruce.User.SmartID, statusCode: http.StatusOK, count: 2, returned: 2},
+		{target: "/disclosures?user_id=" + alfred.User.ID.String(), statusCode: http.StatusOK, count: 1, returned: 1},
+		{target: "/disclosures?limit=2&page=2", statusCode: http.StatusOK, count: 3, returned: 1},
+		{target: "/disclosures?tracking=1Z999AA10123456784", statusCode: http.StatusOK, count: 0, returned: 0},
+		{target: "/disclosures?from=2000-01-01&to=2001-01-01", statusCode: http.StatusOK, count: 0, returned: 0},
+		{target: "/disclosures?from=01/01/2000", statusCode: http.StatusBadRequest},
+		{target: "/disclosures?limit=0", statusCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, v := range samples {
+		rr := query(admin, v.target)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusOK {
+			reply := responses.AddressDisclosuresResponse{}
+			decode(t, rr, &reply)
+			assert.Equal(t, reply.Count, v.count)
+			assert.Equal(t, len(reply.Disclosures), v.returned)
+		}
+	}
+
+	rr := query(limited, "/disclosures")
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+}
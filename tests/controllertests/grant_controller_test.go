@@ -0,0 +1,153 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+// lookupAddress calls a scoped single address handler and returns its reply
+func lookupAddress(t *testing.T, handler http.HandlerFunc, token string, smartID string) responses.AddressSmartIDResponse {
+	rr := serve(handler, "GET", "", token, map[string]string{"smart_id": smartID, "date": "2020-06-02"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cannot look up the address: %d %s\n", rr.Code, rr.Body.String())
+	}
+	reply := responses.AddressSmartIDResponse{}
+	decode(t, rr, &reply)
+	return reply
+}
+
+func TestMissingGrantGivesZipAndAsks(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressBySmartID, models.AddressReadScope)
+
+	reply := lookupAddress(t, handler, full, bruce.User.SmartID)
+	assert.Equal(t, reply.Consent, models.GrantAsk)
+	assert.Equal(t, reply.ZipCode, "10674")
+	assert.Equal(t, reply.LineOne, "")
+	assert.Equal(t, reply.FirstName, "")
+
+	rr := serve(server.GetShareGrants, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	grants := responses.ShareGrantsResponse{}
+	decode(t, rr, &grants)
+	assert.Equal(t, len(grants.Grants), 1)
+	assert.Equal(t, grants.Grants[0].APIUserID, gotham.ID)
+	assert.Equal(t, grants.Grants[0].Name, "gotham")
+	assert.Equal(t, grants.Grants[0].Pending, true)
+	assert.Equal(t, grants.Grants[0].RequestedAt.Valid, true)
+
+	// Answering the pending request lets the next lookup see the full address
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	reply = lookupAddress(t, handler, full, bruce.User.SmartID)
+	assert.Equal(t, reply.Consent, models.GrantDecision(""))
+	assert.Equal(t, reply.LineOne, "1007 Mountain Drive")
+
+	// Only the zip code was disclosed before the user answered
+	count, disclosures, err := server.Store.Disclosures().Find(models.DisclosureFilter{}, 10, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, int64(2))
+	assert.Equal(t, disclosures[0].Scope, models.AddressReadScope)
+	assert.Equal(t, disclosures[1].Scope, models.ZipReadScope)
+}
+
+func TestGrantDecisions(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, admin := apiUserToken(t, server, "watchtower", models.AdminPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingAddressBySmartID, models.AddressReadScope)
+
+	denied := grant(t, server, bruce, gotham.ID, models.GrantDeny)
+	reply := lookupAddress(t, handler, full, bruce.User.SmartID)
+	assert.Equal(t, reply.Consent, models.GrantDeny)
+	assert.Equal(t, reply.LineOne, "")
+
+	rr := serve(server.GetShareGrants, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	grants := responses.ShareGrantsResponse{}
+	decode(t, rr, &grants)
+	assert.Equal(t, grants.Grants[0].Pending, false)
+
+	// Admin API users are not subject to grants
+	reply = lookupAddress(t, handler, admin, bruce.User.SmartID)
+	assert.Equal(t, reply.LineOne, "1007 Mountain Drive")
+
+	// An allow that has expired asks the user again
+	_, err := server.Store.Grants().Save(&models.ShareGrant{UserID: bruce.User.ID, APIUserID: gotham.ID, Decision: models.GrantAllow, ExpiresAt: null.TimeFrom(time.Now().Add(-time.Minute))})
+	assert.Equal(t, err, nil)
+	reply = lookupAddress(t, handler, full, bruce.User.SmartID)
+	assert.Equal(t, reply.Consent, models.GrantAsk)
+
+	// Removing the grant also asks again
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	rr = serve(server.DeleteShareGrant, "DELETE", "", bruce.Token, map[string]string{"id": bruce.User.ID.String(), "grant_id": fmt.Sprintf("%d", denied.ID)})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	reply = lookupAddress(t, handler, full, bruce.User.SmartID)
+	assert.Equal(t, reply.Consent, models.GrantAsk)
+}
+
+func TestSaveShareGrant(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	gotham, _ := apiUserToken(t, server, "gotham", models.FullPermission)
+
+	samples := []struct {
+		token        string
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			token:      bruce.Token,
+			inputJSON:  fmt.Sprintf(`{"api_user_id": %q, "decision": "allow", "expires_at": %q}`, gotham.ID, time.Now().AddDate(0, 1, 0).Format(time.RFC3339)),
+			statusCode: http.StatusOK,
+		},
+		{
+			token:        bruce.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "decision": "maybe"}`, gotham.ID),
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Decision must be allow, deny or ask",
+		},
+		{
+			token:        bruce.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "decision": "allow", "expires_at": "2019-01-01T00:00:00Z"}`, gotham.ID),
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Expiry must be in the future",
+		},
+		{
+			token:        bruce.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "decision": "allow"}`, bruce.User.ID),
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "API user not found",
+		},
+		{
+			token:        alfred.Token,
+			inputJSON:    fmt.Sprintf(`{"api_user_id": %q, "decision": "allow"}`, gotham.ID),
+			statusCode:   http.StatusUnauthorized,
+			errorMessage: "Unauthorized",
+		},
+	}
+
+	for _, v := range samples {
+		rr := serve(server.SaveShareGrant, "POST", v.inputJSON, v.token, map[string]string{"id": bruce.User.ID.String()})
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusOK {
+			reply := responses.ShareGrant{}
+			decode(t, rr, &reply)
+			assert.Equal(t, reply.Decision, models.GrantAllow)
+			assert.Equal(t, reply.ExpiresAt.Valid, true)
+			continue
+		}
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+}
@@ -0,0 +1,66 @@
+package controllertests
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestCreateShippingLabel(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	_, err := server.Store.Carriers().Save(&models.Carrier{Code: "UPS", Name: "UPS", APIUserID: gotham.ID})
+	assert.Equal(t, err, nil)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.CreateShippingLabel, models.AddressReadScope, models.PackageWriteScope)
+	label := func(format string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/shipper/label?format="+format, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+full)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	body := func(recipient string, trackingNumber string) string {
+		return fmt.Sprintf(`{"sender_smart_id": %q, "recipient_smart_id": %q, "tracking": %q, "target_date": "2020-06-02"}`, alfred.User.SmartID, recipient, trackingNumber)
+	}
+
+	rr := label("", body(bruce.User.SmartID, "1Z999AA10123456784"))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "application/pdf")
+	assert.Equal(t, bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-1.4")), true)
+	// Both live at the same address, but Alfred has not allowed gotham to see his, so only his zip code is printed
+	assert.Equal(t, bytes.Count(rr.Body.Bytes(), []byte("(1007 Mountain Drive)")), 1)
+	assert.Equal(t, bytes.Contains(rr.Body.Bytes(), []byte("(10674)")), true)
+
+	rr = label("zpl", body(bruce.User.SmartID, "1Z999AA10123456784"))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "application/x-zpl")
+	assert.Equal(t, bytes.Contains(rr.Body.Bytes(), []byte("^FDMA,"+bruce.User.SmartID+"^FS")), true)
+
+	samples := []struct {
+		format       string
+		body         string
+		code         int
+		errorMessage string
+	}{
+		{format: "png", body: body(bruce.User.SmartID, "1Z999AA10123456784"), code: http.StatusBadRequest, errorMessage: "Format must be pdf or zpl"},
+		{format: "pdf", body: body(bruce.User.SmartID, ""), code: http.StatusUnprocessableEntity, errorMessage: "Required tracking"},
+		{format: "pdf", body: fmt.Sprintf(`{"sender_smart_id": %q, "tracking": "1Z999AA10123456784", "target_date": "2020-06-02"}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "Required recipient smartID"},
+		// Alfred has not allowed gotham to see his address, there is nothing to print but the zip code
+		{format: "pdf", body: body(alfred.User.SmartID, "1Z999AA10123456784"), code: http.StatusForbidden, errorMessage: "Recipient has not allowed their address to be shared"},
+	}
+	for _, v := range samples {
+		rr = label(v.format, v.body)
+		assert.Equal(t, rr.Code, v.code)
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+}
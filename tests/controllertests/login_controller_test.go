@@ -1,136 +1,154 @@
 package controllertests
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"net/http/httptest"
+	"sync"
 	"testing"
 
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
 	"gopkg.in/go-playground/assert.v1"
 )
 
-func TestSignIn(t *testing.T) {
-
-	err := refreshTables()
-	if err != nil {
-		log.Fatal(err)
-	}
-	user, err := seedOneUser()
-	if err != nil {
-		fmt.Printf("This is the error %v\n", err)
-	}
+func TestLogin(t *testing.T) {
+	server := newServer()
+	signup(t, server, "Bruce", "bruce@wayne.com")
 
 	samples := []struct {
-		email        string
-		password     string
+		inputJSON    string
+		statusCode   int
 		errorMessage string
 	}{
 		{
-			email:        user.Email,
-			password:     "password", //Note the password has to be this, not the hashed one from the database
-			errorMessage: "",
+			inputJSON:  `{"email": "bruce@wayne.com", "password": "BigScAryBats!"}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			inputJSON:    `{"email": "bruce@wayne.com", "password": "wrong password"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Incorrect Password",
 		},
 		{
-			email:        user.Email,
-			password:     "Wrong password",
-			errorMessage: "crypto/bcrypt: hashedPassword is not the hash of the given password",
+			inputJSON:    `{"email": "thomas@wayne.com", "password": "BigScAryBats!"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "User Not Found",
 		},
 		{
-			email:        "Wrong email",
-			password:     "password",
-			errorMessage: "User not found",
+			inputJSON:    `{"email": "bruce@wayne.com", "password": ""}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Password required",
 		},
 	}
 
 	for _, v := range samples {
-
-		token, err := server.SignIn(v.email, v.password)
-		if err != nil {
-			assert.Equal(t, err, errors.New(v.errorMessage))
-		} else {
-			assert.NotEqual(t, token, "")
+		rr := serve(server.Login, "POST", v.inputJSON, "", nil)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusOK {
+			reply := responses.UserAndAddressResponse{}
+			decode(t, rr, &reply)
+			assert.NotEqual(t, reply.Token, "")
+			assert.Equal(t, len(reply.Addresses), 1)
+			assert.Equal(t, reply.Addresses[0].LineOne, "1007 Mountain Drive")
+			continue
 		}
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
 	}
 }
 
-func TestLogin(t *testing.T) {
+func TestRefreshTokenIsSingleUse(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	refreshJSON := fmt.Sprintf(`{"refresh_token": %q}`, bruce.RefreshToken)
 
-	refreshUserTable()
+	rr := serve(server.RefreshToken, "POST", refreshJSON, "", nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	tokens := responses.TokenResponse{}
+	decode(t, rr, &tokens)
+	assert.NotEqual(t, tokens.Token, bruce.Token)
 
-	_, err := seedOneUser()
-	if err != nil {
-		fmt.Printf("This is the error %v\n", err)
-	}
-	samples := []struct {
-		inputJSON    string
-		statusCode   int
-		email        string
-		password     string
-		errorMessage string
-	}{
-		{
-			inputJSON:    `{"email": "pet@gmail.com", "password": "password"}`,
-			statusCode:   200,
-			errorMessage: "",
-		},
-		{
-			inputJSON:    `{"email": "pet@gmail.com", "password": "wrong password"}`,
-			statusCode:   422,
-			errorMessage: "Incorrect Password",
-		},
-		{
-			inputJSON:    `{"email": "frank@gmail.com", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "Incorrect Details",
-		},
-		{
-			inputJSON:    `{"email": "kangmail.com", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "Invalid Email",
-		},
-		{
-			inputJSON:    `{"email": "", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "required Email",
-		},
-		{
-			inputJSON:    `{"email": "kan@gmail.com", "password": ""}`,
-			statusCode:   422,
-			errorMessage: "required Password",
-		},
-		{
-			inputJSON:    `{"email": "", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "required Email",
-		},
-	}
+	rr = serve(server.RefreshToken, "POST", refreshJSON, "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
 
-	for _, v := range samples {
+	// The refreshed access token works, the one issued at signup was revoked with its session
+	rr = serve(server.UpdateUser, "PUT", `{"first_name": "Batman", "last_name": "Wayne", "phone": "2125478965", "email": "bruce@wayne.com"}`, tokens.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = serve(server.UpdateUser, "PUT", `{"first_name": "Batman", "last_name": "Wayne", "phone": "2125478965", "email": "bruce@wayne.com"}`, bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
 
-		req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(v.inputJSON))
-		if err != nil {
-			t.Errorf("this is the error: %v", err)
-		}
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(server.Login)
-		handler.ServeHTTP(rr, req)
+func TestConcurrentRefreshesAreSingleUse(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	refreshJSON := fmt.Sprintf(`{"refresh_token": %q}`, bruce.RefreshToken)
 
-		assert.Equal(t, rr.Code, v.statusCode)
-		if v.statusCode == 200 {
-			assert.NotEqual(t, rr.Body.String(), "")
-		}
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(server.RefreshToken, "POST", refreshJSON, "", nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
 
-		if v.statusCode == 422 && v.errorMessage != "" {
-			responseMap := make(map[string]interface{})
-			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
-			if err != nil {
-				t.Errorf("Cannot convert to json: %v", err)
-			}
-			assert.Equal(t, responseMap["error"], v.errorMessage)
+	refreshed := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			refreshed++
+		} else {
+			assert.Equal(t, code, http.StatusUnauthorized)
 		}
 	}
+	assert.Equal(t, refreshed, 1)
+}
+
+func TestLogout(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+
+	rr := serve(server.Logout, "POST", "", bruce.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	rr = serve(server.RefreshToken, "POST", fmt.Sprintf(`{"refresh_token": %q}`, bruce.RefreshToken), "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestAPIUserToken(t *testing.T) {
+	server := newServer()
+	// Usernames are stored upper case by Prepare, the way CreateAPIUser saves them
+	_, err := server.Store.APIUsers().Save(&models.APIUser{Name: "Gotham Post", Username: "GOTHAM", Email: "post@gotham.com", Phone: "2125478965", Password: "password", Permission: models.LimitedPermission})
+	assert.Equal(t, err, nil)
+
+	rr := serve(server.Token, "POST", `{"username": "gotham", "password": "password"}`, "", nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	tokens := responses.TokenResponse{}
+	decode(t, rr, &tokens)
+	assert.NotEqual(t, tokens.Token, "")
+
+	rr = serve(server.Token, "POST", `{"username": "gotham", "password": "wrong password"}`, "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+}
+
+func TestResetPassword(t *testing.T) {
+	server := newServer()
+	signup(t, server, "Bruce", "bruce@wayne.com")
+
+	rr := serve(server.RequestResetPassword, "POST", `{"email": "bruce@wayne.com"}`, "", nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	request := responses.PasswordResetRequest{}
+	decode(t, rr, &request)
+
+	resetJSON := `{"first_name": "Bruce", "last_name": "Wayne", "phone": "2125478965", "email": "bruce@wayne.com", "password": "AlfredKnows"}`
+	rr = serve(server.ResetPassword, "POST", resetJSON, request.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// The token is tied to the old password hash, so it only works once
+	rr = serve(server.ResetPassword, "POST", resetJSON, request.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusInternalServerError)
+
+	rr = serve(server.Login, "POST", `{"email": "bruce@wayne.com", "password": "AlfredKnows"}`, "", nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
 }
@@ -0,0 +1,311 @@
+package controllertests
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// mailingJob fetches the job's status through the mailing job handler
+func mailingJob(t *testing.T, server *controllers.Server, token string, id string) responses.MailingJob {
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingJob, models.AddressReadScope)
+	rr := serve(handler, "GET", "", token, map[string]string{"id": id})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cannot get the mailing job: %d %s\n", rr.Code, rr.Body.String())
+	}
+	job := responses.MailingJob{}
+	decode(t, rr, &job)
+	return job
+}
+
+// uploadMailingList queues the CSV as a mailing job through the upload handler
+func uploadMailingList(t *testing.T, server *controllers.Server, token string, list string) responses.MailingJob {
+	handler := middlewares.SetMiddlewareScope(server.Store, server.CreateMailingJob, models.AddressReadScope)
+	rr := serve(handler, "POST", list, token, nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("cannot upload the mailing list: %d %s\n", rr.Code, rr.Body.String())
+	}
+	job := responses.MailingJob{}
+	decode(t, rr, &job)
+	return job
+}
+
+func TestMailingJob(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	resultHandler := middlewares.SetMiddlewareScope(server.Store, server.GetMailingJobResult, models.AddressReadScope)
+
+	list := fmt.Sprintf("customer_id,smart_id,date\nC-1,%s,2020-06-02\nC-2,%s,\nC-3,NOT-A-SMARTID,2020-06-02\nC-4,%s,2018-06-02\n", bruce.User.SmartID, alfred.User.SmartID, bruce.User.SmartID)
+	job := uploadMailingList(t, server, full, list)
+	assert.Equal(t, job.Status, models.JobQueued)
+	assert.Equal(t, job.TotalRows, 4)
+
+	// The result is only ready once the job has completed
+	rr := serve(resultHandler, "GET", "", full, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusConflict)
+
+	worked, err := server.ProcessMailingJobs(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, worked, 1)
+	job = mailingJob(t, server, full, job.ID.String())
+	assert.Equal(t, job.Status, models.JobCompleted)
+	assert.Equal(t, job.ProcessedRows, 4)
+	assert.Equal(t, job.FailedRows, 2)
+	assert.Equal(t, job.Progress, 100.0)
+
+	rr = serve(resultHandler, "GET", "", full, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "text/csv")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(records), 5)
+	header := records[0]
+	assert.Equal(t, header[0], "customer_id")
+	assert.Equal(t, header[7], "line_one")
+	last := len(header) - 1
+	assert.Equal(t, header[last], "error")
+
+	assert.Equal(t, records[1][0], "C-1")
+	assert.Equal(t, records[1][7], "1007 Mountain Drive")
+	assert.Equal(t, records[1][last], "")
+	// Without a grant only the zip code is given, like a single lookup
+	assert.Equal(t, records[2][7], "")
+	assert.Equal(t, records[2][11], "10674")
+	assert.NotEqual(t, records[3][last], "")
+	assert.Equal(t, records[4][last], "No active address")
+
+	count, _, err := server.Store.Disclosures().Find(models.DisclosureFilter{}, 10, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, int64(2))
+}
+
+func TestMailingJobUploadErrors(t *testing.T) {
+	server := newServer()
+	_, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.CreateMailingJob, models.AddressReadScope)
+
+	samples := []struct {
+		list         string
+		errorMessage string
+	}{
+		{list: "", errorMessage: "Mailing list is empty"},
+		{list: "customer_id,zip_code\nC-1,10674\n", errorMessage: "Required smart_id column"},
+		{list: "customer_id,smart_id\n", errorMessage: "Mailing list has no rows"},
+	}
+	for _, v := range samples {
+		rr := serve(handler, "POST", v.list, full, nil)
+		assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+}
+
+func TestCancelMailingJob(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	_, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, metropolis := apiUserToken(t, server, "metropolis", models.FullPermission)
+	cancelHandler := middlewares.SetMiddlewareScope(server.Store, server.CancelMailingJob, models.AddressReadScope)
+
+	job := uploadMailingList(t, server, full, "smart_id\n"+bruce.User.SmartID+"\n")
+
+	// Other API users cannot see or cancel the job
+	rr := serve(middlewares.SetMiddlewareScope(server.Store, server.GetMailingJob, models.AddressReadScope), "GET", "", metropolis, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = serve(cancelHandler, "POST", "", metropolis, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	rr = serve(cancelHandler, "POST", "", full, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = serve(cancelHandler, "POST", "", full, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusConflict)
+
+	worked, err := server.ProcessMailingJobs(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, worked, 0)
+	job = mailingJob(t, server, full, job.ID.String())
+	assert.Equal(t, job.Status, models.JobCancelled)
+	assert.Equal(t, job.ProcessedRows, 0)
+}
+
+func TestMailingJobResumesAndExpires(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	_, full := apiUserToken(t, server, "gotham", models.FullPermission)
+
+	rows := []string{"smart_id"}
+	for i := 0; i < 150; i++ {
+		rows = append(rows, bruce.User.SmartID)
+	}
+	job := uploadMailingList(t, server, full, strings.Join(rows, "\n"))
+
+	// A worker claims the job and stops before saving any progress, as it would if the server were restarted
+	now := time.Now()
+	_, err := server.Store.MailingJobs().Claim(now, 5*time.Minute)
+	assert.Equal(t, err, nil)
+	worked, err := server.ProcessMailingJobs(now.Add(time.Minute))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, worked, 0)
+	assert.Equal(t, mailingJob(t, server, full, job.ID.String()).Status, models.JobRunning)
+
+	// Once the lease runs out the job is picked up again
+	worked, err = server.ProcessMailingJobs(now.Add(6 * time.Minute))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, worked, 1)
+	job = mailingJob(t, server, full, job.ID.String())
+	assert.Equal(t, job.Status, models.JobCompleted)
+	assert.Equal(t, job.ProcessedRows, 150)
+
+	// The job and its rows are deleted after the retention period
+	_, err = server.ProcessMailingJobs(job.FinishedAt.Time.Add(models.MailingJobRetention))
+	assert.Equal(t, err, nil)
+	rr := serve(middlewares.SetMiddlewareScope(server.Store, server.GetMailingJob, models.AddressReadScope), "GET", "", full, map[string]string{"id": job.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+// slowMailingStore makes the first job it works on slow, and holds the second one when it starts until it is released
+type slowMailingStore struct {
+	repository.Store
+	jobs *slowMailingJobs
+}
+
+type slowMailingJobs struct {
+	mu      sync.Mutex
+	seen    []uuid.UUID
+	slow    time.Duration
+	started chan struct{}
+	release chan struct{}
+}
+
+type slowMailingJobsRepository struct {
+	repository.MailingJobs
+	jobs *slowMailingJobs
+}
+
+func (s slowMailingStore) MailingJobs() repository.MailingJobs {
+	return slowMailingJobsRepository{s.Store.MailingJobs(), s.jobs}
+}
+
+func (r slowMailingJobsRepository) FindPendingRows(jid uuid.UUID, limit int64) (*[]models.MailingJobRow, error) {
+	r.jobs.mu.Lock()
+	first := len(r.jobs.seen) == 0 || r.jobs.seen[len(r.jobs.seen)-1] != jid
+	if first {
+		r.jobs.seen = append(r.jobs.seen, jid)
+	}
+	count := len(r.jobs.seen)
+	r.jobs.mu.Unlock()
+	if first && count == 1 {
+		time.Sleep(r.jobs.slow)
+	}
+	if first && count == 2 {
+		close(r.jobs.started)
+		<-r.jobs.release
+	}
+	return r.MailingJobs.FindPendingRows(jid, limit)
+}
+
+func TestMailingJobLeaseRunsFromClaim(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	_, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	uploadMailingList(t, server, full, "smart_id\n"+bruce.User.SmartID+"\n")
+	second := uploadMailingList(t, server, full, "smart_id\n"+bruce.User.SmartID+"\n")
+
+	store := server.Store
+	jobs := &slowMailingJobs{slow: 200 * time.Millisecond, started: make(chan struct{}), release: make(chan struct{})}
+	server.Store = slowMailingStore{store, jobs}
+
+	// The first job takes long enough that a lease measured from the start of processing would already have run out
+	// when the second job is claimed
+	now := time.Now()
+	done := make(chan int)
+	go func() {
+		worked, _ := server.ProcessMailingJobs(now)
+		done <- worked
+	}()
+	<-jobs.started
+	_, err := store.MailingJobs().Claim(now.Add(5*time.Minute+100*time.Millisecond), 5*time.Minute)
+	assert.Equal(t, err, repository.ErrNotFound)
+	close(jobs.release)
+	assert.Equal(t, <-done, 2)
+
+	server.Store = store
+	assert.Equal(t, mailingJob(t, server, full, second.ID.String()).Status, models.JobCompleted)
+}
+
+// downgradedStore limits the API user's permission once it has been loaded the given number of times
+type downgradedStore struct {
+	repository.Store
+	loads *int
+	after int
+}
+
+type downgradedAPIUsers struct {
+	repository.APIUsers
+	store downgradedStore
+}
+
+func (s downgradedStore) APIUsers() repository.APIUsers {
+	return downgradedAPIUsers{s.Store.APIUsers(), s}
+}
+
+func (r downgradedAPIUsers) FindByID(uid uuid.UUID) (*models.APIUser, error) {
+	apiUser, err := r.APIUsers.FindByID(uid)
+	*r.store.loads++
+	if err == nil && *r.store.loads > r.store.after {
+		apiUser.Permission = models.LimitedPermission
+	}
+	return apiUser, err
+}
+
+func TestMailingJobFailsWhenPermissionIsDowngraded(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, full := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	list := "smart_id\n" + strings.Repeat(bruce.User.SmartID+"\n", 150)
+	job := uploadMailingList(t, server, full, list)
+
+	// The API user is limited to zip codes after the first chunk has been resolved
+	store := server.Store
+	server.Store = downgradedStore{Store: store, loads: new(int), after: 1}
+	worked, err := server.ProcessMailingJobs(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, worked, 1)
+	server.Store = store
+
+	job = mailingJob(t, server, full, job.ID.String())
+	assert.Equal(t, job.Status, models.JobFailed)
+	assert.Equal(t, job.ProcessedRows, 100)
+	assert.Equal(t, job.Error.String, "API user is no longer allowed to read addresses")
+	count, _, err := server.Store.Disclosures().Find(models.DisclosureFilter{}, 1000, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, int64(100))
+
+	// A job uploaded with full access and processed after the downgrade discloses nothing
+	second := uploadMailingList(t, server, full, "smart_id\n"+bruce.User.SmartID+"\n")
+	server.Store = downgradedStore{Store: store, loads: new(int), after: 0}
+	_, err = server.ProcessMailingJobs(time.Now())
+	assert.Equal(t, err, nil)
+	server.Store = store
+	second = mailingJob(t, server, full, second.ID.String())
+	assert.Equal(t, second.Status, models.JobFailed)
+	assert.Equal(t, second.ProcessedRows, 0)
+	count, _, err = server.Store.Disclosures().Find(models.DisclosureFilter{}, 1000, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, int64(100))
+}
@@ -0,0 +1,281 @@
+package controllertests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestRetailerOrder(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	shop := signup(t, server, "Lucius", "lucius@wayne.com")
+	_, admin := apiUserToken(t, server, "admin", models.AdminPermission)
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, metropolisToken := apiUserToken(t, server, "metropolis", models.FullPermission)
+	_, err := server.Store.Carriers().Save(&models.Carrier{Code: "UPS", Name: "UPS", APIUserID: gotham.ID})
+	assert.Equal(t, err, nil)
+
+	createHandler := middlewares.SetMiddlewareScope(server.Store, server.CreateOrder, models.OrderWriteScope)
+	getHandler := middlewares.SetMiddlewareScope(server.Store, server.GetOrder, models.OrderWriteScope)
+	handOffHandler := middlewares.SetMiddlewareScope(server.Store, server.HandOffOrder, models.OrderWriteScope)
+	addressHandler := middlewares.SetMiddlewareScope(server.Store, server.GetOrderAddress, models.AddressReadScope, models.PackageWriteScope)
+	retailerHandler := middlewares.SetMiddlewareScope(server.Store, server.MakeRetailer, models.AdminScope)
+	orderBody := fmt.Sprintf(`{"smart_id": %q, "reference": "A-1001", "ship_date": "2020-06-02"}`, bruce.User.SmartID)
+
+	// Users cannot take orders until an admin makes them a retailer
+	rr := serve(createHandler, "POST", orderBody, shop.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	rr = serve(retailerHandler, "PUT", `{"redirect_url": "http://shop.wayne.com"}`, admin, map[string]string{"id": shop.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, errorMessage(t, rr), "Redirect URL must be an https URL")
+	rr = serve(retailerHandler, "PUT", `{"small_logo": "https://shop.wayne.com/logo.png", "redirect_url": "https://shop.wayne.com/checkout"}`, admin, map[string]string{"id": shop.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	retailer := responses.SenderRecipient{}
+	decode(t, rr, &retailer)
+	assert.Equal(t, retailer.Role.String, "retailer")
+	assert.Equal(t, retailer.RedirectURL.String, "https://shop.wayne.com/checkout")
+
+	// The retailer is given the zip code to quote shipping and nothing else
+	rr = serve(createHandler, "POST", orderBody, shop.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	assert.Equal(t, strings.Contains(rr.Body.String(), "Mountain"), false)
+	order := responses.Order{}
+	decode(t, rr, &order)
+	assert.Equal(t, order.ZipCode, "10674")
+	assert.Equal(t, order.Reference, "A-1001")
+	assert.Equal(t, order.Status, models.OrderCreated)
+	vars := map[string]string{"id": order.ID.String()}
+
+	// The customer can see their zip code was given to the retailer
+	rr = serve(server.GetUserDisclosures, "GET", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	disclosures := responses.AddressDisclosuresResponse{}
+	decode(t, rr, &disclosures)
+	assert.Equal(t, disclosures.Count, int64(1))
+	assert.Equal(t, *disclosures.Disclosures[0].RetailerID, shop.User.ID)
+	assert.Equal(t, disclosures.Disclosures[0].APIUserID == nil, true)
+	assert.Equal(t, strings.HasPrefix(disclosures.Disclosures[0].RequestedBy, "Lucius"), true)
+	assert.Equal(t, disclosures.Disclosures[0].Scope, models.ZipReadScope)
+	assert.Equal(t, disclosures.Disclosures[0].AddressAssignmentID, bruce.Addresses[0].ID)
+
+	// Nobody can resolve the address before the order is handed off
+	rr = serve(addressHandler, "GET", "", gothamToken, vars)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	rr = serve(handOffHandler, "POST", `{"tracking": "1Z999AA10123456784"}`, shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	decode(t, rr, &order)
+	assert.Equal(t, order.Status, models.OrderHandedOff)
+	assert.Equal(t, order.Carrier, "UPS")
+	rr = serve(handOffHandler, "POST", `{"tracking": "1Z999AA10123456784"}`, shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusConflict)
+
+	// Only the carrier the order was handed to can resolve it
+	rr = serve(addressHandler, "GET", "", metropolisToken, vars)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	// The recipient's grant applies to the carrier like any other lookup
+	rr = serve(addressHandler, "GET", "", gothamToken, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	decode(t, rr, &order)
+	assert.Equal(t, order.Status, models.OrderShipped)
+	assert.Equal(t, order.Recipient.LineOne, "")
+	assert.Equal(t, order.Recipient.ZipCode, "10674")
+
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	rr = serve(addressHandler, "GET", "", gothamToken, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	order = responses.Order{}
+	decode(t, rr, &order)
+	assert.Equal(t, order.Recipient.LineOne, "1007 Mountain Drive")
+
+	// The retailer still only sees the order's progress
+	rr = serve(getHandler, "GET", "", shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, strings.Contains(rr.Body.String(), "Mountain"), false)
+	order = responses.Order{}
+	decode(t, rr, &order)
+	assert.Equal(t, order.Status, models.OrderShipped)
+	assert.Equal(t, order.Tracking.String, "1Z999AA10123456784")
+
+	// The order was saved as a package from the retailer
+	saved, err := server.Store.Packages().FindByTrackingForUser(bruce.User.ID, "1Z999AA10123456784")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, saved.SenderID.UUID, shop.User.ID)
+
+	// Plain users cannot see orders
+	rr = serve(getHandler, "GET", "", bruce.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+}
+
+func TestOrderErrors(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	shop := signup(t, server, "Lucius", "lucius@wayne.com")
+	other := signup(t, server, "Selina", "selina@kyle.com")
+	_, admin := apiUserToken(t, server, "admin", models.AdminPermission)
+	retailerHandler := middlewares.SetMiddlewareScope(server.Store, server.MakeRetailer, models.AdminScope)
+	for _, retailer := range []responses.UserAndAddressResponse{shop, other} {
+		rr := serve(retailerHandler, "PUT", `{"redirect_url": "https://shop.example.com"}`, admin, map[string]string{"id": retailer.User.ID.String()})
+		assert.Equal(t, rr.Code, http.StatusOK)
+	}
+	createHandler := middlewares.SetMiddlewareScope(server.Store, server.CreateOrder, models.OrderWriteScope)
+	handOffHandler := middlewares.SetMiddlewareScope(server.Store, server.HandOffOrder, models.OrderWriteScope)
+
+	samples := []struct {
+		body         string
+		code         int
+		errorMessage string
+	}{
+		{body: `{"smart_id": "NOT-A-SMARTID"}`, code: http.StatusBadRequest},
+		{body: fmt.Sprintf(`{"smart_id": %q, "ship_date": "June 2"}`, bruce.User.SmartID), code: http.StatusBadRequest, errorMessage: "Ship date must be formatted YYYY-MM-DD"},
+		{body: fmt.Sprintf(`{"smart_id": %q, "ship_date": "2018-06-02"}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "No active address"},
+	}
+	for _, v := range samples {
+		rr := serve(createHandler, "POST", v.body, shop.Token, nil)
+		assert.Equal(t, rr.Code, v.code)
+		if v.errorMessage != "" {
+			assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+		}
+	}
+
+	rr := serve(createHandler, "POST", fmt.Sprintf(`{"smart_id": %q}`, bruce.User.SmartID), shop.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	order := responses.Order{}
+	decode(t, rr, &order)
+	vars := map[string]string{"id": order.ID.String()}
+
+	// Another retailer cannot see or hand off the order
+	rr = serve(handOffHandler, "POST", `{"tracking": "1Z999AA10123456784"}`, other.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = serve(handOffHandler, "POST", `{"carrier": "UPS"}`, shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, errorMessage(t, rr), "Required tracking")
+	// No carrier is registered for UPS
+	rr = serve(handOffHandler, "POST", `{"tracking": "1Z999AA10123456784"}`, shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+}
+
+// shipFailingStore fails to ship orders, inside transactions as well
+type shipFailingStore struct {
+	repository.Store
+}
+
+type shipFailingOrders struct {
+	repository.Orders
+}
+
+func (shipFailingOrders) Ship(order *models.Order) error {
+	return errors.New("cannot ship the order")
+}
+
+func (s shipFailingStore) Orders() repository.Orders {
+	return shipFailingOrders{s.Store.Orders()}
+}
+
+func (s shipFailingStore) Transaction(fn func(store repository.Store) error) error {
+	return s.Store.Transaction(func(store repository.Store) error {
+		return fn(shipFailingStore{store})
+	})
+}
+
+func TestOrderShipsWithItsPackage(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	shop := signup(t, server, "Lucius", "lucius@wayne.com")
+	_, admin := apiUserToken(t, server, "admin", models.AdminPermission)
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, err := server.Store.Carriers().Save(&models.Carrier{Code: "UPS", Name: "UPS", APIUserID: gotham.ID})
+	assert.Equal(t, err, nil)
+
+	store := server.Store
+	retailerHandler := middlewares.SetMiddlewareScope(store, server.MakeRetailer, models.AdminScope)
+	createHandler := middlewares.SetMiddlewareScope(store, server.CreateOrder, models.OrderWriteScope)
+	handOffHandler := middlewares.SetMiddlewareScope(store, server.HandOffOrder, models.OrderWriteScope)
+	addressHandler := middlewares.SetMiddlewareScope(store, server.GetOrderAddress, models.AddressReadScope, models.PackageWriteScope)
+	rr := serve(retailerHandler, "PUT", `{"redirect_url": "https://shop.wayne.com"}`, admin, map[string]string{"id": shop.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = serve(createHandler, "POST", fmt.Sprintf(`{"smart_id": %q, "ship_date": "2020-06-02"}`, bruce.User.SmartID), shop.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	order := responses.Order{}
+	decode(t, rr, &order)
+	vars := map[string]string{"id": order.ID.String()}
+	rr = serve(handOffHandler, "POST", `{"tracking": "1Z999AA10123456784"}`, shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// When the order cannot be shipped the package is not kept either
+	server.Store = shipFailingStore{store}
+	rr = serve(addressHandler, "GET", "", gothamToken, vars)
+	assert.Equal(t, rr.Code, http.StatusInternalServerError)
+	_, err = store.Packages().FindByTrackingForUser(bruce.User.ID, "1Z999AA10123456784")
+	assert.NotEqual(t, err, nil)
+
+	// The next lookup ships it
+	server.Store = store
+	rr = serve(addressHandler, "GET", "", gothamToken, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	decode(t, rr, &order)
+	assert.Equal(t, order.Status, models.OrderShipped)
+	saved, err := store.Packages().FindByTrackingForUser(bruce.User.ID, "1Z999AA10123456784")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, saved.SenderID.UUID, shop.User.ID)
+	shipped, err := store.Orders().FindByID(order.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, shipped.PackageID.Int64, int64(saved.ID))
+}
+
+func TestConcurrentOrderLookupsShipOnce(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	shop := signup(t, server, "Lucius", "lucius@wayne.com")
+	_, admin := apiUserToken(t, server, "admin", models.AdminPermission)
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, err := server.Store.Carriers().Save(&models.Carrier{Code: "UPS", Name: "UPS", APIUserID: gotham.ID})
+	assert.Equal(t, err, nil)
+
+	rr := serve(middlewares.SetMiddlewareScope(server.Store, server.MakeRetailer, models.AdminScope), "PUT", `{"redirect_url": "https://shop.wayne.com"}`, admin, map[string]string{"id": shop.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = serve(middlewares.SetMiddlewareScope(server.Store, server.CreateOrder, models.OrderWriteScope), "POST", fmt.Sprintf(`{"smart_id": %q, "ship_date": "2020-06-02"}`, bruce.User.SmartID), shop.Token, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	order := responses.Order{}
+	decode(t, rr, &order)
+	vars := map[string]string{"id": order.ID.String()}
+	rr = serve(middlewares.SetMiddlewareScope(server.Store, server.HandOffOrder, models.OrderWriteScope), "POST", `{"tracking": "1Z999AA10123456784"}`, shop.Token, vars)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// Every lookup resolves the order, the ones that lose the race to ship it answer with the order the winner shipped
+	addressHandler := middlewares.SetMiddlewareScope(server.Store, server.GetOrderAddress, models.AddressReadScope, models.PackageWriteScope)
+	var wg sync.WaitGroup
+	replies := make([]responses.Order, 10)
+	codes := make([]int, 10)
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := serve(addressHandler, "GET", "", gothamToken, vars)
+			codes[i] = rr.Code
+			decode(t, rr, &replies[i])
+		}(i)
+	}
+	wg.Wait()
+	for i := range replies {
+		assert.Equal(t, codes[i], http.StatusOK)
+		assert.Equal(t, replies[i].Status, models.OrderShipped)
+		assert.Equal(t, replies[i].ShippedAt, replies[0].ShippedAt)
+	}
+
+	// A second ship is refused, so its transaction rolls back
+	shipped, err := server.Store.Orders().FindByID(order.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, server.Store.Orders().Ship(shipped), models.ErrOrderShipped)
+}
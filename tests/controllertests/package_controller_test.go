@@ -0,0 +1,56 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestUpdatePackageKeepsCarrierStatus(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	events := middlewares.SetMiddlewareScope(server.Store, server.AddPackageEvent, models.PackageWriteScope)
+
+	shipTo(t, server, gothamToken, bruce, "1Z999AA10123456784", "2020-06-10T00:00:00Z")
+	rr := serve(events, "POST", `{"tracking": "1Z999AA10123456784", "status": "out_for_delivery"}`, gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	timeline := func() responses.PackageEventsResponse {
+		rr := serve(server.GetPackageEvents, "GET", "", bruce.Token, map[string]string{"tracking": "1Z999AA10123456784"})
+		assert.Equal(t, rr.Code, http.StatusOK)
+		timeline := responses.PackageEventsResponse{}
+		decode(t, rr, &timeline)
+		return timeline
+	}
+	update := func(body string) {
+		rr := serve(server.UpdatePackage, "PUT", fmt.Sprintf(`{"user_id": %q, "tracking": "1Z999AA10123456784"%s}`, bruce.User.ID, body), bruce.Token, nil)
+		assert.Equal(t, rr.Code, http.StatusOK)
+	}
+
+	// A new estimate is kept without undoing the carrier's out for delivery
+	update(`, "estimated_delivery": "2020-06-12T00:00:00Z"`)
+	current := timeline()
+	assert.Equal(t, current.Status, models.OutForDelivery)
+	assert.Equal(t, len(current.Events), 3)
+	assert.Equal(t, current.Events[2].Status, models.EstimateUpdated)
+	assert.Equal(t, current.Events[2].EstimatedDelivery.Time.Equal(time.Date(2020, 6, 12, 0, 0, 0, 0, time.UTC)), true)
+
+	// Saving the same values again adds nothing
+	update(`, "estimated_delivery": "2020-06-12T00:00:00Z"`)
+	update(``)
+	assert.Equal(t, len(timeline().Events), 3)
+
+	update(`, "delivered": true`)
+	current = timeline()
+	assert.Equal(t, current.Status, models.PackageDelivered)
+	assert.Equal(t, current.Delivered, true)
+	assert.Equal(t, len(current.Events), 4)
+}
@@ -0,0 +1,99 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/rating"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const rateTables = `{
+	"UPS": {
+		"dim_divisor": 139,
+		"zones": [{"zone": 2, "max_miles": 150}, {"zone": 5, "max_miles": 1000}, {"zone": 8}],
+		"rates": [{"max_weight": 1, "prices": [9.5, 11.25, 14]}, {"max_weight": 10, "prices": [14, 19.5, 28.75]}]
+	},
+	"USPS": {
+		"zones": [{"zone": 1}],
+		"rates": [{"max_weight": 1, "prices": [4.5]}]
+	}
+}`
+
+const rateCentroids = `zip_code,city,state,latitude,longitude
+75201,Dallas,TX,32.7876,-96.7994
+`
+
+func TestGetRates(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
+	gotham, _ := apiUserToken(t, server, "gotham", models.FullPermission)
+	metropolis, _ := apiUserToken(t, server, "metropolis", models.FullPermission)
+	_, limited := apiUserToken(t, server, "shopkeeper", models.LimitedPermission)
+	for _, carrier := range []models.Carrier{
+		{Code: "UPS", Name: "UPS", APIUserID: gotham.ID},
+		{Code: "FEDEX", Name: "FedEx", APIUserID: metropolis.ID},
+	} {
+		_, err := server.Store.Carriers().Save(&carrier)
+		assert.Equal(t, err, nil)
+	}
+	handler := middlewares.SetMiddlewareScope(server.Store, server.GetRates, models.ZipReadScope)
+
+	rr := serve(handler, "POST", `{}`, limited, nil)
+	assert.Equal(t, rr.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, errorMessage(t, rr), "Rating is not configured")
+
+	var err error
+	server.Rater, err = rating.New(strings.NewReader(rateTables), strings.NewReader(rateCentroids))
+	if err != nil {
+		t.Fatalf("cannot load the rate tables: %v\n", err)
+	}
+
+	// Signed up users are geocoded to New York, Dallas is found in the centroid table. FedEx has no rate table and USPS is not registered
+	rr = serve(handler, "POST", fmt.Sprintf(`{"origin_zip": "75201", "destination_smart_id": %q, "ship_date": "2020-06-02", "weight": 3}`, bruce.User.SmartID), limited, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, strings.Contains(rr.Body.String(), "Mountain"), false)
+	reply := responses.RatesResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, reply, responses.RatesResponse{
+		OriginZip:      "75201",
+		DestinationZip: "10674",
+		ShipDate:       "2020-06-02",
+		Rates:          []responses.Rate{{Carrier: "UPS", Name: "UPS", Zone: 8, BillableWeight: 3, Price: 28.75}},
+	})
+
+	rr = serve(handler, "POST", fmt.Sprintf(`{"origin_smart_id": %q, "destination_smart_id": %q, "weight": 20}`, alfred.User.SmartID, bruce.User.SmartID), limited, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reply = responses.RatesResponse{}
+	decode(t, rr, &reply)
+	assert.Equal(t, reply.OriginZip, "10674")
+	assert.Equal(t, reply.Rates, []responses.Rate{{Carrier: "UPS", Name: "UPS", Error: rating.ErrTooHeavy.Error()}})
+
+	samples := []struct {
+		body         string
+		code         int
+		errorMessage string
+	}{
+		{body: fmt.Sprintf(`{"origin_zip": "75201", "destination_smart_id": %q}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "Weight must be greater than 0"},
+		{body: `{"origin_zip": "75201", "weight": 1}`, code: http.StatusUnprocessableEntity, errorMessage: "Required destination smartID"},
+		{body: fmt.Sprintf(`{"destination_smart_id": %q, "weight": 1}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "Required origin smartID or zip"},
+		{body: fmt.Sprintf(`{"origin_zip": "66002", "destination_smart_id": %q, "weight": 1}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "Unable to locate origin: Unable to locate zip code"},
+		{body: fmt.Sprintf(`{"origin_zip": "75201", "destination_smart_id": %q, "ship_date": "06/02/2020", "weight": 1}`, bruce.User.SmartID), code: http.StatusBadRequest, errorMessage: "Ship date must be formatted YYYY-MM-DD"},
+		{body: fmt.Sprintf(`{"origin_zip": "75201", "destination_smart_id": %q, "ship_date": "2018-06-02", "weight": 1}`, bruce.User.SmartID), code: http.StatusUnprocessableEntity, errorMessage: "No active address for recipient"},
+	}
+
+	for _, v := range samples {
+		rr := serve(handler, "POST", v.body, limited, nil)
+		assert.Equal(t, rr.Code, v.code)
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+	}
+
+	rr = serve(handler, "POST", fmt.Sprintf(`{"origin_zip": "75201", "destination_smart_id": %q, "weight": 1}`, bruce.User.SmartID), "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
@@ -0,0 +1,141 @@
+package controllertests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// shipTo records a package for the recipient through the recipient lookup, then a carrier event with its estimated delivery
+func shipTo(t *testing.T, server *controllers.Server, token string, recipient responses.UserAndAddressResponse, trackingNumber string, estimatedDelivery string) {
+	lookup := middlewares.SetMiddlewareScope(server.Store, server.GetPackageRecipientAddressBySmartID, models.AddressReadScope, models.PackageWriteScope)
+	rr := serve(lookup, "GET", "", token, map[string]string{"smart_id": recipient.User.SmartID, "date": "2020-06-02", "tracking": trackingNumber})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cannot look up the recipient: %d %s\n", rr.Code, rr.Body.String())
+	}
+	events := middlewares.SetMiddlewareScope(server.Store, server.AddPackageEvent, models.PackageWriteScope)
+	rr = serve(events, "POST", fmt.Sprintf(`{"tracking": %q, "status": "in_transit", "estimated_delivery": %q}`, trackingNumber, estimatedDelivery), token, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot add the package event: %d %s\n", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPackageReroute(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, metropolisToken := apiUserToken(t, server, "metropolis", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	reroutesHandler := middlewares.SetMiddlewareScope(server.Store, server.GetPackageReroutes, models.AddressReadScope, models.PackageWriteScope)
+	acknowledgeHandler := middlewares.SetMiddlewareScope(server.Store, server.AcknowledgePackageReroute, models.PackageWriteScope)
+
+	shipTo(t, server, gothamToken, bruce, "1Z999AA10123456784", "2020-06-10T00:00:00Z")
+	// Arrives after the temporary address ends
+	shipTo(t, server, gothamToken, bruce, "1Z999AA10123456785", "2020-09-01T00:00:00Z")
+
+	moveUser(t, server, bruce, models.Temporary, `, "end_date": "2020-07-01T00:00:00Z"`)
+
+	rr := serve(reroutesHandler, "GET", "", gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reroutes := responses.PackageReroutesResponse{}
+	decode(t, rr, &reroutes)
+	assert.Equal(t, len(reroutes.Reroutes), 1)
+	assert.Equal(t, reroutes.Reroutes[0].Tracking, "1Z999AA10123456784")
+	assert.Equal(t, reroutes.Reroutes[0].From.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, reroutes.Reroutes[0].To.LineOne, "1 Martha Boulevard")
+
+	// The user sees which packages are being rerouted
+	rr = serve(server.GetPackages, "GET", "", bruce.Token, map[string]string{"user_id": bruce.User.ID.String(), "limit": "10", "page": "1", "type": "open", "search": ""})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	packages := responses.PackagesResponse{}
+	decode(t, rr, &packages)
+	assert.Equal(t, len(packages.RequestedPackages), 2)
+	changed := map[string]bool{}
+	for _, p := range packages.RequestedPackages {
+		changed[p.Tracking] = p.AddressChanged
+	}
+	assert.Equal(t, changed["1Z999AA10123456784"], true)
+	assert.Equal(t, changed["1Z999AA10123456785"], false)
+
+	// Other carriers cannot see or acknowledge the reroute
+	rr = serve(reroutesHandler, "GET", "", metropolisToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	others := responses.PackageReroutesResponse{}
+	decode(t, rr, &others)
+	assert.Equal(t, len(others.Reroutes), 0)
+	rr = serve(acknowledgeHandler, "POST", "", metropolisToken, map[string]string{"tracking": "1Z999AA10123456784"})
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	rr = serve(acknowledgeHandler, "POST", "", gothamToken, map[string]string{"tracking": "1Z999AA10123456784"})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = serve(reroutesHandler, "GET", "", gothamToken, nil)
+	reroutes = responses.PackageReroutesResponse{}
+	decode(t, rr, &reroutes)
+	assert.Equal(t, len(reroutes.Reroutes), 0)
+	rr = serve(acknowledgeHandler, "POST", "", gothamToken, map[string]string{"tracking": "1Z999AA10123456784"})
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestPackageRerouteWithoutGrant(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	_, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	reroutesHandler := middlewares.SetMiddlewareScope(server.Store, server.GetPackageReroutes, models.AddressReadScope, models.PackageWriteScope)
+
+	shipTo(t, server, gothamToken, bruce, "1Z999AA10123456784", "2020-06-10T00:00:00Z")
+	moveUser(t, server, bruce, models.Permanent, "")
+
+	// The new address is shared like any other lookup, without a grant only the zip codes are given
+	rr := serve(reroutesHandler, "GET", "", gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reroutes := responses.PackageReroutesResponse{}
+	decode(t, rr, &reroutes)
+	assert.Equal(t, len(reroutes.Reroutes), 1)
+	assert.Equal(t, reroutes.Reroutes[0].To.LineOne, "")
+	assert.Equal(t, reroutes.Reroutes[0].To.ZipCode, "10675")
+	assert.Equal(t, reroutes.Reroutes[0].From.ZipCode, "10674")
+	assert.Equal(t, reroutes.Reroutes[0].To.Consent, models.GrantAsk)
+
+	count, _, err := server.Store.Disclosures().Find(models.DisclosureFilter{}, 10, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, int64(2))
+}
+
+func TestDeleteAddressReroutesPackages(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	grant(t, server, bruce, gotham.ID, models.GrantAllow)
+	reroutesHandler := middlewares.SetMiddlewareScope(server.Store, server.GetPackageReroutes, models.AddressReadScope, models.PackageWriteScope)
+	acknowledgeHandler := middlewares.SetMiddlewareScope(server.Store, server.AcknowledgePackageReroute, models.PackageWriteScope)
+
+	shipTo(t, server, gothamToken, bruce, "1Z999AA10123456784", "2020-06-10T00:00:00Z")
+	temporary := moveUser(t, server, bruce, models.Temporary, `, "end_date": "2020-07-01T00:00:00Z"`)
+	rr := serve(acknowledgeHandler, "POST", "", gothamToken, map[string]string{"tracking": "1Z999AA10123456784"})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	// Without the temporary address the package goes back to the permanent one
+	rr = serve(server.DeleteAddress, "DELETE", "", bruce.Token, map[string]string{"id": fmt.Sprintf("%d", temporary)})
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	rr = serve(reroutesHandler, "GET", "", gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	reroutes := responses.PackageReroutesResponse{}
+	decode(t, rr, &reroutes)
+	assert.Equal(t, len(reroutes.Reroutes), 1)
+	assert.Equal(t, reroutes.Reroutes[0].From.LineOne, "1 Martha Boulevard")
+	assert.Equal(t, reroutes.Reroutes[0].To.LineOne, "1007 Mountain Drive")
+
+	rr = serve(server.GetPackages, "GET", "", bruce.Token, map[string]string{"user_id": bruce.User.ID.String(), "limit": "10", "page": "1", "type": "open", "search": ""})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	packages := responses.PackagesResponse{}
+	decode(t, rr, &packages)
+	assert.Equal(t, len(packages.RequestedPackages), 1)
+	assert.Equal(t, packages.RequestedPackages[0].AddressChanged, true)
+}
@@ -1,426 +1,148 @@
 package controllertests
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
-	"net/http/httptest"
-	"strconv"
 	"testing"
 
-	"github.com/gorilla/mux"
-	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
 	"gopkg.in/go-playground/assert.v1"
 )
 
 func TestCreateUser(t *testing.T) {
+	server := newServer()
 
-	err := refreshUserTable()
-	if err != nil {
-		log.Fatal(err)
-	}
 	samples := []struct {
 		inputJSON    string
 		statusCode   int
-		nickname     string
-		email        string
 		errorMessage string
 	}{
 		{
-			inputJSON:    `{"nickname":"Pet", "email": "pet@gmail.com", "password": "password"}`,
-			statusCode:   201,
-			nickname:     "Pet",
-			email:        "pet@gmail.com",
-			errorMessage: "",
+			inputJSON:  `{"first_name": "Bruce", "last_name": "Wayne", "phone": "2125478965", "email": "bruce@wayne.com", "password": "password"}`,
+			statusCode: http.StatusCreated,
 		},
 		{
-			inputJSON:    `{"nickname":"Frank", "email": "pet@gmail.com", "password": "password"}`,
-			statusCode:   500,
+			inputJSON:    `{"first_name": "Thomas", "last_name": "Wayne", "phone": "2125478965", "email": "bruce@wayne.com", "password": "password"}`,
+			statusCode:   http.StatusInternalServerError,
 			errorMessage: "Email Already Taken",
 		},
 		{
-			inputJSON:    `{"nickname":"Pet", "email": "grand@gmail.com", "password": "password"}`,
-			statusCode:   500,
-			errorMessage: "Nickname Already Taken",
-		},
-		{
-			inputJSON:    `{"nickname":"Kan", "email": "kangmail.com", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "Invalid Email",
+			inputJSON:    `{"first_name": "Bruce", "last_name": "Wayne", "phone": "2125478965", "email": "brucewayne.com", "password": "password"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Invalid email",
 		},
 		{
-			inputJSON:    `{"nickname": "", "email": "kan@gmail.com", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "required Nickname",
+			inputJSON:    `{"first_name": "", "last_name": "Wayne", "phone": "2125478965", "email": "thomas@wayne.com", "password": "password"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "First name required",
 		},
 		{
-			inputJSON:    `{"nickname": "Kan", "email": "", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "required Email",
-		},
-		{
-			inputJSON:    `{"nickname": "Kan", "email": "kan@gmail.com", "password": ""}`,
-			statusCode:   422,
-			errorMessage: "required Password",
+			inputJSON:    `{"first_name": "Thomas", "last_name": "Wayne", "phone": "2125478965", "email": "thomas@wayne.com", "password": ""}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Password required",
 		},
 	}
 
 	for _, v := range samples {
-
-		req, err := http.NewRequest("POST", "/users", bytes.NewBufferString(v.inputJSON))
-		if err != nil {
-			t.Errorf("this is the error: %v", err)
-		}
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(server.CreateUser)
-		handler.ServeHTTP(rr, req)
-
-		responseMap := make(map[string]interface{})
-		err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
-		if err != nil {
-			fmt.Printf("Cannot convert to json: %v", err)
-		}
+		rr := serve(server.CreateUser, "POST", v.inputJSON, "", nil)
 		assert.Equal(t, rr.Code, v.statusCode)
-		if v.statusCode == 201 {
-			assert.Equal(t, responseMap["nickname"], v.nickname)
-			assert.Equal(t, responseMap["email"], v.email)
-		}
-		if v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
-			assert.Equal(t, responseMap["error"], v.errorMessage)
+		if v.statusCode == http.StatusCreated {
+			reply := responses.CreateUserResponse{}
+			decode(t, rr, &reply)
+			assert.Equal(t, reply.Email, "bruce@wayne.com")
+			assert.Equal(t, smartid.Validate(reply.SmartID), nil)
+			continue
 		}
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
 	}
 }
 
-func TestGetUsers(t *testing.T) {
+func TestGetUser(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
 
-	err := refreshUserTable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = seedUsers()
-	if err != nil {
-		log.Fatal(err)
-	}
-	req, err := http.NewRequest("GET", "/users", nil)
-	if err != nil {
-		t.Errorf("this is the error: %v\n", err)
-	}
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(server.GetUsers)
-	handler.ServeHTTP(rr, req)
-
-	var users []models.User
-	err = json.Unmarshal([]byte(rr.Body.String()), &users)
-	if err != nil {
-		log.Fatalf("Cannot convert to json: %v\n", err)
-	}
+	rr := serve(server.GetUser, "GET", "", "", map[string]string{"id": bruce.User.ID.String()})
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(users), 2)
-}
-
-func TestGetUserByID(t *testing.T) {
-
-	err := refreshUserTable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	user, err := seedOneUser()
-	if err != nil {
-		log.Fatal(err)
-	}
-	userSample := []struct {
-		id           string
-		statusCode   int
-		nickname     string
-		email        string
-		errorMessage string
-	}{
-		{
-			id:         strconv.Itoa(int(user.ID)),
-			statusCode: 200,
-			nickname:   user.Nickname,
-			email:      user.Email,
-		},
-		{
-			id:         "unknwon",
-			statusCode: 400,
-		},
-	}
-	for _, v := range userSample {
-
-		req, err := http.NewRequest("GET", "/users", nil)
-		if err != nil {
-			t.Errorf("This is the error: %v\n", err)
-		}
-		req = mux.SetURLVars(req, map[string]string{"id": v.id})
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(server.GetUser)
-		handler.ServeHTTP(rr, req)
-
-		responseMap := make(map[string]interface{})
-		err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
-		if err != nil {
-			log.Fatalf("Cannot convert to json: %v", err)
-		}
+	reply := map[string]interface{}{}
+	decode(t, rr, &reply)
+	assert.Equal(t, reply["smart_id"], bruce.User.SmartID)
 
-		assert.Equal(t, rr.Code, v.statusCode)
+	rr = serve(server.GetUser, "GET", "", "", map[string]string{"id": "8b7e2f0c-2c1c-4a53-9f0e-6f3c1b1c8a11"})
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
 
-		if v.statusCode == 200 {
-			assert.Equal(t, user.Nickname, responseMap["nickname"])
-			assert.Equal(t, user.Email, responseMap["email"])
-		}
-	}
+	rr = serve(server.GetUsers, "GET", "", "", nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	users := []map[string]interface{}{}
+	decode(t, rr, &users)
+	assert.Equal(t, len(users), 1)
 }
 
 func TestUpdateUser(t *testing.T) {
-
-	var AuthEmail, AuthPassword string
-	var AuthID uint32
-
-	err := refreshUserTable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	users, err := seedUsers() //we need atleast two users to properly check the update
-	if err != nil {
-		log.Fatalf("Error seeding user: %v\n", err)
-	}
-	// Get only the first user
-	for _, user := range users {
-		if user.ID == 2 {
-			continue
-		}
-		AuthID = user.ID
-		AuthEmail = user.Email
-		AuthPassword = "password" //Note the password in the database is already hashed, we want unhashed
-	}
-	//Login the user and get the authentication token
-	token, err := server.SignIn(AuthEmail, AuthPassword)
-	if err != nil {
-		log.Fatalf("cannot login: %v\n", err)
-	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
 
 	samples := []struct {
-		id             string
-		updateJSON     string
-		statusCode     int
-		updateNickname string
-		updateEmail    string
-		tokenGiven     string
-		errorMessage   string
+		id           string
+		token        string
+		inputJSON    string
+		statusCode   int
+		errorMessage string
 	}{
 		{
-			// Convert int32 to int first before converting to string
-			id:             strconv.Itoa(int(AuthID)),
-			updateJSON:     `{"nickname":"Grand", "email": "grand@gmail.com", "password": "password"}`,
-			statusCode:     200,
-			updateNickname: "Grand",
-			updateEmail:    "grand@gmail.com",
-			tokenGiven:     tokenString,
-			errorMessage:   "",
-		},
-		{
-			// When password field is empty
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname":"Woman", "email": "woman@gmail.com", "password": ""}`,
-			statusCode:   422,
-			tokenGiven:   tokenString,
-			errorMessage: "required Password",
-		},
-		{
-			// When no token was passed
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname":"Man", "email": "man@gmail.com", "password": "password"}`,
-			statusCode:   401,
-			tokenGiven:   "",
-			errorMessage: "Unauthorized",
+			id:         bruce.User.ID.String(),
+			token:      bruce.Token,
+			inputJSON:  `{"first_name": "Batman", "last_name": "Wayne", "phone": "2125478965", "email": "batman@wayne.com"}`,
+			statusCode: http.StatusOK,
 		},
 		{
-			// When incorrect token was passed
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname":"Woman", "email": "woman@gmail.com", "password": "password"}`,
-			statusCode:   401,
-			tokenGiven:   "This is incorrect token",
+			id:           bruce.User.ID.String(),
+			token:        alfred.Token,
+			inputJSON:    `{"first_name": "Batman", "last_name": "Wayne", "phone": "2125478965", "email": "batman@wayne.com"}`,
+			statusCode:   http.StatusUnauthorized,
 			errorMessage: "Unauthorized",
 		},
 		{
-			// Remember "kenny@gmail.com" belongs to user 2
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname":"Frank", "email": "kenny@gmail.com", "password": "password"}`,
-			statusCode:   500,
-			tokenGiven:   tokenString,
+			id:           bruce.User.ID.String(),
+			token:        bruce.Token,
+			inputJSON:    `{"first_name": "Batman", "last_name": "Wayne", "phone": "2125478965", "email": "alfred@wayne.com"}`,
+			statusCode:   http.StatusInternalServerError,
 			errorMessage: "Email Already Taken",
 		},
 		{
-			// Remember "Kenny Morris" belongs to user 2
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname":"Kenny Morris", "email": "grand@gmail.com", "password": "password"}`,
-			statusCode:   500,
-			tokenGiven:   tokenString,
-			errorMessage: "Nickname Already Taken",
-		},
-		{
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname":"Kan", "email": "kangmail.com", "password": "password"}`,
-			statusCode:   422,
-			tokenGiven:   tokenString,
-			errorMessage: "Invalid Email",
-		},
-		{
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname": "", "email": "kan@gmail.com", "password": "password"}`,
-			statusCode:   422,
-			tokenGiven:   tokenString,
-			errorMessage: "required Nickname",
-		},
-		{
-			id:           strconv.Itoa(int(AuthID)),
-			updateJSON:   `{"nickname": "Kan", "email": "", "password": "password"}`,
-			statusCode:   422,
-			tokenGiven:   tokenString,
-			errorMessage: "required Email",
-		},
-		{
-			id:         "unknwon",
-			tokenGiven: tokenString,
-			statusCode: 400,
-		},
-		{
-			// When user 2 is using user 1 token
-			id:           strconv.Itoa(int(2)),
-			updateJSON:   `{"nickname": "Mike", "email": "mike@gmail.com", "password": "password"}`,
-			tokenGiven:   tokenString,
-			statusCode:   401,
-			errorMessage: "Unauthorized",
+			id:           bruce.User.ID.String(),
+			token:        bruce.Token,
+			inputJSON:    `{"first_name": "", "last_name": "Wayne", "phone": "2125478965", "email": "batman@wayne.com"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "First name required",
 		},
 	}
 
 	for _, v := range samples {
-
-		req, err := http.NewRequest("POST", "/users", bytes.NewBufferString(v.updateJSON))
-		if err != nil {
-			t.Errorf("This is the error: %v\n", err)
-		}
-		req = mux.SetURLVars(req, map[string]string{"id": v.id})
-
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(server.UpdateUser)
-
-		req.Header.Set("Authorization", v.tokenGiven)
-
-		handler.ServeHTTP(rr, req)
-
-		responseMap := make(map[string]interface{})
-		err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
-		if err != nil {
-			t.Errorf("Cannot convert to json: %v", err)
-		}
+		rr := serve(server.UpdateUser, "PUT", v.inputJSON, v.token, map[string]string{"id": v.id})
 		assert.Equal(t, rr.Code, v.statusCode)
-		if v.statusCode == 200 {
-			assert.Equal(t, responseMap["nickname"], v.updateNickname)
-			assert.Equal(t, responseMap["email"], v.updateEmail)
-		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
-			assert.Equal(t, responseMap["error"], v.errorMessage)
+		if v.statusCode == http.StatusOK {
+			reply := responses.UserResponse{}
+			decode(t, rr, &reply)
+			assert.Equal(t, reply.User.FirstName, "Batman")
+			assert.Equal(t, reply.User.SmartID, bruce.User.SmartID)
+			continue
 		}
+		assert.Equal(t, errorMessage(t, rr), v.errorMessage)
 	}
 }
 
 func TestDeleteUser(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	alfred := signup(t, server, "Alfred", "alfred@wayne.com")
 
-	var AuthEmail, AuthPassword string
-	var AuthID uint32
-
-	err := refreshUserTable()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	users, err := seedUsers() //we need atleast two users to properly check the update
-	if err != nil {
-		log.Fatalf("Error seeding user: %v\n", err)
-	}
-	// Get only the first and log him in
-	for _, user := range users {
-		if user.ID == 2 {
-			continue
-		}
-		AuthID = user.ID
-		AuthEmail = user.Email
-		AuthPassword = "password" ////Note the password in the database is already hashed, we want unhashed
-	}
-	//Login the user and get the authentication token
-	token, err := server.SignIn(AuthEmail, AuthPassword)
-	if err != nil {
-		log.Fatalf("cannot login: %v\n", err)
-	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
-
-	userSample := []struct {
-		id           string
-		tokenGiven   string
-		statusCode   int
-		errorMessage string
-	}{
-		{
-			// Convert int32 to int first before converting to string
-			id:           strconv.Itoa(int(AuthID)),
-			tokenGiven:   tokenString,
-			statusCode:   204,
-			errorMessage: "",
-		},
-		{
-			// When no token is given
-			id:           strconv.Itoa(int(AuthID)),
-			tokenGiven:   "",
-			statusCode:   401,
-			errorMessage: "Unauthorized",
-		},
-		{
-			// When incorrect token is given
-			id:           strconv.Itoa(int(AuthID)),
-			tokenGiven:   "This is an incorrect token",
-			statusCode:   401,
-			errorMessage: "Unauthorized",
-		},
-		{
-			id:         "unknwon",
-			tokenGiven: tokenString,
-			statusCode: 400,
-		},
-		{
-			// User 2 trying to use User 1 token
-			id:           strconv.Itoa(int(2)),
-			tokenGiven:   tokenString,
-			statusCode:   401,
-			errorMessage: "Unauthorized",
-		},
-	}
-	for _, v := range userSample {
+	rr := serve(server.DeleteUser, "DELETE", "", alfred.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
 
-		req, err := http.NewRequest("GET", "/users", nil)
-		if err != nil {
-			t.Errorf("This is the error: %v\n", err)
-		}
-		req = mux.SetURLVars(req, map[string]string{"id": v.id})
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(server.DeleteUser)
-
-		req.Header.Set("Authorization", v.tokenGiven)
-
-		handler.ServeHTTP(rr, req)
-		assert.Equal(t, rr.Code, v.statusCode)
+	rr = serve(server.DeleteUser, "DELETE", "", bruce.Token, map[string]string{"id": bruce.User.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
 
-		if v.statusCode == 401 && v.errorMessage != "" {
-			responseMap := make(map[string]interface{})
-			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
-			if err != nil {
-				t.Errorf("Cannot convert to json: %v", err)
-			}
-			assert.Equal(t, responseMap["error"], v.errorMessage)
-		}
-	}
+	_, err := server.Store.Users().FindByID(bruce.User.ID)
+	assert.NotEqual(t, err, nil)
 }
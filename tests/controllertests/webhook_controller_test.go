@@ -0,0 +1,433 @@
+package controllertests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/middlewares"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"github.com/nmelhado/smartmail-api/api/webhooks"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+// receiver is a local webhook endpoint. It answers with the statuses in order, then with 200, and keeps the deliveries whose signature verified
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	received []webhooks.Payload
+	events   []string
+	invalid  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	// The dispatcher signs with the time it was given as the clock runs on, so the tolerance covers the times the tests move it forward to
+	err := webhooks.Verify(rc.secret, r.Header.Get(webhooks.SignatureHeader), body, time.Now(), 24*time.Hour)
+	if err != nil {
+		rc.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if len(rc.statuses) > 0 {
+		status := rc.statuses[0]
+		rc.statuses = rc.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	payload := webhooks.Payload{}
+	json.Unmarshal(body, &payload)
+	rc.received = append(rc.received, payload)
+	rc.events = append(rc.events, r.Header.Get(webhooks.EventHeader))
+}
+
+// stalledReceiver accepts every delivery, holding the first one it receives until it is released
+type stalledReceiver struct {
+	mu       sync.Mutex
+	requests int
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (sr *stalledReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sr.mu.Lock()
+	sr.requests++
+	first := sr.requests == 1
+	sr.mu.Unlock()
+	if first {
+		close(sr.started)
+		<-sr.release
+	}
+}
+
+// endpoints serves local TLS receivers under example.com names, which webhooks may be registered to. The test certificate
+// covers *.example.com, and the dispatcher's client connects each name to its receiver
+type endpoints struct {
+	servers map[string]*httptest.Server
+}
+
+func newEndpoints() *endpoints {
+	return &endpoints{servers: map[string]*httptest.Server{}}
+}
+
+// add starts a receiver and returns its URL
+func (e *endpoints) add(name string, handler http.Handler) string {
+	e.servers[name+".example.com"] = httptest.NewTLSServer(handler)
+	return "https://" + name + ".example.com"
+}
+
+// client returns an HTTP client that trusts the receivers and reaches them by name
+func (e *endpoints) client() *http.Client {
+	transport := &http.Transport{}
+	for _, server := range e.servers {
+		transport = server.Client().Transport.(*http.Transport).Clone()
+		break
+	}
+	dialer := &net.Dialer{}
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		server, ok := e.servers[host]
+		if !ok {
+			return nil, fmt.Errorf("no receiver for %s", host)
+		}
+		return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	return &http.Client{Transport: transport}
+}
+
+func (e *endpoints) close() {
+	for _, server := range e.servers {
+		server.Close()
+	}
+}
+
+// dispatcher creates a dispatcher that sends to the receivers
+func (e *endpoints) dispatcher(server *controllers.Server) *webhooks.Dispatcher {
+	dispatcher := webhooks.NewDispatcher(server.Store)
+	dispatcher.Client = e.client()
+	return dispatcher
+}
+
+// registerWebhook creates a webhook for the API user through the webhooks handler
+func registerWebhook(t *testing.T, server *controllers.Server, token string, inputJSON string) responses.Webhook {
+	handler := middlewares.SetMiddlewareScope(server.Store, server.CreateWebhook, models.PackageReadScope)
+	rr := serve(handler, "POST", inputJSON, token, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot register the webhook: %d %s\n", rr.Code, rr.Body.String())
+	}
+	reply := responses.Webhook{}
+	decode(t, rr, &reply)
+	return reply
+}
+
+// moveUser saves a new address for the user through the address handler and returns the assignment's ID
+func moveUser(t *testing.T, server *controllers.Server, user responses.UserAndAddressResponse, status models.Status, endDate string) uint64 {
+	rr := serve(server.CreateAddress, "POST", `{
+	"user_id": "`+user.User.ID.String()+`",
+	"address": {"line_one": "1 Martha Boulevard", "city": "Gotham", "state": "NY", "zip_code": "10675", "country": "United States"},
+	"status": "`+string(status)+`",
+	"start_date": "2020-06-01T00:00:00Z"`+endDate+`
+}`, user.Token, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("cannot save the address: %d %s\n", rr.Code, rr.Body.String())
+	}
+	reply := struct {
+		Address responses.BasicAddress `json:"address"`
+	}{}
+	decode(t, rr, &reply)
+	return reply.Address.ID
+}
+
+func TestAddressChangeWebhook(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, metropolisToken := apiUserToken(t, server, "metropolis", models.FullPermission)
+
+	err := server.Store.Packages().Save(&models.Package{MailCarrierID: gotham.ID, RecipientID: uuid.NullUUID{UUID: bruce.User.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784")})
+	assert.Equal(t, err, nil)
+
+	receivers := newEndpoints()
+	defer receivers.close()
+	gothamReceiver := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	gothamURL := receivers.add("gotham", gothamReceiver)
+	holdsOnly := &receiver{}
+	holdsURL := receivers.add("holds", holdsOnly)
+	metropolisReceiver := &receiver{}
+	metropolisURL := receivers.add("metropolis", metropolisReceiver)
+
+	gothamReceiver.secret = registerWebhook(t, server, gothamToken, fmt.Sprintf(`{"url": %q}`, gothamURL)).Secret
+	holdsOnly.secret = registerWebhook(t, server, gothamToken, fmt.Sprintf(`{"url": %q, "events": ["address.hold"]}`, holdsURL)).Secret
+	// Metropolis has no package in flight for Bruce
+	metropolisReceiver.secret = registerWebhook(t, server, metropolisToken, fmt.Sprintf(`{"url": %q}`, metropolisURL)).Secret
+
+	moveUser(t, server, bruce, models.Permanent, "")
+
+	dispatcher := receivers.dispatcher(server)
+	now := time.Now()
+	delivered, err := dispatcher.Deliver(now)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 0)
+
+	// The retry waits for the backoff
+	delivered, err = dispatcher.Deliver(now.Add(10 * time.Second))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 0)
+	// The backoff runs from when the attempt was made, a moment after the batch started
+	delivered, err = dispatcher.Deliver(now.Add(models.WebhookBackoff(1) + time.Second))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 1)
+
+	assert.Equal(t, gothamReceiver.invalid, 0)
+	assert.Equal(t, len(gothamReceiver.received), 1)
+	assert.Equal(t, gothamReceiver.events[0], string(models.AddressChangedEvent))
+	assert.Equal(t, gothamReceiver.received[0].Event, models.AddressChangedEvent)
+	assert.Equal(t, gothamReceiver.received[0].Tracking, []string{"1Z999AA10123456784"})
+	assert.Equal(t, len(holdsOnly.received), 0)
+	assert.Equal(t, len(metropolisReceiver.received), 0)
+
+	// A hold reaches the webhook subscribed to holds
+	moveUser(t, server, bruce, models.Hold, `, "end_date": "2020-07-01T00:00:00Z"`)
+	delivered, err = dispatcher.Deliver(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 2)
+	assert.Equal(t, len(holdsOnly.received), 1)
+	assert.Equal(t, holdsOnly.received[0].Event, models.AddressHoldEvent)
+}
+
+func TestDeleteAddressWebhook(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+
+	err := server.Store.Packages().Save(&models.Package{MailCarrierID: gotham.ID, RecipientID: uuid.NullUUID{UUID: bruce.User.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784")})
+	assert.Equal(t, err, nil)
+
+	receivers := newEndpoints()
+	defer receivers.close()
+	gothamReceiver := &receiver{}
+	gothamReceiver.secret = registerWebhook(t, server, gothamToken, fmt.Sprintf(`{"url": %q}`, receivers.add("gotham", gothamReceiver))).Secret
+
+	hold := moveUser(t, server, bruce, models.Hold, `, "end_date": "2099-01-01T00:00:00Z"`)
+	dispatcher := receivers.dispatcher(server)
+	delivered, err := dispatcher.Deliver(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 1)
+
+	// Cancelling the hold puts the permanent address back in effect
+	rr := serve(server.DeleteAddress, "DELETE", "", bruce.Token, map[string]string{"id": fmt.Sprintf("%d", hold)})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	delivered, err = dispatcher.Deliver(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 1)
+	assert.Equal(t, len(gothamReceiver.received), 2)
+	assert.Equal(t, gothamReceiver.received[1].Event, models.AddressChangedEvent)
+	assert.Equal(t, gothamReceiver.received[1].Status, models.Permanent)
+	assert.Equal(t, gothamReceiver.received[1].Tracking, []string{"1Z999AA10123456784"})
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+
+	err := server.Store.Packages().Save(&models.Package{MailCarrierID: gotham.ID, RecipientID: uuid.NullUUID{UUID: bruce.User.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784")})
+	assert.Equal(t, err, nil)
+
+	failing := []int{}
+	for i := 0; i < models.MaxWebhookAttempts; i++ {
+		failing = append(failing, http.StatusInternalServerError)
+	}
+	receivers := newEndpoints()
+	defer receivers.close()
+	gothamReceiver := &receiver{statuses: failing}
+	gothamReceiver.secret = registerWebhook(t, server, gothamToken, fmt.Sprintf(`{"url": %q}`, receivers.add("gotham", gothamReceiver))).Secret
+
+	moveUser(t, server, bruce, models.Permanent, "")
+
+	dispatcher := receivers.dispatcher(server)
+	now := time.Now()
+	for attempt := 1; attempt <= models.MaxWebhookAttempts; attempt++ {
+		_, err = dispatcher.Deliver(now)
+		assert.Equal(t, err, nil)
+		now = now.Add(models.WebhookBackoff(attempt) + time.Second)
+	}
+
+	deadHandler := middlewares.SetMiddlewareScope(server.Store, server.GetDeadWebhookDeliveries, models.PackageReadScope)
+	rr := serve(deadHandler, "GET", "", gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	dead := responses.WebhookDeliveriesResponse{}
+	decode(t, rr, &dead)
+	assert.Equal(t, len(dead.Deliveries), 1)
+	assert.Equal(t, dead.Deliveries[0].Attempts, models.MaxWebhookAttempts)
+	assert.Equal(t, dead.Deliveries[0].LastStatus.Int64, int64(http.StatusInternalServerError))
+
+	// Dead deliveries are not tried again until they are redelivered
+	delivered, err := dispatcher.Deliver(now.Add(24 * time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 0)
+
+	redeliverHandler := middlewares.SetMiddlewareScope(server.Store, server.RedeliverWebhookDelivery, models.PackageReadScope)
+	rr = serve(redeliverHandler, "POST", "", gothamToken, map[string]string{"id": fmt.Sprintf("%d", dead.Deliveries[0].ID)})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	delivered, err = dispatcher.Deliver(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 1)
+	assert.Equal(t, len(gothamReceiver.received), 1)
+}
+
+func TestCreateWebhook(t *testing.T) {
+	server := newServer()
+	_, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	_, limitedToken := apiUserToken(t, server, "bludhaven", models.LimitedPermission)
+	handler := middlewares.SetMiddlewareScope(server.Store, server.CreateWebhook, models.PackageReadScope)
+
+	samples := []struct {
+		token        string
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			token:      gothamToken,
+			inputJSON:  `{"url": "https://gotham.example/hooks", "events": ["address.changed", "address.temporary"]}`,
+			statusCode: http.StatusCreated,
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "gotham.example/hooks"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must be an absolute https URL",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "http://gotham.example/hooks"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must be an absolute https URL",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "https://localhost:8080/hooks"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must not point to a local or private address",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "https://127.0.0.1/hooks"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must not point to a local or private address",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "https://10.0.0.8/hooks"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must not point to a local or private address",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "https://169.254.169.254/latest/meta-data"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must not point to a local or private address",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "https://[::1]/hooks"}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "URL must not point to a local or private address",
+		},
+		{
+			token:        gothamToken,
+			inputJSON:    `{"url": "https://gotham.example/hooks", "events": ["address.deleted"]}`,
+			statusCode:   http.StatusUnprocessableEntity,
+			errorMessage: "Events must be address.changed, address.temporary, address.hold or address.undeliverable",
+		},
+		{
+			token:      limitedToken,
+			inputJSON:  `{"url": "https://bludhaven.example/hooks"}`,
+			statusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, v := range samples {
+		rr := serve(handler, "POST", v.inputJSON, v.token, nil)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusCreated {
+			reply := responses.Webhook{}
+			decode(t, rr, &reply)
+			assert.NotEqual(t, reply.Secret, "")
+			assert.Equal(t, len(reply.Events), 2)
+			continue
+		}
+		if v.errorMessage != "" {
+			assert.Equal(t, errorMessage(t, rr), v.errorMessage)
+		}
+	}
+
+	// The secret is only shown when the webhook is created
+	rr := serve(middlewares.SetMiddlewareScope(server.Store, server.GetWebhooks, models.PackageReadScope), "GET", "", gothamToken, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	webhookList := responses.WebhooksResponse{}
+	decode(t, rr, &webhookList)
+	assert.Equal(t, len(webhookList.Webhooks), 1)
+	assert.Equal(t, webhookList.Webhooks[0].Secret, "")
+}
+
+func TestWebhookDeliveriesAreClaimedOneAtATime(t *testing.T) {
+	server := newServer()
+	bruce := signup(t, server, "Bruce", "bruce@wayne.com")
+	gotham, gothamToken := apiUserToken(t, server, "gotham", models.FullPermission)
+	err := server.Store.Packages().Save(&models.Package{MailCarrierID: gotham.ID, RecipientID: uuid.NullUUID{UUID: bruce.User.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784")})
+	assert.Equal(t, err, nil)
+
+	receivers := newEndpoints()
+	defer receivers.close()
+	stalled := &stalledReceiver{started: make(chan struct{}), release: make(chan struct{})}
+	endpoint := receivers.add("gotham", stalled)
+	registerWebhook(t, server, gothamToken, fmt.Sprintf(`{"url": %q}`, endpoint+"/first"))
+	registerWebhook(t, server, gothamToken, fmt.Sprintf(`{"url": %q}`, endpoint+"/second"))
+	moveUser(t, server, bruce, models.Permanent, "")
+
+	// While one dispatcher waits on a slow receiver, the rest of the queue is left for another
+	now := time.Now()
+	stuck := make(chan int)
+	go func() {
+		delivered, _ := receivers.dispatcher(server).Deliver(now)
+		stuck <- delivered
+	}()
+	<-stalled.started
+	delivered, err := receivers.dispatcher(server).Deliver(now)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, delivered, 1)
+	close(stalled.release)
+	assert.Equal(t, <-stuck, 1)
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	endpoint := httptest.NewTLSServer(&receiver{})
+	defer endpoint.Close()
+
+	// A name that resolves to a private address passes registration, it is refused when the delivery is sent
+	_, err := webhooks.NewClient().Post(endpoint.URL, "application/json", nil)
+	if err == nil || !strings.Contains(err.Error(), "is not public") {
+		t.Fatalf("expected the private address to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package geocodetests
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const centroids = `zip_code,city,state,latitude,longitude
+10021,New York,NY,40.7690,-73.9584
+75201,Dallas,TX,32.7876,-96.7994
+,Gotham,NY,40.7128,-74.0060
+`
+
+type countingGeocoder struct {
+	calls int
+}
+
+func (c *countingGeocoder) Geocode(address models.Address) (geocode.Location, error) {
+	c.calls++
+	return geocode.Location{Latitude: 1, Longitude: 2}, nil
+}
+
+func TestOfflineGeocoder(t *testing.T) {
+	offline, err := geocode.NewOffline(strings.NewReader(centroids))
+	if err != nil {
+		t.Errorf("this is the error loading the centroid table: %v\n", err)
+		return
+	}
+
+	samples := []struct {
+		address  models.Address
+		location geocode.Location
+		err      error
+	}{
+		{
+			address:  models.Address{LineOne: "26 Electric Avenue", City: "New York", State: "NY", ZipCode: "10021-1234"},
+			location: geocode.Location{Latitude: 40.7690, Longitude: -73.9584},
+		},
+		{
+			address:  models.Address{LineOne: "1 Martha Boulevard", City: "gotham", State: "ny", ZipCode: "99999"},
+			location: geocode.Location{Latitude: 40.7128, Longitude: -74.0060},
+		},
+		{
+			address: models.Address{LineOne: "1 Nowhere Road", City: "Smallville", State: "KS", ZipCode: "66002"},
+			err:     geocode.ErrNotFound,
+		},
+	}
+
+	for _, v := range samples {
+		location, err := offline.Geocode(v.address)
+		assert.Equal(t, err, v.err)
+		assert.Equal(t, location, v.location)
+	}
+}
+
+func TestCachedGeocoder(t *testing.T) {
+	counter := &countingGeocoder{}
+	cache := geocode.NewCache(counter, 2)
+
+	first := models.Address{LineOne: "353 Main Street", City: "Dallas", State: "TX", ZipCode: "75201"}
+	sameAsFirst := models.Address{LineOne: " 353  main street", City: "DALLAS", State: "tx", ZipCode: "75201"}
+	second := models.Address{LineOne: "26 Electric Avenue", City: "New York", State: "NY", ZipCode: "10021"}
+	third := models.Address{LineOne: "1 Martha Boulevard", City: "Gotham", State: "NY", ZipCode: "10674"}
+
+	for _, address := range []models.Address{first, sameAsFirst, second, first} {
+		_, err := cache.Geocode(address)
+		if err != nil {
+			t.Errorf("this is the error geocoding: %v\n", err)
+			return
+		}
+	}
+	assert.Equal(t, counter.calls, 2)
+
+	// third evicts first, the oldest entry
+	_, _ = cache.Geocode(third)
+	_, _ = cache.Geocode(first)
+	assert.Equal(t, counter.calls, 4)
+}
+
+func TestGoogleGeocoder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "test-key" {
+			fmt.Fprint(w, `{"results": [], "error_message": "The provided API key is invalid."}`)
+			return
+		}
+		fmt.Fprint(w, `{"results": [{"geometry": {"location": {"lat": 40.769, "lng": -73.9584}}}]}`)
+	}))
+	defer ts.Close()
+
+	google := geocode.NewGoogle("test-key")
+	google.BaseURL = ts.URL
+	location, err := google.Geocode(models.Address{LineOne: "26 Electric Avenue", City: "New York", State: "NY", ZipCode: "10021"})
+	if err != nil {
+		t.Errorf("this is the error geocoding: %v\n", err)
+		return
+	}
+	assert.Equal(t, location, geocode.Location{Latitude: 40.769, Longitude: -73.9584})
+
+	google.APIKey = "wrong-key"
+	_, err = google.Geocode(models.Address{LineOne: "26 Electric Avenue", City: "New York", State: "NY", ZipCode: "10021"})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestMiles(t *testing.T) {
+	newYork := geocode.Location{Latitude: 40.7690, Longitude: -73.9584}
+	dallas := geocode.Location{Latitude: 32.7876, Longitude: -96.7994}
+	assert.Equal(t, geocode.Miles(newYork, newYork), float64(0))
+	assert.Equal(t, math.Round(geocode.Miles(newYork, dallas)), float64(1374))
+	assert.Equal(t, geocode.Miles(newYork, dallas), geocode.Miles(dallas, newYork))
+}
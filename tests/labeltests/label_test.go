@@ -0,0 +1,147 @@
+package labeltests
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/labels"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var label = labels.Label{
+	From: responses.AddressSmartIDResponse{
+		BusinessName: "Wayne Enterprises",
+		AttentionTo:  "Lucius Fox",
+		LineOne:      "1 Wayne Tower",
+		City:         "Gotham",
+		State:        "NY",
+		ZipCode:      "10001",
+		Country:      "United States",
+	},
+	To: responses.AddressSmartIDResponse{
+		SmartID:   "1B3D5F7H",
+		FirstName: "Bruce",
+		LastName:  "Wayne",
+		LineOne:   "1007 Mountain Drive",
+		LineTwo:   "(Manor) ^Gate_2~",
+		City:      "Gotham",
+		State:     "NY",
+		ZipCode:   "10674",
+		Country:   "United States",
+	},
+	Carrier:  "ups",
+	Tracking: "1Z999AA10123456784",
+	Date:     "2020-06-02",
+}
+
+func TestRenderGolden(t *testing.T) {
+	for _, format := range []labels.Format{labels.PDF, labels.ZPL} {
+		rendered, err := labels.Render(label, format)
+		assert.Equal(t, err, nil)
+		golden := filepath.Join("testdata", "label."+string(format))
+		if *update {
+			err = ioutil.WriteFile(golden, rendered, 0644)
+			assert.Equal(t, err, nil)
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("cannot read %s, run the tests with -update to create it: %v\n", golden, err)
+		}
+		if !bytes.Equal(rendered, expected) {
+			t.Errorf("%s label does not match %s, run the tests with -update if the change is intended\n", format, golden)
+		}
+	}
+}
+
+func TestRenderWithoutSmartID(t *testing.T) {
+	// Addresses looked up with a delivery token have no SmartID, so no QR code is drawn
+	tokenLabel := label
+	tokenLabel.To.SmartID = ""
+	rendered, err := labels.Render(tokenLabel, labels.ZPL)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, bytes.Contains(rendered, []byte("^BQN")), false)
+	assert.Equal(t, bytes.Contains(rendered, []byte("^BCN")), true)
+
+	tokenLabel.Tracking = ""
+	_, err = labels.Render(tokenLabel, labels.PDF)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestParseFormat(t *testing.T) {
+	samples := []struct {
+		format   string
+		expected labels.Format
+		err      error
+	}{
+		{format: "", expected: labels.PDF, err: nil},
+		{format: "PDF", expected: labels.PDF, err: nil},
+		{format: "zpl", expected: labels.ZPL, err: nil},
+		{format: "png", expected: "", err: labels.ErrFormat},
+	}
+	for _, v := range samples {
+		format, err := labels.ParseFormat(v.format)
+		assert.Equal(t, format, v.expected)
+		assert.Equal(t, err, v.err)
+	}
+}
+
+func TestCode128(t *testing.T) {
+	widths, err := labels.Code128("PJJ123C")
+	assert.Equal(t, err, nil)
+	// Start B, 7 characters and the checksum are 6 widths each, the stop pattern is 7
+	assert.Equal(t, len(widths), 9*6+7)
+	assert.Equal(t, widths[:6], []int{2, 1, 1, 2, 1, 4})
+	// The checksum is (104 + 1*48 + 2*42 + 3*42 + 4*17 + 5*18 + 6*19 + 7*35) % 103 = 55
+	assert.Equal(t, widths[48:54], []int{3, 1, 1, 3, 2, 1})
+	assert.Equal(t, widths[54:], []int{2, 3, 3, 1, 1, 1, 2})
+
+	// Every symbol is 11 modules wide, so a run of digits in code set C is shorter than in code set B
+	modules := func(widths []int) int {
+		total := 0
+		for _, width := range widths {
+			total += width
+		}
+		return total
+	}
+	digits, err := labels.Code128("12345678")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, modules(digits), (1+4+1)*11+13)
+	mixed, err := labels.Code128("1Z12345")
+	assert.Equal(t, err, nil)
+	// 1 and Z in code set B, 1 to start the odd run, then code C, 23 and 45
+	assert.Equal(t, modules(mixed), (1+3+1+2+1)*11+13)
+
+	_, err = labels.Code128("café")
+	assert.Equal(t, err, labels.ErrCode128Character)
+}
+
+func TestQR(t *testing.T) {
+	samples := []struct {
+		data string
+		size int
+	}{
+		{data: "1B3D5F7H", size: 21},
+		{data: "https://smartmail.example/1B3D5F7H", size: 29},
+		{data: string(bytes.Repeat([]byte("A"), 62)), size: 33},
+	}
+	for _, v := range samples {
+		modules, err := labels.QR(v.data)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(modules), v.size)
+		// Finder patterns in three corners, each with a dark centre and a light ring
+		for _, corner := range [][2]int{{3, 3}, {3, v.size - 4}, {v.size - 4, 3}} {
+			assert.Equal(t, modules[corner[0]][corner[1]], true)
+			assert.Equal(t, modules[corner[0]-2][corner[1]], false)
+			assert.Equal(t, modules[corner[0]-3][corner[1]], true)
+		}
+	}
+
+	_, err := labels.QR(string(bytes.Repeat([]byte("A"), 63)))
+	assert.Equal(t, err, labels.ErrQRTooLong)
+}
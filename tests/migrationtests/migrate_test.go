@@ -0,0 +1,178 @@
+package migrationtests
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres database driver
+	"github.com/nmelhado/smartmail-api/api/migrations"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations`
+const selectSchemaMigrations = `SELECT \* FROM "schema_migrations"`
+
+func newDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("this is the error opening a mock DB: %v\n", err)
+	}
+	gormDB, err := gorm.Open("postgres", db)
+	if err != nil {
+		t.Fatalf("this is the error opening gorm: %v\n", err)
+	}
+	gormDB.LogMode(false)
+	return gormDB, mock
+}
+
+// writeMigrations creates a migration directory from a map of file names to SQL
+func writeMigrations(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatalf("this is the error creating a temp dir: %v\n", err)
+	}
+	for name, sql := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(sql), 0644)
+		if err != nil {
+			t.Fatalf("this is the error writing %s: %v\n", name, err)
+		}
+	}
+	return dir
+}
+
+var twoMigrations = map[string]string{
+	"0001_create_widgets.up.sql":   "CREATE TABLE widgets (id bigserial PRIMARY KEY);",
+	"0001_create_widgets.down.sql": "DROP TABLE widgets;",
+	"0002_add_colour.up.sql":       "-- migrate:no-transaction\nALTER TABLE widgets ADD COLUMN colour text;\nCREATE INDEX ix_widgets_colour ON widgets (colour);\n",
+	"0002_add_colour.down.sql":     "ALTER TABLE widgets DROP COLUMN colour;",
+	"README.md":                    "not a migration",
+}
+
+func expectApplied(mock sqlmock.Sqlmock, versions ...int) {
+	mock.ExpectExec(createSchemaMigrations).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "name", "applied_at"})
+	for _, version := range versions {
+		rows.AddRow(version, "applied", nil)
+	}
+	mock.ExpectQuery(selectSchemaMigrations).WillReturnRows(rows)
+}
+
+func TestRepoMigrationsLoad(t *testing.T) {
+	all, err := migrations.Load(filepath.Join("..", "..", migrations.DefaultDir))
+	if err != nil {
+		t.Fatalf("this is the error loading the migrations: %v\n", err)
+	}
+	for i, migration := range all {
+		// Versions are consecutive so that a gap is noticed in review
+		assert.Equal(t, migration.Version, i+1)
+	}
+}
+
+func TestLoadOrdersMigrations(t *testing.T) {
+	dir := writeMigrations(t, twoMigrations)
+	defer os.RemoveAll(dir)
+
+	all, err := migrations.Load(dir)
+	if err != nil {
+		t.Fatalf("this is the error loading the migrations: %v\n", err)
+	}
+	assert.Equal(t, len(all), 2)
+	assert.Equal(t, all[0].Name, "create_widgets")
+	assert.Equal(t, all[1].Name, "add_colour")
+}
+
+func TestLoadRequiresDownFile(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{"0001_create_widgets.up.sql": "CREATE TABLE widgets (id bigserial PRIMARY KEY);"})
+	defer os.RemoveAll(dir)
+
+	_, err := migrations.Load(dir)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	dir := writeMigrations(t, twoMigrations)
+	defer os.RemoveAll(dir)
+	db, mock := newDB(t)
+
+	expectApplied(mock, 1)
+	// A no-transaction migration is sent one statement at a time
+	mock.ExpectExec(`ALTER TABLE widgets ADD COLUMN colour text;`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE INDEX ix_widgets_colour`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "schema_migrations"`).WithArgs(2, "add_colour", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectCommit()
+
+	migrator, err := migrations.New(db, dir)
+	if err != nil {
+		t.Fatalf("this is the error loading the migrations: %v\n", err)
+	}
+	applied, err := migrator.Up()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(applied), 1)
+	assert.Equal(t, applied[0].Version, 2)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	dir := writeMigrations(t, twoMigrations)
+	defer os.RemoveAll(dir)
+	db, mock := newDB(t)
+
+	expectApplied(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE widgets`).WillReturnError(errors.New("relation already exists"))
+	mock.ExpectRollback()
+
+	migrator, _ := migrations.New(db, dir)
+	applied, err := migrator.Up()
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, len(applied), 0)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestDownRollsBackNewestMigration(t *testing.T) {
+	dir := writeMigrations(t, twoMigrations)
+	defer os.RemoveAll(dir)
+	db, mock := newDB(t)
+
+	expectApplied(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE widgets DROP COLUMN colour`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM "schema_migrations" WHERE \(version = \$1\)`).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	migrator, _ := migrations.New(db, dir)
+	rolledBack, err := migrator.Down(1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rolledBack), 1)
+	assert.Equal(t, rolledBack[0].Version, 2)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestCheckCurrent(t *testing.T) {
+	dir := writeMigrations(t, twoMigrations)
+	defer os.RemoveAll(dir)
+	db, mock := newDB(t)
+	migrator, _ := migrations.New(db, dir)
+
+	expectApplied(mock, 1)
+	err := migrator.CheckCurrent()
+	assert.Equal(t, errors.Is(err, migrations.ErrSchemaBehind), true)
+
+	expectApplied(mock, 1, 2)
+	assert.Equal(t, migrator.CheckCurrent(), nil)
+
+	// A migration applied by a newer build, or removed since it was applied, is not treated as current
+	expectApplied(mock, 1, 2, 3)
+	err = migrator.CheckCurrent()
+	assert.Equal(t, errors.Is(err, migrations.ErrSchemaAhead), true)
+	expectApplied(mock, 2, 3)
+	err = migrator.CheckCurrent()
+	assert.Equal(t, errors.Is(err, migrations.ErrSchemaAhead), true)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
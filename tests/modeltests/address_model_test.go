@@ -1,127 +1,204 @@
 package modeltests
 
 import (
-	"log"
 	"testing"
+	"time"
 
-	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	uuid "github.com/satori/go.uuid"
 	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
 )
 
-func TestFindAllPosts(t *testing.T) {
+func TestSaveAssignmentEndsPriorPermanent(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
 
-	err := refreshTables()
-	if err != nil {
-		log.Fatalf("Error refreshing user and post table %v\n", err)
-	}
-	_, _, _, err = seedTables()
-	if err != nil {
-		log.Fatalf("Error seeding user and post table %v\n", err)
-	}
-	posts, err := postInstance.FindAllPosts(server.DB)
-	if err != nil {
-		t.Errorf("this is the error getting the posts: %v\n", err)
-		return
-	}
-	assert.Equal(t, len(*posts), 2)
+	first := seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	second := seedAssignment(t, store, user, "1 Martha Boulevard", models.Permanent, date(2020, 6, 1), null.Time{})
+	assert.Equal(t, second.User.Email, "bruce@wayne.com")
+	assert.Equal(t, second.Address.LineOne, "1 Martha Boulevard")
+
+	prior, err := store.Assignments().FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, prior.EndDate, null.TimeFrom(date(2020, 5, 31)))
+
+	found, err := store.Assignments().FindMailingAddress(*user, date(2020, 1, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "1007 Mountain Drive")
+
+	found, err = store.Assignments().FindMailingAddress(*user, date(2020, 7, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "1 Martha Boulevard")
 }
 
-func TestSavePost(t *testing.T) {
+func TestSaveAssignmentRejectsOverlappingTemporary(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	seedAssignment(t, store, user, "Wayne Villa", models.Temporary, date(2020, 7, 1), null.TimeFrom(date(2020, 7, 31)))
 
-	err := refreshUserAndPostTable()
-	if err != nil {
-		log.Fatalf("Error user and post refreshing table %v\n", err)
-	}
+	address, err := store.Addresses().Save(&models.Address{LineOne: "Ski Lodge", City: "Aspen", State: "CO", ZipCode: "81611", Country: "United States"})
+	assert.Equal(t, err, nil)
+	_, err = store.Assignments().Save(&models.AddressAssignment{UserID: user.ID, AddressID: address.ID, Status: models.Temporary, StartDate: date(2020, 7, 15), EndDate: null.TimeFrom(date(2020, 8, 15))})
+	assert.Equal(t, err, models.ErrTemporaryConflict)
 
-	user, err := seedOneUser()
-	if err != nil {
-		log.Fatalf("Cannot seed user %v\n", err)
-	}
+	// A temporary address that starts once the other ends is fine
+	_, err = store.Assignments().Save(&models.AddressAssignment{UserID: user.ID, AddressID: address.ID, Status: models.Temporary, StartDate: date(2020, 8, 1), EndDate: null.TimeFrom(date(2020, 8, 15))})
+	assert.Equal(t, err, nil)
 
-	newPost := models.Post{
-		ID:       1,
-		Title:    "This is the title",
-		Content:  "This is the content",
-		AuthorID: user.ID,
-	}
-	savedPost, err := newPost.SavePost(server.DB)
-	if err != nil {
-		t.Errorf("this is the error getting the post: %v\n", err)
-		return
-	}
-	assert.Equal(t, newPost.ID, savedPost.ID)
-	assert.Equal(t, newPost.Title, savedPost.Title)
-	assert.Equal(t, newPost.Content, savedPost.Content)
-	assert.Equal(t, newPost.AuthorID, savedPost.AuthorID)
+	found, err := store.Assignments().FindPackageAddress(*user, date(2020, 7, 10))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "Wayne Villa")
 
+	found, err = store.Assignments().FindPackageAddress(*user, date(2020, 8, 10))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "Ski Lodge")
 }
 
-func TestGetPostByID(t *testing.T) {
+func TestFindAddressDuringHold(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	seedAssignment(t, store, user, "1007 Mountain Drive", models.MailOnlyHold, date(2020, 7, 1), null.TimeFrom(date(2020, 7, 20)))
 
-	err := refreshUserAndPostTable()
-	if err != nil {
-		log.Fatalf("Error refreshing user and post table: %v\n", err)
-	}
-	post, err := seedOneUserAndOnePost()
-	if err != nil {
-		log.Fatalf("Error Seeding User and post table")
-	}
-	foundPost, err := postInstance.FindPostByID(server.DB, post.ID)
-	if err != nil {
-		t.Errorf("this is the error getting one user: %v\n", err)
-		return
-	}
-	assert.Equal(t, foundPost.ID, post.ID)
-	assert.Equal(t, foundPost.Title, post.Title)
-	assert.Equal(t, foundPost.Content, post.Content)
+	held, err := store.Assignments().FindMailingAddress(*user, date(2020, 7, 4))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, held.IsHold(), true)
+
+	// A mail only hold does not stop packages
+	permanent, err := store.Assignments().FindPackageAddress(*user, date(2020, 7, 4))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, permanent.IsHold(), false)
+
+	_, err = store.Assignments().FindMailingAddress(models.User{}, date(2020, 7, 4))
+	assert.Equal(t, err, repository.ErrNotFound)
 }
 
-func TestUpdateAPost(t *testing.T) {
+func TestDeleteAssignmentRestoresPriorPermanent(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	first := seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	second := seedAssignment(t, store, user, "1 Martha Boulevard", models.Permanent, date(2020, 6, 1), null.Time{})
 
-	err := refreshUserAndPostTable()
-	if err != nil {
-		log.Fatalf("Error refreshing user and post table: %v\n", err)
-	}
-	post, err := seedOneUserAndOnePost()
-	if err != nil {
-		log.Fatalf("Error Seeding table")
-	}
-	postUpdate := models.Post{
-		ID:       1,
-		Title:    "modiUpdate",
-		Content:  "modiupdate@gmail.com",
-		AuthorID: post.AuthorID,
-	}
-	updatedPost, err := postUpdate.UpdateAPost(server.DB)
-	if err != nil {
-		t.Errorf("this is the error updating the user: %v\n", err)
-		return
-	}
-	assert.Equal(t, updatedPost.ID, postUpdate.ID)
-	assert.Equal(t, updatedPost.Title, postUpdate.Title)
-	assert.Equal(t, updatedPost.Content, postUpdate.Content)
-	assert.Equal(t, updatedPost.AuthorID, postUpdate.AuthorID)
+	err := store.Assignments().Delete(second)
+	assert.Equal(t, err, nil)
+
+	deleted, err := store.Assignments().FindByID(second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted.Status, models.Deleted)
+
+	prior, err := store.Assignments().FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, prior.EndDate.Valid, false)
 }
 
-func TestDeleteAPost(t *testing.T) {
+func TestExpirySupersededPermanent(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	first := seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	second := seedAssignment(t, store, user, "1 Martha Boulevard", models.Permanent, date(2020, 6, 1), null.Time{})
+	trip := seedAssignment(t, store, user, "Wayne Villa", models.Temporary, date(2020, 6, 10), null.TimeFrom(date(2020, 6, 20)))
+
+	// The temporary address and the superseded permanent address are both expired
+	expired, err := store.Assignments().Expire(date(2020, 7, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expired, int64(2))
+	ended, err := store.Assignments().FindByID(trip.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ended.Status, models.Expired)
+	superseded, err := store.Assignments().FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, superseded.Status, models.Expired)
+	assert.Equal(t, superseded.ExpiredFrom, null.StringFrom(string(models.Permanent)))
+
+	// It is still the address for the dates it covered
+	found, err := store.Assignments().FindMailingAddress(*user, date(2020, 1, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "1007 Mountain Drive")
+	batch, err := store.Assignments().FindForUsers([]uuid.UUID{user.ID}, date(2020, 1, 1), date(2020, 1, 2))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*batch), 1)
+	assert.Equal(t, (*batch)[0].ID, first.ID)
+
+	// and it is restored when the address that replaced it is deleted
+	err = store.Assignments().Delete(second)
+	assert.Equal(t, err, nil)
+	prior, err := store.Assignments().FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, prior.Status, models.Permanent)
+	assert.Equal(t, prior.ExpiredFrom.Valid, false)
+	assert.Equal(t, prior.EndDate.Valid, false)
+	found, err = store.Assignments().FindMailingAddress(*user, date(2020, 7, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "1007 Mountain Drive")
+}
 
-	err := refreshUserAndPostTable()
-	if err != nil {
-		log.Fatalf("Error refreshing user and post table: %v\n", err)
-	}
-	post, err := seedOneUserAndOnePost()
-	if err != nil {
-		log.Fatalf("Error Seeding tables")
-	}
-	isDeleted, err := postInstance.DeleteAPost(server.DB, post.ID, post.AuthorID)
-	if err != nil {
-		t.Errorf("this is the error deleting the user: %v\n", err)
-		return
+func TestUpdateAssignmentMovesExpiredPriorEnd(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	first := seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	second := seedAssignment(t, store, user, "1 Martha Boulevard", models.Permanent, date(2020, 6, 1), null.Time{})
+	_, err := store.Assignments().Expire(date(2020, 7, 1))
+	assert.Equal(t, err, nil)
+
+	// The superseded address stays expired while its new end date has passed
+	moved := *second
+	moved.StartDate = date(2020, 5, 1)
+	err = store.Assignments().Update(&moved, second.StartDate)
+	assert.Equal(t, err, nil)
+	prior, err := store.Assignments().FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, prior.Status, models.Expired)
+	assert.Equal(t, prior.EndDate, null.TimeFrom(date(2020, 4, 30)))
+	found, err := store.Assignments().FindPackageAddress(*user, date(2020, 4, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Address.LineOne, "1007 Mountain Drive")
+}
+
+func TestUpdateAssignmentMovesPriorEnd(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	first := seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, date(2019, 1, 1), null.Time{})
+	second := seedAssignment(t, store, user, "1 Martha Boulevard", models.Permanent, date(2020, 6, 1), null.Time{})
+
+	originalStart := second.StartDate
+	second.StartDate = date(2020, 6, 15)
+	err := store.Assignments().Update(second, originalStart)
+	assert.Equal(t, err, nil)
+
+	prior, err := store.Assignments().FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, prior.EndDate, null.TimeFrom(date(2020, 6, 14)))
+}
+
+func TestFindAllActiveAssignments(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	now := time.Now()
+	seedAssignment(t, store, user, "1007 Mountain Drive", models.Permanent, now.AddDate(-2, 0, 0), null.Time{})
+	seedAssignment(t, store, user, "1 Martha Boulevard", models.Permanent, now.AddDate(-1, 0, 0), null.Time{})
+	seedAssignment(t, store, user, "Wayne Villa", models.Temporary, now.AddDate(0, 1, 0), null.TimeFrom(now.AddDate(0, 2, 0)))
+
+	active, err := store.Assignments().FindAllActiveForUser(user.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*active), 2)
+	for _, aa := range *active {
+		assert.NotEqual(t, aa.Address.LineOne, "1007 Mountain Drive")
 	}
-	//one shows that the record has been deleted or:
-	// assert.Equal(t, int(isDeleted), 1)
+}
+
+func TestUpdateAddressKeepsUnsetFields(t *testing.T) {
+	store := repository.NewMemory()
+	address, err := store.Addresses().Save(&models.Address{LineOne: "1007 Mountain Drive", City: "Gotham", State: "NY", ZipCode: "10674", Country: "United States"})
+	assert.Equal(t, err, nil)
+
+	err = store.Addresses().Update(&models.Address{LineTwo: null.StringFrom("Wayne Manor")}, address.ID)
+	assert.Equal(t, err, nil)
 
-	//Can be done this way too
-	assert.Equal(t, isDeleted, int64(1))
+	updated, err := store.Addresses().FindByID(address.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.LineOne, "1007 Mountain Drive")
+	assert.Equal(t, updated.LineTwo, null.StringFrom("Wayne Manor"))
 }
@@ -0,0 +1,35 @@
+package modeltests
+
+import (
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestDeliveryConfidence(t *testing.T) {
+	now := date(2020, 6, 2)
+	failures := []models.DeliveryFailure{
+		{CarrierID: 1, Reason: models.FailureVacant, CreatedAt: date(2020, 6, 1)},
+		{CarrierID: 1, Reason: models.FailureVacant, CreatedAt: date(2020, 5, 1)},
+		{CarrierID: 2, Reason: models.FailureVacant, CreatedAt: date(2020, 5, 1)},
+		// Older than the window
+		{CarrierID: 3, Reason: models.FailureNoSuchNumber, CreatedAt: date(2020, 2, 1)},
+		// Checked as deliverable by an admin
+		{CarrierID: 3, Reason: models.FailureMoved, CreatedAt: date(2020, 5, 1), ReviewDecision: models.ReviewValid},
+	}
+	assert.Equal(t, models.DeliveryConfidence(nil, now), 1.0)
+	assert.Equal(t, models.DeliveryConfidence(failures, now), 0.4)
+	assert.Equal(t, models.NeedsReview(failures, now), true)
+
+	failures = append(failures, models.DeliveryFailure{CarrierID: 4, Reason: models.FailureNoSuchNumber, CreatedAt: date(2020, 6, 1)})
+	assert.Equal(t, models.DeliveryConfidence(failures, now), 0.0)
+
+	// Once every report is reviewed the address leaves the queue
+	for i := range failures {
+		if failures[i].ReviewDecision == "" {
+			failures[i].ReviewDecision = models.ReviewInvalid
+		}
+	}
+	assert.Equal(t, models.NeedsReview(failures, now), false)
+}
@@ -0,0 +1,54 @@
+package modeltests
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestSaveEventsConcurrently(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	p := models.Package{RecipientID: uuid.NullUUID{UUID: user.ID, Valid: true}, Tracking: null.StringFrom("1Z999AA10123456784")}
+	assert.Equal(t, store.Packages().Save(&p), nil)
+
+	monday := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	delivered := monday.AddDate(0, 0, 3)
+	events := []models.PackageEvent{{PackageID: p.ID, Status: models.PackageDelivered, OccurredAt: delivered}}
+	for hour := 0; hour < 20; hour++ {
+		events = append(events, models.PackageEvent{PackageID: p.ID, Status: models.InTransit, OccurredAt: monday.Add(time.Duration(hour) * time.Hour)})
+	}
+
+	// However the carriers' events interleave, the package ends up in the state derived from all of them
+	var wg sync.WaitGroup
+	errs := make(chan error, len(events))
+	for i := range events {
+		wg.Add(1)
+		go func(event models.PackageEvent) {
+			defer wg.Done()
+			errs <- store.Transaction(func(tx repository.Store) error {
+				_, err := tx.Packages().SaveEvent(&event)
+				return err
+			})
+		}(events[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Equal(t, err, nil)
+	}
+
+	saved, err := store.Packages().FindEvents(p.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*saved), len(events))
+	found, err := store.Packages().FindByTrackingForUser(user.ID, "1Z999AA10123456784")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Delivered, true)
+	assert.Equal(t, found.DeliveredOn, null.TimeFrom(delivered))
+}
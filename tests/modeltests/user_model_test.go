@@ -1,138 +1,115 @@
 package modeltests
 
 import (
-	"log"
 	"testing"
 
-	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres driver
 	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"github.com/nmelhado/smartmail-api/api/utils/formaterror"
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+	uuid "github.com/satori/go.uuid"
 	"gopkg.in/go-playground/assert.v1"
 )
 
-func TestFindAllUsers(t *testing.T) {
-
-	err := refreshTables()
-	if err != nil {
-		log.Fatalf("Error refreshing user table %v\n", err)
-	}
+func TestSaveUser(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
 
-	_, _, _, seedErr := seedTables()
-	if seedErr != nil {
-		log.Fatalf("Error seeding user table %v\n", err)
-	}
+	assert.NotEqual(t, user.ID, uuid.Nil)
+	assert.Equal(t, smartid.Validate(user.SmartID), nil)
+	assert.Equal(t, models.VerifyPassword(user.Password, "BigScAryBats!"), nil)
 
-	users, err := userInstance.FindAllUsers(server.DB)
-	if err != nil {
-		t.Errorf("this is the error getting the users: %v\n", err)
-		return
-	}
-	assert.Equal(t, len(*users), 2)
+	// The second user with an email is rejected the way Postgres rejects it
+	_, err := store.Users().Save(&models.User{FirstName: "Thomas", LastName: "Wayne", Phone: "2125478965", Email: "bruce@wayne.com", Password: "password"})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, formaterror.FormatError(err.Error()).Error(), "Email Already Taken")
 }
 
-func TestSaveUser(t *testing.T) {
+func TestFindUser(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
 
-	err := refreshTables()
-	if err != nil {
-		log.Fatalf("Error user refreshing table %v\n", err)
-	}
-
-	newUser := models.User{
-		SmartID:   "ABCDEFGH",
-		FirstName: "Test",
-		LastName:  "McGee",
-		Phone:     "2125478965",
-		Authority: models.UserAuth,
-		Email:     "test@gmail.com",
-		Password:  "password!",
-	}
-	savedUser, err := newUser.SaveUser(server.DB)
-	if err != nil {
-		t.Errorf("Error while saving a user: %v\n", err)
-		return
-	}
-	assert.Equal(t, newUser.SmartID, savedUser.SmartID)
-	assert.Equal(t, newUser.FirstName, savedUser.FirstName)
-	assert.Equal(t, newUser.LastName, savedUser.LastName)
-	assert.Equal(t, newUser.Phone, savedUser.Phone)
-	assert.Equal(t, newUser.Authority, savedUser.Authority)
-	assert.Equal(t, newUser.Email, savedUser.Email)
-}
+	found, err := store.Users().FindBySmartID(user.SmartID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, user.ID)
+
+	found, err = store.Users().FindByEmail("bruce@wayne.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.SmartID, user.SmartID)
 
-func TestGetUserByID(t *testing.T) {
-
-	err := refreshTables()
-	if err != nil {
-		log.Fatalf("Error user refreshing table %v\n", err)
-	}
-
-	users, _, _, err := seedTables()
-	if err != nil {
-		log.Fatalf("cannot seed users table: %v", err)
-	}
-	foundUser, err := userInstance.FindUserByID(server.DB, users[0].ID)
-	if err != nil {
-		t.Errorf("this is the error getting one user: %v\n", err)
-		return
-	}
-	assert.Equal(t, foundUser.ID, users[0].ID)
-	assert.Equal(t, foundUser.SmartID, users[0].SmartID)
-	assert.Equal(t, foundUser.FirstName, users[0].FirstName)
-	assert.Equal(t, foundUser.LastName, users[0].LastName)
-	assert.Equal(t, foundUser.Phone, users[0].Phone)
-	assert.Equal(t, foundUser.Authority, users[0].Authority)
-	assert.Equal(t, foundUser.Email, users[0].Email)
+	_, err = store.Users().FindContact(user.SmartID, "bruce@wayne.com", "")
+	assert.Equal(t, err, nil)
+	_, err = store.Users().FindContact(user.SmartID, "", "5555555555")
+	assert.Equal(t, err, repository.ErrNotFound)
+
+	_, err = store.Users().FindByID(uuid.NewV4())
+	assert.Equal(t, err, repository.ErrNotFound)
 }
 
-func TestUpdateAUser(t *testing.T) {
-
-	err := refreshTables()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	users, _, _, err := seedTables()
-	if err != nil {
-		log.Fatalf("Cannot seed user: %v\n", err)
-	}
-
-	userUpdate := models.User{
-		FirstName: "Al",
-		Email:     "al@gmail.com",
-		Password:  "NotTheJoker!",
-	}
-	updatedUser, err := userUpdate.UpdateAUser(server.DB, users[0].ID)
-	if err != nil {
-		t.Errorf("this is the error updating the user: %v\n", err)
-		return
-	}
-	assert.Equal(t, updatedUser.ID, users[0].ID)
-	assert.Equal(t, updatedUser.SmartID, users[0].SmartID)
-	assert.Equal(t, updatedUser.FirstName, userUpdate.FirstName)
-	assert.Equal(t, updatedUser.LastName, users[0].LastName)
-	assert.Equal(t, updatedUser.Phone, users[0].Phone)
-	assert.Equal(t, updatedUser.Authority, users[0].Authority)
-	assert.Equal(t, updatedUser.Email, userUpdate.Email)
+func TestUpdateUser(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+	seedUser(t, store, "alfred@wayne.com")
+
+	updated, err := store.Users().UpdateBasic(&models.User{FirstName: "Batman", LastName: "Wayne", Phone: "2125478965", Email: "batman@wayne.com"}, user.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.FirstName, "Batman")
+	assert.Equal(t, updated.SmartID, user.SmartID)
+	assert.Equal(t, updated.Password, user.Password)
+
+	_, err = store.Users().UpdateBasic(&models.User{FirstName: "Batman", LastName: "Wayne", Phone: "2125478965", Email: "alfred@wayne.com"}, user.ID)
+	assert.NotEqual(t, err, nil)
+
+	updated, err = store.Users().Update(&models.User{FirstName: "Batman", LastName: "Wayne", Phone: "2125478965", Email: "batman@wayne.com", Password: "NewPassword"}, user.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, models.VerifyPassword(updated.Password, "NewPassword"), nil)
 }
 
 func TestDeleteUser(t *testing.T) {
+	store := repository.NewMemory()
+	user := seedUser(t, store, "bruce@wayne.com")
+
+	deleted, err := store.Users().Delete(user.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, int64(1))
+
+	_, err = store.Users().Delete(user.ID)
+	assert.Equal(t, err, repository.ErrNotFound)
+}
+
+func TestSaveAPIUser(t *testing.T) {
+	store := repository.NewMemory()
+
+	aUser, err := store.APIUsers().Save(&models.APIUser{Name: "Gotham Post", Username: "gotham", Email: "post@gotham.com", Phone: "2125478965", Password: "password"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, aUser.Permission, models.NoPermission)
+
+	found, err := store.APIUsers().FindByUsername("gotham")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, aUser.ID)
+
+	_, err = store.APIUsers().Save(&models.APIUser{Name: "Gotham Post", Username: "gotham", Email: "other@gotham.com", Phone: "2125478965", Password: "password"})
+	assert.NotEqual(t, err, nil)
+}
 
-	err := refreshTables()
-	if err != nil {
-		log.Fatal(err)
-	}
+func TestTransactionRollback(t *testing.T) {
+	store := repository.NewMemory()
 
-	users, _, _, err := seedTables()
+	err := store.Transaction(func(tx repository.Store) error {
+		seedUser(t, tx, "bruce@wayne.com")
+		return repository.ErrNotFound
+	})
+	assert.Equal(t, err, repository.ErrNotFound)
 
-	if err != nil {
-		log.Fatalf("Cannot seed user: %v\n", err)
-	}
+	_, err = store.Users().FindByEmail("bruce@wayne.com")
+	assert.Equal(t, err, repository.ErrNotFound)
 
-	isDeleted, err := userInstance.DeleteUser(server.DB, users[0].ID)
-	if err != nil {
-		t.Errorf("this is the error deleting the user: %v\n", err)
-		return
-	}
+	err = store.Transaction(func(tx repository.Store) error {
+		seedUser(t, tx, "bruce@wayne.com")
+		return nil
+	})
+	assert.Equal(t, err, nil)
 
-	//one shows that the record has been deleted
-	assert.Equal(t, isDeleted, int64(1))
+	_, err = store.Users().FindByEmail("bruce@wayne.com")
+	assert.Equal(t, err, nil)
 }
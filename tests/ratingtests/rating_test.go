@@ -0,0 +1,103 @@
+package ratingtests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/rating"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const tables = `{
+	"UPS": {
+		"dim_divisor": 139,
+		"zones": [{"zone": 2, "max_miles": 150}, {"zone": 5, "max_miles": 1000}, {"zone": 8}],
+		"rates": [{"max_weight": 1, "prices": [9.5, 11.25, 14]}, {"max_weight": 10, "prices": [14, 19.5, 28.75]}]
+	}
+}`
+
+const centroids = `zip_code,city,state,latitude,longitude
+10021,New York,NY,40.7690,-73.9584
+75201,Dallas,TX,32.7876,-96.7994
+`
+
+func TestQuote(t *testing.T) {
+	rater, err := rating.New(strings.NewReader(tables), strings.NewReader(centroids))
+	if err != nil {
+		t.Fatalf("this is the error loading the rate tables: %v\n", err)
+	}
+
+	samples := []struct {
+		code  string
+		miles float64
+		pkg   rating.Package
+		quote rating.Quote
+		err   error
+	}{
+		{code: "UPS", miles: 10, pkg: rating.Package{Weight: 0.5}, quote: rating.Quote{Zone: 2, BillableWeight: 1, Price: 9.5}},
+		{code: "ups", miles: 150, pkg: rating.Package{Weight: 1.2}, quote: rating.Quote{Zone: 2, BillableWeight: 2, Price: 14}},
+		{code: "UPS", miles: 600, pkg: rating.Package{Weight: 1}, quote: rating.Quote{Zone: 5, BillableWeight: 1, Price: 11.25}},
+		// 10x10x5 inches is 3.6 pounds dimensional weight, which is billed over the actual weight
+		{code: "UPS", miles: 2500, pkg: rating.Package{Weight: 1, Length: 10, Width: 10, Height: 5}, quote: rating.Quote{Zone: 8, BillableWeight: 4, Price: 28.75}},
+		{code: "UPS", miles: 10, pkg: rating.Package{Weight: 1, Length: 12, Width: 12, Height: 12}, err: rating.ErrTooHeavy},
+		{code: "UPS", miles: 10, pkg: rating.Package{Weight: 10.5}, err: rating.ErrTooHeavy},
+		{code: "FEDEX", miles: 10, pkg: rating.Package{Weight: 1}, err: rating.ErrNoRateTable},
+	}
+
+	for _, v := range samples {
+		quote, err := rater.Quote(v.code, v.miles, v.pkg)
+		assert.Equal(t, err, v.err)
+		assert.Equal(t, quote, v.quote)
+	}
+	assert.Equal(t, rater.HasTable("ups"), true)
+	assert.Equal(t, rater.HasTable("USPS"), false)
+}
+
+func TestLocate(t *testing.T) {
+	rater, err := rating.New(strings.NewReader(tables), strings.NewReader(centroids))
+	if err != nil {
+		t.Fatalf("this is the error loading the rate tables: %v\n", err)
+	}
+
+	// Stored coordinates are used before the zip code centroid
+	location, err := rater.Locate(models.Address{ZipCode: "10021", Latitude: 40.7, Longitude: -74})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, location, geocode.Location{Latitude: 40.7, Longitude: -74})
+
+	location, err = rater.Locate(models.Address{ZipCode: "75201-4321"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, location, geocode.Location{Latitude: 32.7876, Longitude: -96.7994})
+
+	_, err = rater.Locate(models.Address{ZipCode: "66002"})
+	assert.Equal(t, err, rating.ErrUnknownZip)
+
+	withoutCentroids, err := rating.New(strings.NewReader(tables), nil)
+	assert.Equal(t, err, nil)
+	_, err = withoutCentroids.LocateZip("10021")
+	assert.Equal(t, err, rating.ErrUnknownZip)
+}
+
+func TestInvalidTables(t *testing.T) {
+	samples := []struct {
+		tables       string
+		errorMessage string
+	}{
+		{tables: `[]`, errorMessage: "Invalid rate tables: json: cannot unmarshal array into Go value of type map[string]rating.Table"},
+		{tables: `{"UPS": {"zones": [{"zone": 2}]}}`, errorMessage: "Rate table UPS: at least one weight band is required"},
+		{tables: `{"UPS": {"zones": [{"zone": 2, "max_miles": 150}, {"zone": 5, "max_miles": 100}, {"zone": 8}], "rates": [{"max_weight": 1, "prices": [1, 2, 3]}]}}`, errorMessage: "Rate table UPS: zone 5 needs a max_miles greater than the zone before it"},
+		{tables: `{"UPS": {"zones": [{"zone": 2, "max_miles": 150}], "rates": [{"max_weight": 1, "prices": [1]}]}}`, errorMessage: "Rate table UPS: the last zone must not have a max_miles"},
+		{tables: `{"UPS": {"zones": [{"zone": 2}], "rates": [{"max_weight": 5, "prices": [1]}, {"max_weight": 2, "prices": [2]}]}}`, errorMessage: "Rate table UPS: weight bands must be in increasing order of max_weight"},
+		{tables: `{"UPS": {"zones": [{"zone": 2, "max_miles": 150}, {"zone": 8}], "rates": [{"max_weight": 1, "prices": [1]}]}}`, errorMessage: "Rate table UPS: weight band 1 needs 2 prices, got 1"},
+	}
+
+	for _, v := range samples {
+		_, err := rating.New(strings.NewReader(v.tables), nil)
+		if err == nil {
+			t.Errorf("expected an error loading %s\n", v.tables)
+			continue
+		}
+		assert.Equal(t, err.Error(), v.errorMessage)
+	}
+}
@@ -0,0 +1,100 @@
+package routingtests
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/routing"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+var depot = geocode.Location{Latitude: 40.7128, Longitude: -74.0060}
+
+// circle returns n stops evenly spaced on a circle around the depot, in shuffled order
+func circle(n int, seed int64) ([]geocode.Location, float64) {
+	stops := make([]geocode.Location, n)
+	for i := range stops {
+		angle := 2 * math.Pi * float64(i) / float64(n)
+		stops[i] = geocode.Location{Latitude: depot.Latitude + 0.1*math.Sin(angle), Longitude: depot.Longitude + 0.1*math.Cos(angle)}
+	}
+	perimeter := 0.0
+	for i := range stops {
+		perimeter += geocode.Miles(stops[i], stops[(i+1)%n])
+	}
+	random := rand.New(rand.NewSource(seed))
+	random.Shuffle(n, func(i, j int) { stops[i], stops[j] = stops[j], stops[i] })
+	return stops, perimeter
+}
+
+// randomStops returns n stops scattered across a city
+func randomStops(n int, seed int64) []geocode.Location {
+	random := rand.New(rand.NewSource(seed))
+	stops := make([]geocode.Location, n)
+	for i := range stops {
+		stops[i] = geocode.Location{Latitude: depot.Latitude + random.Float64()*0.4 - 0.2, Longitude: depot.Longitude + random.Float64()*0.4 - 0.2}
+	}
+	return stops
+}
+
+func checkRoute(t *testing.T, route routing.Route, stops []geocode.Location) {
+	seen := map[int]bool{}
+	for _, i := range route.Order {
+		seen[i] = true
+	}
+	assert.Equal(t, len(route.Order), len(stops))
+	assert.Equal(t, len(seen), len(stops))
+
+	miles := route.Return
+	previous := depot
+	for n, i := range route.Order {
+		assert.Equal(t, math.Abs(route.Legs[n]-geocode.Miles(previous, stops[i])) < 1e-9, true)
+		miles += route.Legs[n]
+		previous = stops[i]
+	}
+	assert.Equal(t, math.Abs(route.Return-geocode.Miles(previous, depot)) < 1e-9, true)
+	assert.Equal(t, math.Abs(route.Miles-miles) < 1e-9, true)
+}
+
+func TestOptimize(t *testing.T) {
+	route := routing.Optimize(depot, nil)
+	assert.Equal(t, route, routing.Route{Order: []int{}, Legs: []float64{}})
+
+	// Stops along a road north of the depot are visited in order of distance
+	line := []geocode.Location{
+		{Latitude: 40.80, Longitude: -74.0060},
+		{Latitude: 40.72, Longitude: -74.0060},
+		{Latitude: 40.76, Longitude: -74.0060},
+	}
+	route = routing.Optimize(depot, line)
+	checkRoute(t, route, line)
+	assert.Equal(t, route.Order, []int{1, 2, 0})
+
+	// The shortest tour of stops on a circle goes around it, nearest neighbour alone can cross over itself
+	stops, perimeter := circle(40, 1)
+	route = routing.Optimize(depot, stops)
+	checkRoute(t, route, stops)
+	first, last := stops[route.Order[0]], stops[route.Order[len(stops)-1]]
+	around := route.Miles - route.Legs[0] - route.Return + geocode.Miles(last, first)
+	assert.Equal(t, math.Abs(around-perimeter) < 1e-6, true)
+}
+
+func TestOptimizeIsFast(t *testing.T) {
+	stops := randomStops(500, 2)
+	start := time.Now()
+	route := routing.Optimize(depot, stops)
+	elapsed := time.Since(start)
+	checkRoute(t, route, stops)
+	if elapsed > time.Second {
+		t.Errorf("routing 500 stops took %v\n", elapsed)
+	}
+}
+
+func BenchmarkOptimize(b *testing.B) {
+	stops := randomStops(300, 3)
+	for i := 0; i < b.N; i++ {
+		routing.Optimize(depot, stops)
+	}
+}
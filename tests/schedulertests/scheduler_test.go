@@ -0,0 +1,60 @@
+package schedulertests
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/scheduler"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestSchedulerRunsJobsUntilStopped(t *testing.T) {
+	var runs, failures int32
+	s := scheduler.New(
+		scheduler.Job{Name: "count", Interval: 10 * time.Millisecond, Run: func() error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}},
+		scheduler.Job{Name: "fail", Interval: 10 * time.Millisecond, Run: func() error {
+			atomic.AddInt32(&failures, 1)
+			return errors.New("job failed")
+		}},
+	)
+
+	s.Start()
+	time.Sleep(55 * time.Millisecond)
+	s.Stop()
+
+	stoppedAt := atomic.LoadInt32(&runs)
+	if stoppedAt < 3 {
+		t.Errorf("expected the job to run at least 3 times, it ran %d times", stoppedAt)
+	}
+	// A failing job keeps being scheduled
+	if atomic.LoadInt32(&failures) < 3 {
+		t.Errorf("expected the failing job to keep running, it ran %d times", failures)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt32(&runs), stoppedAt)
+}
+
+func TestSchedulerStopWaitsForRunningJob(t *testing.T) {
+	var finished int32
+	started := make(chan struct{})
+	s := scheduler.New(scheduler.Job{Name: "slow", Interval: time.Hour, Run: func() error {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		return nil
+	}})
+
+	s.Start()
+	<-started
+	s.Stop()
+	assert.Equal(t, atomic.LoadInt32(&finished), int32(1))
+
+	// Stopping twice is safe
+	s.Stop()
+}
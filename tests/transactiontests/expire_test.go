@@ -0,0 +1,109 @@
+package transactiontests
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestExpireAddressAssignments(t *testing.T) {
+	server, mock := newServer(t)
+	now := time.Date(2020, 6, 1, 3, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	// Superseded permanent assignments are expired along with temporary addresses and holds
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments" WHERE \(status IN \(.*\) AND end_date IS NOT NULL AND end_date <= \$\d+\)`).
+		WithArgs(models.Permanent, models.Temporary, models.PackageOnlyPermanent, models.PackageOnlyTemporary, models.MailOnlyPermanent, models.MailOnlyTemporary, models.Hold, models.MailOnlyHold, models.PackageOnlyHold, "2020-06-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(4, []byte("temporary")).AddRow(7, []byte("permanent")))
+	for _, status := range []models.Status{models.Temporary, models.Permanent} {
+		mock.ExpectQuery(`INSERT INTO "address_status_transitions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		// The status it was expired from is kept, so a superseded permanent assignment is still found for past dates
+		mock.ExpectExec(`UPDATE "address_assignments" SET "expired_from" = \$1, "status" = \$2`).WithArgs(status, models.Expired, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	expired, err := models.ExpireAddressAssignments(server.DB, now)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expired, int64(2))
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("expectations were not met: %v\n", err)
+	}
+}
+
+func TestExpireAddressAssignmentsRollsBack(t *testing.T) {
+	server, mock := newServer(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(4, []byte("temporary")))
+	mock.ExpectQuery(`INSERT INTO "address_status_transitions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "address_assignments"`).WillReturnError(errInjected)
+	mock.ExpectRollback()
+
+	expired, err := models.ExpireAddressAssignments(server.DB, time.Now())
+	assert.Equal(t, err, errInjected)
+	assert.Equal(t, expired, int64(0))
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("expectations were not met: %v\n", err)
+	}
+}
+
+func TestMemoryExpireRecordsTransitions(t *testing.T) {
+	store := repository.NewMemory()
+	user, err := store.Users().Save(&models.User{FirstName: "Bruce", LastName: "Wayne", Phone: "2125478965", Email: "bruce@wayne.com", Password: "BigScAryBats!"})
+	assert.Equal(t, err, nil)
+	address, err := store.Addresses().Save(&models.Address{LineOne: "Wayne Villa", City: "Gotham", State: "NY", ZipCode: "10674", Country: "United States"})
+	assert.Equal(t, err, nil)
+	temporary, err := store.Assignments().Save(&models.AddressAssignment{UserID: user.ID, AddressID: address.ID, Status: models.Temporary, StartDate: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), EndDate: null.TimeFrom(time.Date(2020, 5, 31, 0, 0, 0, 0, time.UTC))})
+	assert.Equal(t, err, nil)
+
+	// The in-memory store keeps the same history as ExpireAddressAssignments
+	now := time.Date(2020, 6, 1, 3, 0, 0, 0, time.UTC)
+	expired, err := store.Assignments().Expire(now)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expired, int64(1))
+
+	transitions, err := store.Assignments().FindTransitions(temporary.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*transitions), 1)
+	assert.Equal(t, (*transitions)[0].FromStatus, models.Temporary)
+	assert.Equal(t, (*transitions)[0].ToStatus, models.Expired)
+	assert.Equal(t, (*transitions)[0].TransitionedAt, now)
+
+	// Nothing is left to expire the next night, so no other transition is recorded
+	expired, err = store.Assignments().Expire(now.AddDate(0, 0, 1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expired, int64(0))
+	transitions, err = store.Assignments().FindTransitions(temporary.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*transitions), 1)
+}
+
+func TestPastLookupFindsSupersededPermanent(t *testing.T) {
+	server, mock := newServer(t)
+	targetDate := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments" WHERE \(user_id = \$1 AND status IN \(\$2,\$3\) AND start_date < \$4 AND end_date > \$5\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments" WHERE \(user_id = \$1 AND status IN \(\$2,\$3\) AND start_date < \$4`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// The permanent address that covered the date is expired, it is found under the status it was expired from
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments" WHERE \(user_id = \$1 AND \(status IN \(\$2,\$3,\$4,\$5\) OR \(status = \$6 AND expired_from IN \(\$7,\$8\)\)\) AND recurrence IS NULL`).
+		WithArgs(userID, models.Permanent, models.Temporary, models.MailOnlyPermanent, models.MailOnlyTemporary, models.Expired, models.Permanent, models.MailOnlyPermanent, targetDate, targetDate).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status", "expired_from"}).AddRow(2, userID.String(), []byte("expired"), "permanent"))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID.String()))
+
+	aa := models.AddressAssignment{}
+	found, err := aa.FindMailingAddressWithSmartID(server.DB, models.User{ID: userID}, targetDate)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, uint64(2))
+	assert.Equal(t, found.IsSuperseded(), true)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
@@ -0,0 +1,66 @@
+package transactiontests
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/responses"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+var assignmentColumns = []string{"id", "user_id", "address_id", "status", "start_date", "end_date", "pickup_location"}
+
+func expectAssignment(mock sqlmock.Sqlmock, pattern string, values ...interface{}) {
+	rows := sqlmock.NewRows(assignmentColumns)
+	if len(values) > 0 {
+		rows.AddRow(toDriverValues(values)...)
+	}
+	mock.ExpectQuery(pattern).WillReturnRows(rows)
+	if len(values) > 0 {
+		loadUser(mock, false)
+		loadAddress(mock, false)
+	}
+}
+
+func TestMailingAddressDuringHold(t *testing.T) {
+	server, mock := newServer(t)
+	target := time.Date(2020, 7, 4, 0, 0, 0, 0, time.UTC)
+	resume := time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	expectAssignment(mock, `status IN \(\$2,\$3\) AND start_date < \$4 AND end_date > \$5`, 9, userID.String(), 1, []byte("mail_only_hold"), time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC), resume, "Gotham Post Office")
+
+	address := models.AddressAssignment{}
+	held, err := address.FindMailingAddressWithSmartID(server.DB, models.User{ID: userID}, target)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, held.IsHold(), true)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+
+	reply := responses.AddressSmartIDResponse{}
+	responses.TranslateSmartAddressResponse(held, &reply)
+	assert.Equal(t, reply.Held, true)
+	assert.Equal(t, reply.HeldUntil, "2020-07-20")
+	assert.Equal(t, reply.PickupLocation, "Gotham Post Office")
+	assert.Equal(t, reply.LineOne, "")
+}
+
+func TestMailingAddressWithoutHold(t *testing.T) {
+	server, mock := newServer(t)
+	target := time.Date(2020, 7, 4, 0, 0, 0, 0, time.UTC)
+
+	expectAssignment(mock, `status IN \(\$2,\$3\) AND start_date < \$4 AND end_date > \$5`)
+	expectAssignment(mock, `status IN \(\$2,\$3\) AND start_date < \$4 AND \(end_date IS NULL`)
+	expectAssignment(mock, `recurrence IS NULL`, 2, userID.String(), 1, []byte("permanent"), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), nil, nil)
+
+	address := models.AddressAssignment{}
+	permanent, err := address.FindMailingAddressWithSmartID(server.DB, models.User{ID: userID}, target)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, permanent.IsHold(), false)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+
+	reply := responses.AddressSmartIDResponse{}
+	responses.TranslateSmartAddressResponse(permanent, &reply)
+	assert.Equal(t, reply.Held, false)
+	assert.Equal(t, reply.LineOne, "1 Martha Boulevard")
+}
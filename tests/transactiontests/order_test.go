@@ -0,0 +1,29 @@
+package transactiontests
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestShipOrderRefusesShippedOrder(t *testing.T) {
+	server, mock := newServer(t)
+	order := models.Order{ID: uuid.NewV4(), Status: models.OrderHandedOff}
+	order.Ship(5, time.Now())
+
+	// Another lookup shipped the order first, so the update changes nothing
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "orders" SET .* WHERE \(id = \$\d+ AND status = \$\d+\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	assert.Equal(t, order.ShipOrder(server.DB), models.ErrOrderShipped)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "orders"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.Equal(t, order.ShipOrder(server.DB), nil)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
@@ -0,0 +1,103 @@
+package transactiontests
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestDerivePackageState(t *testing.T) {
+	monday := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	estimate := null.TimeFrom(monday.AddDate(0, 0, 3))
+	events := []models.PackageEvent{
+		{Status: models.OutForDelivery, OccurredAt: monday.AddDate(0, 0, 3)},
+		{Status: models.LabelCreated, OccurredAt: monday},
+		{Status: models.InTransit, OccurredAt: monday.AddDate(0, 0, 1), EstimatedDelivery: estimate},
+	}
+
+	state := models.DerivePackageState(events)
+	assert.Equal(t, state.Status, models.OutForDelivery)
+	assert.Equal(t, state.Delivered, false)
+	assert.Equal(t, state.EstimatedDelivery, estimate)
+
+	delivered := monday.AddDate(0, 0, 3).Add(5 * time.Hour)
+	events = append(events, models.PackageEvent{Status: models.PackageDelivered, OccurredAt: delivered})
+	state = models.DerivePackageState(events)
+	assert.Equal(t, state.Status, models.PackageDelivered)
+	assert.Equal(t, state.Delivered, true)
+	assert.Equal(t, state.DeliveredOn, null.TimeFrom(delivered))
+
+	latest, ok := models.LatestPackageEvent(events)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, latest.Status, models.PackageDelivered)
+
+	// A sender or recipient's new estimate leaves the status the carrier reported
+	later := null.TimeFrom(monday.AddDate(0, 0, 5))
+	events = append(events[:3], models.PackageEvent{Status: models.EstimateUpdated, OccurredAt: monday.AddDate(0, 0, 4), EstimatedDelivery: later})
+	state = models.DerivePackageState(events)
+	assert.Equal(t, state.Status, models.OutForDelivery)
+	assert.Equal(t, state.EstimatedDelivery, later)
+	latest, _ = models.LatestPackageEvent(events)
+	assert.Equal(t, latest.Status, models.OutForDelivery)
+
+	_, ok = models.LatestPackageEvent(nil)
+	assert.Equal(t, ok, false)
+}
+
+func TestSavePackageEventUpdatesPackage(t *testing.T) {
+	server, mock := newServer(t)
+	delivered := time.Date(2020, 6, 4, 14, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	// The package is locked before the event is added, so events saved concurrently are derived one at a time
+	mock.ExpectQuery(`SELECT id FROM packages WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery(`INSERT INTO "package_events"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery(`SELECT \* FROM "package_events" WHERE \(package_id = \$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "package_id", "status", "occurred_at"}).
+			AddRow(1, 5, "in_transit", delivered.AddDate(0, 0, -1)).
+			AddRow(2, 5, "delivered", delivered))
+	mock.ExpectExec(`UPDATE "packages" SET "delivered" = \$1, "delivered_on" = \$2`).
+		WithArgs(true, null.TimeFrom(delivered), null.Time{}, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	event := models.PackageEvent{PackageID: 5, Status: models.PackageDelivered, OccurredAt: delivered}
+	event.Prepare()
+	assert.Equal(t, event.Validate(), nil)
+	// The event is saved with the handle it is given, so it joins the caller's transaction
+	err := server.Store.Transaction(func(store repository.Store) error {
+		_, err := store.Packages().SaveEvent(&event)
+		return err
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestSavePackageEventForMissingPackage(t *testing.T) {
+	server, mock := newServer(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM packages WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	event := models.PackageEvent{PackageID: 5, Status: models.InTransit, OccurredAt: time.Now()}
+	err := server.Store.Transaction(func(store repository.Store) error {
+		_, err := store.Packages().SaveEvent(&event)
+		return err
+	})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestPackageEventValidate(t *testing.T) {
+	event := models.PackageEvent{Status: "lost_in_space"}
+	assert.NotEqual(t, event.Validate(), nil)
+	// Only senders and recipients update the estimate on its own
+	event = models.PackageEvent{Status: models.EstimateUpdated}
+	assert.NotEqual(t, event.Validate(), nil)
+}
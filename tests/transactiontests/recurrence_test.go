@@ -0,0 +1,57 @@
+package transactiontests
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func existingTemporary(mock sqlmock.Sqlmock, recurrence string) {
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments" WHERE \(user_id = \$1 AND status IN \('temporary', \$2\) AND \(end_date IS NULL OR end_date >= \$3\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status", "start_date", "end_date", "recurrence"}).
+			AddRow(3, userID.String(), []byte("temporary"), time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC), nil, recurrence))
+}
+
+func TestSaveRecurringAddressConflicts(t *testing.T) {
+	server, mock := newServer(t)
+	existingTemporary(mock, "yearly:11-01/04-30")
+
+	winter := models.AddressAssignment{
+		UserID:     userID,
+		AddressID:  1,
+		Status:     models.Temporary,
+		StartDate:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    null.TimeFrom(time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)),
+		Recurrence: null.StringFrom("weekly:sat,sun"),
+	}
+	_, err := winter.SaveAddressAssignment(server.DB)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestSaveRecurringAddressWithoutConflict(t *testing.T) {
+	server, mock := newServer(t)
+	existingTemporary(mock, "yearly:11-01/04-30")
+	mock.ExpectBegin()
+	insertAssignment(mock, false)
+	reloadAssignment(mock, false)
+	mock.ExpectCommit()
+	loadUser(mock, false)
+	loadAddress(mock, false)
+
+	// A summer address every year never overlaps with the winter address
+	summer := models.AddressAssignment{
+		UserID:     userID,
+		AddressID:  1,
+		Status:     models.Temporary,
+		StartDate:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Recurrence: null.StringFrom("yearly:06-01/08-31"),
+	}
+	_, err := summer.SaveAddressAssignment(server.DB)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
@@ -0,0 +1,56 @@
+package transactiontests
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestFlagReroutesReadsEveryAssignmentInTheWindow(t *testing.T) {
+	server, mock := newServer(t)
+	shipped := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	estimate := time.Date(2020, 8, 10, 0, 0, 0, 0, time.UTC)
+	moved := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	temporary := models.AddressAssignment{ID: 120, UserID: userID, AddressID: 2, Status: models.Temporary, StartDate: moved, EndDate: null.TimeFrom(moved.AddDate(0, 1, 0))}
+
+	packages := sqlmock.NewRows([]string{"id", "recipient_id", "delivered", "estimated_delivery", "address_assignment_id"}).
+		AddRow(7, userID.String(), false, estimate, 1)
+	mock.ExpectQuery(`SELECT \* FROM "packages"`).WillReturnRows(packages)
+
+	// Assignments are read for the packages' estimated deliveries without a limit. Many mail only moves come back before the
+	// temporary address, which is the one that covers the estimated delivery
+	assignments := sqlmock.NewRows(assignmentColumns).AddRow(1, userID.String(), 1, []byte("permanent"), shipped, nil, nil)
+	for id := 2; id < 120; id++ {
+		assignments.AddRow(id, userID.String(), 3, []byte("mail_only_temporary"), moved.AddDate(0, 0, -id), estimate.AddDate(0, 0, id), nil)
+	}
+	assignments.AddRow(120, userID.String(), 2, []byte("temporary"), moved, temporary.EndDate.Time, nil)
+	mock.ExpectQuery(`SELECT \* FROM "address_assignments" WHERE \(user_id IN \(\$1\) AND .* start_date < \$\d+ AND \(end_date IS NULL OR end_date > \$\d+\)\) ORDER BY "id"$`).
+		WithArgs(append(append([]driver.Value{userID.String()}, anyArgs(6)...), estimate.AddDate(0, 0, 1), estimate)...).
+		WillReturnRows(assignments)
+	loadUser(mock, false)
+	loadAddress(mock, false)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "packages" SET "address_changed" = \$1, "reroute_assignment_id" = \$2, "updated_at" = \$3 WHERE \(id = \$4\)`).
+		WithArgs(true, 120, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	flagged, err := models.FlagPackageReroutes(server.DB, &temporary)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, flagged, int64(1))
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+// anyArgs matches n query arguments of any value
+func anyArgs(n int) []driver.Value {
+	args := []driver.Value{}
+	for i := 0; i < n; i++ {
+		args = append(args, sqlmock.AnyArg())
+	}
+	return args
+}
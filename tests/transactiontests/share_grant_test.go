@@ -0,0 +1,29 @@
+package transactiontests
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/models"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestRequestShareGrantKeepsConcurrentGrant(t *testing.T) {
+	server, mock := newServer(t)
+	apiUserID := uuid.NewV4()
+
+	// Another request adds the grant between the lookup and the insert, which then does nothing
+	mock.ExpectQuery(`SELECT \* FROM "share_grants"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(`INSERT INTO share_grants .* ON CONFLICT \(user_id, api_user_id\) DO NOTHING`).
+		WithArgs(userID, apiUserID, models.GrantAsk, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT \* FROM "share_grants"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "api_user_id", "decision"}).AddRow(7, userID.String(), apiUserID.String(), "allow"))
+
+	grant, err := (&models.ShareGrant{}).RequestShareGrant(server.DB, userID, apiUserID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, grant.ID, uint64(7))
+	assert.Equal(t, grant.Decision, models.GrantAllow)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
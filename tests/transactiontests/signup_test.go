@@ -0,0 +1,189 @@
+package transactiontests
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/nmelhado/smartmail-api/api/auth"
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"github.com/nmelhado/smartmail-api/api/geocode"
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/repository"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+var userID = uuid.FromStringOrNil("8b7e2f0c-2c1c-4a53-9f0e-6f3c1b1c8a11")
+
+var errInjected = errors.New("injected failure")
+
+type stubGeocoder struct{}
+
+func (stubGeocoder) Geocode(address models.Address) (geocode.Location, error) {
+	return geocode.Location{Latitude: 40.769, Longitude: -73.9584}, nil
+}
+
+func TestMain(m *testing.M) {
+	os.Setenv("API_SECRET", "transaction-test-secret")
+	os.Exit(m.Run())
+}
+
+func newServer(t *testing.T) (*controllers.Server, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("this is the error opening a mock DB: %v\n", err)
+	}
+	gormDB, err := gorm.Open("postgres", db)
+	if err != nil {
+		t.Fatalf("this is the error opening gorm: %v\n", err)
+	}
+	gormDB.LogMode(false)
+	return &controllers.Server{DB: gormDB, Store: repository.NewGorm(gormDB), Geocoder: stubGeocoder{}}, mock
+}
+
+// step is a single statement run inside the signup transaction
+type step func(mock sqlmock.Sqlmock, fail bool)
+
+func query(pattern string, columns []string, values ...interface{}) step {
+	return func(mock sqlmock.Sqlmock, fail bool) {
+		expected := mock.ExpectQuery(pattern)
+		if fail {
+			expected.WillReturnError(errInjected)
+			return
+		}
+		rows := sqlmock.NewRows(columns)
+		if len(values) > 0 {
+			rows.AddRow(toDriverValues(values)...)
+		}
+		expected.WillReturnRows(rows)
+	}
+}
+
+func exec(pattern string) step {
+	return func(mock sqlmock.Sqlmock, fail bool) {
+		expected := mock.ExpectExec(pattern)
+		if fail {
+			expected.WillReturnError(errInjected)
+			return
+		}
+		expected.WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+func toDriverValues(values []interface{}) []driver.Value {
+	driverValues := []driver.Value{}
+	for _, value := range values {
+		driverValues = append(driverValues, value)
+	}
+	return driverValues
+}
+
+var (
+	countSmartID     = query(`SELECT count\(\*\) FROM "users"`, []string{"count"}, 0)
+	insertUser       = query(`INSERT INTO "users"`, []string{"id"}, userID.String())
+	insertAddress    = query(`INSERT INTO "addresses"`, []string{"id"}, 1)
+	insertAssignment = query(`INSERT INTO "address_assignments"`, []string{"id"}, 1)
+	reloadAssignment = query(`SELECT "end_date" FROM "address_assignments"`, []string{"end_date"}, nil)
+	loadUser         = query(`SELECT \* FROM "users"`, []string{"id", "smart_id", "email"}, userID.String(), "NC4LL93K", "alfred@gmail.com")
+	loadAddress      = query(`SELECT \* FROM "addresses"`, []string{"id", "line_one"}, 1, "1 Martha Boulevard")
+	endPriorAddress  = exec(`UPDATE "address_assignments"`)
+	insertSession    = query(`INSERT INTO "sessions"`, []string{"id"}, uuid.NewV4().String())
+	openPackages     = query(`SELECT \* FROM "packages"`, []string{"id"})
+	countLookups     = query(`SELECT count\(\*\) FROM "address_disclosures"`, []string{"count"}, 0)
+	findLookups      = query(`SELECT \* FROM "address_disclosures"`, []string{"id"})
+	reroutePackages  = query(`SELECT \* FROM "packages"`, []string{"id"})
+)
+
+const signupJSON = `{
+	"user": {"first_name": "Alfred", "last_name": "Pennyworth", "phone": "2125478965", "email": "alfred@gmail.com", "password": "BigScAryBats!"},
+	"address": {"line_one": "1 Martha Boulevard", "city": "Gotham", "state": "NY", "zip_code": "10674", "country": "United States"},
+	"status": "permanent",
+	"start_date": "2020-01-01T00:00:00Z"
+}`
+
+const addressJSON = `{
+	"user_id": "8b7e2f0c-2c1c-4a53-9f0e-6f3c1b1c8a11",
+	"address": {"line_one": "1 Martha Boulevard", "city": "Gotham", "state": "NY", "zip_code": "10674", "country": "United States"},
+	"status": "permanent",
+	"start_date": "2020-01-01T00:00:00Z"
+}`
+
+// runTransactionSteps injects a failure at each step in turn and checks that the transaction is rolled back
+// (and never committed), then runs every step successfully and checks that the transaction is committed
+func runTransactionSteps(t *testing.T, steps []step, before func(mock sqlmock.Sqlmock), request func(server *controllers.Server) *httptest.ResponseRecorder, successCode int) {
+	for failAt := range steps {
+		server, mock := newServer(t)
+		before(mock)
+		mock.ExpectBegin()
+		for i := 0; i <= failAt; i++ {
+			steps[i](mock, i == failAt)
+		}
+		mock.ExpectRollback()
+
+		rr := request(server)
+		assert.Equal(t, rr.Code, http.StatusInternalServerError)
+		err := mock.ExpectationsWereMet()
+		if err != nil {
+			t.Errorf("failure at step %d was not rolled back: %v\n", failAt, err)
+		}
+	}
+
+	server, mock := newServer(t)
+	before(mock)
+	mock.ExpectBegin()
+	for _, s := range steps {
+		s(mock, false)
+	}
+	mock.ExpectCommit()
+
+	rr := request(server)
+	assert.Equal(t, rr.Code, successCode)
+	err := mock.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("successful transaction was not committed: %v\n", err)
+	}
+}
+
+func TestCreateUserAndAddressTransaction(t *testing.T) {
+	steps := []step{countSmartID, insertUser, insertAddress, insertAssignment, reloadAssignment, loadUser, loadAddress, endPriorAddress, insertSession}
+
+	runTransactionSteps(t, steps, func(mock sqlmock.Sqlmock) {}, func(server *controllers.Server) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/signup", bytes.NewBufferString(signupJSON))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreateUserAndAddress).ServeHTTP(rr, req)
+		return rr
+	}, http.StatusCreated)
+}
+
+func TestCreateAddressTransaction(t *testing.T) {
+	// Webhook deliveries for the change are queued, and packages flagged for rerouting, in the same transaction
+	steps := []step{insertAddress, insertAssignment, reloadAssignment, loadUser, loadAddress, endPriorAddress, openPackages, countLookups, findLookups, reroutePackages}
+
+	token, err := auth.CreateToken(userID, "ui", uuid.NewV4())
+	if err != nil {
+		t.Fatalf("this is the error creating a token: %v\n", err)
+	}
+
+	runTransactionSteps(t, steps, func(mock sqlmock.Sqlmock) {
+		loadUser(mock, false)
+	}, func(server *controllers.Server) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/address", bytes.NewBufferString(addressJSON))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreateAddress).ServeHTTP(rr, req)
+		return rr
+	}, http.StatusCreated)
+}
@@ -0,0 +1,42 @@
+package transactiontests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/nmelhado/smartmail-api/api/controllers"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func shipperPackageRequest(t *testing.T, server *controllers.Server, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/shipper/addresses/package", bytes.NewBufferString(body))
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.ShipperProvidePackageAddressToAndFromBySmartID).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestShipperPackageRejectsBadCheckDigit(t *testing.T) {
+	server, mock := newServer(t)
+	mock.ExpectQuery(`SELECT \* FROM "carriers" WHERE \(code = \$1\)`).WithArgs("UPS").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name"}).AddRow(1, "UPS", "UPS"))
+
+	rr := shipperPackageRequest(t, server, `{"recipient_smart_id": "NC4LL93K", "carrier": "ups", "tracking": "1Z 999 AA1 0123 4567 85", "target_date": "2020-06-01"}`)
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
+
+func TestShipperPackageRejectsUndetectableCarrier(t *testing.T) {
+	server, mock := newServer(t)
+	mock.ExpectQuery(`SELECT \* FROM "carriers"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "tracking_patterns"}).AddRow(1, "UPS", "UPS", []byte(`^1Z[0-9A-Z]{16}$`)))
+
+	rr := shipperPackageRequest(t, server, `{"recipient_smart_id": "NC4LL93K", "tracking": "NOT-A-TRACKING-NUMBER", "target_date": "2020-06-01"}`)
+	assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, mock.ExpectationsWereMet(), nil)
+}
@@ -0,0 +1,78 @@
+package utiltests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/utils/recurrence"
+	"gopkg.in/go-playground/assert.v1"
+	"gopkg.in/guregu/null.v3"
+)
+
+func day(date string) time.Time {
+	parsed, _ := time.Parse("2006-01-02", date)
+	return parsed
+}
+
+func TestParseRecurrence(t *testing.T) {
+	for _, rule := range []string{"yearly:11-01/04-30", "YEARLY:06-01/08-31", "weekly:sat,sun", "weekly: mon, wed"} {
+		_, err := recurrence.Parse(rule)
+		assert.Equal(t, err, nil)
+	}
+	for _, rule := range []string{"", "yearly", "yearly:11-01", "yearly:13-01/04-30", "weekly:someday", "monthly:1"} {
+		_, err := recurrence.Parse(rule)
+		assert.Equal(t, errors.Is(err, recurrence.ErrInvalidRule), true)
+	}
+}
+
+func TestRecurrenceMatches(t *testing.T) {
+	samples := []struct {
+		rule    string
+		date    string
+		matches bool
+	}{
+		{"yearly:11-01/04-30", "2020-11-01", true},
+		{"yearly:11-01/04-30", "2021-01-15", true},
+		{"yearly:11-01/04-30", "2021-04-30", true},
+		{"yearly:11-01/04-30", "2021-05-01", false},
+		{"yearly:11-01/04-30", "2021-10-31", false},
+		{"yearly:06-01/08-31", "2021-07-04", true},
+		{"yearly:06-01/08-31", "2021-12-25", false},
+		{"weekly:sat,sun", "2020-06-06", true},
+		{"weekly:sat,sun", "2020-06-07", true},
+		{"weekly:sat,sun", "2020-06-08", false},
+	}
+	for _, v := range samples {
+		rule, err := recurrence.Parse(v.rule)
+		if err != nil {
+			t.Errorf("this is the error parsing %s: %v\n", v.rule, err)
+			continue
+		}
+		assert.Equal(t, rule.Matches(day(v.date)), v.matches)
+	}
+}
+
+func TestRecurringAddressActiveOn(t *testing.T) {
+	snowbird := models.AddressAssignment{
+		Status:     models.Temporary,
+		StartDate:  day("2019-10-01"),
+		Recurrence: null.StringFrom("yearly:11-01/04-30"),
+	}
+	assert.Equal(t, snowbird.Validate(), nil)
+	assert.Equal(t, snowbird.ActiveOn(day("2019-10-15")), false)
+	assert.Equal(t, snowbird.ActiveOn(day("2019-12-01")), true)
+	assert.Equal(t, snowbird.ActiveOn(day("2023-02-14")), true)
+	assert.Equal(t, snowbird.ActiveOn(day("2023-07-04")), false)
+
+	snowbird.EndDate = null.TimeFrom(day("2022-01-01"))
+	assert.Equal(t, snowbird.ActiveOn(day("2023-02-14")), false)
+
+	permanent := models.AddressAssignment{
+		Status:     models.Permanent,
+		StartDate:  day("2019-10-01"),
+		Recurrence: null.StringFrom("weekly:sat"),
+	}
+	assert.NotEqual(t, permanent.Validate(), nil)
+}
@@ -0,0 +1,49 @@
+package utiltests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/utils/smartid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestGenerateSmartID(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		newSmartID, err := smartid.Generate()
+		if err != nil {
+			t.Errorf("this is the error generating a smartID: %v\n", err)
+			return
+		}
+		assert.Equal(t, len(newSmartID), smartid.Length)
+		assert.Equal(t, strings.ContainsAny(newSmartID, "ISZO"), false)
+		assert.Equal(t, smartid.Validate(newSmartID), nil)
+	}
+}
+
+func TestValidateSmartID(t *testing.T) {
+	check, err := smartid.CheckCharacter("1B3D5F7")
+	if err != nil {
+		t.Errorf("this is the error calculating a check character: %v\n", err)
+		return
+	}
+	valid := "1B3D5F7" + string(check)
+
+	samples := []struct {
+		smartID string
+		err     error
+	}{
+		{smartID: valid, err: nil},
+		{smartID: "1B3D5F7", err: smartid.ErrInvalidFormat},
+		{smartID: "1B3D5F7H9", err: smartid.ErrInvalidFormat},
+		{smartID: "1B3D5FOH", err: smartid.ErrInvalidFormat},
+		// single character typo
+		{smartID: "1B3D6F7" + string(check), err: smartid.ErrInvalidCheckDigit},
+		// adjacent characters swapped
+		{smartID: "B13D5F7" + string(check), err: smartid.ErrInvalidCheckDigit},
+	}
+
+	for _, v := range samples {
+		assert.Equal(t, smartid.Validate(v.smartID), v.err)
+	}
+}
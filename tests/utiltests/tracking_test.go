@@ -0,0 +1,62 @@
+package utiltests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nmelhado/smartmail-api/api/utils/tracking"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestNormalizeTracking(t *testing.T) {
+	assert.Equal(t, tracking.Normalize(" 1z 999 aa1-0123456784 "), "1Z999AA10123456784")
+}
+
+func TestDetectTracking(t *testing.T) {
+	samples := []struct {
+		tracking string
+		carrier  string
+	}{
+		{"1Z999AA10123456784", tracking.UPS},
+		{"9205590164917312751089", tracking.USPS},
+		{"420221539101026837331000039521", tracking.USPS},
+		{"EE123456785US", tracking.USPS},
+		{"986578788855", tracking.FedEx},
+		{"449044304137821", tracking.FedEx},
+		{"9611020987654312345672", tracking.FedEx},
+		{"1LS123456789012", tracking.LaserShip},
+		{"LX12345678", tracking.LaserShip},
+	}
+	for _, v := range samples {
+		carrier, err := tracking.Detect(v.tracking)
+		if err != nil {
+			t.Errorf("this is the error detecting %s: %v\n", v.tracking, err)
+			continue
+		}
+		assert.Equal(t, carrier, v.carrier)
+		assert.Equal(t, tracking.Validate(v.carrier, v.tracking), nil)
+	}
+}
+
+func TestInvalidTracking(t *testing.T) {
+	samples := []struct {
+		tracking string
+		carrier  string
+	}{
+		{"1Z999AA10123456785", tracking.UPS},
+		{"1Z999AA1012345678", tracking.UPS},
+		{"9205590164917312751088", tracking.USPS},
+		{"EE123456784US", tracking.USPS},
+		{"986578788856", tracking.FedEx},
+		{"449044304137822", tracking.FedEx},
+		{"1Z999AA10123456784", tracking.FedEx},
+		{"LX1234567", tracking.LaserShip},
+	}
+	for _, v := range samples {
+		err := tracking.Validate(v.carrier, v.tracking)
+		assert.Equal(t, errors.Is(err, tracking.ErrInvalidTracking), true)
+	}
+
+	_, err := tracking.Detect("1Z999AA10123456785")
+	assert.Equal(t, errors.Is(err, tracking.ErrUnknownCarrier), true)
+}
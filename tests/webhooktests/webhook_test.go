@@ -0,0 +1,90 @@
+package webhooktests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nmelhado/smartmail-api/api/models"
+	"github.com/nmelhado/smartmail-api/api/webhooks"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	sent := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"event":"address.changed"}`)
+	header := webhooks.Sign("whsec_batcave", sent, body)
+
+	samples := []struct {
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		err    error
+	}{
+		{secret: "whsec_batcave", header: header, body: body, now: sent.Add(time.Minute), err: nil},
+		{secret: "whsec_batcave", header: header, body: []byte(`{"event":"address.hold"}`), now: sent, err: webhooks.ErrInvalidSignature},
+		{secret: "whsec_arkham", header: header, body: body, now: sent, err: webhooks.ErrInvalidSignature},
+		// Replayed after the tolerance
+		{secret: "whsec_batcave", header: header, body: body, now: sent.Add(webhooks.DefaultTolerance + time.Second), err: webhooks.ErrInvalidSignature},
+		{secret: "whsec_batcave", header: "v1=abc", body: body, now: sent, err: webhooks.ErrInvalidSignature},
+		{secret: "whsec_batcave", header: "garbage", body: body, now: sent, err: webhooks.ErrInvalidSignature},
+	}
+
+	for _, v := range samples {
+		err := webhooks.Verify(v.secret, v.header, v.body, v.now, webhooks.DefaultTolerance)
+		assert.Equal(t, err, v.err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, models.WebhookBackoff(1), 30*time.Second)
+	assert.Equal(t, models.WebhookBackoff(2), time.Minute)
+	assert.Equal(t, models.WebhookBackoff(5), 8*time.Minute)
+	assert.Equal(t, models.WebhookBackoff(20), 6*time.Hour)
+}
+
+func TestRecordAttempt(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	delivery := models.WebhookDelivery{NextAttemptAt: now}
+
+	delivery.RecordAttempt(now, 503, nil)
+	assert.Equal(t, delivery.Attempts, 1)
+	assert.Equal(t, delivery.NextAttemptAt, now.Add(30*time.Second))
+	assert.Equal(t, delivery.LastStatus.Int64, int64(503))
+	assert.Equal(t, delivery.DeadAt.Valid, false)
+
+	delivery.RecordAttempt(now, 0, errors.New("connection refused"))
+	assert.Equal(t, delivery.LastStatus.Valid, false)
+	assert.Equal(t, delivery.LastError.String, "connection refused")
+
+	for delivery.Attempts < models.MaxWebhookAttempts {
+		delivery.RecordAttempt(now, 500, nil)
+	}
+	assert.Equal(t, delivery.DeadAt.Valid, true)
+
+	delivered := models.WebhookDelivery{}
+	delivered.RecordAttempt(now, 204, nil)
+	assert.Equal(t, delivered.DeliveredAt.Valid, true)
+	assert.Equal(t, delivered.LastError.Valid, false)
+}
+
+func TestAssignmentEvent(t *testing.T) {
+	samples := []struct {
+		status models.Status
+		event  models.WebhookEvent
+		ok     bool
+	}{
+		{status: models.Permanent, event: models.AddressChangedEvent, ok: true},
+		{status: models.PackageOnlyPermanent, event: models.AddressChangedEvent, ok: true},
+		{status: models.MailOnlyTemporary, event: models.AddressTemporaryEvent, ok: true},
+		{status: models.PackageOnlyHold, event: models.AddressHoldEvent, ok: true},
+		{status: models.Expired, event: "", ok: false},
+	}
+
+	for _, v := range samples {
+		event, ok := models.AssignmentEvent(&models.AddressAssignment{Status: v.status})
+		assert.Equal(t, event, v.event)
+		assert.Equal(t, ok, v.ok)
+	}
+}